@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/matti777/google-photos-uploader/internal/config"
+	photos "github.com/matti777/google-photos-uploader/internal/googlephotos"
+	"github.com/matti777/google-photos-uploader/internal/util"
+)
+
+// albumInfo is the JSON output of an album
+type albumInfo struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	Items     int64  `json:"items"`
+	Writeable bool   `json:"writeable"`
+	URL       string `json:"url"`
+	ShareURL  string `json:"shareUrl,omitempty"`
+}
+
+// itemInfo is the JSON output of a media item
+type itemInfo struct {
+	ID           string     `json:"id"`
+	FileName     string     `json:"fileName"`
+	MimeType     string     `json:"mimeType"`
+	CreationTime *time.Time `json:"creationTime,omitempty"`
+	Width        int64      `json:"width"`
+	Height       int64      `json:"height"`
+	Description  string     `json:"description,omitempty"`
+	URL          string     `json:"url"`
+}
+
+func toAlbumInfo(a *photos.Album) *albumInfo {
+	info := &albumInfo{ID: a.ID, Title: a.Title, Items: a.MediaItemsCount,
+		Writeable: a.IsWriteable, URL: a.ProductURL}
+	if a.ShareInfo != nil {
+		info.ShareURL = a.ShareInfo.ShareableURL
+	}
+
+	return info
+}
+
+func toItemInfo(m *photos.MediaItem) *itemInfo {
+	info := &itemInfo{ID: m.ID, FileName: m.FileName, MimeType: m.MimeType,
+		Width: m.Width, Height: m.Height, Description: m.Description, URL: m.ProductURL}
+	if !m.CreationTime.IsZero() {
+		info.CreationTime = &m.CreationTime
+	}
+
+	return info
+}
+
+func toItemInfos(items []*photos.MediaItem) []*itemInfo {
+	infos := make([]*itemInfo, 0, len(items))
+	for _, m := range items {
+		infos = append(infos, toItemInfo(m))
+	}
+
+	return infos
+}
+
+// Writes the value to stdout as indented JSON
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(v)
+}
+
+// Prints the media items either as JSON or as a table
+func printItems(items []*photos.MediaItem, asJSON bool) error {
+	if asJSON {
+		return printJSON(toItemInfos(items))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "FILE NAME\tTYPE\tCREATED\tSIZE\tID\n")
+	for _, m := range items {
+		created := ""
+		if !m.CreationTime.IsZero() {
+			created = m.CreationTime.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%vx%v\t%v\n", m.FileName, m.MimeType, created,
+			m.Width, m.Height, m.ID)
+	}
+	w.Flush()
+	fmt.Printf("%v item(s)\n", len(items))
+
+	return nil
+}
+
+// Authorizes (without confirmation) and lists the albums for the read-only
+// commands. The albums are stored into settings.
+func mustListAlbums(c *cli.Context) photos.Client {
+	client := mustInitClient(c, false)
+
+	albums, err := client.ListAlbums()
+	if err != nil {
+		log.Fatalf("Failed to list albums: %v", err)
+	}
+	settings.Albums = albums
+
+	return client
+}
+
+func albumsListAction(c *cli.Context) error {
+	mustListAlbums(c)
+
+	if c.Bool("json") {
+		infos := make([]*albumInfo, 0, len(settings.Albums))
+		for _, a := range settings.Albums {
+			infos = append(infos, toAlbumInfo(a))
+		}
+		return printJSON(infos)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "TITLE\tITEMS\tWRITEABLE\tID\n")
+	for _, a := range settings.Albums {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", a.Title, a.MediaItemsCount, a.IsWriteable, a.ID)
+	}
+	w.Flush()
+	fmt.Printf("%v album(s)\n", len(settings.Albums))
+
+	return nil
+}
+
+func albumsShowAction(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.Exit("Must define an album title or ID", 1)
+	}
+
+	client := mustListAlbums(c)
+
+	album := findAlbumByTitleOrID(c.Args().First())
+	if album == nil {
+		return cli.Exit(fmt.Sprintf("Album '%v' not found", c.Args().First()), 1)
+	}
+
+	items, err := client.ListAlbumItems(album)
+	if err != nil {
+		log.Fatalf("Failed to list the items of album '%v': %v", album.Title, err)
+	}
+
+	if c.Bool("json") {
+		return printJSON(struct {
+			*albumInfo
+			MediaItems []*itemInfo `json:"mediaItems"`
+		}{toAlbumInfo(album), toItemInfos(items)})
+	}
+
+	fmt.Printf("Album:     %v\n", album.Title)
+	fmt.Printf("ID:        %v\n", album.ID)
+	fmt.Printf("URL:       %v\n", album.ProductURL)
+	fmt.Printf("Writeable: %v\n", album.IsWriteable)
+	if album.ShareInfo != nil {
+		fmt.Printf("Share URL: %v\n", album.ShareInfo.ShareableURL)
+	}
+	fmt.Println()
+
+	return printItems(items, false)
+}
+
+// Parses a --from / --to date; accepts the same formats as the directory
+// names, eg. 2019, 2019-06 or 2019-06-14.
+func parseSearchDate(c *cli.Context, name string) (util.AlbumDate, error) {
+	s := c.String(name)
+	if s == "" {
+		return util.AlbumDate{}, nil
+	}
+
+	d, err := util.ParseAlbumDate(s)
+	if err != nil {
+		return d, cli.Exit(fmt.Sprintf("Invalid --%v date '%v'", name, s), 1)
+	}
+
+	return d, nil
+}
+
+func isContentCategory(category string) bool {
+	for _, c := range photos.ContentCategories {
+		if c == category {
+			return true
+		}
+	}
+
+	return false
+}
+
+func itemsSearchAction(c *cli.Context) error {
+	from, err := parseSearchDate(c, "from")
+	if err != nil {
+		return err
+	}
+	to, err := parseSearchDate(c, "to")
+	if err != nil {
+		return err
+	}
+
+	filter := &photos.SearchFilter{StartDate: from.Time}
+	if !to.IsZero() {
+		filter.EndDate = to.End()
+	}
+
+	for _, category := range c.StringSlice("category") {
+		category = strings.ToUpper(category)
+		if !isContentCategory(category) {
+			return cli.Exit(fmt.Sprintf("Invalid --category '%v'; must be one of: %v",
+				category, strings.Join(photos.ContentCategories, ", ")), 1)
+		}
+		filter.ContentCategories = append(filter.ContentCategories, category)
+	}
+
+	switch t := strings.ToLower(c.String("type")); t {
+	case "":
+	case "photo", "video":
+		filter.MediaType = strings.ToUpper(t)
+	default:
+		return cli.Exit(fmt.Sprintf("Invalid --type '%v'; must be photo or video", t), 1)
+	}
+
+	items, err := mustInitClient(c, false).SearchMediaItems(filter)
+	if err != nil {
+		log.Fatalf("Failed to search media items: %v", err)
+	}
+
+	return printItems(items, c.Bool("json"))
+}
+
+func whoamiAction(c *cli.Context) error {
+	appConfig = config.ReadAppConfig()
+	if appConfig.AuthToken == nil {
+		return cli.Exit("Not authorized; run with --authorize to authorize", 1)
+	}
+
+	if c.Bool("json") {
+		return printJSON(appConfig.UserInfo)
+	}
+
+	fmt.Printf("Name:  %v\n", appConfig.UserInfo.Name)
+	fmt.Printf("Email: %v\n", appConfig.UserInfo.Email)
+	fmt.Printf("ID:    %v\n", appConfig.UserInfo.ID)
+
+	return nil
+}
+
+var jsonFlag = &cli.BoolFlag{
+	Name:  "json",
+	Usage: "Print the output as JSON",
+}
+
+func albumsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "albums",
+		Usage: "Inspect the Google Photos albums",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "list",
+				Usage:  "List the albums with their item counts, IDs and writeable flags",
+				Action: albumsListAction,
+				Flags:  []cli.Flag{jsonFlag},
+			},
+			{
+				Name:      "show",
+				Usage:     "List the media items of an album",
+				ArgsUsage: "<album title or ID>",
+				Action:    albumsShowAction,
+				Flags:     []cli.Flag{jsonFlag},
+			},
+		},
+	}
+}
+
+func itemsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "items",
+		Usage: "Inspect the Google Photos library",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "search",
+				Usage: "Search the library by date range and / or content category",
+				Description: "Dates may be given as a year (2019), a month (2019-06) or a " +
+					"day (2019-06-14); --to includes the whole year / month / day.",
+				Action: itemsSearchAction,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "from",
+						Usage: "Search items created on or after this date",
+					},
+					&cli.StringFlag{
+						Name:  "to",
+						Usage: "Search items created on or before this date",
+					},
+					&cli.StringSliceFlag{
+						Name: "category",
+						Usage: "Content category to search for, eg. LANDSCAPES, PETS or " +
+							"SELFIES; may be given multiple times",
+					},
+					&cli.StringFlag{
+						Name:  "type",
+						Usage: "Media type to search for: photo or video",
+					},
+					jsonFlag,
+				},
+			},
+		},
+	}
+}
+
+func whoamiCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "whoami",
+		Usage:  "Print the authorized Google account",
+		Action: whoamiAction,
+		Flags:  []cli.Flag{jsonFlag},
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/matti777/google-photos-uploader/internal/files"
+	photos "github.com/matti777/google-photos-uploader/internal/googlephotos"
+)
+
+// Downloads the given albums (or all of them) into the directory
+func downloadAction(c *cli.Context) error {
+	readFlags(c)
+
+	baseDir, err := resolveBaseDir(c)
+	if err != nil {
+		return err
+	}
+
+	mustInitClient(c, false)
+	mustFetchAlbums()
+
+	albums := []*photos.Album{}
+	for _, titleOrID := range c.Args().Tail() {
+		album := findAlbumByTitleOrID(titleOrID)
+		if album == nil {
+			return cli.Exit(fmt.Sprintf("Album '%v' not found", titleOrID), 1)
+		}
+		albums = append(albums, album)
+	}
+	if c.NArg() < 2 {
+		albums = settings.Albums
+	}
+
+	files.DownloadAlbums(baseDir, albums, c.Bool("library"))
+
+	return nil
+}
+
+func downloadCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "download",
+		Usage:     "Download albums from Google Photos into a directory",
+		ArgsUsage: "directory [album title or ID ...]",
+		Description: "Mirrors the given albums (or all the albums if none given) into " +
+			"the directory, one subdirectory per album. The files are named and dated " +
+			"after the media items. Only new and changed items are downloaded, so an " +
+			"interrupted download can be resumed by running the command again. The " +
+			"directory can be uploaded again with the default 'top' layout.",
+		Action: downloadAction,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name: "library",
+				Usage: "Also download the library items that are not in any of the " +
+					"albums, into a directory per year",
+			},
+		},
+	}
+}
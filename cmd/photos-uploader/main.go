@@ -4,13 +4,19 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/matti777/google-photos-uploader/internal/config"
+	"github.com/matti777/google-photos-uploader/internal/destination"
 	"github.com/matti777/google-photos-uploader/internal/exiftool"
 	"github.com/matti777/google-photos-uploader/internal/files"
 	photos "github.com/matti777/google-photos-uploader/internal/googlephotos"
 	photosutil "github.com/matti777/google-photos-uploader/internal/googlephotos/util"
+	"github.com/matti777/google-photos-uploader/internal/imageproc"
 	"github.com/matti777/google-photos-uploader/internal/logging"
+	"github.com/matti777/google-photos-uploader/internal/mirror"
+	"github.com/matti777/google-photos-uploader/internal/privacy"
 	"github.com/matti777/google-photos-uploader/internal/util"
 
 	"github.com/sirupsen/logrus"
@@ -29,6 +35,18 @@ func readFlags(c *cli.Context) {
 	settings.Recurse = c.IsSet("recursive")
 	log.Debugf("Recurse into subdirectories: %v", settings.Recurse)
 
+	settings.Layout = c.String("layout")
+	settings.AlbumName = c.String("album-name")
+	log.Debugf("Album layout: %v", settings.Layout)
+
+	settings.Order = c.String("order")
+	if err := files.ValidateOrder(settings.Order); err != nil {
+		log.Fatalf("Invalid --order: %v", err)
+	}
+
+	settings.EventGap = c.Duration("event-gap")
+	settings.EventDistanceKm = c.Float64("event-distance")
+
 	settings.SkipConfirmation = c.IsSet("yes")
 	if settings.SkipConfirmation {
 		log.Debugf("--yes defined, will skip all confirmations")
@@ -48,72 +66,218 @@ func readFlags(c *cli.Context) {
 	settings.NoParseYear = c.IsSet("no-parse-year")
 	log.Debugf("Skipping parsing folder year?: %v", settings.NoParseYear)
 
+	settings.DatePatterns = c.StringSlice("date-pattern")
+	if err := util.AddDatePatterns(settings.DatePatterns); err != nil {
+		log.Fatalf("Invalid --date-pattern: %v", err)
+	}
+
+	settings.FilenamePatterns = c.StringSlice("filename-pattern")
+	if err := util.AddFilenamePatterns(settings.FilenamePatterns); err != nil {
+		log.Fatalf("Invalid --filename-pattern: %v", err)
+	}
+
+	dateSources, err := files.ParseDateSources(c.String("date-sources"))
+	if err != nil {
+		log.Fatalf("Invalid --date-sources: %v", err)
+	}
+	settings.DateSources = dateSources
+	log.Debugf("Photo date sources: %v", settings.DateSources)
+
+	if tz := c.String("timezone"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			log.Fatalf("Invalid --timezone: %v", err)
+		}
+		settings.Timezone = loc
+		log.Debugf("Photo timezone: %v", loc)
+	}
+
+	settings.ClockOffsets = map[string]time.Duration{}
+	for _, s := range c.StringSlice("clock-offset") {
+		model, offset, err := files.ParseClockOffset(s)
+		if err != nil {
+			log.Fatalf("Invalid --clock-offset: %v", err)
+		}
+		settings.ClockOffsets[model] = offset
+	}
+
 	settings.Capitalize = c.Bool("capitalize")
 	log.Debugf("Capitalizing folder name words: %v", settings.Capitalize)
 
 	settings.MaxConcurrency = c.Int("concurrency")
 	log.Debugf("maxConcurrency = %v", settings.MaxConcurrency)
+
+	settings.UploadRetries = c.Int("retries")
+
+	// The location and the personal data are stripped in pure Go when
+	// processing the images; the privacy policy removes the remaining tags
+	// with exiftool and checks the result
+	settings.Processing = imageproc.Options{
+		MaxDimension:  c.Int("max-dimension"),
+		Quality:       c.Int("jpeg-quality"),
+		AutoOrient:    c.Bool("auto-orient"),
+		StripGPS:      c.Bool("strip-gps"),
+		StripPersonal: c.Bool("strip-personal"),
+	}
+	if err := settings.Processing.Validate(); err != nil {
+		log.Fatalf("Invalid image processing options: %v", err)
+	}
+	log.Debugf("Image processing: %+v", settings.Processing)
+
+	settings.Privacy = privacy.Policy{
+		StripGPS:      c.Bool("strip-gps"),
+		HomeRadiusKm:  c.Float64("home-radius"),
+		StripPersonal: c.Bool("strip-personal"),
+		Denylist:      c.StringSlice("deny-tag"),
+	}
+	if c.IsSet("home") {
+		home, err := privacy.ParseLocation(c.String("home"))
+		if err != nil {
+			log.Fatalf("Invalid --home: %v", err)
+		}
+		settings.Privacy.Home = &home
+	}
+	if err := settings.Privacy.Validate(); err != nil {
+		log.Fatalf("Invalid privacy options: %v", err)
+	}
+	log.Debugf("Privacy policy: %+v", settings.Privacy)
+
+	settings.ConflictPolicy = c.String("on-existing-album")
+	log.Debugf("Existing album conflict policy: %v", settings.ConflictPolicy)
+
+	settings.Share = c.Bool("share")
+	settings.ShareCollaborative = c.Bool("share-collaborative")
+	settings.ShareCommentable = c.Bool("share-commentable")
+	settings.IndexFile = c.String("index-file")
+	log.Debugf("Share albums: %v, index file: %v", settings.Share, settings.IndexFile)
+
+	settings.Include = c.StringSlice("include")
+	settings.Exclude = c.StringSlice("exclude")
+	log.Debugf("Include patterns: %v, exclude patterns: %v",
+		settings.Include, settings.Exclude)
+}
+
+// Authorizes the user if not yet authorized; if already authorized, asks
+// for confirmation of the account when confirm is true.
+func handleAuthorize(c *cli.Context, confirm bool) error {
+	appConfig = mustAuthorizeProfile(c, "", confirm)
+
+	return nil
 }
 
-func handleAuthorize(c *cli.Context) error {
-	appConfig = config.ReadAppConfig()
+// Authorizes the Google account of a profile (the default account if profile
+// is empty) if not yet authorized; if already authorized, asks for confirmation
+// of the account when confirm is true. A new profile uses the app credentials
+// of the default account, if any. Returns the app configuration of the profile.
+func mustAuthorizeProfile(c *cli.Context, profile string, confirm bool) *config.AppConfiguration {
+	cfg := config.ReadProfileConfig(profile)
 
 	authorize := c.IsSet("authorize")
 
 	if authorize {
-		appConfig.ClientID = ""
-		appConfig.ClientSecret = ""
-		appConfig.AuthToken = nil
-		appConfig.UserInfo = photosutil.UserInfo{}
-		config.MustWriteAppConfig(appConfig)
+		cfg.ClientID = ""
+		cfg.ClientSecret = ""
+		cfg.AuthToken = nil
+		cfg.UserInfo = photosutil.UserInfo{}
+		config.MustWriteProfileConfig(profile, cfg)
 		log.Debugf("Re-authentication requested; all authentication data has been reset.")
 	}
 
-	if appConfig.ClientID == "" || appConfig.ClientSecret == "" {
-		appConfig.ClientID, appConfig.ClientSecret = config.MustReadAppCredentials()
-		config.MustWriteAppConfig(appConfig)
+	if (cfg.ClientID == "" || cfg.ClientSecret == "") && profile != "" {
+		defaultCfg := config.ReadAppConfig()
+		cfg.ClientID, cfg.ClientSecret = defaultCfg.ClientID, defaultCfg.ClientSecret
+	}
+
+	if cfg.ClientID == "" || cfg.ClientSecret == "" {
+		cfg.ClientID, cfg.ClientSecret = config.MustReadAppCredentials()
+		config.MustWriteProfileConfig(profile, cfg)
+	}
+
+	account := ""
+	if profile != "" {
+		account = fmt.Sprintf(" for account '%v'", profile)
 	}
 
 	// Check if need to authenticate the user
-	if appConfig.AuthToken == nil {
-		fmt.Printf("Authenticating you..\n")
-		a := photosutil.NewAuthenticator(appConfig.ClientID, appConfig.ClientSecret)
+	if cfg.AuthToken == nil {
+		fmt.Printf("Authenticating you%v..\n", account)
+		a := photosutil.NewAuthenticator(cfg.ClientID, cfg.ClientSecret)
 		token, userInfo, err := a.Authorize()
 		if err != nil {
 			log.Fatalf("Failed to get authorization token")
 		} else {
 			fmt.Println("Authorization OK!")
-			appConfig.AuthToken = token
-			appConfig.UserInfo = *userInfo
+			cfg.AuthToken = token
+			cfg.UserInfo = *userInfo
 
 			// TODO fetch further user info to get email address etc
 
-			config.MustWriteAppConfig(appConfig)
+			config.MustWriteProfileConfig(profile, cfg)
 		}
-		fmt.Printf("Authorized as '%v' (%v) -- specify --authorize to authorize "+
-			"on a different account.\n", appConfig.UserInfo.Name,
-			appConfig.UserInfo.Email)
-	} else {
-		util.MustConfirm(fmt.Sprintf("You have authenticated as %v (%v).",
-			appConfig.UserInfo.Name, appConfig.UserInfo.Email),
+		fmt.Printf("Authorized as '%v' (%v)%v -- specify --authorize to authorize "+
+			"on a different account.\n", cfg.UserInfo.Name, cfg.UserInfo.Email, account)
+	} else if confirm {
+		util.MustConfirm(fmt.Sprintf("You have authenticated as %v (%v)%v.",
+			cfg.UserInfo.Name, cfg.UserInfo.Email, account),
 			"Re-run with --authorize to re-authorize as a different user.")
 	}
 
-	return nil
+	return cfg
 }
 
-func mustInitGooglePhotos() {
+// Creates the Google Photos client out of the stored credentials
+func mustCreatePhotosClient() photos.Client {
 	if appConfig.ClientID == "" || appConfig.ClientSecret == "" || appConfig.AuthToken == nil {
 		log.Fatalf("appConfig missing credentials to create Photos client")
 	}
 
-	photosClient := photos.MustCreateClient(appConfig.ClientID, appConfig.ClientSecret,
+	return photos.MustCreateClient(appConfig.ClientID, appConfig.ClientSecret,
 		appConfig.AuthToken)
+}
+
+// Creates the Photos client: the local mirror if --mirror is given, the
+// destination given with --destination, or otherwise the Google Photos
+// client, authorizing the user first if needed.
+func mustInitClient(c *cli.Context, confirm bool) photos.Client {
+	if dir := c.String("mirror"); dir != "" {
+		return mustUseMirror(dir)
+	}
+
+	if spec := c.String("destination"); spec != "" {
+		d, err := destination.Open(spec)
+		if err != nil {
+			log.Fatalf("Failed to open destination: %v", err)
+		}
+		photos.SetClient(d)
+
+		return d
+	}
+
+	if err := handleAuthorize(c, confirm); err != nil {
+		log.Fatalf("Failed to authorize: %v", err)
+	}
+
+	return mustCreatePhotosClient()
+}
 
-	// Retrieve the list of albums and store into settings
-	fmt.Printf("Fetching the list of existing Google Photos albums..\n")
+// Uses the local mirror in the directory instead of Google Photos
+func mustUseMirror(dir string) photos.Client {
+	m, err := mirror.New(dir)
+	if err != nil {
+		log.Fatalf("Failed to open local mirror '%v': %v", dir, err)
+	}
+	photos.SetClient(m)
+
+	return m
+}
+
+// Retrieves the list of existing albums and stores it into settings
+func mustFetchAlbums() {
+	photosClient := photos.MustGetClient()
+
+	log.Info("Fetching the list of existing albums..")
 	if l, err := photosClient.ListAlbums(); err != nil {
-		log.Fatalf("Failed to list Google Photos albums: %v", err)
+		log.Fatalf("Failed to list albums: %v", err)
 	} else {
 		settings.Albums = l
 	}
@@ -123,42 +287,59 @@ func mustInitGooglePhotos() {
 	}
 }
 
-func defaultAction(c *cli.Context) error {
-	logLevel := logrus.ErrorLevel
-	if c.IsSet("verbose") {
-		logLevel = logrus.DebugLevel
+// Sets up logging; run before any command
+func setupLogging(c *cli.Context) error {
+	options := logging.Options{
+		Format:     c.String("log-format"),
+		Level:      c.String("log-level"),
+		File:       c.String("log-file"),
+		MaxSize:    c.Int64("log-max-size") * 1024 * 1024,
+		MaxBackups: c.Int("log-max-backups"),
+	}
+	if c.Bool("verbose") {
+		options.Level = logrus.DebugLevel.String()
 	}
-	log = logging.MustGetLogger()
-	log.SetLevel(logLevel)
 
-	readFlags(c)
+	log = logging.MustGetLogger()
+	if err := logging.Configure(options); err != nil {
+		return cli.Exit(fmt.Sprintf("Invalid logging options: %v", err), 1)
+	}
 
-	exiftool.MustCheckExiftoolInstalled()
+	return nil
+}
 
+// Resolves the absolute path of the base directory given as the first argument
+func resolveBaseDir(c *cli.Context) (string, error) {
 	baseDir := c.Args().Get(0)
 	if baseDir == "" {
 		cli.ShowAppHelp(c)
-		return cli.Exit("Must define a base directory!", -1)
+		return "", cli.Exit("Must define a base directory!", -1)
 	}
 
-	// Resolve the base dir
 	baseDir, err := filepath.Abs(baseDir)
 	if err != nil {
 		log.Fatalf("Failed to get absolute path for '%v': %v", baseDir, err)
 	}
 	log.Debugf("Base directory is: %v", baseDir)
 
-	if !settings.DryRun {
-		if err := handleAuthorize(c); err != nil {
-			return nil
-		}
+	return baseDir, nil
+}
 
-		mustInitGooglePhotos()
+func defaultAction(c *cli.Context) error {
+	readFlags(c)
+
+	exiftool.MustCheckExiftoolInstalled()
+
+	baseDir, err := resolveBaseDir(c)
+	if err != nil {
+		return err
 	}
 
-	files.ProcessBaseDir(baseDir)
+	if c.Bool("select") && !settings.SkipConfirmation {
+		return selectAndUpload(c, baseDir)
+	}
 
-	return nil
+	return uploadBaseDir(c, baseDir)
 }
 
 func main() {
@@ -167,6 +348,8 @@ func main() {
 	// Setup CLI app framework
 	app := cli.NewApp()
 	app.EnableBashCompletion = true
+	// The --target and pattern flags contain commas; repeat the flags instead
+	app.DisableSliceFlagSeparator = true
 	app.Name = appname
 	app.ArgsUsage = "[directory]"
 	app.Usage = "A command line Google Photos upload utility"
@@ -179,7 +362,18 @@ func main() {
 		"For help, run '%v help'", appname)
 	app.Copyright = "(c) 2018-2023 Matti Dahlbom"
 	app.Version = "1.0.0"
+	app.Before = setupLogging
 	app.Action = defaultAction
+	app.Commands = []*cli.Command{
+		shareCommand(),
+		albumsCommand(),
+		itemsCommand(),
+		whoamiCommand(),
+		verifyCommand(),
+		downloadCommand(),
+		planCommand(),
+		applyCommand(),
+	}
 	app.Flags = []cli.Flag{
 		&cli.BoolFlag{
 			Name:    "authorize",
@@ -198,17 +392,78 @@ func main() {
 			Value:   false,
 			Usage:   "Process subdirectories of the photo directories recursively",
 		},
+		&cli.StringFlag{
+			Name:  "layout",
+			Value: files.LayoutTopLevel,
+			Usage: "How the directories / files are mapped into albums: " +
+				"'top' creates an album of each subdirectory of the base directory, " +
+				"'leaf' an album of each directory containing photos, " +
+				"'path' likewise but titled with the directory path (eg. 'Travel / 2019 / Japan'), " +
+				"'month' / 'year' an album per EXIF capture month / year, " +
+				"'events' an album per event, clustered by the EXIF capture time (see --event-gap), " +
+				"'single' a single album named by --album-name and " +
+				"'none' uploads the photos without adding them to any album. " +
+				"Only the 'top' layout honours --recursive; the others always scan the whole tree.",
+		},
+		&cli.StringFlag{
+			Name:  "order",
+			Value: files.OrderName,
+			Usage: "Order of the photos in the albums: 'name' by the file names in natural " +
+				"order (IMG_2 before IMG_10), 'time' by the capture time, or 'manifest' as " +
+				"listed in the .album-order.txt file of the album directory (one file name " +
+				"per line). Can be set per album with 'order' in the album override file.",
+		},
+		&cli.DurationFlag{
+			Name:  "event-gap",
+			Value: files.DefaultEventGap,
+			Usage: "For the 'events' layout, start a new event (album) after a gap " +
+				"this long between two photos",
+		},
+		&cli.Float64Flag{
+			Name: "event-distance",
+			Usage: "For the 'events' layout, also start a new event when two consecutive " +
+				"photos were taken more than this many kilometers apart (using EXIF GPS " +
+				"data). 0 disables splitting by location.",
+		},
+		&cli.StringFlag{
+			Name:  "album-name",
+			Usage: "Album name for the 'single' layout",
+		},
 		&cli.BoolFlag{
 			Name:    "yes",
 			Aliases: []string{"y"},
 			Value:   false,
 			Usage:   "Answer Yes to all confirmations",
 		},
+		&cli.BoolFlag{
+			Name: "select",
+			Usage: "After scanning, choose the albums to upload and edit their titles in an " +
+				"interactive list instead of confirming each album; with line based " +
+				"commands when not run in a terminal. Ignored with --yes.",
+		},
 		&cli.BoolFlag{
 			Name:    "dry-run",
 			Aliases: []string{"n"},
 			Value:   false,
-			Usage:   "Specify to just scan, not actually upload anything",
+			Usage: "Specify to just scan, not actually upload anything; the uploads are " +
+				"simulated into a local mirror in a temporary directory (or the one " +
+				"given with --mirror) for inspecting the result",
+		},
+		&cli.StringFlag{
+			Name: "mirror",
+			Usage: "Use a local mirror in this directory instead of Google Photos; " +
+				"albums become folders, uploaded files blobs and a manifest.json " +
+				"records the media items and albums",
+		},
+		&cli.StringFlag{
+			Name:  "destination",
+			Usage: "Upload into this destination instead of Google Photos: " + destination.Usage,
+		},
+		&cli.StringSliceFlag{
+			Name: "target",
+			Usage: "Upload into several targets in one run (may be repeated), each with its " +
+				"own concurrency and ledger; a failing target does not stop the others. " +
+				"Format: " + destination.TargetUsage,
 		},
 		&cli.IntFlag{
 			Name:    "concurrency",
@@ -216,6 +471,59 @@ func main() {
 			Usage:   "Maximum number of simultaneous uploads",
 			Value:   1,
 		},
+		&cli.IntFlag{
+			Name: "retries",
+			Usage: "How many times to retry an upload failing with a transient error " +
+				"(eg. a network failure, a server error or rate limiting), with an " +
+				"exponential backoff",
+			Value: 2,
+		},
+		&cli.IntFlag{
+			Name: "max-dimension",
+			Usage: "Scale the images down so that neither their width nor height " +
+				"exceeds this many pixels, eg. 2048",
+		},
+		&cli.IntFlag{
+			Name: "jpeg-quality",
+			Usage: "Re-encode the images with this JPEG quality (1-100); resized or " +
+				"rotated images are re-encoded with quality 90 by default",
+		},
+		&cli.BoolFlag{
+			Name:  "auto-orient",
+			Usage: "Rotate the images upright according to their EXIF orientation",
+		},
+		&cli.BoolFlag{
+			Name:  "strip-gps",
+			Usage: "Remove the location from all the uploaded images",
+		},
+		&cli.StringFlag{
+			Name: "home",
+			Usage: "Remove the location from the images taken within --home-radius of " +
+				"this location; 'latitude,longitude' in decimal degrees, eg. " +
+				"'60.1699,24.9384'",
+		},
+		&cli.Float64Flag{
+			Name:  "home-radius",
+			Usage: "Radius (km) around --home within which the location is removed",
+			Value: 1,
+		},
+		&cli.BoolFlag{
+			Name: "strip-personal",
+			Usage: "Remove the owner and author names, the camera / lens serial numbers " +
+				"and the maker notes from the uploaded images",
+		},
+		&cli.StringSliceFlag{
+			Name: "deny-tag",
+			Usage: "Fail the upload of a file that would contain a metadata tag matching " +
+				"this pattern (may be repeated); a tag name such as 'SerialNumber', or " +
+				"a group and a name such as 'XMP-dc:Creator', as listed by " +
+				"'exiftool -G1'. '*' matches any characters.",
+		},
+		&cli.StringFlag{
+			Name: "metrics-addr",
+			Usage: "Serve Prometheus metrics at http://<address>/metrics and the " +
+				"progress of the uploads as JSON at /status, eg. ':9090'",
+		},
 		&cli.StringFlag{
 			Name:    "folder-name-substitutions",
 			Aliases: []string{"s"},
@@ -229,12 +537,53 @@ func main() {
 		&cli.BoolFlag{
 			Name:  "no-parse-year",
 			Value: false,
-			Usage: "Do not attempt to parse the year from the directory " +
+			Usage: "Do not attempt to parse the date from the directory " +
 				"name; by default, an attempt is made to extract Photos Folder " +
-				"creation date using regex '.*[\\- _][0-9]{4}'. Eg. " +
+				"creation date from a full date ('2019-06-14', '20190614', '14.6.2019'), " +
+				"a year and month ('2019-06', 'June 2019', 'kesäkuu 2019'), a year " +
+				"range ('2018-2019') or a year anywhere in the name. Eg. " +
 				"'Pictures_from_Thailand-2009' would generate folder creation " +
 				"year 2009.",
 		},
+		&cli.StringSliceFlag{
+			Name: "date-pattern",
+			Usage: "Additional regular expression for parsing the album date from " +
+				"the directory name; may be given multiple times. Must contain a named " +
+				"group 'year' and may contain named groups 'month' (number or name) and " +
+				"'day', eg. '(?P<day>\\d\\d)(?P<month>\\d\\d)(?P<year>\\d{4})'.",
+		},
+		&cli.StringSliceFlag{
+			Name: "filename-pattern",
+			Usage: "Additional regular expression for parsing the time a photo was " +
+				"taken from its file name; may be given multiple times. Must contain " +
+				"named groups 'year', 'month' and 'day' and may contain 'hour', " +
+				"'minute', 'second' and 'ampm', eg. '^DSC(?P<year>\\d{4})(?P<month>\\d\\d)" +
+				"(?P<day>\\d\\d)'.",
+		},
+		&cli.StringFlag{
+			Name:  "date-sources",
+			Value: strings.Join(files.DefaultDateSources, ","),
+			Usage: "Comma separated sources of the photo dates in order of preference: " +
+				"'sidecar' (Takeout or XMP sidecar file), 'exif' (EXIF capture time), " +
+				"'filename' (eg. 'IMG-20190614-WA0003.jpg') and 'mtime' (file " +
+				"modification time). The first source with a date within the album " +
+				"date is used; if none has one, the album date is used.",
+		},
+		&cli.StringFlag{
+			Name: "timezone",
+			Usage: "Timezone the photos were taken in, eg. 'Asia/Tokyo'; by default " +
+				"the local timezone. The dates are written into the photos in this " +
+				"timezone along with the EXIF offset tags. Can be set per directory " +
+				"with 'timezone' in the album override file.",
+		},
+		&cli.StringSliceFlag{
+			Name: "clock-offset",
+			Usage: "How much the clock of a camera model was ahead of the correct " +
+				"time, eg. 'Canon EOS 5D=+1h23m'; may be given multiple times. The " +
+				"offset is subtracted from the EXIF and file name times of the photos " +
+				"taken with the camera. Can be set per directory with 'clock_offset' " +
+				"in the album override file.",
+		},
 		&cli.BoolFlag{
 			Name:  "capitalize",
 			Value: true,
@@ -243,11 +592,77 @@ func main() {
 				"would become 'Trip To Tonga, 2018'. Combine with " +
 				"folder-name-substitutions to clean up the directory names",
 		},
+		&cli.StringFlag{
+			Name:  "on-existing-album",
+			Value: files.ConflictSkip,
+			Usage: "What to do when an album with the same name already exists: " +
+				"'skip' the directory, 'append' the photos to the existing album " +
+				"(only possible for albums created by this app), create a new album " +
+				"with a numeric 'suffix' (eg. 'Trip (2)') or 'fail'",
+		},
+		&cli.BoolFlag{
+			Name:  "share",
+			Usage: "Share the created albums; the sharing URLs are listed in the report",
+		},
+		&cli.BoolFlag{
+			Name:  "share-collaborative",
+			Usage: "Allow others to add photos into the shared albums",
+		},
+		&cli.BoolFlag{
+			Name:  "share-commentable",
+			Usage: "Allow others to comment the shared albums",
+		},
+		&cli.StringFlag{
+			Name: "index-file",
+			Usage: "Write an index of the created albums with their (sharing) links " +
+				"into this file; as HTML if the name ends with .html, otherwise as Markdown",
+		},
+		&cli.StringSliceFlag{
+			Name: "include",
+			Usage: "gitignore style pattern of the directories / files to include; " +
+				"may be given multiple times. Patterns ending with '/' select album " +
+				"directories, others select files. Prefix a pattern with 're:' to " +
+				"use a regular expression matched against the path relative to the " +
+				"base directory.",
+		},
+		&cli.StringSliceFlag{
+			Name: "exclude",
+			Usage: "gitignore style pattern of the directories / files to exclude; " +
+				"may be given multiple times. Patterns may also be listed in " +
+				".photosignore files in the base directory or any directory below it. " +
+				"Hidden files and @eaDir / Thumbs directories are always excluded.",
+		},
 		&cli.BoolFlag{
 			Name:    "verbose",
 			Aliases: []string{"vv"},
 			Value:   false,
-			Usage:   "Specify to enable debug logging",
+			Usage:   "Specify to enable debug logging; same as --log-level debug",
+		},
+		&cli.StringFlag{
+			Name:  "log-level",
+			Value: "info",
+			Usage: "Minimum level of the logged events: debug, info, warn or error",
+		},
+		&cli.StringFlag{
+			Name:  "log-format",
+			Value: logging.FormatText,
+			Usage: "Format of the log: text, or json for one JSON object per event with " +
+				"fields such as album, file, bytes, duration and error_class",
+		},
+		&cli.StringFlag{
+			Name:  "log-file",
+			Usage: "Write the log into this file instead of stderr",
+		},
+		&cli.Int64Flag{
+			Name:  "log-max-size",
+			Value: 10,
+			Usage: "Rotate the log file when it grows beyond this many megabytes; 0 " +
+				"disables rotation",
+		},
+		&cli.IntFlag{
+			Name:  "log-max-backups",
+			Value: 3,
+			Usage: "Number of rotated log files to keep",
 		},
 	}
 
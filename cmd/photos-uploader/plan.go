@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/matti777/google-photos-uploader/internal/exiftool"
+	"github.com/matti777/google-photos-uploader/internal/files"
+	"github.com/matti777/google-photos-uploader/internal/util"
+)
+
+// Scans the base directory and writes the upload plan
+func planAction(c *cli.Context) error {
+	readFlags(c)
+
+	baseDir, err := resolveBaseDir(c)
+	if err != nil {
+		return err
+	}
+
+	plan := files.MakePlan(baseDir)
+	out := c.String("out")
+	if err := plan.Write(out); err != nil {
+		log.Fatalf("Failed to write the upload plan: %v", err)
+	}
+
+	plan.Print()
+	fmt.Printf("Upload plan written to %v; review it and run 'apply %v' to upload.\n",
+		out, out)
+
+	return nil
+}
+
+// Uploads the albums and files of an upload plan
+func applyAction(c *cli.Context) error {
+	readFlags(c)
+
+	exiftool.MustCheckExiftoolInstalled()
+
+	planFile := c.Args().Get(0)
+	if planFile == "" {
+		return cli.Exit("Must define the upload plan file!", -1)
+	}
+
+	plan, err := files.ReadPlan(planFile)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	var changed *files.PlanChangedError
+	if err := plan.Check(); errors.As(err, &changed) {
+		for _, f := range changed.Files {
+			fmt.Printf("  %v\n", f)
+		}
+		return cli.Exit(fmt.Sprintf("%v file(s) have changed since the plan was made; "+
+			"make a new plan", len(changed.Files)), 1)
+	} else if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	plan.Print()
+	albums, count := plan.Counts()
+	util.MustConfirm(fmt.Sprintf("About to upload %v file(s) into %v album(s) as planned "+
+		"in %v", count, albums, planFile), "")
+
+	return runUploads(c, func(targets []*files.Target) int {
+		return files.ApplyPlan(plan, targets)
+	})
+}
+
+func planCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "plan",
+		Usage:     "Write an upload plan of a directory for reviewing",
+		ArgsUsage: "directory",
+		Description: "Maps the directory into albums the same way as when uploading (so " +
+			"give the same --layout etc. options before the command name) and writes the " +
+			"albums and the files to upload with their titles, names and dates, and the " +
+			"skipped albums and files with the reasons, into a YAML plan file. Review and " +
+			"edit the plan, then upload it with 'apply'. Nothing is uploaded.",
+		Action: planAction,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "out",
+				Aliases: []string{"o"},
+				Value:   files.DefaultPlanFile,
+				Usage:   "Write the upload plan into this file",
+			},
+		},
+	}
+}
+
+func applyCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "apply",
+		Usage:     "Upload the albums and files of an upload plan",
+		ArgsUsage: "plan-file",
+		Description: "Uploads exactly the albums and files of a plan written by 'plan', " +
+			"with the titles, names, dates and order of the plan, asking for a single " +
+			"confirmation. Refuses to run if any of the files have been modified or " +
+			"removed since the plan was made, or if the directories of the albums have " +
+			"new files. The upload options (targets, privacy, image " +
+			"processing etc.) are given before the command name as usual.",
+		Action: applyAction,
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"github.com/urfave/cli/v2"
+
+	"github.com/matti777/google-photos-uploader/internal/files"
+	"github.com/matti777/google-photos-uploader/internal/selector"
+)
+
+// Reason of the albums left out in the selector
+const deselectedReason = "deselected"
+
+// Returns the selector items of the albums of a plan
+func selectorItems(plan *files.Plan) []*selector.Item {
+	items := make([]*selector.Item, 0, len(plan.Albums))
+
+	for _, a := range plan.Albums {
+		item := &selector.Item{Title: a.Title, Date: a.Date, Files: len(a.Files),
+			Renamable: !a.Library}
+		if a.Library {
+			item.Title = "(library)"
+		}
+		for _, f := range a.Files {
+			item.Bytes += f.Size
+		}
+
+		switch {
+		case a.Skip != "":
+			item.Disabled = "skipped: " + a.Skip
+		case len(a.Files) == 0:
+			item.Disabled = "no files to upload"
+		default:
+			item.Selected = true
+		}
+
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// Scans the base directory and lets the user select and rename the albums,
+// then uploads the selected ones
+func selectAndUpload(c *cli.Context, baseDir string) error {
+	plan := files.MakePlan(baseDir)
+	items := selectorItems(plan)
+
+	started, err := selector.Run(items)
+	if err != nil {
+		log.Fatalf("Failed to select the albums: %v", err)
+	}
+	if !started {
+		return cli.Exit("Cancelled", 1)
+	}
+
+	for i, a := range plan.Albums {
+		if a.Skip != "" {
+			continue
+		}
+		if !items[i].Selected {
+			a.Skip = deselectedReason
+		} else if !a.Library {
+			a.Title = items[i].Title
+		}
+	}
+
+	plan.Print()
+
+	return runUploads(c, func(targets []*files.Target) int {
+		return files.ApplyPlan(plan, targets)
+	})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/matti777/google-photos-uploader/internal/files"
+	photos "github.com/matti777/google-photos-uploader/internal/googlephotos"
+)
+
+// Finds an album by its title or ID from the list of existing albums
+func findAlbumByTitleOrID(titleOrID string) *photos.Album {
+	if a := settings.FindAlbum(titleOrID); a != nil {
+		return a
+	}
+
+	for _, a := range settings.Albums {
+		if a.ID == titleOrID {
+			return a
+		}
+	}
+
+	return nil
+}
+
+// Shares the given albums or, if none given, lists the shared albums.
+func shareAction(c *cli.Context) error {
+	mustInitClient(c, true)
+	mustFetchAlbums()
+
+	reports := []*files.AlbumReport{}
+
+	if c.NArg() == 0 {
+		albums, err := photos.MustGetClient().ListSharedAlbums()
+		if err != nil {
+			log.Fatalf("Failed to list shared albums: %v", err)
+		}
+
+		for _, a := range albums {
+			reports = append(reports, files.NewAlbumReport(a, 0))
+		}
+	}
+
+	for _, titleOrID := range c.Args().Slice() {
+		album := findAlbumByTitleOrID(titleOrID)
+		if album == nil {
+			return cli.Exit(fmt.Sprintf("Album '%v' not found", titleOrID), 1)
+		}
+
+		if album.ShareInfo == nil {
+			fmt.Printf("Sharing album: %v\n", album.Title)
+			if _, err := photos.MustGetClient().ShareAlbum(album, c.Bool("collaborative"),
+				c.Bool("commentable")); err != nil {
+				log.Fatalf("Failed to share album '%v' (only albums created by this "+
+					"app can be shared): %v", album.Title, err)
+			}
+		} else {
+			fmt.Printf("Album '%v' is already shared\n", album.Title)
+		}
+
+		reports = append(reports, files.NewAlbumReport(album, 0))
+	}
+
+	fmt.Printf("%v shared album(s):\n", len(reports))
+	files.PrintReport(reports)
+
+	if path := c.String("index-file"); path != "" {
+		if err := files.WriteIndex(path, reports); err != nil {
+			log.Fatalf("Failed to write album index: %v", err)
+		}
+		fmt.Printf("Album index written to %v\n", path)
+	}
+
+	return nil
+}
+
+func shareCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "share",
+		Usage:     "Share albums or list the shared albums",
+		ArgsUsage: "[album title or ID ...]",
+		Description: "Shares the given albums and prints their sharing URLs. Only albums " +
+			"created by this app can be shared. Without arguments, lists all the shared albums.",
+		Action: shareAction,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "collaborative",
+				Usage: "Allow others to add photos into the shared albums",
+			},
+			&cli.BoolFlag{
+				Name:  "commentable",
+				Usage: "Allow others to comment the shared albums",
+			},
+			&cli.StringFlag{
+				Name: "index-file",
+				Usage: "Write an index of the albums with their sharing links into this " +
+					"file; as HTML if the name ends with .html, otherwise as Markdown",
+			},
+		},
+	}
+}
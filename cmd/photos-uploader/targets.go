@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/matti777/google-photos-uploader/internal/destination"
+	"github.com/matti777/google-photos-uploader/internal/files"
+	photos "github.com/matti777/google-photos-uploader/internal/googlephotos"
+	"github.com/matti777/google-photos-uploader/internal/metrics"
+	"github.com/matti777/google-photos-uploader/internal/mirror"
+)
+
+// Creates the client of a --target; authorizes its Google account first if needed
+func mustCreateTargetClient(c *cli.Context, t *destination.Target) photos.Client {
+	if t.Destination != "" {
+		client, err := destination.Open(t.Destination)
+		if err != nil {
+			log.Fatalf("Failed to open target %v: %v", t.Name, err)
+		}
+		return client
+	}
+
+	cfg := mustAuthorizeProfile(c, t.Profile, true)
+	client, err := photos.NewClient(cfg.ClientID, cfg.ClientSecret, cfg.AuthToken)
+	if err != nil {
+		log.Fatalf("Failed to create Google Photos client for target %v: %v", t.Name, err)
+	}
+
+	return client
+}
+
+// Creates a local mirror for simulating a dry run; in a temporary directory
+// if dir is empty. Returns the mirror and its directory.
+func mustCreateDryRunMirror(dir string) (*mirror.Mirror, string) {
+	if dir == "" {
+		var err error
+		if dir, err = os.MkdirTemp("", "photos-uploader-dry-run-"); err != nil {
+			log.Fatalf("Failed to create temporary directory: %v", err)
+		}
+	}
+
+	m, err := mirror.New(dir)
+	if err != nil {
+		log.Fatalf("Failed to create local mirror '%v': %v", dir, err)
+	}
+
+	return m, dir
+}
+
+// Creates the upload targets: the ones given with --target, or otherwise the
+// single destination given with --mirror / --destination or Google Photos. In
+// dry run mode the uploads are simulated into local mirrors, one per target.
+// Returns the targets and the directory of the dry run mirrors, if any.
+func mustCreateTargets(c *cli.Context) ([]*files.Target, string) {
+	specs := c.StringSlice("target")
+
+	if len(specs) == 0 {
+		if settings.DryRun && c.String("mirror") == "" {
+			m, dir := mustCreateDryRunMirror("")
+			photos.SetClient(m)
+			return []*files.Target{{Client: m}}, dir
+		}
+
+		return []*files.Target{{Client: mustInitClient(c, true)}}, ""
+	}
+
+	if c.IsSet("mirror") || c.IsSet("destination") {
+		log.Fatalf("--target cannot be combined with --mirror or --destination")
+	}
+
+	parsed, err := destination.ParseTargets(specs)
+	if err != nil {
+		log.Fatalf("Invalid --target: %v", err)
+	}
+
+	targets := make([]*files.Target, 0, len(parsed))
+	dryRunDir := ""
+
+	for _, t := range parsed {
+		target := &files.Target{Name: t.Name, Concurrency: t.Concurrency}
+
+		if settings.DryRun {
+			if dryRunDir == "" {
+				if dryRunDir, err = os.MkdirTemp("", "photos-uploader-dry-run-"); err != nil {
+					log.Fatalf("Failed to create temporary directory: %v", err)
+				}
+			}
+			target.Client, _ = mustCreateDryRunMirror(filepath.Join(dryRunDir, t.Name))
+		} else {
+			target.Client = mustCreateTargetClient(c, t)
+		}
+
+		targets = append(targets, target)
+	}
+
+	return targets, dryRunDir
+}
+
+// Uploads the base directory into the targets; returns an error (exit
+// status 1) if any of the targets failed.
+func uploadBaseDir(c *cli.Context, baseDir string) error {
+	return runUploads(c, func(targets []*files.Target) int {
+		return files.ProcessBaseDir(baseDir, targets)
+	})
+}
+
+// Runs the uploads into the targets, serving the metrics if requested;
+// upload returns the number of failed targets. Returns an error (exit status
+// 1) if any of the targets failed.
+func runUploads(c *cli.Context, upload func(targets []*files.Target) int) error {
+	if addr := c.String("metrics-addr"); addr != "" {
+		server, err := metrics.Serve(addr, metrics.Default, func() any { return files.Status() })
+		if err != nil {
+			log.Fatalf("Failed to serve metrics: %v", err)
+		}
+		defer server.Close()
+		log.Infof("Serving metrics at http://%v/metrics and the status at /status",
+			server.Addr())
+	}
+
+	targets, dryRunDir := mustCreateTargets(c)
+	if dryRunDir != "" && len(targets) > 1 {
+		defer fmt.Printf("Dry run: the uploads were simulated into local mirrors in %v\n",
+			dryRunDir)
+	} else if dryRunDir != "" {
+		defer fmt.Printf("Dry run: the uploads were simulated into local mirror %v\n", dryRunDir)
+	}
+
+	if failed := upload(targets); failed > 0 {
+		return cli.Exit(fmt.Sprintf("%v of %v target(s) failed", failed, len(targets)), 1)
+	}
+
+	return nil
+}
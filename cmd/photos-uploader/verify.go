@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/matti777/google-photos-uploader/internal/files"
+)
+
+// Verifies the albums of the base directory against Google Photos; exits
+// with a non-zero status if there are discrepancies.
+func verifyAction(c *cli.Context) error {
+	readFlags(c)
+
+	baseDir, err := resolveBaseDir(c)
+	if err != nil {
+		return err
+	}
+
+	mustInitClient(c, false)
+	mustFetchAlbums()
+
+	if failed := files.VerifyBaseDir(baseDir); failed > 0 {
+		return cli.Exit(fmt.Sprintf("%v album(s) do not match", failed), 1)
+	}
+
+	return nil
+}
+
+func verifyCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "verify",
+		Usage:     "Verify that the albums of a directory have been completely uploaded",
+		ArgsUsage: "directory",
+		Description: "Maps the directory into albums the same way as when uploading (so " +
+			"give the same --layout etc. options before the command name) and compares " +
+			"each album against Google Photos. The files are matched to the media items " +
+			"by the upload ledger and by file name. Prints the differences and exits with " +
+			"status 1 if any album is missing or does not match.",
+		Action: verifyAction,
+	}
+}
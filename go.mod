@@ -7,12 +7,16 @@ require (
 	github.com/dsoprea/go-jpeg-image-structure/v2 v2.0.0-20221012074422-4f3f7e934102
 	github.com/google/uuid v1.1.1
 	github.com/gorilla/mux v1.7.1
-	github.com/gosuri/uiprogress v0.0.1
+	github.com/gosuri/uilive v0.0.4
+	github.com/mattn/go-isatty v0.0.19
 	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.9.3
 	github.com/urfave/cli/v2 v2.25.7
+	golang.org/x/net v0.12.0
 	golang.org/x/oauth2 v0.0.0-20190402181905-9f3314589c9a
+	golang.org/x/sys v0.10.0
 	google.golang.org/api v0.3.2
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
@@ -26,12 +30,7 @@ require (
 	github.com/go-xmlfmt/xmlfmt v1.1.2 // indirect
 	github.com/golang/geo v0.0.0-20230421003525-6adc56603217 // indirect
 	github.com/golang/protobuf v1.2.0 // indirect
-	github.com/gosuri/uilive v0.0.4 // indirect
-	github.com/mattn/go-isatty v0.0.19 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/xrash/smetrics v0.0.0-20201216005158-039620a65673 // indirect
-	golang.org/x/net v0.12.0 // indirect
-	golang.org/x/sys v0.10.0 // indirect
 	google.golang.org/appengine v1.4.0 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
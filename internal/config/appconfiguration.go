@@ -36,20 +36,38 @@ var (
 
 // Returns the path to the app config file. Panics on failure.
 func MustGetAppConfigPath() string {
+	return MustGetProfileConfigPath("")
+}
+
+// Returns the path to the app config file of a profile (an additional Google
+// account, eg. ~/.photos-uploader.work.config); the default one if profile
+// is empty. Panics on failure.
+func MustGetProfileConfigPath(profile string) string {
 	u, err := user.Current()
 	if err != nil {
 		log.Fatalf("Failed to get current user: %v", err)
 	}
 
-	return filepath.Join(u.HomeDir, appConfigFilename)
+	if profile == "" {
+		return filepath.Join(u.HomeDir, appConfigFilename)
+	}
+
+	return filepath.Join(u.HomeDir, strings.TrimSuffix(appConfigFilename, ".config")+
+		"."+profile+".config")
 }
 
 // Reads the app configuration file. If the file is not found, returns
 // an empty config
 func ReadAppConfig() *AppConfiguration {
+	return ReadProfileConfig("")
+}
+
+// Reads the app configuration file of a profile. If the file is not found,
+// returns an empty config
+func ReadProfileConfig(profile string) *AppConfiguration {
 	var cfg AppConfiguration
 
-	appCfgFilePath := MustGetAppConfigPath()
+	appCfgFilePath := MustGetProfileConfigPath(profile)
 	log.Debugf("Reading application configuration file %v", appCfgFilePath)
 
 	file, err := os.Open(appCfgFilePath)
@@ -75,7 +93,12 @@ func ReadAppConfig() *AppConfiguration {
 
 // Write the app configuration file. Panics on failure.
 func MustWriteAppConfig(c *AppConfiguration) {
-	appCfgFilePath := MustGetAppConfigPath()
+	MustWriteProfileConfig("", c)
+}
+
+// Write the app configuration file of a profile. Panics on failure.
+func MustWriteProfileConfig(profile string, c *AppConfiguration) {
+	appCfgFilePath := MustGetProfileConfigPath(profile)
 	log.Debugf("Writing application configuration file %v", appCfgFilePath)
 
 	file, err := os.Create(appCfgFilePath)
@@ -121,7 +144,7 @@ func MustReadAppCredentials() (string, string) {
 		clientSecret = strings.Trim(clientSecret, " \n")
 	}
 
-	log.Debug("Read app credentials: %v, %v", clientID, clientSecret)
+	log.Debugf("Read app credentials: %v, %v", clientID, clientSecret)
 
 	return clientID, clientSecret
 }
@@ -2,8 +2,11 @@ package config
 
 import (
 	"sync"
+	"time"
 
 	photos "github.com/matti777/google-photos-uploader/internal/googlephotos"
+	"github.com/matti777/google-photos-uploader/internal/imageproc"
+	"github.com/matti777/google-photos-uploader/internal/privacy"
 )
 
 type Settings struct {
@@ -19,6 +22,24 @@ type Settings struct {
 	// file date will be used as the EXIF date.
 	NoParseYear bool
 
+	// User supplied regexes for parsing album dates from folder names
+	DatePatterns []string
+
+	// User supplied regexes for parsing photo times from file names
+	FilenamePatterns []string
+
+	// Sources of the photo dates in order of preference (sidecar, exif,
+	// filename, mtime); the default order if empty
+	DateSources []string
+
+	// Timezone the photos were taken in unless set for their directory; nil
+	// for the local timezone
+	Timezone *time.Location
+
+	// How much the camera clocks were ahead of the correct time by their
+	// (EXIF) camera model, unless set for the directory of the photos
+	ClockOffsets map[string]time.Duration
+
 	// Whether to skip (assume Yes) all confirmations)
 	SkipConfirmation bool
 
@@ -28,9 +49,61 @@ type Settings struct {
 	// Whether to recurse into subdirectories
 	Recurse bool
 
+	// Album layout; how the scanned files are mapped into albums
+	Layout string
+
+	// Album name for the single album layout
+	AlbumName string
+
+	// Maximum time between two consecutive photos of the same event in the
+	// events album layout
+	EventGap time.Duration
+
+	// Maximum distance (km) between two consecutive photos of the same event in
+	// the events album layout; 0 to not split events by location
+	EventDistanceKm float64
+
+	// Order of the photos in the albums (name, time or manifest)
+	Order string
+
 	// Maximum concurrency (number of simultaneous uploads)
 	MaxConcurrency int
 
+	// How many times a failed upload is retried after a transient error, eg.
+	// a network failure or a server error
+	UploadRetries int
+
+	// Processing of the images before uploading them (resizing, re-encoding,
+	// rotating and stripping metadata)
+	Processing imageproc.Options
+
+	// Privacy policy of the uploaded files; the metadata tags to remove and
+	// the ones that must not be uploaded
+	Privacy privacy.Policy
+
+	// What to do when an album with the same name already exists
+	// (skip, append, suffix or fail)
+	ConflictPolicy string
+
+	// Whether to share the created albums
+	Share bool
+
+	// Whether others may add photos into / comment the shared albums
+	ShareCollaborative bool
+	ShareCommentable   bool
+
+	// Path of the Markdown / HTML index file of the created albums to write;
+	// empty for none
+	IndexFile string
+
+	// gitignore style patterns (or regexes prefixed with 're:') of the
+	// directories / files to include; if empty, everything is included
+	Include []string
+
+	// gitignore style patterns (or regexes prefixed with 're:') of the
+	// directories / files to exclude
+	Exclude []string
+
 	// List of albums
 	// TODO this may need to change
 	Albums []*photos.Album
@@ -58,6 +131,20 @@ func MustGetSettings() *Settings {
 	return settings
 }
 
+// FindAlbums returns all the albums with the given name; Google Photos allows
+// several albums to have the same name.
+func (s *Settings) FindAlbums(name string) []*photos.Album {
+	res := []*photos.Album{}
+
+	for _, a := range s.Albums {
+		if a.Title == name {
+			res = append(res, a)
+		}
+	}
+
+	return res
+}
+
 func (s *Settings) FindAlbum(name string) *photos.Album {
 	log.Debugf("Trying to find existing album with name '%v'", name)
 
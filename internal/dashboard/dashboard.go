@@ -0,0 +1,506 @@
+// Package dashboard displays the progress of the uploads: a live view of the
+// totals, throughput, ETA, active uploads and recent failures when stdout is a
+// terminal. Otherwise the progress is only logged, by the caller.
+package dashboard
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gosuri/uilive"
+	"github.com/mattn/go-isatty"
+
+	"github.com/matti777/google-photos-uploader/internal/logging"
+	"github.com/matti777/google-photos-uploader/internal/util"
+)
+
+// How often the live view is redrawn
+const refreshInterval = 200 * time.Millisecond
+
+// Number of recent failures shown in the live view
+const maxFailures = 5
+
+// Width of the progress bars of the active uploads
+const barWidth = 20
+
+// Dashboard displays the progress of the uploads. The totals accumulate over
+// the albums of the run. All the methods are safe to call on a nil Dashboard.
+type Dashboard struct {
+	out io.Writer
+
+	// Whether to redraw a live view instead of printing lines
+	live    bool
+	writer  *uilive.Writer
+	done    chan struct{}
+	stopped sync.WaitGroup
+
+	// Restores the console log redirected above the live view
+	restoreConsole func()
+
+	// Returns the current time; replaced in tests
+	now func() time.Time
+
+	lock sync.Mutex
+
+	// Current album, when its uploads started and whether they are running
+	album   string
+	started time.Time
+	running bool
+
+	// Time spent uploading in the previous albums
+	elapsed time.Duration
+
+	totalFiles, doneFiles, failedFiles int
+	totalBytes, doneBytes              int64
+
+	// Counts at the start of the current album
+	albumFiles, albumFailed int
+	albumBytes              int64
+
+	// Progress of each target, in the order they were started; the totals
+	// above are the sums over the targets
+	targets []*targetProgress
+
+	active   []*Upload
+	failures []string
+}
+
+// Totals is the number and size of the files to upload into a target
+type Totals struct {
+	// Name of the target; empty for the single default target
+	Target string
+
+	Files int
+	Bytes int64
+}
+
+// targetProgress is the progress of the uploads into a target
+type targetProgress struct {
+	name                               string
+	totalFiles, doneFiles, failedFiles int
+	totalBytes, doneBytes              int64
+}
+
+// Summary summarizes the uploads of an album
+type Summary struct {
+	Files, Failed int
+	Bytes         int64
+	Duration      time.Duration
+}
+
+// Upload is a file being uploaded
+type Upload struct {
+	d      *Dashboard
+	target *targetProgress
+	label  string
+	size   int64
+	bytes  int64
+}
+
+// New creates a dashboard writing to stdout; with a live view if stdout is a
+// terminal.
+func New() *Dashboard {
+	fd := os.Stdout.Fd()
+	live := isatty.IsTerminal(fd) || isatty.IsCygwinTerminal(fd)
+
+	return newDashboard(os.Stdout, live)
+}
+
+func newDashboard(out io.Writer, live bool) *Dashboard {
+	return &Dashboard{out: out, live: live, now: time.Now}
+}
+
+// Start starts displaying the uploads of an album of the given number of files
+// and bytes. An empty album name means the library.
+func (d *Dashboard) Start(album string, files int, bytes int64) {
+	d.StartTargets(album, []Totals{{Files: files, Bytes: bytes}})
+}
+
+// StartTargets starts displaying the uploads of an album into one or more
+// targets; with more than one, the progress is displayed per target.
+func (d *Dashboard) StartTargets(album string, totals []Totals) {
+	if d == nil {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.album = album
+	d.started = d.now()
+	d.running = true
+	for _, t := range totals {
+		p := d.target(t.Target)
+		p.totalFiles += t.Files
+		p.totalBytes += t.Bytes
+		d.totalFiles += t.Files
+		d.totalBytes += t.Bytes
+	}
+	d.albumFiles, d.albumFailed, d.albumBytes = d.doneFiles, d.failedFiles, d.doneBytes
+
+	if !d.live {
+		return
+	}
+
+	d.writer = uilive.New()
+	d.writer.Out = d.out
+	d.restoreConsole = logging.RedirectConsole(d.writer.Bypass())
+	d.done = make(chan struct{})
+	d.stopped.Add(1)
+	go d.refresh(d.writer, d.done)
+}
+
+// Stop stops displaying the uploads of the current album, clearing the live
+// view. Returns the summary of the album's uploads.
+func (d *Dashboard) Stop() Summary {
+	if d == nil {
+		return Summary{}
+	}
+
+	d.lock.Lock()
+	writer, done, restore := d.writer, d.done, d.restoreConsole
+	d.writer, d.done, d.restoreConsole = nil, nil, nil
+	d.lock.Unlock()
+
+	if writer != nil {
+		close(done)
+		d.stopped.Wait()
+		restore()
+		// Clear the live view
+		writer.Bypass().Write(nil)
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	elapsed := d.now().Sub(d.started)
+	d.elapsed += elapsed
+	d.running = false
+	d.active = nil
+
+	return Summary{Files: d.doneFiles - d.albumFiles, Failed: d.failedFiles - d.albumFailed,
+		Bytes: d.doneBytes - d.albumBytes, Duration: elapsed}
+}
+
+// Live tells whether the live view is displayed; if not, the progress of the
+// uploads should be logged.
+func (d *Dashboard) Live() bool {
+	return d != nil && d.live
+}
+
+// target returns the progress of a target, adding it if new; must be called
+// with the lock held.
+func (d *Dashboard) target(name string) *targetProgress {
+	for _, p := range d.targets {
+		if p.name == name {
+			return p
+		}
+	}
+
+	p := &targetProgress{name: name}
+	d.targets = append(d.targets, p)
+
+	return p
+}
+
+// StartUpload starts displaying the upload of a file of the given size
+func (d *Dashboard) StartUpload(label string, size int64) *Upload {
+	return d.StartTargetUpload("", label, size)
+}
+
+// StartTargetUpload starts displaying the upload of a file of the given size
+// into a target
+func (d *Dashboard) StartTargetUpload(target, label string, size int64) *Upload {
+	u := &Upload{d: d, label: label, size: size}
+	if d == nil {
+		return u
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	u.target = d.target(target)
+	d.active = append(d.active, u)
+
+	return u
+}
+
+// Set sets the number of bytes uploaded
+func (u *Upload) Set(bytes int64) {
+	if u.d == nil {
+		return
+	}
+
+	u.d.lock.Lock()
+	defer u.d.lock.Unlock()
+
+	if bytes > u.size {
+		bytes = u.size
+	}
+	u.bytes = bytes
+}
+
+// Done ends the upload, successfully if err is nil
+func (u *Upload) Done(err error) {
+	d := u.d
+	if d == nil {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for i, a := range d.active {
+		if a == u {
+			d.active = append(d.active[:i], d.active[i+1:]...)
+			break
+		}
+	}
+
+	if err != nil {
+		d.failedFiles++
+		u.target.failedFiles++
+		failure := fmt.Sprintf("%v: %v", strings.TrimSpace(u.label), err)
+		d.failures = append(d.failures, failure)
+		if len(d.failures) > maxFailures {
+			d.failures = d.failures[1:]
+		}
+		return
+	}
+
+	d.doneFiles++
+	d.doneBytes += u.size
+	u.target.doneFiles++
+	u.target.doneBytes += u.size
+}
+
+// refresh redraws the live view until done is closed
+func (d *Dashboard) refresh(writer *uilive.Writer, done chan struct{}) {
+	defer d.stopped.Done()
+
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		d.lock.Lock()
+		writer.Write([]byte(d.render()))
+		d.lock.Unlock()
+		writer.Flush()
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// albumName returns the description of the current album
+func (d *Dashboard) albumName() string {
+	if d.album == "" {
+		return "without an album"
+	}
+
+	return fmt.Sprintf("to album '%v'", d.album)
+}
+
+// progress returns the time spent uploading the current album, the bytes
+// uploaded so far, the throughput (bytes per second) and the estimated time
+// remaining (0 if not known); must be called with the lock held.
+func (d *Dashboard) progress() (time.Duration, int64, float64, time.Duration) {
+	var elapsed time.Duration
+	if d.running {
+		elapsed = d.now().Sub(d.started)
+	}
+	bytes := d.doneBytes
+	for _, u := range d.active {
+		bytes += u.bytes
+	}
+
+	var speed float64
+	if total := d.elapsed + elapsed; total > 0 {
+		speed = float64(bytes) / total.Seconds()
+	}
+
+	var remaining time.Duration
+	if speed > 0 && d.totalBytes > bytes {
+		remaining = time.Duration(float64(d.totalBytes-bytes) / speed * float64(time.Second))
+	}
+
+	return elapsed, bytes, speed, remaining
+}
+
+// Status is the progress of the uploads
+type Status struct {
+	// Whether an album is being uploaded
+	Running bool `json:"running"`
+
+	// Album being (or last) uploaded; empty for the library
+	Album string `json:"album"`
+
+	Files       int   `json:"files"`
+	FilesDone   int   `json:"filesDone"`
+	FilesFailed int   `json:"filesFailed"`
+	Bytes       int64 `json:"bytes"`
+	BytesDone   int64 `json:"bytesDone"`
+
+	BytesPerSecond float64 `json:"bytesPerSecond"`
+	ETASeconds     float64 `json:"etaSeconds,omitempty"`
+
+	Active         []UploadStatus `json:"active"`
+	RecentFailures []string       `json:"recentFailures"`
+
+	// Progress of each target when uploading into more than one; the counts
+	// above are the sums over the targets
+	Targets []TargetStatus `json:"targets,omitempty"`
+}
+
+// TargetStatus is the progress of the uploads into a target
+type TargetStatus struct {
+	Target      string `json:"target"`
+	Files       int    `json:"files"`
+	FilesDone   int    `json:"filesDone"`
+	FilesFailed int    `json:"filesFailed"`
+	Bytes       int64  `json:"bytes"`
+	BytesDone   int64  `json:"bytesDone"`
+}
+
+// UploadStatus is the progress of an active upload
+type UploadStatus struct {
+	File      string `json:"file"`
+	Bytes     int64  `json:"bytes"`
+	BytesDone int64  `json:"bytesDone"`
+}
+
+// Status returns the progress of the uploads
+func (d *Dashboard) Status() Status {
+	if d == nil {
+		return Status{Active: []UploadStatus{}, RecentFailures: []string{}}
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	_, bytes, speed, remaining := d.progress()
+	s := Status{Running: d.running, Album: d.album,
+		Files: d.totalFiles, FilesDone: d.doneFiles, FilesFailed: d.failedFiles,
+		Bytes: d.totalBytes, BytesDone: bytes, BytesPerSecond: speed,
+		ETASeconds: remaining.Seconds(), Active: []UploadStatus{},
+		RecentFailures: append([]string{}, d.failures...)}
+	for _, u := range d.active {
+		s.Active = append(s.Active, UploadStatus{File: u.label, Bytes: u.size,
+			BytesDone: u.bytes})
+	}
+	if len(d.targets) > 1 {
+		for _, p := range d.targets {
+			s.Targets = append(s.Targets, TargetStatus{Target: p.name,
+				Files: p.totalFiles, FilesDone: p.doneFiles, FilesFailed: p.failedFiles,
+				Bytes: p.totalBytes, BytesDone: d.targetBytes(p)})
+		}
+	}
+
+	return s
+}
+
+// render returns the live view; must be called with the lock held
+func (d *Dashboard) render() string {
+	var b strings.Builder
+
+	elapsed, bytes, speed, remaining := d.progress()
+	eta := "-"
+	if remaining > 0 {
+		eta = remaining.Round(time.Second).String()
+	}
+
+	fmt.Fprintf(&b, "Uploading %v\n", d.albumName())
+	if len(d.targets) > 1 {
+		d.renderTargets(&b)
+	} else {
+		fmt.Fprintf(&b, "Files:   %v/%v", d.doneFiles, d.totalFiles)
+		if d.failedFiles > 0 {
+			fmt.Fprintf(&b, " (%v failed)", d.failedFiles)
+		}
+		fmt.Fprintf(&b, "\nBytes:   %v / %v %v\n", util.FormatBytes(bytes),
+			util.FormatBytes(d.totalBytes), percent(bytes, d.totalBytes))
+	}
+	fmt.Fprintf(&b, "Speed:   %v/s   Elapsed: %v   ETA: %v\n", util.FormatBytes(int64(speed)),
+		elapsed.Round(time.Second), eta)
+
+	if len(d.active) > 0 {
+		padLength := 0
+		for _, u := range d.active {
+			if len(u.label) > padLength {
+				padLength = len(u.label)
+			}
+		}
+
+		fmt.Fprintf(&b, "Active:\n")
+		for _, u := range d.active {
+			filled := 0
+			if u.size > 0 {
+				filled = int(u.bytes * barWidth / u.size)
+			}
+			fmt.Fprintf(&b, "  %-*v [%v%v] %v\n", padLength, u.label,
+				strings.Repeat("#", filled), strings.Repeat(" ", barWidth-filled),
+				percent(u.bytes, u.size))
+		}
+	}
+
+	if len(d.failures) > 0 {
+		fmt.Fprintf(&b, "Recent failures:\n")
+		for _, f := range d.failures {
+			fmt.Fprintf(&b, "  %v\n", f)
+		}
+	}
+
+	return b.String()
+}
+
+// percent formats n as a percentage of total
+func percent(n, total int64) string {
+	if total <= 0 {
+		return "100%"
+	}
+
+	return fmt.Sprintf("%3d%%", n*100/total)
+}
+
+// targetBytes returns the bytes uploaded into a target so far, including the
+// active uploads; must be called with the lock held.
+func (d *Dashboard) targetBytes(p *targetProgress) int64 {
+	bytes := p.doneBytes
+	for _, u := range d.active {
+		if u.target == p {
+			bytes += u.bytes
+		}
+	}
+
+	return bytes
+}
+
+// renderTargets renders the files and bytes of each target; must be called
+// with the lock held.
+func (d *Dashboard) renderTargets(b *strings.Builder) {
+	padLength := 0
+	for _, p := range d.targets {
+		if len(p.name) > padLength {
+			padLength = len(p.name)
+		}
+	}
+
+	fmt.Fprintf(b, "Targets:\n")
+	for _, p := range d.targets {
+		bytes := d.targetBytes(p)
+		fmt.Fprintf(b, "  %-*v %v/%v files, %v / %v %v", padLength+1, p.name+":",
+			p.doneFiles, p.totalFiles, util.FormatBytes(bytes), util.FormatBytes(p.totalBytes),
+			percent(bytes, p.totalBytes))
+		if p.failedFiles > 0 {
+			fmt.Fprintf(b, " (%v failed)", p.failedFiles)
+		}
+		b.WriteString("\n")
+	}
+}
@@ -0,0 +1,164 @@
+package dashboard
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matti777/google-photos-uploader/internal/logging"
+)
+
+// Returns a clock advanced by calling the returned function
+func fakeClock() (func() time.Time, func(time.Duration)) {
+	now := time.Date(2019, 6, 14, 12, 0, 0, 0, time.UTC)
+	return func() time.Time { return now }, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestSummary(t *testing.T) {
+	var out bytes.Buffer
+	d := newDashboard(&out, false)
+	now, advance := fakeClock()
+	d.now = now
+
+	d.Start("Trip 2019", 2, 3000)
+	u := d.StartUpload("a.jpg", 1000)
+	u.Set(500)
+	u.Done(nil)
+	d.StartUpload("b.jpg", 2000).Done(errors.New("connection reset"))
+	advance(3 * time.Second)
+
+	expected := Summary{Files: 1, Failed: 1, Bytes: 1000, Duration: 3 * time.Second}
+	if s := d.Stop(); s != expected {
+		t.Errorf("expected %+v, got %+v", expected, s)
+	}
+
+	d.Start("", 1, 10)
+	d.StartUpload("c.jpg", 10).Done(nil)
+	expected = Summary{Files: 1, Bytes: 10}
+	if s := d.Stop(); s != expected {
+		t.Errorf("expected %+v, got %+v", expected, s)
+	}
+
+	if d.totalFiles != 3 || d.doneFiles != 2 || d.failedFiles != 1 {
+		t.Errorf("unexpected totals: %v files, %v done, %v failed", d.totalFiles,
+			d.doneFiles, d.failedFiles)
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected no output without the live view, got:\n%v", out.String())
+	}
+}
+
+func TestRender(t *testing.T) {
+	d := newDashboard(&bytes.Buffer{}, false)
+	now, advance := fakeClock()
+	d.now = now
+
+	d.Start("Trip 2019", 4, 4000000)
+	d.StartUpload("a.jpg", 1000000).Done(nil)
+	d.StartUpload("b.jpg", 1000000).Done(errors.New("quota exceeded"))
+	d.StartUpload("long-name.jpg", 1000000).Set(500000)
+	advance(2 * time.Second)
+
+	expected := "Uploading to album 'Trip 2019'\n" +
+		"Files:   1/4 (1 failed)\n" +
+		"Bytes:   1.5 MB / 4.0 MB  37%\n" +
+		"Speed:   750.0 kB/s   Elapsed: 2s   ETA: 3s\n" +
+		"Active:\n" +
+		"  long-name.jpg [##########          ]  50%\n" +
+		"Recent failures:\n" +
+		"  b.jpg: quota exceeded\n"
+	if s := d.render(); s != expected {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, s)
+	}
+}
+
+func TestRenderTargets(t *testing.T) {
+	d := newDashboard(&bytes.Buffer{}, false)
+	now, advance := fakeClock()
+	d.now = now
+
+	d.StartTargets("Trip 2019", []Totals{{Target: "home", Files: 2, Bytes: 2000000},
+		{Target: "backup", Files: 2, Bytes: 2000000}})
+	d.StartTargetUpload("home", "home: a.jpg", 1000000).Done(nil)
+	d.StartTargetUpload("backup", "backup: a.jpg", 1000000).Done(errors.New("quota exceeded"))
+	d.StartTargetUpload("home", "home: b.jpg", 1000000).Set(500000)
+	advance(time.Second)
+
+	expected := "Uploading to album 'Trip 2019'\n" +
+		"Targets:\n" +
+		"  home:   1/2 files, 1.5 MB / 2.0 MB  75%\n" +
+		"  backup: 0/2 files, 0 B / 2.0 MB   0% (1 failed)\n" +
+		"Speed:   1.5 MB/s   Elapsed: 1s   ETA: 2s\n" +
+		"Active:\n" +
+		"  home: b.jpg [##########          ]  50%\n" +
+		"Recent failures:\n" +
+		"  backup: a.jpg: quota exceeded\n"
+	if s := d.render(); s != expected {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, s)
+	}
+
+	s := d.Status()
+	if s.Files != 4 || len(s.Targets) != 2 || s.Targets[0].FilesDone != 1 ||
+		s.Targets[0].BytesDone != 1500000 || s.Targets[1].FilesFailed != 1 ||
+		s.Targets[1].Bytes != 2000000 {
+		t.Errorf("unexpected status: %+v", s)
+	}
+}
+
+func TestLive(t *testing.T) {
+	var out bytes.Buffer
+	d := newDashboard(&out, true)
+
+	d.Start("Trip 2019", 1, 100)
+	u := d.StartUpload("a.jpg", 100)
+	u.Set(100)
+	u.Done(nil)
+	logging.MustGetLogger().Warn("Sharing albums is not supported")
+	if s := d.Stop(); s.Files != 1 {
+		t.Errorf("expected 1 file uploaded, got %+v", s)
+	}
+
+	s := out.String()
+	for _, expected := range []string{"Uploading to album 'Trip 2019'",
+		"Sharing albums is not supported"} {
+		if !strings.Contains(s, expected) {
+			t.Errorf("expected output to contain %q, got:\n%v", expected, s)
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	d := newDashboard(&bytes.Buffer{}, false)
+	now, advance := fakeClock()
+	d.now = now
+
+	d.Start("Trip 2019", 2, 2000)
+	d.StartUpload("a.jpg", 1000).Done(nil)
+	d.StartUpload("b.jpg", 1000).Set(500)
+	advance(time.Second)
+
+	s := d.Status()
+	if !s.Running || s.Album != "Trip 2019" || s.Files != 2 || s.FilesDone != 1 ||
+		s.BytesDone != 1500 || s.BytesPerSecond != 1500 || len(s.Active) != 1 ||
+		s.Active[0].BytesDone != 500 {
+		t.Errorf("unexpected status: %+v", s)
+	}
+
+	d.Stop()
+	advance(time.Second)
+	if s := d.Status(); s.Running || s.BytesPerSecond != 1000 || len(s.Active) != 0 {
+		t.Errorf("unexpected status after stopping: %+v", s)
+	}
+}
+
+func TestNil(t *testing.T) {
+	var d *Dashboard
+	d.Start("Trip", 1, 1)
+	u := d.StartUpload("a.jpg", 1)
+	u.Set(1)
+	u.Done(nil)
+	d.Stop()
+	d.Status()
+}
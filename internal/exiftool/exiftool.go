@@ -2,6 +2,7 @@ package exiftool
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
@@ -9,8 +10,9 @@ import (
 )
 
 const (
-	binaryName = "exiftool"
-	dateFormat = "2006:01:02 15:04:05" // YYYY:MM:DD HH:mm:ss
+	binaryName   = "exiftool"
+	dateFormat   = "2006:01:02 15:04:05" // YYYY:MM:DD HH:mm:ss
+	offsetFormat = "-07:00"              // +HH:mm
 )
 
 func IsInstalled() bool {
@@ -29,10 +31,12 @@ func MustCheckExiftoolInstalled() {
 	}
 }
 
-func SetAllDates(inFilePath, outFilePath string, exifDate time.Time) error {
-	allDates := fmt.Sprintf("-AllDates=\"%s\"", exifDate.Format(dateFormat))
-	args := []string{"-o", outFilePath, allDates, inFilePath}
+// Tags are the metadata tags of a file by their group and name, eg.
+// 'GPS:GPSLatitude'; numeric values are float64s.
+type Tags map[string]interface{}
 
+// run runs exiftool, returning its output
+func run(args ...string) ([]byte, error) {
 	cmd := exec.Command(binaryName, args...)
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
@@ -40,9 +44,58 @@ func SetAllDates(inFilePath, outFilePath string, exifDate time.Time) error {
 	cmd.Stderr = &stderr
 
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to call exiftool - stdout: %v, stderr: %v, error: %w",
+		return nil, fmt.Errorf("failed to call exiftool - stdout: %v, stderr: %v, error: %w",
 			stdout.String(), stderr.String(), err)
 	}
 
-	return nil
+	return stdout.Bytes(), nil
+}
+
+func SetAllDates(inFilePath, outFilePath string, exifDate time.Time) error {
+	return WriteTags(inFilePath, outFilePath, exifDate, nil)
+}
+
+// WriteTags writes a copy of the file with all its dates set to exifDate and
+// the given tags deleted. The dates are written in the timezone of exifDate,
+// which is written into the EXIF offset tags (OffsetTimeOriginal etc.). The
+// tags may be given with their group, eg. 'GPS:all' deletes all the GPS tags.
+func WriteTags(inFilePath, outFilePath string, exifDate time.Time,
+	deleteTags []string) error {
+
+	allDates := fmt.Sprintf("-AllDates=\"%s\"", exifDate.Format(dateFormat))
+	offset := exifDate.Format(offsetFormat)
+	args := []string{"-o", outFilePath, allDates, "-OffsetTimeOriginal=" + offset,
+		"-OffsetTimeDigitized=" + offset, "-OffsetTime=" + offset}
+	for _, t := range deleteTags {
+		args = append(args, "-"+t+"=")
+	}
+
+	_, err := run(append(args, inFilePath)...)
+
+	return err
+}
+
+// ReadTags reads the tags of a file
+func ReadTags(filePath string) (Tags, error) {
+	out, err := run("-json", "-n", "-G1", filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseTags(out)
+}
+
+// ParseTags parses the tags of a file from the output of 'exiftool -json -n
+// -G1'
+func ParseTags(data []byte) (Tags, error) {
+	res := []Tags{}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("failed to parse exiftool output: %w", err)
+	}
+	if len(res) != 1 {
+		return nil, fmt.Errorf("expected the tags of 1 file, got %v", len(res))
+	}
+	delete(res[0], "SourceFile")
+
+	return res[0], nil
 }
@@ -0,0 +1,125 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	photos "github.com/matti777/google-photos-uploader/internal/googlephotos"
+	"github.com/matti777/google-photos-uploader/internal/logging"
+)
+
+// Policies for handling an album whose name is already taken
+const (
+	// Skip the album (default)
+	ConflictSkip = "skip"
+
+	// Add the photos into the existing album; only possible for albums
+	// created by this app
+	ConflictAppend = "append"
+
+	// Create a new album with a numeric suffix, eg. 'Trip (2)'; the later runs
+	// add the photos into the same album
+	ConflictSuffix = "suffix"
+
+	// Abort the run
+	ConflictFail = "fail"
+)
+
+// errAlbumExists is the error of an existing album with the policy
+// ConflictFail; it aborts the run
+var errAlbumExists = errors.New("album already exists")
+
+// ConflictPolicies lists the names of all the conflict policies
+var ConflictPolicies = []string{ConflictSkip, ConflictAppend, ConflictSuffix, ConflictFail}
+
+// checkConflictPolicy validates a conflict policy name
+func checkConflictPolicy(policy string) error {
+	for _, p := range ConflictPolicies {
+		if p == policy {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown conflict policy '%v', must be one of: %v", policy,
+		strings.Join(ConflictPolicies, ", "))
+}
+
+// resolveAlbum finds or creates the album to upload into, applying the
+// conflict policy if albums with the same name exist already in the target.
+// The key identifies the album across runs. Returns the album and whether it
+// was created, or a nil album if the album is to be skipped.
+func (t *Target) resolveAlbum(key, name string) (*photos.Album, bool, error) {
+	existing := t.findAlbums(name)
+	if len(existing) == 0 {
+		album, err := t.createAlbum(name)
+		return album, true, err
+	}
+
+	entry := t.logger().WithField(logging.FieldAlbum, name)
+	if len(existing) > 1 {
+		entry.Warnf("There are %v albums with the same name", len(existing))
+	}
+
+	switch settings.ConflictPolicy {
+	case ConflictAppend:
+		for _, a := range existing {
+			if a.IsWriteable {
+				entry.Info("Adding photos to existing album")
+				return a, false, nil
+			}
+		}
+		entry.Warn("Album already exists but was not created by this app; " +
+			"photos cannot be added to it -- skipping")
+		return nil, false, nil
+	case ConflictSuffix:
+		// Add the photos into the album created on an earlier run
+		if id := t.ledger.suffixedAlbum(key); id != "" {
+			for _, a := range t.albums {
+				if a.ID == id {
+					entry.Infof("Adding photos to album '%v' created earlier", a.Title)
+					return a, false, nil
+				}
+			}
+		}
+
+		for i := 2; ; i++ {
+			suffixed := fmt.Sprintf("%v (%v)", name, i)
+			if len(t.findAlbums(suffixed)) == 0 {
+				album, err := t.createAlbum(suffixed)
+				if err != nil {
+					return nil, false, err
+				}
+
+				t.ledger.recordSuffixedAlbum(key, album)
+				if err := t.ledger.save(); err != nil {
+					log.Errorf("Failed to save the upload ledger: %v", err)
+				}
+
+				return album, true, nil
+			}
+		}
+	case ConflictFail:
+		return nil, false, fmt.Errorf("album '%v': %w", name, errAlbumExists)
+	}
+
+	entry.Info("Album already exists -- skipping")
+
+	return nil, false, nil
+}
+
+// conflictAborted tells whether a target has failed on an existing album with
+// the policy ConflictFail, aborting the run
+func conflictAborted(targets []*Target) bool {
+	for _, t := range targets {
+		t.lock.Lock()
+		err := t.err
+		t.lock.Unlock()
+
+		if errors.Is(err, errAlbumExists) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,245 @@
+package files
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/matti777/google-photos-uploader/internal/logging"
+	"github.com/matti777/google-photos-uploader/internal/util"
+)
+
+// Sources of the photo dates
+const (
+	// Capture time in the sidecar file
+	DateSourceSidecar = "sidecar"
+
+	// EXIF DateTimeOriginal (or DateTimeDigitized / DateTime)
+	DateSourceExif = "exif"
+
+	// Time parsed from the file name, eg. IMG-20190614-WA0003.jpg
+	DateSourceFilename = "filename"
+
+	// File modification time
+	DateSourceModTime = "mtime"
+
+	// Album date; used if none of the sources has the date
+	dateSourceAlbum = "album"
+
+	// Date of a planned file in the upload plan
+	dateSourcePlan = "plan"
+)
+
+// DefaultDateSources lists the sources of the photo dates in order of
+// preference; the first one with a date within the album date is used
+var DefaultDateSources = []string{DateSourceSidecar, DateSourceExif, DateSourceFilename,
+	DateSourceModTime}
+
+// ParseDateSources parses a comma separated list of date sources
+func ParseDateSources(s string) ([]string, error) {
+	res := []string{}
+	for _, source := range strings.Split(s, ",") {
+		source = strings.TrimSpace(source)
+		switch source {
+		case DateSourceSidecar, DateSourceExif, DateSourceFilename, DateSourceModTime:
+			res = append(res, source)
+		default:
+			return nil, fmt.Errorf("unknown date source '%v'; expected one of %v", source,
+				strings.Join(DefaultDateSources, ", "))
+		}
+	}
+
+	return res, nil
+}
+
+// dateSources returns the date sources in order of preference
+func dateSources() []string {
+	if len(settings.DateSources) > 0 {
+		return settings.DateSources
+	}
+
+	return DefaultDateSources
+}
+
+// photoClock tells how to interpret the times of the photos in a directory
+type photoClock struct {
+	// Timezone the photos were taken in; nil for the default timezone
+	location *time.Location
+
+	// How much the camera clock was ahead of the correct time; nil for the
+	// offset of the camera model
+	offset *time.Duration
+}
+
+// with returns the clock with the settings of an album override file applied
+func (c photoClock) with(o *albumOverride) photoClock {
+	if o == nil {
+		return c
+	}
+	if o.location != nil {
+		c.location = o.location
+	}
+	if o.clockOffset != nil {
+		c.offset = o.clockOffset
+	}
+
+	return c
+}
+
+// ParseClockOffset parses a camera clock offset given as 'MODEL=DURATION',
+// eg. 'Canon EOS 5D=+1h23m'
+func ParseClockOffset(s string) (string, time.Duration, error) {
+	i := strings.LastIndex(s, "=")
+	if i < 0 {
+		return "", 0, fmt.Errorf("invalid clock offset '%v'; expected 'MODEL=DURATION'", s)
+	}
+
+	model := strings.TrimSpace(s[:i])
+	d, err := time.ParseDuration(strings.TrimSpace(s[i+1:]))
+	if err != nil || model == "" {
+		return "", 0, fmt.Errorf("invalid clock offset '%v'; expected 'MODEL=DURATION'", s)
+	}
+
+	return model, d, nil
+}
+
+// location returns the timezone the photo was taken in, and whether it was
+// set explicitly for its directory or with the settings
+func (f *photoFile) location() (*time.Location, bool) {
+	if f.clock.location != nil {
+		return f.clock.location, true
+	}
+	if settings.Timezone != nil {
+		return settings.Timezone, true
+	}
+
+	return time.Local, false
+}
+
+// clockOffset returns how much the camera clock was ahead of the correct time;
+// set for the directory of the photo or for its camera model
+func (f *photoFile) clockOffset() time.Duration {
+	if f.clock.offset != nil {
+		return *f.clock.offset
+	}
+
+	if len(settings.ClockOffsets) > 0 {
+		if model, ok := f.exifTags().stringValue("Model"); ok {
+			model = strings.TrimSpace(strings.TrimRight(model, "\x00"))
+			for m, offset := range settings.ClockOffsets {
+				if strings.EqualFold(m, model) {
+					return offset
+				}
+			}
+		}
+	}
+
+	return 0
+}
+
+// inLocation returns the same wall clock time in another location
+func inLocation(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(),
+		t.Nanosecond(), loc)
+}
+
+// dateFrom returns the date of the photo from a source in the timezone of
+// the photo; false if the source does not have it. The camera clock offset is
+// applied to the times from the camera (the EXIF data and the file name).
+func (f *photoFile) dateFrom(source string) (time.Time, bool) {
+	loc, explicit := f.location()
+
+	switch source {
+	case DateSourceSidecar:
+		if s := f.sidecar(); s != nil && !s.date.IsZero() {
+			if s.naive {
+				return inLocation(s.date, loc), true
+			}
+			return s.date.In(loc), true
+		}
+	case DateSourceExif:
+		if t, err := f.exifTags().captureTime(loc); err == nil {
+			// The EXIF offset is kept unless the timezone is set
+			if explicit {
+				t = t.In(loc)
+			}
+			return t.Add(-f.clockOffset()), true
+		}
+	case DateSourceFilename:
+		if t, ok := util.ParseFilenameTime(f.info.Name(), loc); ok {
+			return t.Add(-f.clockOffset()), true
+		}
+	case DateSourceModTime:
+		return f.info.ModTime().In(loc), true
+	}
+
+	return time.Time{}, false
+}
+
+// date returns the date of the photo from the first of the date sources that
+// has it, along with the name of the source; empty if none has it.
+func (f *photoFile) date() (time.Time, string) {
+	for _, source := range dateSources() {
+		if t, ok := f.dateFrom(source); ok {
+			return t, source
+		}
+	}
+
+	return time.Time{}, ""
+}
+
+// capturedAt returns the time the photo was taken, falling back to the file
+// modification time if none of the date sources has it.
+func (f *photoFile) capturedAt() time.Time {
+	if t, source := f.date(); source != "" {
+		return t
+	}
+
+	loc, _ := f.location()
+
+	return f.info.ModTime().In(loc)
+}
+
+// Returns the date to write into the uploaded file, in the timezone of the
+// photo: the date of the photo from the first of the date sources that has it
+// within the album date (year, month or day depending on its precision), or
+// from the first that has it at all if the album date is not known; otherwise
+// the album date is used. The modification time is used only if it is one of
+// the date sources. The date of a planned file is the one in the plan.
+func getDateForFile(albumDate util.AlbumDate, f *photoFile) time.Time {
+	t, _ := resolveFileDate(albumDate, f)
+
+	return t
+}
+
+// resolveFileDate returns the date to write into the uploaded file along with
+// its source; dateSourceAlbum for the album date and dateSourcePlan for the
+// date of a planned file.
+func resolveFileDate(albumDate util.AlbumDate, f *photoFile) (time.Time, string) {
+	if f.plan != nil {
+		return f.plan.Date, dateSourcePlan
+	}
+
+	// A date outside the album date is taken to be wrong, eg. the time a
+	// photo was scanned
+	for _, source := range dateSources() {
+		t, ok := f.dateFrom(source)
+		if !ok {
+			continue
+		}
+		if albumDate.IsZero() || albumDate.Contains(t) {
+			return t, source
+		}
+		log.WithField(logging.FieldFile, ledgerPath(f)).Debugf("Ignoring %v date %v "+
+			"outside the album date %v", source, t.Format(time.RFC3339), albumDate)
+	}
+
+	loc, _ := f.location()
+	if albumDate.IsZero() {
+		// There is no other date to use
+		return f.info.ModTime().In(loc), DateSourceModTime
+	}
+
+	// The album date is a calendar date; it is in the timezone of the photo
+	return inLocation(albumDate.Time, loc), dateSourceAlbum
+}
@@ -0,0 +1,394 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	photos "github.com/matti777/google-photos-uploader/internal/googlephotos"
+	"github.com/matti777/google-photos-uploader/internal/logging"
+	"github.com/matti777/google-photos-uploader/internal/util"
+)
+
+// Suffix of the files being downloaded
+const partialDownloadSuffix = ".part"
+
+// Preferred file name extensions of the common media types
+var mediaTypeExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/heic":      ".heic",
+	"image/gif":       ".gif",
+	"video/mp4":       ".mp4",
+	"video/quicktime": ".mov",
+}
+
+// download is a media item to download into a file
+type download struct {
+	item *photos.MediaItem
+
+	// Album the item is downloaded as part of; nil for library items
+	album *photos.Album
+
+	// Path of the file relative to the base directory, with forward slashes
+	relPath string
+}
+
+// downloadPlanner assigns local file paths to media items, keeping the paths
+// already recorded in the ledger and never overwriting unrelated files.
+type downloadPlanner struct {
+	baseDir string
+	ledger  *ledger
+
+	// Paths (relative to the base directory) assigned during the run
+	used map[string]bool
+
+	// Number of items already downloaded and unchanged
+	upToDate int
+}
+
+// sanitizeFileName replaces the characters not allowed in file names
+func sanitizeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		if r < ' ' {
+			return -1
+		}
+		return r
+	}, name)
+
+	return strings.Trim(strings.TrimSpace(name), ".")
+}
+
+// albumDirName forms a directory name out of an album title
+func albumDirName(title string) string {
+	if name := sanitizeFileName(title); name != "" {
+		return name
+	}
+
+	return "Untitled"
+}
+
+// itemFileName returns the file name of the media item; formed out of its
+// ID if the item has no file name.
+func itemFileName(item *photos.MediaItem) string {
+	if name := sanitizeFileName(item.FileName); name != "" {
+		return name
+	}
+
+	ext := mediaTypeExtensions[item.MimeType]
+	if exts, _ := mime.ExtensionsByType(item.MimeType); ext == "" && len(exts) > 0 {
+		ext = exts[0]
+	}
+
+	return item.ID + ext
+}
+
+// exists tells whether a path (relative to the base directory) exists
+func (p *downloadPlanner) exists(relPath string) bool {
+	_, err := os.Stat(filepath.Join(p.baseDir, filepath.FromSlash(relPath)))
+	return err == nil
+}
+
+// uniquePath returns a free path for the name within the directory, adding a
+// numeric suffix (eg. 'IMG_1 (2).jpg') if needed.
+func (p *downloadPlanner) uniquePath(relDir, name string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	relPath := path.Join(relDir, name)
+	for i := 2; p.used[relPath] || p.exists(relPath); i++ {
+		relPath = path.Join(relDir, fmt.Sprintf("%v (%v)%v", base, i, ext))
+	}
+	p.used[relPath] = true
+
+	return relPath
+}
+
+// plan returns the downloads needed for mirroring the items into the directory
+func (p *downloadPlanner) plan(relDir string, album *photos.Album,
+	items []*photos.MediaItem) []*download {
+
+	res := []*download{}
+
+	for _, item := range items {
+		if e := p.ledger.findMediaItem(item.ID, relDir); e != nil &&
+			path.Dir(e.Path) == relDir && !p.used[e.Path] {
+
+			p.used[e.Path] = true
+
+			info, err := os.Stat(filepath.Join(p.baseDir, filepath.FromSlash(e.Path)))
+			if err == nil && e.matches(info) {
+				p.upToDate++
+				continue
+			}
+
+			// Missing or modified locally; download again into the same file
+			res = append(res, &download{item: item, album: album, relPath: e.Path})
+			continue
+		}
+
+		// A file downloaded on an interrupted run, before the ledger was saved,
+		// has the creation time of the item
+		relPath := path.Join(relDir, itemFileName(item))
+		info, err := os.Stat(filepath.Join(p.baseDir, filepath.FromSlash(relPath)))
+		if err == nil && !p.used[relPath] && !item.CreationTime.IsZero() &&
+			info.ModTime().Equal(item.CreationTime) {
+
+			p.used[relPath] = true
+			p.ledger.put(relPath, info, album, item, true)
+			p.upToDate++
+			continue
+		}
+
+		res = append(res, &download{item: item, album: album,
+			relPath: p.uniquePath(relDir, itemFileName(item))})
+	}
+
+	return res
+}
+
+// downloadFile downloads a media item into its file and records it in the
+// ledger. The file is written under a temporary name so that an interrupted
+// download is resumed on the next run.
+func downloadFile(baseDir string, d *download, l *ledger) error {
+	filePath := filepath.Join(baseDir, filepath.FromSlash(d.relPath))
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	partPath := filePath + partialDownloadSuffix
+	f, err := os.Create(partPath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+
+	_, err = photos.MustGetClient().DownloadMediaItem(d.item, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(partPath)
+		return err
+	}
+
+	if err := os.Rename(partPath, filePath); err != nil {
+		return fmt.Errorf("failed to rename downloaded file: %w", err)
+	}
+
+	// Set the file time to the creation time of the item; it is used for
+	// dating the photo when uploading it again
+	if !d.item.CreationTime.IsZero() {
+		if err := os.Chtimes(filePath, d.item.CreationTime, d.item.CreationTime); err != nil {
+			return fmt.Errorf("failed to set file time: %w", err)
+		}
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat downloaded file: %w", err)
+	}
+
+	l.put(d.relPath, info, d.album, d.item, true)
+
+	return nil
+}
+
+// mustDownloadAll downloads the items concurrently. Returns the number of
+// files downloaded.
+func mustDownloadAll(baseDir string, downloads []*download, l *ledger) int {
+	if settings.DryRun {
+		for _, d := range downloads {
+			fmt.Printf("  Would download %v\n", d.relPath)
+		}
+		return len(downloads)
+	}
+
+	q, err := util.NewOperationQueue(settings.MaxConcurrency, 100)
+	if err != nil {
+		log.Fatalf("Failed to create operation queue: %v", err)
+	}
+
+	var lock sync.Mutex
+	downloaded := 0
+
+	for _, d := range downloads {
+		d := d
+
+		q.Add(func() {
+			if err := downloadFile(baseDir, d, l); err != nil {
+				log.WithFields(logrus.Fields{logging.FieldFile: d.relPath,
+					logging.FieldErrorClass: photos.ErrorClass(err)}).WithError(err).
+					Error("Failed to download")
+				return
+			}
+
+			lock.Lock()
+			downloaded++
+			lock.Unlock()
+			fmt.Printf("  %v\n", d.relPath)
+		})
+	}
+
+	q.GracefulShutdown()
+
+	if err := l.save(); err != nil {
+		log.Fatalf("Failed to save the ledger: %v", err)
+	}
+
+	return downloaded
+}
+
+// writeDownloadOverride writes an album override file into a downloaded album
+// directory (unless it already has one) so that uploading the directory again
+// recreates the album with the same title and year.
+func writeDownloadOverride(baseDir, relDir string, album *photos.Album,
+	items []*photos.MediaItem) error {
+
+	filePath := filepath.Join(baseDir, filepath.FromSlash(relDir), albumOverrideFilename)
+	if _, err := os.Stat(filePath); !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	o := struct {
+		Title string `yaml:"title"`
+		Year  int    `yaml:"year,omitempty"`
+	}{Title: album.Title}
+
+	for _, item := range items {
+		if item.CreationTime.IsZero() {
+			continue
+		}
+		if year := item.CreationTime.Local().Year(); o.Year == 0 || year < o.Year {
+			o.Year = year
+		}
+	}
+
+	data, err := yaml.Marshal(&o)
+	if err != nil {
+		return fmt.Errorf("failed to marshal album override: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	return os.WriteFile(filePath, data, 0644)
+}
+
+// albumDirFor returns the directory for the album; the one used on previous
+// runs, or a new one named after the album title.
+func (p *downloadPlanner) albumDirFor(album *photos.Album, usedDirs map[string]bool) string {
+	if dir := p.ledger.albumDir(album.ID); dir != "" && !usedDirs[dir] {
+		usedDirs[dir] = true
+		return dir
+	}
+
+	name := albumDirName(album.Title)
+	dir := name
+	for i := 2; usedDirs[dir] || (p.exists(dir) && !p.exists(path.Join(dir,
+		albumOverrideFilename))); i++ {
+		dir = fmt.Sprintf("%v (%v)", name, i)
+	}
+	usedDirs[dir] = true
+
+	return dir
+}
+
+// DownloadAlbums mirrors the albums into directories under the base directory,
+// one directory per album. If library is true, also the library items not in
+// any of the albums are downloaded, into a directory per year. Already
+// downloaded files are skipped, so the download can be resumed by running it
+// again. The files are recorded in the ledger of the base directory, so the
+// directory can be verified and uploaded again.
+func DownloadAlbums(absoluteDirPath string, albums []*photos.Album, library bool) {
+	if err := os.MkdirAll(absoluteDirPath, 0755); err != nil {
+		log.Fatalf("Failed to create directory '%v': %v", absoluteDirPath, err)
+	}
+
+	l, err := readLedger(absoluteDirPath, "")
+	if err != nil {
+		log.Fatalf("Failed to read the ledger: %v", err)
+	}
+
+	p := &downloadPlanner{baseDir: absoluteDirPath, ledger: l, used: map[string]bool{}}
+	usedDirs := map[string]bool{}
+	downloadedIDs := map[string]bool{}
+	downloaded := 0
+
+	client := photos.MustGetClient()
+
+	for _, album := range albums {
+		// The items are listed right before downloading them since their
+		// base URLs expire
+		items, err := client.ListAlbumItems(album)
+		if err != nil {
+			log.Fatalf("Failed to list the items of album '%v': %v", album.Title, err)
+		}
+
+		dir := p.albumDirFor(album, usedDirs)
+		fmt.Printf("Album '%v' (%v items) -> %v\n", album.Title, len(items), dir)
+
+		if !settings.DryRun {
+			if err := writeDownloadOverride(absoluteDirPath, dir, album, items); err != nil {
+				log.Fatalf("Failed to write album override: %v", err)
+			}
+		}
+
+		downloaded += mustDownloadAll(absoluteDirPath, p.plan(dir, album, items), l)
+
+		for _, item := range items {
+			downloadedIDs[item.ID] = true
+		}
+	}
+
+	if library {
+		items, err := client.SearchMediaItems(&photos.SearchFilter{})
+		if err != nil {
+			log.Fatalf("Failed to list the library: %v", err)
+		}
+
+		byYear := map[string][]*photos.MediaItem{}
+		for _, item := range items {
+			if downloadedIDs[item.ID] {
+				continue
+			}
+			if e := l.findMediaItem(item.ID, ""); e != nil && e.AlbumID != "" {
+				// Downloaded as part of an album on a previous run
+				continue
+			}
+
+			year := "Unknown"
+			if !item.CreationTime.IsZero() {
+				year = fmt.Sprint(item.CreationTime.Local().Year())
+			}
+			byYear[year] = append(byYear[year], item)
+		}
+
+		years := make([]string, 0, len(byYear))
+		for y := range byYear {
+			years = append(years, y)
+		}
+		sort.Strings(years)
+
+		for _, y := range years {
+			fmt.Printf("Library %v (%v items) -> %v\n", y, len(byYear[y]), y)
+			downloaded += mustDownloadAll(absoluteDirPath, p.plan(y, nil, byYear[y]), l)
+		}
+	}
+
+	fmt.Printf("%v file(s) downloaded, %v already up to date.\n", downloaded, p.upToDate)
+}
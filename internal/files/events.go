@@ -0,0 +1,123 @@
+package files
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/matti777/google-photos-uploader/internal/util"
+)
+
+const (
+	// LayoutEvents clusters the photos into events by their capture time
+	LayoutEvents = "events"
+
+	// Default maximum time between two photos of the same event
+	DefaultEventGap = 12 * time.Hour
+
+	// Mean radius of the Earth in kilometers
+	earthRadiusKm = 6371.0
+)
+
+// eventLayout clusters the photos into events (albums) by their capture
+// time: a new event starts whenever there is a gap longer than maxGap between
+// two consecutive photos or, if maxDistanceKm is set, when two consecutive
+// photos with GPS positions were taken further apart than that.
+type eventLayout struct {
+	maxGap        time.Duration
+	maxDistanceKm float64
+
+	// Event of each file; set up by prepare()
+	events map[*photoFile]*event
+}
+
+// event is a cluster of photos taken close to each other.
+type event struct {
+	start time.Time
+	end   time.Time
+}
+
+func (l *eventLayout) scanDepth() int {
+	return -1
+}
+
+// distanceKm returns the great-circle distance between two positions
+// using the haversine formula.
+func distanceKm(p1, p2 gpsPosition) float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := rad(p2.lat - p1.lat)
+	dLon := rad(p2.lon - p1.lon)
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(p1.lat))*math.Cos(rad(p2.lat))*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadiusKm * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// prepare clusters all the files into events.
+func (l *eventLayout) prepare(files []*photoFile) {
+	sorted := make([]*photoFile, len(files))
+	copy(sorted, files)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].capturedAt().Before(sorted[j].capturedAt())
+	})
+
+	l.events = make(map[*photoFile]*event, len(files))
+
+	var current *event
+	var lastPos *gpsPosition
+
+	for _, f := range sorted {
+		t := f.capturedAt()
+
+		startNew := current == nil || t.Sub(current.end) > l.maxGap
+
+		pos, hasPos := f.position()
+		if !startNew && hasPos && lastPos != nil && l.maxDistanceKm > 0 &&
+			distanceKm(*lastPos, pos) > l.maxDistanceKm {
+			startNew = true
+		}
+
+		if startNew {
+			current = &event{start: t}
+			lastPos = nil
+		}
+		current.end = t
+		if hasPos {
+			lastPos = &pos
+		}
+
+		l.events[f] = current
+	}
+}
+
+func (l *eventLayout) albumFor(f *photoFile) (albumKey, bool) {
+	e := l.events[f]
+	if e == nil {
+		return albumKey{}, false
+	}
+
+	return albumKey{
+		key:   e.start.Format("2006-01-02T15:04:05"),
+		title: formatDateRange(e.start, e.end),
+		date:  util.AlbumDate{Time: e.start, Precision: util.PrecisionDay, Until: e.end},
+	}, true
+}
+
+// formatDateRange forms an album title out of a date range, eg.
+// '14 Jun 2019', '14-16 Jun 2019', '30 Jun - 2 Jul 2019' or
+// '30 Dec 2019 - 2 Jan 2020'.
+func formatDateRange(start, end time.Time) string {
+	switch {
+	case start.Year() != end.Year():
+		return fmt.Sprintf("%v - %v", start.Format("2 Jan 2006"), end.Format("2 Jan 2006"))
+	case start.Month() != end.Month():
+		return fmt.Sprintf("%v - %v", start.Format("2 Jan"), end.Format("2 Jan 2006"))
+	case start.Day() != end.Day():
+		return fmt.Sprintf("%v-%v", start.Day(), end.Format("2 Jan 2006"))
+	}
+
+	return start.Format("2 Jan 2006")
+}
@@ -0,0 +1,146 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	exif "github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
+)
+
+const (
+	// EXIF date format (YYYY:MM:DD HH:mm:ss)
+	exifDateFormat = "2006:01:02 15:04:05"
+
+	// EXIF offset format (+HH:mm)
+	exifOffsetFormat = "-07:00"
+)
+
+var (
+	errNoExifDate = errors.New("no EXIF date found")
+	errNoExifGPS  = errors.New("no EXIF GPS position found")
+
+	// EXIF date tags in order of preference
+	exifDateTags = []string{"DateTimeOriginal", "DateTimeDigitized", "DateTime"}
+
+	// EXIF offset tags (timezones) of the date tags
+	exifOffsetTags = []string{"OffsetTimeOriginal", "OffsetTimeDigitized", "OffsetTime"}
+)
+
+// exifTags maps EXIF tag names to their values
+type exifTags map[string]exif.ExifTag
+
+// gpsPosition is a position in decimal degrees.
+type gpsPosition struct {
+	lat float64
+	lon float64
+}
+
+// readExifTags reads the EXIF tags of an image file.
+func readExifTags(path string) (exifTags, error) {
+	rawExif, err := exif.SearchFileAndExtractExif(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read EXIF data: %w", err)
+	}
+
+	entries, _, err := exif.GetFlatExifData(rawExif, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse EXIF data: %w", err)
+	}
+
+	tags := make(exifTags, len(entries))
+	for _, e := range entries {
+		// Prefer the tags of the first IFD when tag names repeat
+		if _, ok := tags[e.TagName]; !ok {
+			tags[e.TagName] = e
+		}
+	}
+
+	return tags, nil
+}
+
+// stringValue returns the value of an ASCII tag.
+func (t exifTags) stringValue(name string) (string, bool) {
+	tag, ok := t[name]
+	if !ok || tag.TagTypeId != exifcommon.TypeAscii {
+		return "", false
+	}
+
+	s, ok := tag.Value.(string)
+
+	return s, ok
+}
+
+// captureTime returns the capture time of the photo. The time is in the
+// timezone of the matching EXIF offset tag (eg. OffsetTimeOriginal) if the
+// photo has one; otherwise it is taken to be in the given location.
+func (t exifTags) captureTime(loc *time.Location) (time.Time, error) {
+	for i, name := range exifDateTags {
+		s, ok := t.stringValue(name)
+		if !ok {
+			continue
+		}
+
+		in := loc
+		if offset, ok := t.stringValue(exifOffsetTags[i]); ok {
+			if o, err := time.Parse(exifOffsetFormat, offset); err == nil {
+				in = o.Location()
+			} else {
+				log.Debugf("Invalid EXIF %v '%v': %v", exifOffsetTags[i], offset, err)
+			}
+		}
+
+		d, err := time.ParseInLocation(exifDateFormat, s, in)
+		if err != nil {
+			log.Debugf("Invalid EXIF %v '%v': %v", name, s, err)
+			continue
+		}
+
+		return d, nil
+	}
+
+	return time.Time{}, errNoExifDate
+}
+
+// degrees converts a degrees / minutes / seconds GPS tag into decimal degrees.
+func (t exifTags) degrees(name, refName, negativeRef string) (float64, bool) {
+	tag, ok := t[name]
+	if !ok {
+		return 0, false
+	}
+
+	dms, ok := tag.Value.([]exifcommon.Rational)
+	if !ok || len(dms) != 3 {
+		return 0, false
+	}
+
+	res := 0.0
+	for i, divisor := range []float64{1, 60, 3600} {
+		if dms[i].Denominator == 0 {
+			return 0, false
+		}
+		res += float64(dms[i].Numerator) / float64(dms[i].Denominator) / divisor
+	}
+
+	if ref, _ := t.stringValue(refName); ref == negativeRef {
+		res = -res
+	}
+
+	return res, true
+}
+
+// gpsPosition returns the position where the photo was taken.
+func (t exifTags) gpsPosition() (gpsPosition, error) {
+	lat, ok := t.degrees("GPSLatitude", "GPSLatitudeRef", "S")
+	if !ok {
+		return gpsPosition{}, errNoExifGPS
+	}
+
+	lon, ok := t.degrees("GPSLongitude", "GPSLongitudeRef", "W")
+	if !ok {
+		return gpsPosition{}, errNoExifGPS
+	}
+
+	return gpsPosition{lat: lat, lon: lon}, nil
+}
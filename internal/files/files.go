@@ -1,22 +1,19 @@
 package files
 
 import (
+	"errors"
 	"fmt"
-	"io/fs"
 	"mime"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/google/uuid"
-	"github.com/gosuri/uiprogress"
-	"github.com/gosuri/uiprogress/util/strutil"
-	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 
 	"github.com/matti777/google-photos-uploader/internal/config"
-	"github.com/matti777/google-photos-uploader/internal/exiftool"
-	photos "github.com/matti777/google-photos-uploader/internal/googlephotos"
+	"github.com/matti777/google-photos-uploader/internal/dashboard"
 	"github.com/matti777/google-photos-uploader/internal/logging"
 	"github.com/matti777/google-photos-uploader/internal/util"
 )
@@ -25,6 +22,13 @@ var (
 	log            = logging.MustGetLogger()
 	settings       = config.MustGetSettings()
 	utcLocation, _ = time.LoadLocation("UTC")
+
+	// Include / exclude filter for the directories and files being scanned;
+	// created in ProcessBaseDir
+	filter *pathFilter
+
+	// Dashboard displaying the progress of the uploads
+	board = dashboard.New()
 )
 
 // Checks that a path is an existing directory
@@ -43,8 +47,15 @@ func directoryExists(dir string) (bool, error) {
 	}
 }
 
-// Returns files and subdirectories. Panics on errors.
+// Returns files and subdirectories, leaving out the ones excluded by
+// the include / exclude filter. Panics on errors.
 func mustScanDirectory(dir string) ([]os.FileInfo, []os.FileInfo) {
+	if filter != nil {
+		if err := filter.loadIgnoreFile(dir); err != nil {
+			log.Fatalf("Failed to read ignore file: %v", err)
+		}
+	}
+
 	d, err := os.Open(dir)
 	if err != nil {
 		log.Fatalf("Failed to open directory '%v': %v", dir, err)
@@ -60,6 +71,10 @@ func mustScanDirectory(dir string) ([]os.FileInfo, []os.FileInfo) {
 	files := []os.FileInfo{}
 
 	for _, info := range infos {
+		if filter.isExcluded(filepath.Join(dir, info.Name()), info.IsDir()) {
+			continue
+		}
+
 		if info.IsDir() {
 			dirs = append(dirs, info)
 		} else {
@@ -87,310 +102,433 @@ func formAlbumName(dirName string, capitalize bool, substitutionTokens string) s
 	return albumName
 }
 
-func createAlbum(name string) (*photos.Album, error) {
-	log.Debugf("Creating new Photos album: '%v'", name)
+// Returns an arbitrary date within the given album year
+func dateForAlbumYear(albumYear int) util.AlbumDate {
+	return util.AlbumDate{Time: time.Date(albumYear, 1, 10, 10, 10, 10, 0, utcLocation),
+		Precision: util.PrecisionYear}
+}
 
-	album, err := photos.MustGetClient().CreateAlbum(name)
-	if err != nil {
-		log.Errorf("Failed to create Photos Album: %v", err)
-		return nil, err
+// Returns the reason for not uploading a file of the album; empty if the file
+// is to be uploaded
+func fileSkipReason(group *albumGroup, f *photoFile) string {
+	if mime.TypeByExtension(filepath.Ext(f.info.Name())) != "image/jpeg" {
+		return "unsupported file type"
+	}
+	if !group.override.includesFile(f.info.Name()) {
+		return "excluded by " + albumOverrideFilename
 	}
 
-	log.Debugf("Album created.")
-
-	return album, nil
+	return ""
 }
 
-// Simulates the upload of a file.
-func simulateUploadPhoto(path string, size int64,
-	callback func(int64)) (string, error) {
-
-	const steps = 10
-	const duration = 1.0
-	const sleep = float32(time.Second) * (float32(duration) / steps)
-
-	remaining := size
-	sent := int64(0)
-	perStep := remaining / steps
-
-	for i := 0; i < steps; i++ {
-		time.Sleep(time.Duration(sleep))
-
-		if remaining < perStep {
-			sent += remaining
-		} else {
-			sent += perStep
-		}
-
-		callback(sent)
-
-		remaining -= perStep
+// Returns the files of the album to upload in the album order, leaving out the
+// non-supported files and the ones excluded by the album override. The files
+// of a planned album are uploaded as planned.
+func uploadableFiles(group *albumGroup) []*photoFile {
+	if group.planned {
+		return group.files
 	}
 
-	// Generate a random UUIDv4 to act as an upload token
-	uuidv4, err := uuid.NewRandom()
-	if err != nil {
-		return "", err
+	imageFiles := make([]*photoFile, 0, len(group.files))
+	for _, f := range group.files {
+		if reason := fileSkipReason(group, f); reason != "" {
+			log.Debugf("Skipping file %v: %v", f.info.Name(), reason)
+			continue
+		}
+		imageFiles = append(imageFiles, f)
 	}
+	sortFiles(group, imageFiles)
 
-	return uuidv4.String(), nil
+	return imageFiles
 }
 
-// Synchronously uploads an image file (or simulates it). Manages a progress
-// bar for the upload.
-// Returns image upload token or error.
-func upload(progress *uiprogress.Progress, dir string, file os.FileInfo,
-	padLength, albumYear int) (string, error) {
-
-	paddedName := strutil.PadRight(file.Name(), padLength, ' ')
+// Resolves the album date from the album override or by parsing it from the
+// album's date names. Returns false if the date could not be resolved.
+func resolveAlbumDate(group *albumGroup) (util.AlbumDate, bool) {
+	if !group.date.IsZero() {
+		return group.date, true
+	}
 
-	filePath := filepath.Join(dir, file.Name())
-	fileSize := file.Size()
+	override := group.override
+	if override != nil && !override.date.IsZero() {
+		return util.AlbumDate{Time: override.date, Precision: util.PrecisionDay}, true
+	}
+	if override != nil && override.Year > 0 {
+		return dateForAlbumYear(override.Year), true
+	}
 
-	// Write creation date to EXIF data so Google Photos album will get a proper year
-	if !settings.DryRun {
-		tempFile, err := os.CreateTemp("", "*.jpeg")
-		if err != nil {
-			log.Fatalf("failed to create temp file: %v", err)
-		}
-		os.Remove(tempFile.Name())       // exiftool refuses to overwrite existing files
-		defer os.Remove(tempFile.Name()) // cleanup
-
-		fileDate := getDateForFile(albumYear, file)
-		log.Debugf("Writing file date %v for image: %v/%v to tempFile: %v",
-			fileDate, dir, file.Name(), tempFile.Name())
-		if err := exiftool.SetAllDates(filePath, tempFile.Name(), fileDate); err != nil {
-			return "", fmt.Errorf("failed to call exiftool.SetAllDates: %w", err)
-		}
+	if settings.NoParseYear || len(group.dateNames) == 0 {
+		return dateForAlbumYear(time.Now().Year()), true
+	}
 
-		// Point filePath and fileSize to the new file
-		filePath = tempFile.Name()
-		f, err := os.Stat(filePath)
-		if err != nil {
-			return "", errors.Wrap(err, "failed to get file size")
+	// Attempt to parse the album date from the directory names
+	for _, name := range group.dateNames {
+		if albumDate, err := util.ParseAlbumDate(name); err == nil {
+			log.Debugf("Using album date: %v", albumDate)
+			return albumDate, true
 		}
-		fileSize = f.Size()
 	}
 
-	bar := progress.AddBar(int(fileSize)).PrependElapsed().AppendCompleted()
-	bar.PrependFunc(func(b *uiprogress.Bar) string {
-		return paddedName
-	})
-	bar.Fill = '#'
-	bar.Head = '#'
-	bar.Empty = ' '
+	log.WithField(logging.FieldAlbum, group.dateNames[0]).Warn("Failed to parse album " +
+		"date from directory name -- skipping this directory. You can disable album " +
+		"date parsing by supplying command line parameter --no-parse-year, or add " +
+		"your own date patterns with --date-pattern.")
 
-	progressCallback := func(count int64) {
-		bar.Set(int(count))
-	}
+	return util.AlbumDate{}, false
+}
 
-	if !settings.DryRun {
-		return photos.MustGetClient().UploadPhoto(filePath, progressCallback)
-	} else {
-		return simulateUploadPhoto(filePath, file.Size(), progressCallback)
+// Returns the title of the album; from the album override or formed by the layout
+func albumTitle(group *albumGroup) string {
+	if group.override != nil && group.override.Title != "" {
+		return group.override.Title
 	}
+
+	return group.title
 }
 
-func getDateForFile(albumYear int, file fs.FileInfo) time.Time {
-	fileDate := file.ModTime()
+// Status returns the progress of the uploads; served by the status endpoint
+func Status() dashboard.Status {
+	return board.Status()
+}
 
-	// If the file date's year matches the parsed album year
-	// (or album year has not been parsed successfully) use the file date
-	if albumYear <= 0 || albumYear == fileDate.Year() {
-		return fileDate
+// Returns the targets that have not failed
+func activeTargets(targets []*Target) []*Target {
+	res := make([]*Target, 0, len(targets))
+	for _, t := range targets {
+		if !t.failed() {
+			res = append(res, t)
+		}
 	}
 
-	// Otherwise form an arbitrary date using the parsed album year
-	return time.Date(albumYear, 1, 10, 10, 10, 10, 0, utcLocation)
+	return res
 }
 
-// Uploads the given files and returns the upload tokens for the uploaded photos.
-func uploadAll(absoluteDirPath string, albumYear int, files []os.FileInfo) []string {
-	uploadTokens := make([]string, 0, len(files))
-
-	// Calculate the common padding length from the longest filename
-	padLength := util.FindLongestName(files)
+// Processes a Photo Album; creates the album in each target (or finds an
+// existing one to append to) and uploads all of its files into the targets
+// concurrently. The files are prepared for uploading once, for all the targets.
+// If confirm is set, the user is asked to confirm the uploads of the album.
+func processAlbum(group *albumGroup, targets []*Target, confirm bool) {
+	override := group.override
 
-	// Create a concurrency execution queue for the uploads
-	q, err := util.NewOperationQueue(settings.MaxConcurrency, 100)
-	if err != nil {
-		log.Fatalf("Failed to create operation queue: %v", err)
+	if override != nil && override.Skip {
+		log.WithField(logging.FieldAlbum, group.key).Infof("Skipping directory %v as "+
+			"requested by %v", group.dir, albumOverrideFilename)
+		return
 	}
 
-	progress := uiprogress.New()
-	progress.Start()
-
-	for _, f := range files {
-		file := f
+	albumDate, ok := resolveAlbumDate(group)
+	if !ok {
+		return
+	}
 
-		q.Add(func() {
-			uploadToken, err := upload(progress, absoluteDirPath, file, padLength, albumYear)
-			if err != nil {
-				log.Fatalf("File upload failed: %v", err)
-			}
+	files := uploadableFiles(group)
 
-			if uploadToken != "" {
-				uploadTokens = append(uploadTokens, uploadToken)
-			} else {
-				log.Debugf("Uploaded photo didn't receive upload token " +
-					"-- it has already been uploaded with another token.")
-			}
-		})
+	// Files not added to any album (group.key is empty) are only uploaded
+	// into the library
+	albumName := ""
+	if group.key != "" {
+		albumName = albumTitle(group)
+		log.Debugf("Processing album %v (%v files), album name: %v..",
+			group.key, len(group.files), albumName)
 	}
 
-	// Wait for all the operations to complete
-	q.GracefulShutdown()
-	log.Debugf("All uploads finished.")
-	progress.Stop()
-	progress.Bars = nil
+	uploads := []*albumUpload{}
+	pending := 0
 
-	return uploadTokens
-}
+	for _, t := range activeTargets(targets) {
+		u := &albumUpload{target: t}
 
-// Out of a list of files as input, filters out our non-supported files and
-// attempts to upload the valid ones. Successfully uploaded files are then added to the
-// specified album.
-func handleFileUpload(absoluteDirPath string, files []fs.FileInfo,
-	album *photos.Album, albumYear int) {
+		if albumName != "" {
+			// Create the album or find an existing one, depending on the
+			// conflict policy
+			album, created, err := t.resolveAlbum(group.key, albumName)
+			if errors.Is(err, errAlbumExists) {
+				// Nothing is uploaded into the other targets either
+				t.fail(err)
+				return
+			}
+			if err != nil {
+				t.fail(err)
+				continue
+			}
+			if album == nil {
+				continue
+			}
+			u.album, u.created = album, created
+		}
 
-	// Filter out all non-supported files by extension
-	imageFiles := make([]fs.FileInfo, 0, len(files))
-	for _, f := range files {
-		if mime.TypeByExtension(filepath.Ext(f.Name())) != "image/jpeg" {
+		u.files = t.notInAlbum(files, u.album)
+		if u.album == nil && len(u.files) == 0 {
 			continue
 		}
-		imageFiles = append(imageFiles, f)
+		if len(u.files) > pending {
+			pending = len(u.files)
+		}
+
+		uploads = append(uploads, u)
 	}
 
-	if len(imageFiles) == 0 {
-		log.Debugf("No image files to uplkoad.")
+	if len(uploads) == 0 {
 		return
 	}
 
-	// Ask the user whether to continue uploading to this album
-	util.MustConfirm(fmt.Sprintf("About to upload directory %v (%v image files) to album '%v'",
-		absoluteDirPath, len(imageFiles), album.Title), "")
-
-	// Upload all the files in this directory
-	uploadTokens := uploadAll(absoluteDirPath, albumYear, imageFiles)
-
-	// If there is something to add, add the photos to albums
-	if len(uploadTokens) > 0 {
-		log.Debugf("Adding %v photos to album %v", len(uploadTokens), album.Title)
+	if pending == 0 {
+		log.Debugf("No image files to upload.")
+	} else {
+		// Ask the user whether to continue uploading to this album
+		into := "without an album"
+		if albumName != "" {
+			into = fmt.Sprintf("to album '%v'", albumName)
+		}
+		if len(targets) > 1 {
+			into += fmt.Sprintf(" in %v target(s)", len(uploads))
+		}
+		if confirm {
+			util.MustConfirm(fmt.Sprintf("About to upload %v image files %v", pending, into), "")
+		}
 
-		if !settings.DryRun {
-			// We must split the tokens into groups of max MaxAddPhotosPerCall items
-			chunks := util.Chunked(uploadTokens, photos.MaxAddPhotosPerCall)
-			for _, c := range chunks {
-				// Create n media items at a time in the album
-				if err := photos.MustGetClient().AddToAlbum(album, c); err != nil {
-					log.Fatalf("failed to add photos to album: %v", err)
+		// The totals are per target; a file uploaded into several targets is
+		// counted once in the log
+		totals := make([]dashboard.Totals, 0, len(uploads))
+		unique := map[*photoFile]bool{}
+		size := int64(0)
+		for _, u := range uploads {
+			t := dashboard.Totals{Target: u.target.Name, Files: len(u.files)}
+			for _, f := range u.files {
+				t.Bytes += f.info.Size()
+				if !unique[f] {
+					unique[f] = true
+					size += f.info.Size()
 				}
 			}
+			totals = append(totals, t)
 		}
+		log.WithFields(logrus.Fields{logging.FieldAlbum: albumName,
+			logging.FieldFiles: len(unique), logging.FieldBytes: size}).Info("Uploading album")
+		board.StartTargets(albumName, totals)
 	}
+
+	prepared := newPreparedFiles(albumDate)
+	defer prepared.cleanup()
+
+	var wg sync.WaitGroup
+	for _, u := range uploads {
+		wg.Add(1)
+		go func(u *albumUpload) {
+			defer wg.Done()
+			u.run(group, prepared)
+		}(u)
+	}
+	wg.Wait()
+
+	if pending > 0 {
+		s := board.Stop()
+		log.WithFields(logrus.Fields{logging.FieldAlbum: albumName, logging.FieldFiles: s.Files,
+			logging.FieldFailed: s.Failed, logging.FieldBytes: s.Bytes,
+			logging.FieldDuration: s.Duration.Seconds()}).Info("Album uploaded")
+	}
+
+	log.Debugf("Photo Album %v processed.", group.key)
+}
+
+// Recursively scans a directory for files, down to maxDepth levels below
+// the base directory (-1 for no limit).
+func mustScanFiles(baseDir, absoluteDirPath string, depth, maxDepth int) []*photoFile {
+	return mustScanFilesWithClock(baseDir, absoluteDirPath, depth, maxDepth, photoClock{})
 }
 
-func mustProcessPhotoAlbumSubDirectory(absoluteDirPath string, album *photos.Album, albumYear int) {
-	// Find all the files & subdirectories
+// Recursively scans a directory for files; the timezone and the clock offset
+// of the photos are inherited from the parent directory unless set in the
+// album override file of the directory.
+func mustScanFilesWithClock(baseDir, absoluteDirPath string, depth, maxDepth int,
+	clock photoClock) []*photoFile {
+
 	files, dirs := mustScanDirectory(absoluteDirPath)
 
-	handleFileUpload(absoluteDirPath, files, album, albumYear)
+	override, err := readAlbumOverride(absoluteDirPath)
+	if err != nil {
+		log.Fatalf("Failed to read album override for '%v': %v", absoluteDirPath, err)
+	}
+	clock = clock.with(override)
+
+	relDir, err := filepath.Rel(baseDir, absoluteDirPath)
+	if err != nil {
+		log.Fatalf("Failed to get relative path for '%v': %v", absoluteDirPath, err)
+	}
+	relDir = filepath.ToSlash(relDir)
 
-	if settings.Recurse {
+	// Sidecar files by their lower case names
+	sidecars := map[string]string{}
+	for _, f := range files {
+		if isSidecar(f.Name()) {
+			sidecars[strings.ToLower(f.Name())] = f.Name()
+		}
+	}
+
+	res := make([]*photoFile, 0, len(files))
+	for _, f := range files {
+		if isSidecar(f.Name()) {
+			continue
+		}
+		res = append(res, &photoFile{dir: absoluteDirPath, relDir: relDir, info: f,
+			sidecarName: findSidecar(f.Name(), sidecars), clock: clock})
+	}
+
+	if maxDepth < 0 || depth < maxDepth {
 		for _, d := range dirs {
-			absoluteSubDirPath := filepath.Join(absoluteDirPath, d.Name())
-			mustProcessPhotoAlbumSubDirectory(absoluteSubDirPath, album, albumYear)
+			res = append(res, mustScanFilesWithClock(baseDir,
+				filepath.Join(absoluteDirPath, d.Name()), depth+1, maxDepth, clock)...)
 		}
 	}
 
-	log.Debugf("Photo Album '%v' subdirectory %v processed.", album.Title, absoluteDirPath)
+	return res
 }
 
-// Processes a Photo Album directory. Handles all the files in the directory and
-// optionally all the subdirectories as well. Aborts as soon as an upload fails.
-// Returns true if an album was created.
-func mustProcessPhotoAlbumDirectory(absoluteDirPath string) bool {
+// Scans the base directory and maps the files into albums according to the
+// album layout. Reads the album override files of the album directories.
+func mustScanAlbumGroups(absoluteDirPath string) []*albumGroup {
 	// Check that the diretory exists
 	if exists, _ := directoryExists(absoluteDirPath); !exists {
-		log.Fatalf("directory '%v' does not exist!", absoluteDirPath)
+		log.Fatalf("Directory '%v' does not exist!", absoluteDirPath)
 	}
 
-	dirName := filepath.Base(absoluteDirPath)
-	albumName := formAlbumName(dirName, settings.Capitalize, settings.NameSubstitutionTokens)
+	var err error
+	filter, err = newPathFilter(absoluteDirPath, settings.Include, settings.Exclude)
+	if err != nil {
+		log.Fatalf("Invalid include / exclude patterns: %v", err)
+	}
 
-	log.Debugf("Processing directory %v with name %v, album name: %v..",
-		absoluteDirPath, dirName, albumName)
+	layout, err := newAlbumLayout(settings.Layout)
+	if err != nil {
+		log.Fatalf("Invalid album layout: %v", err)
+	}
 
-	albumYear := time.Now().Year()
-	var err error
+	files := mustScanFiles(absoluteDirPath, absoluteDirPath, 0, layout.scanDepth())
+	groups := groupByAlbum(layout, files)
 
-	if !settings.NoParseYear {
-		// Attempt to parse the album year from the directory name
-		albumYear, err = util.ParseAlbumYear(dirName)
-		if err != nil {
-			fmt.Printf("failed to parse album year from directory name '%v' -- "+
-				"skipping this directory. You can disable album year parsing by supplying "+
-				"command line parameter --no-parse-year.", dirName)
-			return false
+	for _, g := range groups {
+		if g.dir != "" {
+			g.override, err = readAlbumOverride(g.dir)
+			if err != nil {
+				log.Fatalf("Failed to read album override for '%v': %v", g.dir, err)
+			}
 		}
 	}
 
-	log.Debugf("Using album year: %v", albumYear)
+	return groups
+}
 
-	// First check that there isn't already and album with such name
-	album := settings.FindAlbum(albumName)
-	if album != nil {
-		fmt.Printf("Album '%v' already exists\n", albumName)
-		return false
+// indexFileFor returns the path of the album index file of a target; with
+// several targets the target name is added before the extension, eg.
+// albums.archive.md.
+func indexFileFor(path string, t *Target, targets []*Target) string {
+	if len(targets) < 2 {
+		return path
 	}
 
-	// Create album by albumName
-	fmt.Printf("Creating new Google Photos album: %v\n", albumName)
-	if settings.DryRun {
-		album = &photos.Album{Title: albumName, ID: "123"}
-	} else {
-		album, err = createAlbum(albumName)
-		if err != nil {
-			log.Fatalf("failed to create album: %v", err)
+	ext := filepath.Ext(path)
+
+	return strings.TrimSuffix(path, ext) + "." + t.Name + ext
+}
+
+// Prints the summary of the uploads into the target and writes its album index
+func printTargetSummary(t *Target, targets []*Target) {
+	created := 0
+	for _, r := range t.reports {
+		if !r.Appended {
+			created++
 		}
 	}
 
-	// Find all the files & subdirectories
-	files, dirs := mustScanDirectory(absoluteDirPath)
+	if len(targets) > 1 {
+		fmt.Printf("Target %v:\n", t.Name)
+	}
+	fmt.Printf("%v album(s) created, %v appended to.\n", created, len(t.reports)-created)
+	PrintReport(t.reports)
 
-	handleFileUpload(absoluteDirPath, files, album, albumYear)
+	if t.err != nil {
+		fmt.Printf("FAILED: %v\n", t.err)
+	}
 
-	if settings.Recurse {
-		for _, d := range dirs {
-			absoluteSubDirPath := filepath.Join(absoluteDirPath, d.Name())
-			mustProcessPhotoAlbumSubDirectory(absoluteSubDirPath, album, albumYear)
+	if settings.IndexFile != "" {
+		path := indexFileFor(settings.IndexFile, t, targets)
+		if err := WriteIndex(path, t.reports); err != nil {
+			log.Fatalf("Failed to write album index: %v", err)
 		}
+		fmt.Printf("Album index written to %v\n", path)
 	}
+}
 
-	log.Debugf("Photo Album directory %v processed.", absoluteDirPath)
+// Scans the "base" directory (one containing all the subdirectories of photos to
+// be uploaded as albums), maps the files into albums according to the album
+// layout and uploads them into the targets. A failing target is skipped for the
+// rest of the run without affecting the others. Returns the number of failed
+// targets.
+func ProcessBaseDir(absoluteDirPath string, targets []*Target) int {
+	mustCheckConflictPolicy()
+
+	return processGroups(absoluteDirPath, mustScanAlbumGroups(absoluteDirPath), targets, true)
+}
 
-	return true
+// Checks the conflict policy, defaulting to skipping the existing albums
+func mustCheckConflictPolicy() {
+	if settings.ConflictPolicy == "" {
+		settings.ConflictPolicy = ConflictSkip
+	}
+	if err := checkConflictPolicy(settings.ConflictPolicy); err != nil {
+		log.Fatalf("Invalid conflict policy: %v", err)
+	}
 }
 
-// Scans the "base" directory (one containing all the subdirectories of photos to
-// be uploaded as albums)
-func ProcessBaseDir(absoluteDirPath string) {
-	// Check that the diretory exists
-	if exists, _ := directoryExists(absoluteDirPath); !exists {
-		log.Fatalf("Directory '%v' does not exist!", absoluteDirPath)
+// Uploads the albums into the targets, asking to confirm each album if confirm
+// is set; returns the number of failed targets
+func processGroups(absoluteDirPath string, groups []*albumGroup, targets []*Target,
+	confirm bool) int {
+
+	log.Info("Fetching the list of existing albums..")
+	for _, t := range targets {
+		if err := t.open(absoluteDirPath); err != nil {
+			t.fail(err)
+		}
 	}
 
-	_, subdirs := mustScanDirectory(absoluteDirPath)
+	appliedOverrides := map[string][]string{}
+	overrideDirs := []string{}
+
+	for _, g := range groups {
+		if len(activeTargets(targets)) == 0 {
+			break
+		}
 
-	albumCount := 0
+		if g.override != nil {
+			relDir, _ := filepath.Rel(absoluteDirPath, g.dir)
+			appliedOverrides[relDir] = g.override.summary()
+			overrideDirs = append(overrideDirs, relDir)
+		}
+
+		processAlbum(g, targets, confirm)
+
+		if conflictAborted(targets) {
+			log.Errorf("Aborting the run on an existing album (--on-existing-album %v)",
+				ConflictFail)
+			break
+		}
+	}
+
+	failed := 0
+	for _, t := range targets {
+		printTargetSummary(t, targets)
+		if t.err != nil {
+			failed++
+		}
+	}
 
-	for _, d := range subdirs {
-		subDir := filepath.Join(absoluteDirPath, d.Name())
-		if mustProcessPhotoAlbumDirectory(subDir) {
-			albumCount += 1
+	if len(overrideDirs) > 0 {
+		fmt.Printf("Album overrides (%v) applied:\n", albumOverrideFilename)
+		for _, d := range overrideDirs {
+			fmt.Printf("  %v: %v\n", d, strings.Join(appliedOverrides[d], ", "))
 		}
 	}
 
-	fmt.Printf("%v album(s) created.\n", albumCount)
+	return failed
 }
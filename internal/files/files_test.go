@@ -1,7 +1,24 @@
 package files
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	exif "github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
+	photos "github.com/matti777/google-photos-uploader/internal/googlephotos"
+	"github.com/matti777/google-photos-uploader/internal/metrics"
+	"github.com/matti777/google-photos-uploader/internal/mirror"
+	"github.com/matti777/google-photos-uploader/internal/util"
 )
 
 func TestFormAlbumName(t *testing.T) {
@@ -13,3 +30,1545 @@ func TestFormAlbumName(t *testing.T) {
 		t.Errorf("failed to form album name")
 	}
 }
+
+func TestParseAlbumOverride(t *testing.T) {
+	o, err := parseAlbumOverride([]byte("title: My Album\ndate: 2019-06-14\n" +
+		"exclude: ['*_edited.jpg']\n"))
+	if err != nil {
+		t.Fatalf("failed to parse album override: %v", err)
+	}
+
+	if o.Title != "My Album" || o.Year != 2019 || o.date.Month() != 6 {
+		t.Errorf("album override parsed incorrectly: %+v", o)
+	}
+
+	if o.includesFile("foo_edited.jpg") || !o.includesFile("foo.jpg") {
+		t.Errorf("album override exclude failed")
+	}
+
+	o, err = parseAlbumOverride([]byte("include: ['IMG_*']\n"))
+	if err != nil {
+		t.Fatalf("failed to parse album override: %v", err)
+	}
+
+	if !o.includesFile("IMG_0001.jpg") || o.includesFile("DSC_0001.jpg") {
+		t.Errorf("album override include failed")
+	}
+
+	if _, err := parseAlbumOverride([]byte("date: 14.6.2019\n")); err == nil {
+		t.Errorf("should have failed to parse invalid date")
+	}
+
+	if _, err := parseAlbumOverride([]byte("titel: typo\n")); err == nil {
+		t.Errorf("should have failed to parse unknown field")
+	}
+
+	o, err = parseAlbumOverride([]byte("timezone: Asia/Tokyo\nclock_offset: +1h23m\n"))
+	if err != nil {
+		t.Fatalf("failed to parse album override: %v", err)
+	}
+
+	if o.location.String() != "Asia/Tokyo" || *o.clockOffset != 83*time.Minute {
+		t.Errorf("album override timezone parsed incorrectly: %+v", o)
+	}
+
+	if _, err := parseAlbumOverride([]byte("timezone: Mars/Olympus\n")); err == nil {
+		t.Errorf("should have failed to parse invalid timezone")
+	}
+
+	if _, err := parseAlbumOverride([]byte("clock_offset: 1 hour\n")); err == nil {
+		t.Errorf("should have failed to parse invalid clock offset")
+	}
+}
+
+func TestPathFilter(t *testing.T) {
+	baseDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(baseDir, "Trip-2019", "raw"), 0755); err != nil {
+		t.Fatalf("failed to create directories: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(baseDir, ".photosignore"),
+		[]byte("# comment\n*_edited.jpg\n!keep_edited.jpg\nTrip-2019/raw/\n"), 0644); err != nil {
+		t.Fatalf("failed to write ignore file: %v", err)
+	}
+
+	f, err := newPathFilter(baseDir, nil, []string{"re:.*\\.tmp$", "**/private/**"})
+	if err != nil {
+		t.Fatalf("failed to create filter: %v", err)
+	}
+
+	tests := []struct {
+		path     string
+		isDir    bool
+		excluded bool
+	}{
+		{"Trip-2019", true, false},
+		{"Trip-2019/foo.jpg", false, false},
+		{"Trip-2019/foo_edited.jpg", false, true},
+		{"Trip-2019/keep_edited.jpg", false, false},
+		{"Trip-2019/raw", true, true},
+		{"Other/raw", true, false},
+		{"Trip-2019/@eaDir", true, true},
+		{"Trip-2019/.hidden.jpg", false, true},
+		{".git", true, true},
+		{"Trip-2019/foo.tmp", false, true},
+		{"Trip-2019/private/foo.jpg", false, true},
+	}
+
+	for _, tt := range tests {
+		res := f.check(filepath.Join(baseDir, tt.path), tt.isDir)
+		if res.excluded != tt.excluded {
+			t.Errorf("%v: expected excluded=%v, got %v (%v)", tt.path, tt.excluded,
+				res.excluded, res.reason)
+		}
+	}
+
+	f, err = newPathFilter(baseDir, []string{"*.jpg", "*-2019/"}, nil)
+	if err != nil {
+		t.Fatalf("failed to create filter: %v", err)
+	}
+
+	if f.check(filepath.Join(baseDir, "Trip-2019"), true).excluded {
+		t.Errorf("directory should have been included")
+	}
+	if !f.check(filepath.Join(baseDir, "Trip-2018"), true).excluded {
+		t.Errorf("directory should have been excluded")
+	}
+	if !f.check(filepath.Join(baseDir, "Trip-2019/foo.png"), false).excluded {
+		t.Errorf("file should have been excluded")
+	}
+
+	// The last matching include pattern decides
+	f, err = newPathFilter(baseDir, []string{"a*", "!ab*"}, nil)
+	if err != nil {
+		t.Fatalf("failed to create filter: %v", err)
+	}
+
+	for _, tt := range []struct {
+		path     string
+		excluded bool
+		reason   string
+	}{
+		{"abc", true, "matches negated include pattern '!ab*' (--include)"},
+		{"acd", false, "matches include pattern 'a*' (--include)"},
+		{"bcd", true, "does not match any include pattern"},
+	} {
+		res := f.check(filepath.Join(baseDir, tt.path), false)
+		if res.excluded != tt.excluded || res.reason != tt.reason {
+			t.Errorf("%v: expected excluded=%v (%v), got %v (%v)", tt.path, tt.excluded,
+				tt.reason, res.excluded, res.reason)
+		}
+	}
+
+	// The subdirectories of an included directory are included
+	f, err = newPathFilter(baseDir, []string{"Summer-2020/", "!Summer-2020/raw/private/"}, nil)
+	if err != nil {
+		t.Fatalf("failed to create filter: %v", err)
+	}
+
+	for _, tt := range []struct {
+		path     string
+		excluded bool
+	}{
+		{"Summer-2020", false},
+		{"Summer-2020/raw", false},
+		{"Summer-2020/raw/2", false},
+		{"Summer-2020/raw/private", true},
+		{"Summer-2020/raw/private/more", true},
+		{"Summer-2021", true},
+		{"Summer-2021/raw", true},
+		{"Other/Summer-2020", false},
+		{"Other/Summer-2020/raw", false},
+	} {
+		res := f.check(filepath.Join(baseDir, tt.path), true)
+		if res.excluded != tt.excluded {
+			t.Errorf("%v: expected excluded=%v, got %v (%v)", tt.path, tt.excluded,
+				res.excluded, res.reason)
+		}
+	}
+}
+
+func TestAlbumLayouts(t *testing.T) {
+	baseDir := t.TempDir()
+	for _, p := range []string{"root.jpg", "Travel/2019/Japan/a.jpg",
+		"Travel/2019/Japan/b.jpg", "Travel/2019/c.jpg", "Home-2020/d.jpg"} {
+		path := filepath.Join(baseDir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte{}, 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	oldSettings := *settings
+	defer func() { *settings = oldSettings }()
+	settings.Capitalize = false
+	settings.NameSubstitutionTokens = ""
+	settings.AlbumName = "Everything"
+
+	tests := []struct {
+		layout  string
+		recurse bool
+		titles  []string
+		counts  []int
+	}{
+		{LayoutTopLevel, false, []string{"Home-2020"}, []int{1}},
+		{LayoutTopLevel, true, []string{"Home-2020", "Travel"}, []int{1, 3}},
+		{LayoutLeaf, false, []string{"Home-2020", "2019", "Japan"}, []int{1, 1, 2}},
+		{LayoutPath, false, []string{"Home-2020", "Travel / 2019", "Travel / 2019 / Japan"},
+			[]int{1, 1, 2}},
+		{LayoutSingle, false, []string{"Everything"}, []int{5}},
+		{LayoutNone, false, []string{""}, []int{5}},
+	}
+
+	for _, tt := range tests {
+		settings.Recurse = tt.recurse
+		layout, err := newAlbumLayout(tt.layout)
+		if err != nil {
+			t.Fatalf("failed to create layout: %v", err)
+		}
+
+		files := mustScanFiles(baseDir, baseDir, 0, layout.scanDepth())
+		groups := groupByAlbum(layout, files)
+
+		if len(groups) != len(tt.titles) {
+			t.Errorf("%v: expected %v albums, got %v", tt.layout, len(tt.titles), len(groups))
+			continue
+		}
+
+		for i, g := range groups {
+			if g.title != tt.titles[i] || len(g.files) != tt.counts[i] {
+				t.Errorf("%v: expected album '%v' with %v files, got '%v' with %v files",
+					tt.layout, tt.titles[i], tt.counts[i], g.title, len(g.files))
+			}
+		}
+	}
+
+	if _, err := newAlbumLayout("foo"); err == nil {
+		t.Errorf("should have failed to create unknown layout")
+	}
+}
+
+func TestEventLayout(t *testing.T) {
+	baseDir := t.TempDir()
+	start := time.Date(2019, 6, 14, 10, 0, 0, 0, time.Local)
+
+	offsets := map[string]time.Duration{
+		"a.jpg": 0,
+		"b.jpg": 2 * time.Hour,
+		"c.jpg": 20 * time.Hour,
+		"d.jpg": 30 * time.Hour,
+		"e.jpg": 24 * 20 * time.Hour,
+	}
+	for name, offset := range offsets {
+		path := filepath.Join(baseDir, "DCIM", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte{}, 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		if err := os.Chtimes(path, start.Add(offset), start.Add(offset)); err != nil {
+			t.Fatalf("failed to set file time: %v", err)
+		}
+	}
+
+	layout := &eventLayout{maxGap: DefaultEventGap}
+	groups := groupByAlbum(layout, mustScanFiles(baseDir, baseDir, 0, -1))
+
+	expected := []struct {
+		title string
+		count int
+	}{
+		{"14 Jun 2019", 2},
+		{"15 Jun 2019", 2},
+		{"4 Jul 2019", 1},
+	}
+
+	if len(groups) != len(expected) {
+		t.Fatalf("expected %v events, got %v", len(expected), len(groups))
+	}
+
+	for i, g := range groups {
+		if g.title != expected[i].title || len(g.files) != expected[i].count {
+			t.Errorf("expected event '%v' with %v files, got '%v' with %v files",
+				expected[i].title, expected[i].count, g.title, len(g.files))
+		}
+	}
+}
+
+func TestEventAlbumDate(t *testing.T) {
+	baseDir := t.TempDir()
+	start := time.Date(2019, 6, 14, 20, 0, 0, 0, time.Local)
+
+	// An event from the evening of 14 Jun to the noon of 15 Jun
+	times := map[string]time.Time{
+		"a.jpg": start,
+		"b.jpg": start.Add(8 * time.Hour),
+		"c.jpg": start.Add(16 * time.Hour),
+	}
+	for name, mtime := range times {
+		path := filepath.Join(baseDir, name)
+		if err := os.WriteFile(path, []byte{}, 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatalf("failed to set file time: %v", err)
+		}
+	}
+
+	layout := &eventLayout{maxGap: DefaultEventGap}
+	groups := groupByAlbum(layout, mustScanFiles(baseDir, baseDir, 0, -1))
+	if len(groups) != 1 || groups[0].title != "14-15 Jun 2019" {
+		t.Fatalf("expected a single event of 14-15 Jun 2019, got %v groups", len(groups))
+	}
+
+	albumDate, _ := resolveAlbumDate(groups[0])
+	for _, f := range groups[0].files {
+		d, source := resolveFileDate(albumDate, f)
+		if !d.Equal(times[f.info.Name()]) || source != DateSourceModTime {
+			t.Errorf("%v: expected %v from '%v', got %v from '%v'", f.info.Name(),
+				times[f.info.Name()], DateSourceModTime, d, source)
+		}
+	}
+}
+
+func TestFormatDateRange(t *testing.T) {
+	tests := []struct {
+		start, end time.Time
+		expected   string
+	}{
+		{time.Date(2019, 6, 14, 10, 0, 0, 0, time.UTC), time.Date(2019, 6, 14, 20, 0, 0, 0, time.UTC),
+			"14 Jun 2019"},
+		{time.Date(2019, 6, 14, 10, 0, 0, 0, time.UTC), time.Date(2019, 6, 16, 20, 0, 0, 0, time.UTC),
+			"14-16 Jun 2019"},
+		{time.Date(2019, 6, 30, 10, 0, 0, 0, time.UTC), time.Date(2019, 7, 2, 20, 0, 0, 0, time.UTC),
+			"30 Jun - 2 Jul 2019"},
+		{time.Date(2019, 12, 30, 10, 0, 0, 0, time.UTC), time.Date(2020, 1, 2, 20, 0, 0, 0, time.UTC),
+			"30 Dec 2019 - 2 Jan 2020"},
+	}
+
+	for _, tt := range tests {
+		if r := formatDateRange(tt.start, tt.end); r != tt.expected {
+			t.Errorf("expected '%v', got '%v'", tt.expected, r)
+		}
+	}
+}
+
+func TestDistanceKm(t *testing.T) {
+	helsinki := gpsPosition{lat: 60.1699, lon: 24.9384}
+	tampere := gpsPosition{lat: 61.4978, lon: 23.7610}
+
+	if d := distanceKm(helsinki, tampere); d < 155 || d > 165 {
+		t.Errorf("incorrect distance: %v", d)
+	}
+}
+
+func TestAlbumEnrichments(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, albumReadmeFilename),
+		[]byte("Midsummer at the cottage\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	group := &albumGroup{albumKey: albumKey{dir: dir}}
+	if d := albumDescription(group); d != "Midsummer at the cottage" {
+		t.Errorf("incorrect album description: '%v'", d)
+	}
+
+	o, err := parseAlbumOverride([]byte("description: From override\n" +
+		"location: {name: Hanko, lat: 59.82, lon: 22.97}\n" +
+		"map:\n  origin: {name: Helsinki, lat: 60.17, lon: 24.94}\n" +
+		"  destination: {name: Hanko, lat: 59.82, lon: 22.97}\n"))
+	if err != nil {
+		t.Fatalf("failed to parse album override: %v", err)
+	}
+	group.override = o
+
+	e := albumEnrichments(group)
+	if len(e) != 3 || e[0].Text != "From override" || e[1].Location.Name != "Hanko" ||
+		e[2].MapOrigin.Name != "Helsinki" || e[2].MapDestination.Latitude != 59.82 {
+		t.Errorf("incorrect album enrichments: %+v", e)
+	}
+
+	if _, err := parseAlbumOverride([]byte("map: {origin: {name: Helsinki}}\n")); err == nil {
+		t.Errorf("should have failed to parse map without destination")
+	}
+}
+
+func TestWriteIndex(t *testing.T) {
+	reports := []*AlbumReport{
+		{Title: "Trip | 2019", Items: 3, URL: "https://photos.google.com/a",
+			ShareURL: "https://photos.app.goo.gl/x"},
+		{Title: "<Home>", Items: 1, URL: "https://photos.google.com/b"},
+	}
+
+	dir := t.TempDir()
+
+	mdPath := filepath.Join(dir, "index.md")
+	if err := WriteIndex(mdPath, reports); err != nil {
+		t.Fatalf("failed to write index: %v", err)
+	}
+	md, _ := os.ReadFile(mdPath)
+	if !strings.Contains(string(md), "| Trip \\| 2019 | 3 | https://photos.app.goo.gl/x |") ||
+		!strings.Contains(string(md), "| <Home> | 1 | https://photos.google.com/b |") {
+		t.Errorf("incorrect Markdown index: %v", string(md))
+	}
+
+	htmlPath := filepath.Join(dir, "index.html")
+	if err := WriteIndex(htmlPath, reports); err != nil {
+		t.Fatalf("failed to write index: %v", err)
+	}
+	html, _ := os.ReadFile(htmlPath)
+	if !strings.Contains(string(html), `<a href="https://photos.google.com/b">&lt;Home&gt;</a>`) {
+		t.Errorf("incorrect HTML index: %v", string(html))
+	}
+}
+
+func TestResolveAlbumConflicts(t *testing.T) {
+	oldSettings := *settings
+	defer func() { *settings = oldSettings }()
+
+	m, err := mirror.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create mirror: %v", err)
+	}
+	target := &Target{Client: m}
+
+	reset := func(policy string) {
+		settings.ConflictPolicy = policy
+		target.albums = []*photos.Album{
+			{ID: "1", Title: "Trip", IsWriteable: false},
+			{ID: "2", Title: "Trip", IsWriteable: true},
+			{ID: "3", Title: "Trip (2)"},
+			{ID: "4", Title: "Home"},
+		}
+	}
+
+	reset(ConflictSkip)
+	if a, _, _ := target.resolveAlbum("Trip", "Trip"); a != nil {
+		t.Errorf("album should have been skipped")
+	}
+	if a, created, err := target.resolveAlbum("New", "New"); err != nil || a == nil || !created ||
+		a.Title != "New" {
+		t.Errorf("album should have been created")
+	}
+	if a, _, _ := target.resolveAlbum("New", "New"); a != nil {
+		t.Errorf("album created during the run should have been skipped")
+	}
+
+	reset(ConflictAppend)
+	if a, created, _ := target.resolveAlbum("Trip", "Trip"); a == nil || created || a.ID != "2" {
+		t.Errorf("should have appended to the writeable album, got %+v", a)
+	}
+	if a, _, _ := target.resolveAlbum("Home", "Home"); a != nil {
+		t.Errorf("should not have appended to a non-writeable album")
+	}
+
+	reset(ConflictSuffix)
+	if a, created, _ := target.resolveAlbum("Trip", "Trip"); a == nil || !created || a.Title != "Trip (3)" {
+		t.Errorf("should have created a suffixed album, got %+v", a)
+	}
+
+	// The suffixed album is recorded in the ledger and reused on later runs
+	baseDir := t.TempDir()
+	target.ledger, _ = readLedger(baseDir, "")
+	reset(ConflictSuffix)
+	suffixed, _, _ := target.resolveAlbum("Trip", "Trip")
+	target.ledger, _ = readLedger(baseDir, "")
+	if a, created, _ := target.resolveAlbum("Trip", "Trip"); a == nil || created ||
+		a.ID != suffixed.ID {
+
+		t.Errorf("should have reused the suffixed album %+v, got %+v", suffixed, a)
+	}
+	if a, _, _ := target.resolveAlbum("Other/Trip", "Trip"); a == nil || a.ID == suffixed.ID {
+		t.Errorf("should have created another suffixed album, got %+v", a)
+	}
+	target.ledger = nil
+
+	reset(ConflictFail)
+	if _, _, err := target.resolveAlbum("Trip", "Trip"); err == nil {
+		t.Errorf("should have failed on an existing album")
+	}
+
+	if err := checkConflictPolicy("foo"); err == nil {
+		t.Errorf("should have failed to accept unknown policy")
+	}
+}
+
+func TestConflictFail(t *testing.T) {
+	oldSettings := *settings
+	defer func() { *settings = oldSettings }()
+	settings.SkipConfirmation = true
+	settings.Layout = "leaf"
+	settings.NoParseYear = true
+	settings.ConflictPolicy = ConflictFail
+
+	prepare := prepareFileFunc
+	defer func() { prepareFileFunc = prepare }()
+	prepareFileFunc = func(photo *photoFile, albumDate util.AlbumDate) (string, error) {
+		tmp := filepath.Join(t.TempDir(), photo.info.Name())
+		return tmp, os.WriteFile(tmp, []byte(photo.info.Name()), 0644)
+	}
+
+	baseDir := t.TempDir()
+	for _, name := range []string{"Home/a.jpg", "Trip/b.jpg"} {
+		p := filepath.Join(baseDir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	mirrors := []*mirror.Mirror{}
+	targets := []*Target{}
+	for _, name := range []string{"one", "two"} {
+		m, err := mirror.New(t.TempDir())
+		if err != nil {
+			t.Fatalf("failed to create mirror: %v", err)
+		}
+		mirrors = append(mirrors, m)
+		targets = append(targets, &Target{Name: name, Client: m})
+	}
+	if _, err := mirrors[0].CreateAlbum("Home"); err != nil {
+		t.Fatalf("failed to create album: %v", err)
+	}
+
+	// The existing album aborts the run in all the targets
+	if failed := ProcessBaseDir(baseDir, targets); failed != 1 {
+		t.Errorf("expected 1 failed target, got %v", failed)
+	}
+	for i, m := range mirrors {
+		if items, _ := m.SearchMediaItems(&photos.SearchFilter{}); len(items) != 0 {
+			t.Errorf("%v: expected nothing to be uploaded, got %v items", targets[i].Name,
+				len(items))
+		}
+	}
+}
+
+// failingClient is a Photos client whose uploads fail
+type failingClient struct {
+	*mirror.Mirror
+}
+
+func (c *failingClient) UploadPhoto(path string, callback func(int64)) (string, error) {
+	return "", fmt.Errorf("upload failed")
+}
+
+// statusError is an HTTP error response
+type statusError int
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("status %v", int(e))
+}
+
+func (e statusError) StatusCode() int {
+	return int(e)
+}
+
+// flakyClient is a Photos client whose first upload of each file fails with
+// a server error
+type flakyClient struct {
+	*mirror.Mirror
+	failed map[string]bool
+	lock   sync.Mutex
+}
+
+func (c *flakyClient) UploadPhoto(path string, callback func(int64)) (string, error) {
+	c.lock.Lock()
+	failed := c.failed[path]
+	c.failed[path] = true
+	c.lock.Unlock()
+
+	if !failed {
+		return "", statusError(503)
+	}
+
+	return c.Mirror.UploadPhoto(path, callback)
+}
+
+func TestUploadTargets(t *testing.T) {
+	oldSettings := *settings
+	defer func() { *settings = oldSettings }()
+	settings.SkipConfirmation = true
+	settings.Layout = "leaf"
+	settings.NoParseYear = true
+	settings.UploadRetries = 1
+
+	delay := retryDelay
+	defer func() { retryDelay = delay }()
+	retryDelay = 0
+
+	baseDir := t.TempDir()
+	for _, name := range []string{"Trip/a.jpg", "Trip/b.jpg", "Home/c.jpg"} {
+		p := filepath.Join(baseDir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	newMirror := func() *mirror.Mirror {
+		m, err := mirror.New(t.TempDir())
+		if err != nil {
+			t.Fatalf("failed to create mirror: %v", err)
+		}
+		return m
+	}
+
+	// Prepare the files without exiftool
+	var prepared []string
+	var lock sync.Mutex
+	prepare := prepareFileFunc
+	defer func() { prepareFileFunc = prepare }()
+	prepareFileFunc = func(photo *photoFile, albumDate util.AlbumDate) (string, error) {
+		lock.Lock()
+		prepared = append(prepared, photo.info.Name())
+		lock.Unlock()
+		tmp := filepath.Join(t.TempDir(), photo.info.Name())
+		return tmp, os.WriteFile(tmp, []byte(photo.info.Name()), 0644)
+	}
+
+	ok := &Target{Name: "ok", Client: newMirror(), Concurrency: 2}
+	failing := &Target{Name: "failing", Client: &failingClient{newMirror()}}
+	flaky := &Target{Name: "flaky", Client: &flakyClient{Mirror: newMirror(),
+		failed: map[string]bool{}}}
+
+	if failed := ProcessBaseDir(baseDir, []*Target{ok, failing, flaky}); failed != 1 {
+		t.Errorf("expected 1 failed target, got %v", failed)
+	}
+
+	if len(prepared) != 3 {
+		t.Errorf("expected each file to be prepared once, got %v", prepared)
+	}
+	if ok.err != nil || len(ok.reports) != 2 || failing.err == nil {
+		t.Errorf("unexpected target states: %v, %v reports, %v", ok.err, len(ok.reports),
+			failing.err)
+	}
+
+	l, err := readLedger(baseDir, "ok")
+	if err != nil || len(l.Entries) != 3 {
+		t.Errorf("expected 3 ledger entries for the target, got %v: %v", len(l.Entries), err)
+	}
+	if l, _ := readLedger(baseDir, "failing"); len(l.Entries) != 0 {
+		t.Errorf("expected no ledger entries for the failed target")
+	}
+
+	// The failed uploads are retried
+	if flaky.err != nil || len(flaky.reports) != 2 {
+		t.Errorf("expected the retried uploads to succeed, got %v", flaky.err)
+	}
+	for _, c := range []struct {
+		name     string
+		counter  *metrics.Counter
+		labels   []string
+		expected float64
+	}{
+		{"retries", metrics.UploadRetries, []string{"flaky"}, 3},
+		{"errors", metrics.APIErrors, []string{"flaky", "503"}, 3},
+		{"files", metrics.FilesTotal, []string{"flaky", metrics.StatusUploaded}, 3},
+		{"files", metrics.FilesTotal, []string{"ok", metrics.StatusUploaded}, 3},
+		{"files", metrics.FilesTotal, []string{"failing", metrics.StatusFailed}, 1},
+		{"retries", metrics.UploadRetries, []string{"failing"}, 0},
+	} {
+		if v := c.counter.Value(c.labels...); v != c.expected {
+			t.Errorf("%v %v: expected %v, got %v", c.name, c.labels, c.expected, v)
+		}
+	}
+}
+
+// The targets upload the same files at the same time; run with -race
+func TestUploadTargetsDescriptions(t *testing.T) {
+	oldSettings := *settings
+	defer func() { *settings = oldSettings }()
+	settings.SkipConfirmation = true
+	settings.Layout = "leaf"
+	settings.NoParseYear = true
+
+	baseDir := t.TempDir()
+	for name, data := range map[string]string{
+		"Trip/a.jpg": "", "Trip/a.jpg.json": `{"description": "Sunset"}`,
+		"Trip/b.jpg": "", "Trip/c.jpg": "", "Trip/d.jpg": "",
+	} {
+		p := filepath.Join(baseDir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	prepare := prepareFileFunc
+	defer func() { prepareFileFunc = prepare }()
+	prepareFileFunc = func(photo *photoFile, albumDate util.AlbumDate) (string, error) {
+		tmp := filepath.Join(t.TempDir(), photo.info.Name())
+		return tmp, os.WriteFile(tmp, []byte(photo.info.Name()), 0644)
+	}
+
+	targets := []*Target{}
+	for _, name := range []string{"one", "two"} {
+		m, err := mirror.New(t.TempDir())
+		if err != nil {
+			t.Fatalf("failed to create mirror: %v", err)
+		}
+		targets = append(targets, &Target{Name: name, Client: m, Concurrency: 4})
+	}
+
+	if failed := ProcessBaseDir(baseDir, targets); failed != 0 {
+		t.Fatalf("expected no failed targets, got %v", failed)
+	}
+
+	for _, target := range targets {
+		items, err := target.Client.SearchMediaItems(&photos.SearchFilter{})
+		if err != nil || len(items) != 4 {
+			t.Fatalf("%v: expected 4 items, got %v: %v", target.Name, len(items), err)
+		}
+		for _, item := range items {
+			expected := ""
+			if item.FileName == "a.jpg" {
+				expected = "Sunset"
+			}
+			if item.Description != expected {
+				t.Errorf("%v: expected description '%v' for %v, got '%v'", target.Name,
+					expected, item.FileName, item.Description)
+			}
+		}
+	}
+}
+
+// enrichmentClient is a Photos client counting the enrichments added
+type enrichmentClient struct {
+	*mirror.Mirror
+	enrichments int
+}
+
+func (c *enrichmentClient) AddEnrichment(album *photos.Album, e *photos.Enrichment,
+	first bool) error {
+
+	c.enrichments++
+
+	return c.Mirror.AddEnrichment(album, e, first)
+}
+
+func TestAppendAlbum(t *testing.T) {
+	oldSettings := *settings
+	defer func() { *settings = oldSettings }()
+	settings.SkipConfirmation = true
+	settings.Layout = "leaf"
+	settings.NoParseYear = true
+	settings.ConflictPolicy = ConflictAppend
+
+	prepare := prepareFileFunc
+	defer func() { prepareFileFunc = prepare }()
+	prepareFileFunc = func(photo *photoFile, albumDate util.AlbumDate) (string, error) {
+		tmp := filepath.Join(t.TempDir(), photo.info.Name())
+		return tmp, os.WriteFile(tmp, []byte(photo.info.Name()), 0644)
+	}
+
+	baseDir := t.TempDir()
+	overridePath := filepath.Join(baseDir, "Trip", albumOverrideFilename)
+	os.MkdirAll(filepath.Dir(overridePath), 0755)
+	if err := os.WriteFile(overridePath, []byte("description: Trip to Lapland\n"+
+		"location: {name: Rovaniemi}\n"), 0644); err != nil {
+		t.Fatalf("failed to write album override: %v", err)
+	}
+
+	m, err := mirror.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create mirror: %v", err)
+	}
+	c := &enrichmentClient{Mirror: m}
+
+	// Create the album, then append new photos into it twice
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		if err := os.WriteFile(filepath.Join(baseDir, "Trip", name), []byte(name),
+			0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		if failed := ProcessBaseDir(baseDir, []*Target{{Client: c}}); failed != 0 {
+			t.Fatalf("%v: expected no failed targets", name)
+		}
+	}
+
+	albums, _ := m.ListAlbums()
+	if len(albums) != 1 || albums[0].MediaItemsCount != 3 {
+		t.Fatalf("expected the photos to be added into a single album, got %+v", albums)
+	}
+	if c.enrichments != 2 {
+		t.Errorf("expected the enrichments to be added once, got %v", c.enrichments)
+	}
+}
+
+// slowClient is a Photos client whose uploads of the files earlier in the
+// alphabet take longer, recording the order the items are added in
+type slowClient struct {
+	*mirror.Mirror
+	added []string
+}
+
+func (c *slowClient) UploadPhoto(path string, callback func(int64)) (string, error) {
+	time.Sleep(time.Duration('z'-filepath.Base(path)[0]) * time.Millisecond)
+
+	return c.Mirror.UploadPhoto(path, callback)
+}
+
+func (c *slowClient) AddToAlbum(album *photos.Album,
+	items []*photos.NewMediaItem) ([]*photos.MediaItem, error) {
+
+	for _, item := range items {
+		c.added = append(c.added, item.FileName)
+	}
+
+	return c.Mirror.AddToAlbum(album, items)
+}
+
+func TestUploadOrder(t *testing.T) {
+	baseDir := t.TempDir()
+	names := []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg", "e.jpg", "f.jpg"}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(baseDir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	prepare := prepareFileFunc
+	defer func() { prepareFileFunc = prepare }()
+	prepareFileFunc = func(photo *photoFile, albumDate util.AlbumDate) (string, error) {
+		return photo.path(), nil
+	}
+
+	m, err := mirror.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create mirror: %v", err)
+	}
+	c := &slowClient{Mirror: m}
+
+	group := &albumGroup{files: mustScanFiles(baseDir, baseDir, 0, 0)}
+	u := &albumUpload{target: &Target{Client: c, Concurrency: len(names)},
+		files: uploadableFiles(group)}
+	if _, err := u.uploadFiles(newPreparedFiles(util.AlbumDate{})); err != nil {
+		t.Fatalf("failed to upload files: %v", err)
+	}
+
+	// The items are added in the album order, not in the order of completion
+	if !reflect.DeepEqual(c.added, names) {
+		t.Errorf("expected the items to be added in order %v, got %v", names, c.added)
+	}
+}
+
+func TestSortFiles(t *testing.T) {
+	baseDir := t.TempDir()
+	for _, name := range []string{"IMG_10.jpg", "IMG_2.jpg", "img_1.jpg", "IMG-20190101-WA0001.jpg",
+		"IMG_20180101_101010.jpg"} {
+
+		if err := os.WriteFile(filepath.Join(baseDir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	oldOrder := settings.Order
+	defer func() { settings.Order = oldOrder }()
+
+	names := func(files []*photoFile) []string {
+		res := []string{}
+		for _, f := range files {
+			res = append(res, f.info.Name())
+		}
+		return res
+	}
+
+	mtime := time.Date(2020, 1, 1, 12, 0, 0, 0, time.Local)
+	for _, name := range []string{"IMG_10.jpg", "IMG_2.jpg", "img_1.jpg"} {
+		if err := os.Chtimes(filepath.Join(baseDir, name), mtime, mtime); err != nil {
+			t.Fatalf("failed to set file time: %v", err)
+		}
+		mtime = mtime.Add(-time.Hour)
+	}
+
+	tests := []struct {
+		order    string
+		manifest string
+		expected []string
+	}{
+		{order: OrderName, expected: []string{"IMG-20190101-WA0001.jpg", "img_1.jpg",
+			"IMG_2.jpg", "IMG_10.jpg", "IMG_20180101_101010.jpg"}},
+		{order: OrderTime, expected: []string{"IMG_20180101_101010.jpg",
+			"IMG-20190101-WA0001.jpg", "img_1.jpg", "IMG_2.jpg", "IMG_10.jpg"}},
+		// The files missing from the manifest come last, by name
+		{order: OrderManifest, manifest: "# cover first\nIMG_10.jpg\n\nmissing.jpg\nIMG_2.jpg\n",
+			expected: []string{"IMG_10.jpg", "IMG_2.jpg", "IMG-20190101-WA0001.jpg",
+				"img_1.jpg", "IMG_20180101_101010.jpg"}},
+		// Without a manifest, the files are ordered by name
+		{order: OrderManifest, expected: []string{"IMG-20190101-WA0001.jpg", "img_1.jpg",
+			"IMG_2.jpg", "IMG_10.jpg", "IMG_20180101_101010.jpg"}},
+	}
+
+	manifestPath := filepath.Join(baseDir, albumManifestFilename)
+	for _, test := range tests {
+		os.Remove(manifestPath)
+		if test.manifest != "" {
+			if err := os.WriteFile(manifestPath, []byte(test.manifest), 0644); err != nil {
+				t.Fatalf("failed to write manifest: %v", err)
+			}
+		}
+
+		settings.Order = test.order
+		group := &albumGroup{albumKey: albumKey{dir: baseDir},
+			files: mustScanFiles(baseDir, baseDir, 0, 0)}
+		if files := names(uploadableFiles(group)); !reflect.DeepEqual(files, test.expected) {
+			t.Errorf("%v: expected %v, got %v", test.order, test.expected, files)
+		}
+	}
+
+	if err := ValidateOrder("random"); err == nil {
+		t.Errorf("expected an error for an unknown order")
+	}
+}
+
+func TestPlan(t *testing.T) {
+	oldSettings := *settings
+	defer func() { *settings = oldSettings }()
+	settings.Layout = "leaf"
+	settings.NoParseYear = false
+	settings.ConflictPolicy = ""
+	settings.SkipConfirmation = false
+	settings.Include = nil
+	settings.Exclude = []string{"*_edited.jpg"}
+
+	baseDir := t.TempDir()
+	for name, data := range map[string]string{
+		"Trip 2019/IMG_2.jpg":        "2",
+		"Trip 2019/IMG_10.jpg":       "10",
+		"Trip 2019/notes.txt":        "notes",
+		"Trip 2019/.album.yaml":      "description: Trip\n",
+		"Trip 2019/IMG_2.jpg.json":   `{"description": "Beach"}`,
+		"Trip 2019/IMG_2.xmp":        "",
+		"Trip 2019/Thumbs.db":        "",
+		"Trip 2019/IMG_2_edited.jpg": "e",
+		"Trip 2019/raw.jpg":          "raw",
+		".photosignore":              "raw.jpg\n",
+		"Skipped 2018/a.jpg":         "a",
+		"Skipped 2018/.album.yaml":   "skip: true\n",
+		"Undated/b.jpg":              "b",
+	} {
+		p := filepath.Join(baseDir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	plan := MakePlan(baseDir)
+	albums := map[string]*PlanAlbum{}
+	for _, a := range plan.Albums {
+		albums[a.Dir] = a
+	}
+
+	trip := albums["Trip 2019"]
+	if trip == nil || trip.Skip != "" || trip.Title != "Trip 2019" || trip.Date != "2019" ||
+		len(trip.Files) != 2 || trip.Files[0].Path != "Trip 2019/IMG_2.jpg" {
+
+		t.Fatalf("unexpected planned album %+v", trip)
+	}
+	if len(trip.Skipped) != 1 || trip.Skipped[0].Reason != "unsupported file type" {
+		t.Errorf("expected notes.txt to be skipped, got %+v", trip.Skipped)
+	}
+	if a := albums["Skipped 2018"]; a == nil || a.Skip == "" || len(a.Files) > 0 {
+		t.Errorf("expected the album to be skipped, got %+v", a)
+	}
+	if a := albums["Undated"]; a == nil || a.Skip == "" {
+		t.Errorf("expected the undated album to be skipped, got %+v", a)
+	}
+
+	// Edit the plan: rename a file and reorder the files
+	planFile := filepath.Join(t.TempDir(), DefaultPlanFile)
+	if err := plan.Write(planFile); err != nil {
+		t.Fatalf("failed to write plan: %v", err)
+	}
+	plan, err := ReadPlan(planFile)
+	if err != nil {
+		t.Fatalf("failed to read plan: %v", err)
+	}
+	for _, a := range plan.Albums {
+		if a.Dir == "Trip 2019" {
+			trip = a
+		}
+	}
+	trip.Files[0], trip.Files[1] = trip.Files[1], trip.Files[0]
+	trip.Files[0].Name = "first.jpg"
+	trip.Files[0].Date = time.Date(2019, 6, 14, 10, 11, 12, 0, time.UTC)
+	trip.Title = "Summer Trip"
+
+	// The sidecars and the excluded files are not new files, also when
+	// checking the plan in a new process
+	filter = nil
+	if err := plan.Check(); err != nil {
+		t.Errorf("expected the plan to be unchanged, got %v", err)
+	}
+
+	prepare := prepareFileFunc
+	defer func() { prepareFileFunc = prepare }()
+	dates := map[string]time.Time{}
+	prepareFileFunc = func(photo *photoFile, albumDate util.AlbumDate) (string, error) {
+		dates[photo.info.Name()] = getDateForFile(albumDate, photo)
+		return photo.path(), nil
+	}
+
+	m, err := mirror.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create mirror: %v", err)
+	}
+	c := &slowClient{Mirror: m}
+	if failed := ApplyPlan(plan, []*Target{{Client: c}}); failed != 0 {
+		t.Fatalf("failed to apply plan")
+	}
+	if settings.SkipConfirmation {
+		t.Errorf("expected applying the plan not to change the confirmation setting")
+	}
+
+	if !reflect.DeepEqual(c.added, []string{"first.jpg", "IMG_2.jpg"}) {
+		t.Errorf("expected the planned files to be added, got %v", c.added)
+	}
+	if d := dates["IMG_10.jpg"]; !d.Equal(trip.Files[0].Date) {
+		t.Errorf("expected the planned date, got %v", d)
+	}
+	albumList, _ := m.ListAlbums()
+	if len(albumList) != 1 || albumList[0].Title != "Summer Trip" {
+		t.Errorf("expected only the planned album to be created, got %v", albumList)
+	}
+
+	// Modified and removed files
+	if err := os.WriteFile(filepath.Join(baseDir, "Trip 2019", "IMG_2.jpg"), []byte("22"),
+		0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	os.Remove(filepath.Join(baseDir, "Trip 2019", "IMG_10.jpg"))
+
+	// New files; the hidden ones and the ones of the skipped albums are ignored
+	for _, name := range []string{"Trip 2019/IMG_3.jpg", "Trip 2019/.DS_Store",
+		"Skipped 2018/c.jpg"} {
+		if err := os.WriteFile(filepath.Join(baseDir, filepath.FromSlash(name)), []byte("3"),
+			0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	var changed *PlanChangedError
+	if err := plan.Check(); !errors.As(err, &changed) || !reflect.DeepEqual(changed.Files,
+		[]string{"Trip 2019/IMG_10.jpg (missing)", "Trip 2019/IMG_2.jpg (modified)",
+			"Trip 2019/IMG_3.jpg (new)"}) {
+
+		t.Errorf("expected the files to have changed, got %v", err)
+	}
+}
+
+func TestReadPlan(t *testing.T) {
+	file := "- path: a.jpg\n    name: a.jpg\n    date: 2019-06-14T10:11:12Z\n"
+	tests := []struct {
+		plan string
+		err  bool
+	}{
+		{plan: "version: 1\nbase_dir: /photos\nalbums:\n- title: Trip\n  date: \"2019\"\n  files:\n  " + file},
+		{plan: "version: 2\nbase_dir: /photos\n", err: true},
+		{plan: "version: 1\nbase_dir: photos\n", err: true},
+		{plan: "version: 1\nbase_dir: /photos\nalbums:\n- dir: Trip\n", err: true},
+		{plan: "version: 1\nbase_dir: /photos\nalbums:\n- dir: Trip\n  skip: later\n"},
+		{plan: "version: 1\nbase_dir: /photos\nalbums:\n- library: true\n  files:\n  " + file},
+		{plan: "version: 1\nbase_dir: /photos\nalbums:\n- title: Trip\n  date: June\n", err: true},
+		{plan: "version: 1\nbase_dir: /photos\nalbums:\n- title: Trip\n  files:\n  " + file +
+			"  " + file, err: true},
+		{plan: "version: 1\nbase_dir: /photos\nalbums:\n- title: Trip\n  files:\n  - path: ../a.jpg\n" +
+			"    date: 2019-06-14T10:11:12Z\n", err: true},
+		{plan: "version: 1\nbase_dir: /photos\nalbums:\n- title: Trip\n  files:\n  - path: a.jpg\n",
+			err: true},
+		{plan: "version: 1\nbase_dir: /photos\nalbumz: []\n", err: true},
+	}
+
+	for i, test := range tests {
+		planFile := filepath.Join(t.TempDir(), DefaultPlanFile)
+		if err := os.WriteFile(planFile, []byte(test.plan), 0644); err != nil {
+			t.Fatalf("failed to write plan: %v", err)
+		}
+		if _, err := ReadPlan(planFile); (err != nil) != test.err {
+			t.Errorf("%v: expected error: %v, got %v", i, test.err, err)
+		}
+	}
+}
+
+func TestDiffAlbum(t *testing.T) {
+	baseDir := t.TempDir()
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg"} {
+		if err := os.WriteFile(filepath.Join(baseDir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	files := mustScanFiles(baseDir, baseDir, 0, 0)
+	sort.Slice(files, func(i, j int) bool { return files[i].info.Name() < files[j].info.Name() })
+
+	l, err := readLedger(baseDir, "")
+	if err != nil {
+		t.Fatalf("failed to read ledger: %v", err)
+	}
+
+	// a.jpg was renamed online; b.jpg has been modified after the upload
+	l.record(files[0], nil, &photos.MediaItem{ID: "1", FileName: "renamed.jpg"})
+	l.record(files[1], nil, &photos.MediaItem{ID: "2", FileName: "b.jpg"})
+	l.Entries[ledgerPath(files[1])].Size = 1
+
+	if err := l.save(); err != nil {
+		t.Fatalf("failed to save ledger: %v", err)
+	}
+	if l, err = readLedger(baseDir, ""); err != nil || len(l.Entries) != 2 {
+		t.Fatalf("failed to read back ledger: %v", err)
+	}
+
+	items := []*photos.MediaItem{
+		{ID: "1", FileName: "renamed.jpg"},
+		{ID: "2", FileName: "b.jpg"},
+		{ID: "3", FileName: "c.jpg"},
+		{ID: "4", FileName: "e.jpg"},
+	}
+
+	d := diffAlbum("Album", files, items, l)
+	if d.ok() {
+		t.Errorf("diff should have discrepancies")
+	}
+	if strings.Join(d.MissingOnline, ",") != "d.jpg" {
+		t.Errorf("expected d.jpg missing online, got %v", d.MissingOnline)
+	}
+	if strings.Join(d.ExtraOnline, ",") != "e.jpg" {
+		t.Errorf("expected e.jpg extra online, got %v", d.ExtraOnline)
+	}
+	if strings.Join(d.Changed, ",") != "b.jpg" {
+		t.Errorf("expected b.jpg changed, got %v", d.Changed)
+	}
+
+	items = []*photos.MediaItem{{ID: "5", FileName: "b.jpg"}, {ID: "6", FileName: "a.jpg"}}
+	d = diffAlbum("Album", files[:2], items, nil)
+	if !d.ok() {
+		t.Errorf("diff should match by file name, got %+v", d)
+	}
+}
+
+func TestDownloadPlanner(t *testing.T) {
+	if name := albumDirName("Trip: Japan / Kyoto"); name != "Trip_ Japan _ Kyoto" {
+		t.Errorf("unexpected album directory name: %v", name)
+	}
+	if name := albumDirName(".."); name != "Untitled" {
+		t.Errorf("unexpected album directory name: %v", name)
+	}
+
+	baseDir := t.TempDir()
+	created := time.Date(2019, 6, 14, 10, 0, 0, 0, time.UTC)
+
+	write := func(relPath string, modTime time.Time) os.FileInfo {
+		p := filepath.Join(baseDir, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(relPath), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		if err := os.Chtimes(p, modTime, modTime); err != nil {
+			t.Fatalf("failed to set file time: %v", err)
+		}
+		info, _ := os.Stat(p)
+		return info
+	}
+
+	l, _ := readLedger(baseDir, "")
+	album := &photos.Album{ID: "album", Title: "Trip"}
+	items := []*photos.MediaItem{
+		{ID: "1", FileName: "a.jpg", CreationTime: created},
+		{ID: "2", FileName: "b.jpg", CreationTime: created},
+		{ID: "3", FileName: "b.jpg", CreationTime: created},
+		{ID: "4", FileName: "c.jpg", CreationTime: created},
+		{ID: "5", FileName: "", MimeType: "image/jpeg"},
+	}
+
+	// a.jpg has been downloaded before; c.jpg was downloaded on an interrupted run
+	// and an unrelated b.jpg exists in the directory
+	l.put("Trip/a.jpg", write("Trip/a.jpg", created), album, items[0], true)
+	write("Trip/c.jpg", created)
+	write("Trip/b.jpg", created.Add(time.Hour))
+
+	p := &downloadPlanner{baseDir: baseDir, ledger: l, used: map[string]bool{}}
+	if dir := p.albumDirFor(album, map[string]bool{}); dir != "Trip" {
+		t.Errorf("expected album directory from the ledger, got %v", dir)
+	}
+
+	downloads := p.plan("Trip", album, items)
+	paths := []string{}
+	for _, d := range downloads {
+		paths = append(paths, d.relPath)
+	}
+
+	expected := "Trip/b (2).jpg,Trip/b (3).jpg,Trip/5.jpg"
+	if strings.Join(paths, ",") != expected {
+		t.Errorf("expected downloads %v, got %v", expected, paths)
+	}
+	if p.upToDate != 2 || l.findMediaItem("4", "Trip") == nil {
+		t.Errorf("expected a.jpg and c.jpg to be up to date, got %v", p.upToDate)
+	}
+
+	other := &photos.Album{ID: "other", Title: "Trip"}
+	if dir := p.albumDirFor(other, map[string]bool{"Trip": true}); dir != "Trip (2)" {
+		t.Errorf("expected a suffixed album directory, got %v", dir)
+	}
+}
+
+func TestSidecars(t *testing.T) {
+	baseDir := t.TempDir()
+
+	takeout := `{
+  "title": "IMG_1.jpg",
+  "description": "Sunset at the beach",
+  "photoTakenTime": {"timestamp": "1560507072", "formatted": "Jun 14, 2019, 10:11:12 AM UTC"},
+  "geoData": {"latitude": 60.1699, "longitude": 24.9384, "altitude": 0.0},
+  "favorited": true
+}`
+	noLocation := `{"photoTakenTime": {"timestamp": "1560507072"},
+  "geoData": {"latitude": 0.0, "longitude": 0.0}}`
+	xmp := `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:exif="http://ns.adobe.com/exif/1.0/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmp:CreateDate="2018-01-01T00:00:00"
+    exif:DateTimeOriginal="2019-06-14T10:11:12.50"
+    exif:GPSLatitude="33,51.408S"
+    exif:GPSLongitude="151,12,55.08E">
+   <dc:description>
+    <rdf:Alt>
+     <rdf:li xml:lang="x-default">Opera House</rdf:li>
+    </rdf:Alt>
+   </dc:description>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+
+	for name, data := range map[string]string{
+		"IMG_1.jpg": "", "IMG_1.jpg.json": takeout,
+		"IMG_2.JPG": "", "IMG_2.xmp": xmp,
+		"IMG_3(1).jpg": "", "IMG_3.jpg(1).json": noLocation,
+		"IMG_4-edited.jpg": "", "IMG_4.jpg.supplemental-metadata.json": noLocation,
+		"plain.jpg": "", "broken.jpg": "", "broken.jpg.json": "{",
+	} {
+		if err := os.WriteFile(filepath.Join(baseDir, name), []byte(data), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	mtime := time.Date(2020, 1, 1, 12, 0, 0, 0, time.Local)
+	if err := os.Chtimes(filepath.Join(baseDir, "plain.jpg"), mtime, mtime); err != nil {
+		t.Fatalf("failed to set file time: %v", err)
+	}
+
+	taken := time.Unix(1560507072, 0)
+	tests := map[string]struct {
+		sidecar     string
+		date        time.Time
+		description string
+		lat         float64
+	}{
+		"IMG_1.jpg": {sidecar: "IMG_1.jpg.json", date: taken,
+			description: "Sunset at the beach", lat: 60.1699},
+		"IMG_2.JPG": {sidecar: "IMG_2.xmp",
+			date:        time.Date(2019, 6, 14, 10, 11, 12, 500000000, time.Local),
+			description: "Opera House", lat: -33.8568},
+		"IMG_3(1).jpg":     {sidecar: "IMG_3.jpg(1).json", date: taken},
+		"IMG_4-edited.jpg": {sidecar: "IMG_4.jpg.supplemental-metadata.json", date: taken},
+		"plain.jpg":        {date: mtime},
+		"broken.jpg":       {sidecar: "broken.jpg.json"},
+	}
+
+	files := mustScanFiles(baseDir, baseDir, 0, 0)
+	if len(files) != len(tests) {
+		t.Fatalf("expected %v files, got %v", len(tests), len(files))
+	}
+
+	albumDate := util.AlbumDate{Time: time.Date(2019, 1, 1, 0, 0, 0, 0, time.Local),
+		Precision: util.PrecisionYear}
+
+	for _, f := range files {
+		test, ok := tests[f.info.Name()]
+		if !ok {
+			t.Errorf("unexpected file %v", f.info.Name())
+			continue
+		}
+		if f.sidecarName != test.sidecar {
+			t.Errorf("%v: expected sidecar '%v', got '%v'", f.info.Name(), test.sidecar,
+				f.sidecarName)
+		}
+		if d := itemDescription(f); d != test.description {
+			t.Errorf("%v: expected description '%v', got '%v'", f.info.Name(),
+				test.description, d)
+		}
+
+		pos, ok := f.position()
+		if ok != (test.lat != 0) || fmt.Sprintf("%.4f", pos.lat) != fmt.Sprintf("%.4f", test.lat) {
+			t.Errorf("%v: expected latitude %v, got %v (%v)", f.info.Name(), test.lat,
+				pos.lat, ok)
+		}
+
+		// The sidecar dates within the album date are used
+		expected := test.date
+		if expected.IsZero() || f.sidecarName == "" {
+			expected = albumDate.Time
+		}
+		if d := getDateForFile(albumDate, f); !d.Equal(expected) {
+			t.Errorf("%v: expected file date %v, got %v", f.info.Name(), expected, d)
+		}
+		if !test.date.IsZero() && !f.capturedAt().Equal(test.date) {
+			t.Errorf("%v: expected capture time %v, got %v", f.info.Name(), test.date,
+				f.capturedAt())
+		}
+	}
+}
+
+func TestDateSources(t *testing.T) {
+	baseDir := t.TempDir()
+
+	for name, data := range map[string]string{
+		"IMG-20190614-WA0003.jpg": "",
+		"IMG-20190615-WA0001.jpg": "", "IMG-20190615-WA0001.jpg.json": `{"photoTakenTime": {"timestamp": "1560507072"}}`,
+		"plain.jpg": "", "scan.jpg": "",
+	} {
+		if err := os.WriteFile(filepath.Join(baseDir, name), []byte(data), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	// A photo of 1985 scanned in 2023
+	scanned := time.Date(2023, 3, 1, 10, 0, 0, 0, time.Local)
+	if err := os.Chtimes(filepath.Join(baseDir, "scan.jpg"), scanned, scanned); err != nil {
+		t.Fatalf("failed to set file time: %v", err)
+	}
+
+	mtime := time.Date(2019, 7, 1, 12, 0, 0, 0, time.Local)
+	for _, name := range []string{"IMG-20190614-WA0003.jpg", "IMG-20190615-WA0001.jpg",
+		"plain.jpg"} {
+
+		if err := os.Chtimes(filepath.Join(baseDir, name), mtime, mtime); err != nil {
+			t.Fatalf("failed to set file time: %v", err)
+		}
+	}
+
+	files := map[string]*photoFile{}
+	for _, f := range mustScanFiles(baseDir, baseDir, 0, 0) {
+		files[f.info.Name()] = f
+	}
+	scan := files["scan.jpg"]
+	scan.tagsOnce.Do(func() {
+		scan.tags = exifTags{"DateTimeOriginal": exif.ExifTag{TagTypeId: exifcommon.TypeAscii,
+			Value: scanned.Format("2006:01:02 15:04:05")}}
+	})
+
+	filename14 := time.Date(2019, 6, 14, 12, 0, 0, 0, time.Local)
+	filename15 := time.Date(2019, 6, 15, 12, 0, 0, 0, time.Local)
+	taken := time.Unix(1560507072, 0)
+	inYear := util.AlbumDate{Time: time.Date(2019, 1, 1, 0, 0, 0, 0, time.Local),
+		Precision: util.PrecisionYear}
+	inDay := util.AlbumDate{Time: time.Date(2019, 6, 1, 0, 0, 0, 0, time.Local),
+		Precision: util.PrecisionDay}
+
+	tests := []struct {
+		sources   []string
+		file      string
+		albumDate util.AlbumDate
+		expected  time.Time
+		source    string
+	}{
+		{file: "IMG-20190614-WA0003.jpg", albumDate: inYear, expected: filename14,
+			source: DateSourceFilename},
+		{file: "IMG-20190615-WA0001.jpg", albumDate: inYear, expected: taken,
+			source: DateSourceSidecar},
+		{file: "plain.jpg", albumDate: inYear, expected: mtime, source: DateSourceModTime},
+		// The modification time is used only within the album date
+		{file: "plain.jpg", albumDate: inDay, expected: inDay.Time, source: DateSourceModTime},
+		{sources: []string{DateSourceFilename, DateSourceSidecar},
+			file: "IMG-20190615-WA0001.jpg", albumDate: inYear, expected: filename15,
+			source: DateSourceFilename},
+		{sources: []string{DateSourceModTime}, file: "IMG-20190614-WA0003.jpg",
+			albumDate: inYear, expected: mtime, source: DateSourceModTime},
+		{sources: []string{DateSourceSidecar}, file: "plain.jpg", albumDate: inDay,
+			expected: inDay.Time},
+		// The modification time is not used if it is not a date source
+		{sources: []string{DateSourceSidecar}, file: "plain.jpg", albumDate: inYear,
+			expected: inYear.Time},
+	}
+
+	defer func() { settings.DateSources = nil }()
+
+	for _, test := range tests {
+		settings.DateSources = test.sources
+		f := files[test.file]
+		if _, source := f.date(); source != test.source {
+			t.Errorf("%v %v: expected source '%v', got '%v'", test.sources, test.file,
+				test.source, source)
+		}
+		if d := getDateForFile(test.albumDate, f); !d.Equal(test.expected) {
+			t.Errorf("%v %v: expected file date %v, got %v", test.sources, test.file,
+				test.expected, d)
+		}
+	}
+
+	// The dates outside the album date are skipped for the next source
+	settings.DateSources = nil
+	in1985 := util.AlbumDate{Time: time.Date(1985, 1, 1, 0, 0, 0, 0, time.Local),
+		Precision: util.PrecisionYear}
+	in2023 := util.AlbumDate{Time: time.Date(2023, 1, 1, 0, 0, 0, 0, time.Local),
+		Precision: util.PrecisionYear}
+	inDay15 := util.AlbumDate{Time: time.Date(2019, 6, 15, 0, 0, 0, 0, time.Local),
+		Precision: util.PrecisionDay}
+
+	for _, test := range []struct {
+		file      string
+		albumDate util.AlbumDate
+		expected  time.Time
+		source    string
+	}{
+		{file: "scan.jpg", albumDate: in1985, expected: in1985.Time, source: dateSourceAlbum},
+		{file: "scan.jpg", albumDate: in2023, expected: scanned, source: DateSourceExif},
+		{file: "IMG-20190614-WA0003.jpg", albumDate: in1985, expected: in1985.Time,
+			source: dateSourceAlbum},
+		{file: "IMG-20190615-WA0001.jpg", albumDate: inDay15, expected: filename15,
+			source: DateSourceFilename},
+	} {
+		d, source := resolveFileDate(test.albumDate, files[test.file])
+		if !d.Equal(test.expected) || source != test.source {
+			t.Errorf("%v in %v: expected %v from '%v', got %v from '%v'", test.file,
+				test.albumDate, test.expected, test.source, d, source)
+		}
+	}
+}
+
+func TestParseDateSources(t *testing.T) {
+	sources, err := ParseDateSources("filename, exif")
+	if err != nil || !reflect.DeepEqual(sources, []string{DateSourceFilename, DateSourceExif}) {
+		t.Errorf("unexpected date sources %v: %v", sources, err)
+	}
+
+	if _, err := ParseDateSources("exif,ctime"); err == nil {
+		t.Errorf("expected an error for an unknown date source")
+	}
+}
+
+func TestPhotoTimezones(t *testing.T) {
+	baseDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(baseDir, "Japan", "Canon"), 0755); err != nil {
+		t.Fatalf("failed to create directories: %v", err)
+	}
+
+	for name, data := range map[string]string{
+		"IMG_20190614_101112.jpg":             "",
+		"Japan/.album.yaml":                   "timezone: Asia/Tokyo\n",
+		"Japan/IMG_20190614_101112.jpg":       "",
+		"Japan/IMG_20190615_101112.jpg.json":  `{"photoTakenTime": {"timestamp": "1560507072"}}`,
+		"Japan/IMG_20190615_101112.jpg":       "",
+		"Japan/Canon/.album.yaml":             "clock_offset: +1h23m\n",
+		"Japan/Canon/IMG_20190616_101112.jpg": "",
+	} {
+		if err := os.WriteFile(filepath.Join(baseDir, filepath.FromSlash(name)), []byte(data),
+			0644); err != nil {
+
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skipf("no timezone database: %v", err)
+	}
+
+	tests := map[string]time.Time{
+		"IMG_20190614_101112.jpg":       time.Date(2019, 6, 14, 10, 11, 12, 0, time.Local),
+		"Japan/IMG_20190614_101112.jpg": time.Date(2019, 6, 14, 10, 11, 12, 0, tokyo),
+		// The sidecar time is converted into the timezone of the directory
+		"Japan/IMG_20190615_101112.jpg": time.Unix(1560507072, 0).In(tokyo),
+		// The timezone is inherited; the clock offset is subtracted
+		"Japan/Canon/IMG_20190616_101112.jpg": time.Date(2019, 6, 16, 8, 48, 12, 0, tokyo),
+	}
+
+	albumDate := util.AlbumDate{Time: time.Date(2019, 1, 10, 10, 10, 10, 0, time.UTC),
+		Precision: util.PrecisionYear}
+
+	for _, f := range mustScanFiles(baseDir, baseDir, 0, -1) {
+		name := path.Join(f.relDir, f.info.Name())
+		expected, ok := tests[name]
+		if !ok {
+			continue
+		}
+		delete(tests, name)
+
+		d := getDateForFile(albumDate, f)
+		if !d.Equal(expected) || d.Location().String() != expected.Location().String() {
+			t.Errorf("%v: expected %v, got %v", name, expected, d)
+		}
+	}
+
+	if len(tests) > 0 {
+		t.Errorf("files not found: %v", tests)
+	}
+}
+
+func TestCaptureTime(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+	ascii := func(s string) exif.ExifTag {
+		return exif.ExifTag{TagTypeId: exifcommon.TypeAscii, Value: s}
+	}
+
+	tests := []struct {
+		name     string
+		tags     exifTags
+		expected time.Time
+	}{
+		{name: "naive", tags: exifTags{"DateTimeOriginal": ascii("2019:06:14 10:11:12")},
+			expected: time.Date(2019, 6, 14, 10, 11, 12, 0, tokyo)},
+		{name: "offset", tags: exifTags{"DateTimeOriginal": ascii("2019:06:14 10:11:12"),
+			"OffsetTimeOriginal": ascii("+03:00")},
+			expected: time.Date(2019, 6, 14, 7, 11, 12, 0, time.UTC)},
+		{name: "offset of another tag", tags: exifTags{"DateTime": ascii("2019:06:14 10:11:12"),
+			"OffsetTimeOriginal": ascii("+03:00")},
+			expected: time.Date(2019, 6, 14, 10, 11, 12, 0, tokyo)},
+		{name: "invalid offset", tags: exifTags{"DateTimeOriginal": ascii("2019:06:14 10:11:12"),
+			"OffsetTimeOriginal": ascii("   :  ")},
+			expected: time.Date(2019, 6, 14, 10, 11, 12, 0, tokyo)},
+	}
+
+	for _, test := range tests {
+		d, err := test.tags.captureTime(tokyo)
+		if err != nil || !d.Equal(test.expected) {
+			t.Errorf("%v: expected %v, got %v: %v", test.name, test.expected, d, err)
+		}
+	}
+
+	if _, err := (exifTags{}).captureTime(tokyo); err != errNoExifDate {
+		t.Errorf("expected no EXIF date, got %v", err)
+	}
+}
+
+func TestParseClockOffset(t *testing.T) {
+	model, offset, err := ParseClockOffset("Canon EOS 5D = -1h23m")
+	if err != nil || model != "Canon EOS 5D" || offset != -83*time.Minute {
+		t.Errorf("unexpected clock offset %v=%v: %v", model, offset, err)
+	}
+
+	for _, s := range []string{"Canon EOS 5D", "=1h", "Canon=1 hour"} {
+		if _, _, err := ParseClockOffset(s); err == nil {
+			t.Errorf("%v: expected an error", s)
+		}
+	}
+}
+
+func TestSidecarNames(t *testing.T) {
+	long := "a_very_long_file_name_from_a_google_takeout_export.jpg"
+	names := sidecarNames(long)
+	if truncated := long[:46] + ".json"; !strings.Contains(strings.Join(names, " "), truncated) {
+		t.Errorf("expected %v in %v", truncated, names)
+	}
+
+	if names := sidecarNames("DSC_0001.NEF"); names[len(names)-2] != "DSC_0001.xmp" {
+		t.Errorf("expected the Lightroom sidecar in %v", names)
+	}
+}
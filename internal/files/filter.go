@@ -0,0 +1,369 @@
+package files
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/matti777/google-photos-uploader/internal/logging"
+)
+
+const (
+	// Name of the optional gitignore style exclusion file; may be placed in
+	// the base directory or in any directory below it.
+	ignoreFilename = ".photosignore"
+
+	// Prefix which marks a pattern as a regular expression instead of a glob
+	regexPatternPrefix = "re:"
+)
+
+// Patterns that are always excluded; hidden files and directories as well as
+// the thumbnail directories created by Synology NAS devices.
+var defaultExcludePatterns = []string{".*", "@eaDir/", "Thumbs/", "Thumbs.db"}
+
+// filterRule is a single include or exclude pattern.
+type filterRule struct {
+	// The pattern as written by the user; used for reporting
+	pattern string
+
+	// Where the pattern came from (command line, file path, ..)
+	source string
+
+	// Directory (relative to the base directory, slash separated) the
+	// pattern is relative to; empty for the base directory itself
+	base string
+
+	// Whether the pattern was negated with '!'
+	negate bool
+
+	// Whether the pattern only matches directories (trailing '/')
+	dirOnly bool
+
+	// Whether the pattern is matched against the full relative path (it
+	// contains a '/' or is a regex) instead of just the file name
+	anchored bool
+
+	re *regexp.Regexp
+}
+
+// pathFilter decides which directories and files are processed.
+// Exclude rules are evaluated gitignore style: the last matching rule wins
+// and a negated rule ('!pattern') re-includes a previously excluded path.
+type pathFilter struct {
+	baseDir  string
+	includes []*filterRule
+	excludes []*filterRule
+
+	// Directories whose ignore files have already been loaded
+	loadedDirs map[string]bool
+}
+
+// filterResult describes why a path was excluded.
+type filterResult struct {
+	excluded bool
+	reason   string
+}
+
+// globToRegexp converts a gitignore style glob into a regular expression
+// which matches a slash separated path.
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			b.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated character class in '%v'", glob)
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	b.WriteString("$")
+
+	return regexp.Compile(b.String())
+}
+
+// newFilterRule parses a single pattern. Returns nil (and no error) for
+// empty patterns and comments.
+func newFilterRule(pattern, source, base string) (*filterRule, error) {
+	p := strings.TrimRight(pattern, " \r\n")
+	if p == "" || strings.HasPrefix(p, "#") {
+		return nil, nil
+	}
+
+	r := &filterRule{pattern: p, source: source, base: base}
+
+	if strings.HasPrefix(p, "!") {
+		r.negate = true
+		p = p[1:]
+	}
+
+	var err error
+
+	if strings.HasPrefix(p, regexPatternPrefix) {
+		r.anchored = true
+		r.re, err = regexp.Compile(strings.TrimPrefix(p, regexPatternPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex pattern '%v': %w", pattern, err)
+		}
+		return r, nil
+	}
+
+	if strings.HasSuffix(p, "/") {
+		r.dirOnly = true
+		p = strings.TrimRight(p, "/")
+	}
+
+	if strings.Contains(p, "/") {
+		r.anchored = true
+		p = strings.TrimPrefix(p, "/")
+	}
+
+	r.re, err = globToRegexp(p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid glob pattern '%v': %w", pattern, err)
+	}
+
+	return r, nil
+}
+
+// matches tells whether the rule matches a path relative to the base
+// directory (slash separated).
+func (r *filterRule) matches(relPath string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+
+	if r.base != "" {
+		if !strings.HasPrefix(relPath, r.base+"/") {
+			return false
+		}
+		relPath = strings.TrimPrefix(relPath, r.base+"/")
+	}
+
+	if r.anchored {
+		return r.re.MatchString(relPath)
+	}
+
+	return r.re.MatchString(path.Base(relPath))
+}
+
+// describe returns a human readable description of the rule for reporting.
+func (r *filterRule) describe() string {
+	return fmt.Sprintf("'%v' (%v)", r.pattern, r.source)
+}
+
+// newPathFilter creates a new filter for the base directory using the default
+// exclude patterns, the given command line patterns and the base directory's
+// ignore file, if any.
+func newPathFilter(baseDir string, includes, excludes []string) (*pathFilter, error) {
+	f := &pathFilter{baseDir: baseDir, loadedDirs: map[string]bool{}}
+
+	for _, p := range defaultExcludePatterns {
+		if err := f.addExclude(p, "default", ""); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, p := range excludes {
+		if err := f.addExclude(p, "--exclude", ""); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, p := range includes {
+		r, err := newFilterRule(p, "--include", "")
+		if err != nil {
+			return nil, err
+		}
+		if r != nil {
+			f.includes = append(f.includes, r)
+		}
+	}
+
+	if err := f.loadIgnoreFile(baseDir); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *pathFilter) addExclude(pattern, source, base string) error {
+	r, err := newFilterRule(pattern, source, base)
+	if err != nil {
+		return err
+	}
+	if r != nil {
+		f.excludes = append(f.excludes, r)
+	}
+
+	return nil
+}
+
+// relativePath returns the slash separated path relative to the base directory
+func (f *pathFilter) relativePath(absolutePath string) string {
+	rel, err := filepath.Rel(f.baseDir, absolutePath)
+	if err != nil {
+		return filepath.ToSlash(absolutePath)
+	}
+
+	return filepath.ToSlash(rel)
+}
+
+// loadIgnoreFile reads the ignore file in the given directory, if one exists.
+// Its patterns apply to the contents of that directory.
+func (f *pathFilter) loadIgnoreFile(absoluteDirPath string) error {
+	if f.loadedDirs[absoluteDirPath] {
+		return nil
+	}
+	f.loadedDirs[absoluteDirPath] = true
+
+	filePath := filepath.Join(absoluteDirPath, ignoreFilename)
+	file, err := os.Open(filePath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to open %v: %w", filePath, err)
+	}
+	defer file.Close()
+
+	base := f.relativePath(absoluteDirPath)
+	if base == "." {
+		base = ""
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if err := f.addExclude(scanner.Text(), filePath, base); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// check tells whether a path is excluded, and why.
+func (f *pathFilter) check(absolutePath string, isDir bool) filterResult {
+	relPath := f.relativePath(absolutePath)
+
+	var matched *filterRule
+	for _, r := range f.excludes {
+		if r.matches(relPath, isDir) {
+			matched = r
+		}
+	}
+
+	if matched != nil && !matched.negate {
+		return filterResult{excluded: true,
+			reason: fmt.Sprintf("matches exclude pattern %v", matched.describe())}
+	}
+
+	// Include patterns only apply to the kind of entry they target:
+	// patterns ending with a '/' select directories, others select files.
+	// As with the excludes, the last matching pattern decides.
+	hasIncludes := false
+	for _, r := range f.includes {
+		if r.dirOnly == isDir {
+			hasIncludes = true
+		}
+	}
+
+	if r := f.lastInclude(relPath, isDir); r != nil {
+		if r.negate {
+			return filterResult{excluded: true,
+				reason: fmt.Sprintf("matches negated include pattern %v", r.describe())}
+		}
+		return filterResult{reason: fmt.Sprintf("matches include pattern %v", r.describe())}
+	}
+
+	// As with gitignore, the subdirectories of an included directory are
+	// included; the nearest matching ancestor decides
+	if hasIncludes && isDir {
+		for dir := path.Dir(relPath); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			r := f.lastInclude(dir, true)
+			if r == nil {
+				continue
+			}
+			if r.negate {
+				return filterResult{excluded: true, reason: fmt.Sprintf(
+					"is below a directory matching negated include pattern %v", r.describe())}
+			}
+			return filterResult{reason: fmt.Sprintf(
+				"is below a directory matching include pattern %v", r.describe())}
+		}
+	}
+
+	if hasIncludes {
+		return filterResult{excluded: true, reason: "does not match any include pattern"}
+	}
+
+	return filterResult{}
+}
+
+// lastInclude returns the last include pattern of the kind of the entry that
+// matches the path, or nil if none does.
+func (f *pathFilter) lastInclude(relPath string, isDir bool) *filterRule {
+	var res *filterRule
+	for _, r := range f.includes {
+		if r.dirOnly == isDir && r.matches(relPath, isDir) {
+			res = r
+		}
+	}
+
+	return res
+}
+
+// isExcluded tells whether a path is excluded by the filter, reporting the
+// reason. A nil filter excludes nothing.
+func (f *pathFilter) isExcluded(absolutePath string, isDir bool) bool {
+	if f == nil {
+		return false
+	}
+
+	res := f.check(absolutePath, isDir)
+	if res.excluded {
+		entry := log.WithField(logging.FieldFile, f.relativePath(absolutePath))
+		if settings.DryRun {
+			entry.Infof("Excluded: %v", res.reason)
+		} else {
+			entry.Debugf("Excluded: %v", res.reason)
+		}
+	}
+
+	return res.excluded
+}
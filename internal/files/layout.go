@@ -0,0 +1,371 @@
+package files
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matti777/google-photos-uploader/internal/logging"
+	"github.com/matti777/google-photos-uploader/internal/util"
+)
+
+// Names of the supported album layouts
+const (
+	LayoutTopLevel = "top"
+	LayoutLeaf     = "leaf"
+	LayoutPath     = "path"
+	LayoutMonth    = "month"
+	LayoutYear     = "year"
+	LayoutSingle   = "single"
+	LayoutNone     = "none"
+)
+
+// Layouts lists the names of all the supported album layouts
+var Layouts = []string{LayoutTopLevel, LayoutLeaf, LayoutPath, LayoutMonth,
+	LayoutYear, LayoutEvents, LayoutSingle, LayoutNone}
+
+// Separator between the directory names in album titles of the path layout
+const pathTitleSeparator = " / "
+
+// photoFile is a single (image) file found when scanning the base directory.
+type photoFile struct {
+	// Absolute path of the directory containing the file
+	dir string
+
+	// Directory path relative to the base directory (slash separated);
+	// "." for the base directory itself
+	relDir string
+
+	info os.FileInfo
+
+	// EXIF tags of the file; read on demand
+	tags     exifTags
+	tagsOnce sync.Once
+
+	// Name of the sidecar file holding the metadata of the file; empty if none
+	sidecarName string
+
+	// Metadata of the sidecar file; read on demand
+	meta     *sidecar
+	metaOnce sync.Once
+
+	// Timezone and camera clock offset of the directory of the file
+	clock photoClock
+
+	// The file in the upload plan being applied; its name, date and
+	// description are used instead of the resolved ones. Nil if not applying
+	// a plan.
+	plan *PlanFile
+}
+
+// uploadName returns the file name of the uploaded photo
+func (f *photoFile) uploadName() string {
+	if f.plan != nil && f.plan.Name != "" {
+		return f.plan.Name
+	}
+
+	return f.info.Name()
+}
+
+// path returns the absolute path of the file.
+func (f *photoFile) path() string {
+	return filepath.Join(f.dir, f.info.Name())
+}
+
+// exifTags returns the EXIF tags of the file; nil if the file has none.
+func (f *photoFile) exifTags() exifTags {
+	f.tagsOnce.Do(func() {
+		tags, err := readExifTags(f.path())
+		if err != nil {
+			log.Debugf("No EXIF data for %v: %v", f.path(), err)
+		}
+		f.tags = tags
+	})
+
+	return f.tags
+}
+
+// sidecar returns the metadata of the file's sidecar file; nil if the file
+// has none.
+func (f *photoFile) sidecar() *sidecar {
+	f.metaOnce.Do(func() {
+		if f.sidecarName == "" {
+			return
+		}
+
+		meta, err := readSidecar(filepath.Join(f.dir, f.sidecarName))
+		if err != nil {
+			log.WithField(logging.FieldFile, ledgerPath(f)).WithError(err).
+				Warnf("Failed to read sidecar %v", f.sidecarName)
+			return
+		}
+		f.meta = meta
+	})
+
+	return f.meta
+}
+
+// position returns the GPS position of the photo from its EXIF data or its
+// sidecar, if it has one.
+func (f *photoFile) position() (gpsPosition, bool) {
+	if pos, err := f.exifTags().gpsPosition(); err == nil {
+		return pos, true
+	}
+
+	if s := f.sidecar(); s != nil && s.position != nil {
+		return *s.position, true
+	}
+
+	return gpsPosition{}, false
+}
+
+// albumKey identifies the album a file belongs to.
+type albumKey struct {
+	// Unique key of the album; empty when the files are not added to any album
+	key string
+
+	// Album title (before any override)
+	title string
+
+	// Absolute path of the directory the album is derived from; empty if the
+	// album does not correspond to a directory
+	dir string
+
+	// Names to parse the album date from, in order of preference
+	dateNames []string
+
+	// Album date; if set, it is used instead of parsing dateNames
+	date util.AlbumDate
+}
+
+// albumLayout is a strategy for mapping the scanned files into albums.
+type albumLayout interface {
+	// scanDepth returns the maximum depth of directories (relative to the
+	// base directory) to scan, or -1 for no limit.
+	scanDepth() int
+
+	// albumFor returns the album the file belongs to, or false if the file
+	// is not to be uploaded.
+	albumFor(f *photoFile) (albumKey, bool)
+}
+
+// preparingLayout is implemented by the layouts which must see all the files
+// before mapping them into albums.
+type preparingLayout interface {
+	albumLayout
+
+	prepare(files []*photoFile)
+}
+
+// albumGroup holds the files to be uploaded into a single album.
+type albumGroup struct {
+	albumKey
+
+	// Album override file of the album directory, if any
+	override *albumOverride
+
+	files []*photoFile
+
+	// Whether the album comes from an upload plan; its files, title and date
+	// are as planned
+	planned bool
+}
+
+// newAlbumLayout creates the album layout strategy by name.
+func newAlbumLayout(name string) (albumLayout, error) {
+	switch name {
+	case "", LayoutTopLevel:
+		return &topLevelLayout{recurse: settings.Recurse}, nil
+	case LayoutLeaf:
+		return &directoryLayout{}, nil
+	case LayoutPath:
+		return &directoryLayout{joinPath: true}, nil
+	case LayoutMonth:
+		return &dateLayout{monthly: true}, nil
+	case LayoutYear:
+		return &dateLayout{}, nil
+	case LayoutEvents:
+		gap := settings.EventGap
+		if gap <= 0 {
+			gap = DefaultEventGap
+		}
+		return &eventLayout{maxGap: gap, maxDistanceKm: settings.EventDistanceKm}, nil
+	case LayoutSingle:
+		if settings.AlbumName == "" {
+			return nil, fmt.Errorf("layout '%v' requires an album name", name)
+		}
+		return &singleLayout{title: settings.AlbumName}, nil
+	case LayoutNone:
+		return &singleLayout{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown album layout '%v', must be one of: %v",
+		name, strings.Join(Layouts, ", "))
+}
+
+// albumTitleForDir forms an album title from a directory name
+func albumTitleForDir(dirName string) string {
+	return formAlbumName(dirName, settings.Capitalize, settings.NameSubstitutionTokens)
+}
+
+// topLevelLayout creates an album out of each direct subdirectory of the base
+// directory; the contents of deeper subdirectories are optionally flattened
+// into the album.
+type topLevelLayout struct {
+	recurse bool
+}
+
+func (l *topLevelLayout) scanDepth() int {
+	if l.recurse {
+		return -1
+	}
+	return 1
+}
+
+func (l *topLevelLayout) albumFor(f *photoFile) (albumKey, bool) {
+	if f.relDir == "." {
+		return albumKey{}, false
+	}
+
+	topDir := strings.SplitN(f.relDir, "/", 2)[0]
+	albumDir := f.dir
+	for i := strings.Count(f.relDir, "/"); i > 0; i-- {
+		albumDir = filepath.Dir(albumDir)
+	}
+
+	return albumKey{
+		key:       topDir,
+		title:     albumTitleForDir(topDir),
+		dir:       albumDir,
+		dateNames: []string{topDir},
+	}, true
+}
+
+// directoryLayout creates an album out of each directory containing files.
+// The album title is either the directory name or, if joinPath is set, all
+// the directory names on the path joined together (eg. 'Travel / 2019 / Japan').
+type directoryLayout struct {
+	joinPath bool
+}
+
+func (l *directoryLayout) scanDepth() int {
+	return -1
+}
+
+func (l *directoryLayout) albumFor(f *photoFile) (albumKey, bool) {
+	if f.relDir == "." {
+		return albumKey{}, false
+	}
+
+	names := strings.Split(f.relDir, "/")
+
+	// Parse the date from the innermost directory name first
+	dateNames := make([]string, 0, len(names))
+	for i := len(names) - 1; i >= 0; i-- {
+		dateNames = append(dateNames, names[i])
+	}
+
+	title := albumTitleForDir(path.Base(f.relDir))
+	if l.joinPath {
+		titles := make([]string, len(names))
+		for i, n := range names {
+			titles[i] = albumTitleForDir(n)
+		}
+		title = strings.Join(titles, pathTitleSeparator)
+	}
+
+	return albumKey{
+		key:       f.relDir,
+		title:     title,
+		dir:       f.dir,
+		dateNames: dateNames,
+	}, true
+}
+
+// dateLayout creates an album out of each month or year, based on the photos'
+// capture time.
+type dateLayout struct {
+	monthly bool
+}
+
+func (l *dateLayout) scanDepth() int {
+	return -1
+}
+
+func (l *dateLayout) albumFor(f *photoFile) (albumKey, bool) {
+	t := f.capturedAt()
+
+	if l.monthly {
+		return albumKey{
+			key:   t.Format("2006-01"),
+			title: t.Format("January 2006"),
+			date: util.AlbumDate{Time: time.Date(t.Year(), t.Month(), 1, 10, 10, 10, 0, utcLocation),
+				Precision: util.PrecisionMonth},
+		}, true
+	}
+
+	return albumKey{
+		key:   t.Format("2006"),
+		title: t.Format("2006"),
+		date:  dateForAlbumYear(t.Year()),
+	}, true
+}
+
+// singleLayout puts all the files into a single album or, if title is empty,
+// uploads them into the library without adding them to any album.
+type singleLayout struct {
+	title string
+}
+
+func (l *singleLayout) scanDepth() int {
+	return -1
+}
+
+func (l *singleLayout) albumFor(f *photoFile) (albumKey, bool) {
+	if l.title == "" {
+		return albumKey{}, true
+	}
+
+	return albumKey{key: l.title, title: l.title}, true
+}
+
+// groupByAlbum maps the files into albums using the layout. The groups
+// are returned sorted by their keys.
+func groupByAlbum(layout albumLayout, files []*photoFile) []*albumGroup {
+	if l, ok := layout.(preparingLayout); ok {
+		l.prepare(files)
+	}
+
+	groups := map[string]*albumGroup{}
+	keys := []string{}
+
+	for _, f := range files {
+		k, ok := layout.albumFor(f)
+		if !ok {
+			log.Debugf("File %v does not belong to any album", f.path())
+			continue
+		}
+
+		g := groups[k.key]
+		if g == nil {
+			g = &albumGroup{albumKey: k}
+			groups[k.key] = g
+			keys = append(keys, k.key)
+		}
+		g.files = append(g.files, f)
+	}
+
+	sort.Strings(keys)
+
+	res := make([]*albumGroup, len(keys))
+	for i, k := range keys {
+		res[i] = groups[k]
+	}
+
+	return res
+}
@@ -0,0 +1,247 @@
+package files
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	photos "github.com/matti777/google-photos-uploader/internal/googlephotos"
+)
+
+const (
+	// Name of the upload ledger file, stored in the base directory
+	ledgerFilename = ".photos-uploader.json"
+
+	// Current version of the ledger file format
+	ledgerVersion = 1
+)
+
+// ledgerEntry records an uploaded (or downloaded) file and its media item
+type ledgerEntry struct {
+	// Path of the file relative to the base directory, with forward slashes
+	Path string `json:"path"`
+
+	// Size and modification time of the file at the time of the upload
+	// (or download)
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+
+	// Album the file belongs to; empty if only in the library
+	AlbumID    string `json:"albumId,omitempty"`
+	AlbumTitle string `json:"albumTitle,omitempty"`
+
+	MediaItemID string `json:"mediaItemId"`
+
+	// Time of the upload or download
+	SyncedAt time.Time `json:"syncedAt"`
+
+	// Whether the file was downloaded from (rather than uploaded to) Google Photos
+	Downloaded bool `json:"downloaded,omitempty"`
+}
+
+// matches tells whether the file is unchanged since it was recorded
+func (e *ledgerEntry) matches(info os.FileInfo) bool {
+	return e.Size == info.Size() && e.ModTime.Equal(info.ModTime())
+}
+
+// ledger records the files uploaded from (or downloaded into) a base directory.
+// It is used for verifying the uploads and for incremental uploads and downloads.
+type ledger struct {
+	Version int                     `json:"version"`
+	Entries map[string]*ledgerEntry `json:"entries"`
+
+	// IDs of the albums created with a numeric suffix by the keys of the
+	// albums, for adding the photos into them again on later runs
+	SuffixedAlbums map[string]string `json:"suffixedAlbums,omitempty"`
+
+	// Path of the ledger file
+	path string
+
+	lock sync.Mutex
+}
+
+// ledgerFileName returns the name of the ledger file of an upload target;
+// the default one for an unnamed target, eg. '.photos-uploader.archive.json'
+// for a target named 'archive'.
+func ledgerFileName(target string) string {
+	if target == "" {
+		return ledgerFilename
+	}
+
+	return strings.TrimSuffix(ledgerFilename, ".json") + "." + target + ".json"
+}
+
+// readLedger reads the ledger of the base directory for the upload target
+// (the default ledger if target is empty); returns an empty ledger if the
+// directory has none.
+func readLedger(baseDir, target string) (*ledger, error) {
+	l := &ledger{Version: ledgerVersion, Entries: map[string]*ledgerEntry{},
+		path: filepath.Join(baseDir, ledgerFileName(target))}
+
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return l, nil
+		}
+		return nil, fmt.Errorf("failed to read %v: %w", l.path, err)
+	}
+
+	if err := json.Unmarshal(data, l); err != nil {
+		return nil, fmt.Errorf("invalid ledger file %v: %w", l.path, err)
+	}
+	if l.Entries == nil {
+		l.Entries = map[string]*ledgerEntry{}
+	}
+
+	return l, nil
+}
+
+// ledgerPath returns the ledger key of a file
+func ledgerPath(f *photoFile) string {
+	return path.Join(f.relDir, f.info.Name())
+}
+
+// lookup returns the ledger entry of a file, or nil if it has not been uploaded.
+// Nil-safe.
+func (l *ledger) lookup(f *photoFile) *ledgerEntry {
+	if l == nil {
+		return nil
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.Entries[ledgerPath(f)]
+}
+
+// record records a file as uploaded into the album (nil for the library only).
+// Nil-safe.
+func (l *ledger) record(f *photoFile, album *photos.Album, item *photos.MediaItem) {
+	l.put(ledgerPath(f), f.info, album, item, false)
+}
+
+// put adds an entry for the file at relPath. Nil-safe.
+func (l *ledger) put(relPath string, info os.FileInfo, album *photos.Album,
+	item *photos.MediaItem, downloaded bool) {
+
+	if l == nil {
+		return
+	}
+
+	e := &ledgerEntry{Path: relPath, Size: info.Size(), ModTime: info.ModTime(),
+		MediaItemID: item.ID, SyncedAt: time.Now(), Downloaded: downloaded}
+	if album != nil {
+		e.AlbumID = album.ID
+		e.AlbumTitle = album.Title
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.Entries[e.Path] = e
+}
+
+// findMediaItem returns an entry of the media item, preferring one within the
+// directory relDir (relative to the base directory, "" for any). Nil-safe.
+func (l *ledger) findMediaItem(id, relDir string) *ledgerEntry {
+	if l == nil {
+		return nil
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var res *ledgerEntry
+	for _, e := range l.Entries {
+		if e.MediaItemID != id {
+			continue
+		}
+		if relDir == "" || path.Dir(e.Path) == relDir {
+			return e
+		}
+		res = e
+	}
+
+	return res
+}
+
+// albumDir returns the directory of the files recorded for the album, or ""
+// if there are none. Nil-safe.
+func (l *ledger) albumDir(albumID string) string {
+	if l == nil {
+		return ""
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for _, e := range l.Entries {
+		dir := path.Dir(e.Path)
+		if e.AlbumID == albumID && dir != "." && !strings.Contains(dir, "/") {
+			return dir
+		}
+	}
+
+	return ""
+}
+
+// suffixedAlbum returns the ID of the album created with a numeric suffix for
+// the album key, or "" if there is none. Nil-safe.
+func (l *ledger) suffixedAlbum(key string) string {
+	if l == nil {
+		return ""
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.SuffixedAlbums[key]
+}
+
+// recordSuffixedAlbum records the album created with a numeric suffix for the
+// album key. Nil-safe.
+func (l *ledger) recordSuffixedAlbum(key string, album *photos.Album) {
+	if l == nil {
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.SuffixedAlbums == nil {
+		l.SuffixedAlbums = map[string]string{}
+	}
+	l.SuffixedAlbums[key] = album.ID
+}
+
+// save writes the ledger file. Nil-safe.
+func (l *ledger) save() error {
+	if l == nil {
+		return nil
+	}
+
+	l.lock.Lock()
+	data, err := json.MarshalIndent(l, "", "  ")
+	l.lock.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal ledger: %w", err)
+	}
+
+	// Write via a temp file so that an interrupted run cannot corrupt the ledger
+	tmpPath := l.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write ledger: %w", err)
+	}
+	if err := os.Rename(tmpPath, l.path); err != nil {
+		return fmt.Errorf("failed to write ledger: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	photos "github.com/matti777/google-photos-uploader/internal/googlephotos"
+)
+
+const (
+	// Name of the optional text file holding the album description
+	albumReadmeFilename = "README.txt"
+)
+
+// Placeholder descriptions written by cameras into EXIF ImageDescription;
+// these are not used as media item descriptions.
+var cameraDescriptionPlaceholders = map[string]bool{
+	"OLYMPUS DIGITAL CAMERA":  true,
+	"SONY DSC":                true,
+	"DIGITAL CAMERA":          true,
+	"DCIM\\100MEDIA":          true,
+	"Exif_JPEG_PICTURE":       true,
+	"Default":                 true,
+	"<KENOX S630  / Samsung>": true,
+}
+
+// albumDescription returns the description of the album; from the album
+// override file or, if not set there, from the README.txt file in the
+// album directory.
+func albumDescription(group *albumGroup) string {
+	if group.override != nil && group.override.Description != "" {
+		return group.override.Description
+	}
+
+	if group.dir == "" {
+		return ""
+	}
+
+	data, err := os.ReadFile(filepath.Join(group.dir, albumReadmeFilename))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Errorf("Failed to read %v: %v", albumReadmeFilename, err)
+		}
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+// itemDescription returns the description of a photo from its sidecar or,
+// if it has none, from its EXIF ImageDescription tag; the description of a
+// planned file is the one in the plan.
+func itemDescription(f *photoFile) string {
+	if f.plan != nil {
+		return f.plan.Description
+	}
+
+	if s := f.sidecar(); s != nil && s.description != "" {
+		return s.description
+	}
+
+	s, ok := f.exifTags().stringValue("ImageDescription")
+	if !ok {
+		return ""
+	}
+
+	s = strings.TrimSpace(strings.Trim(s, "\x00"))
+	if cameraDescriptionPlaceholders[s] {
+		return ""
+	}
+
+	return s
+}
+
+// toLocation converts an override file location to an API location
+func toLocation(l *overrideLocation) *photos.Location {
+	if l == nil {
+		return nil
+	}
+
+	return &photos.Location{Name: l.Name, Latitude: l.Lat, Longitude: l.Lon}
+}
+
+// albumEnrichments returns the enrichments to add to the album: the album
+// description as a text enrichment followed by the location and map
+// enrichments of the album override file.
+func albumEnrichments(group *albumGroup) []*photos.Enrichment {
+	res := []*photos.Enrichment{}
+
+	if d := albumDescription(group); d != "" {
+		res = append(res, &photos.Enrichment{Text: d})
+	}
+
+	o := group.override
+	if o == nil {
+		return res
+	}
+
+	if o.Location != nil {
+		res = append(res, &photos.Enrichment{Location: toLocation(o.Location)})
+	}
+	if o.Map != nil {
+		res = append(res, &photos.Enrichment{MapOrigin: toLocation(o.Map.Origin),
+			MapDestination: toLocation(o.Map.Destination)})
+	}
+
+	return res
+}
+
+// applyAlbumMetadata adds the enrichments to the album and sets the cover
+// photo out of the created media items.
+func (t *Target) applyAlbumMetadata(group *albumGroup, album *photos.Album,
+	items []*photos.MediaItem) error {
+
+	// Add the enrichments to the beginning of the album in reverse order so
+	// that they end up in the original order
+	enrichments := albumEnrichments(group)
+	for i := len(enrichments) - 1; i >= 0; i-- {
+		err := t.Client.AddEnrichment(album, enrichments[i], true)
+		if errors.Is(err, photos.ErrNotSupported) {
+			log.Debugf("Album enrichments not supported by the destination")
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to add enrichment to album '%v': %w", album.Title, err)
+		}
+	}
+
+	if group.override == nil || group.override.Cover == "" {
+		return nil
+	}
+
+	for _, item := range items {
+		if item.FileName == group.override.Cover {
+			if err := t.Client.SetAlbumCover(album, item.ID); err != nil {
+				log.Errorf("Failed to set cover photo of album '%v': %v", album.Title, err)
+			}
+			return nil
+		}
+	}
+
+	log.Errorf("Cover photo %v not found in album '%v'", group.override.Cover, album.Title)
+
+	return nil
+}
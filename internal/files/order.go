@@ -0,0 +1,183 @@
+package files
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/matti777/google-photos-uploader/internal/logging"
+	"github.com/matti777/google-photos-uploader/internal/util"
+)
+
+// Orders of the photos in an album
+const (
+	// File names in natural order, eg. IMG_2.jpg before IMG_10.jpg
+	OrderName = "name"
+
+	// Capture time of the photos
+	OrderTime = "time"
+
+	// Order listed in the manifest file of the album directory
+	OrderManifest = "manifest"
+)
+
+// Orders lists the names of all the supported album orders
+var Orders = []string{OrderName, OrderTime, OrderManifest}
+
+// Name of the manifest file listing the files of an album in order
+const albumManifestFilename = ".album-order.txt"
+
+// ValidateOrder checks that the album order is supported
+func ValidateOrder(order string) error {
+	for _, o := range Orders {
+		if order == o {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown album order '%v', must be one of: %v", order,
+		strings.Join(Orders, ", "))
+}
+
+// albumOrder returns the order of the photos in the album; from the album
+// override or the settings
+func albumOrder(group *albumGroup) string {
+	if group.override != nil && group.override.Order != "" {
+		return group.override.Order
+	}
+	if settings.Order != "" {
+		return settings.Order
+	}
+
+	return OrderName
+}
+
+// byName tells whether a file sorts before another by its path in natural
+// order
+func byName(a, b *photoFile) bool {
+	if a.relDir != b.relDir {
+		return util.NaturalLess(a.relDir, b.relDir)
+	}
+
+	return util.NaturalLess(a.info.Name(), b.info.Name())
+}
+
+// sortFiles sorts the files of the album into the album order. The files are
+// uploaded and added into the album in this order.
+func sortFiles(group *albumGroup, files []*photoFile) {
+	order := albumOrder(group)
+
+	if order == OrderManifest {
+		if rank, ok := manifestRanks(group, files); ok {
+			sort.SliceStable(files, func(i, j int) bool {
+				ri, rj := rank[files[i]], rank[files[j]]
+				if ri != rj {
+					return ri < rj
+				}
+				return byName(files[i], files[j])
+			})
+			return
+		}
+		order = OrderName
+	}
+
+	if order == OrderTime {
+		times := make(map[*photoFile]int64, len(files))
+		for _, f := range files {
+			times[f] = f.capturedAt().UnixNano()
+		}
+		sort.SliceStable(files, func(i, j int) bool {
+			ti, tj := times[files[i]], times[files[j]]
+			if ti != tj {
+				return ti < tj
+			}
+			return byName(files[i], files[j])
+		})
+		return
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return byName(files[i], files[j])
+	})
+}
+
+// manifestRanks returns the positions of the files in the manifest of the
+// album; the files not listed in it come after the listed ones. Returns false
+// if the album has no manifest.
+func manifestRanks(group *albumGroup, files []*photoFile) (map[*photoFile]int, bool) {
+	entry := log.WithField(logging.FieldAlbum, group.key)
+	if group.dir == "" {
+		entry.Warnf("Album has no directory for %v -- ordering by name",
+			albumManifestFilename)
+		return nil, false
+	}
+
+	names, err := readManifest(filepath.Join(group.dir, albumManifestFilename))
+	if err != nil {
+		entry.WithError(err).Warn("Failed to read the album manifest -- ordering by name")
+		return nil, false
+	}
+	if names == nil {
+		entry.Warnf("No %v in %v -- ordering by name", albumManifestFilename, group.dir)
+		return nil, false
+	}
+
+	// The files are listed by their paths relative to the album directory
+	byPath := make(map[string]*photoFile, len(files))
+	for _, f := range files {
+		if rel, err := filepath.Rel(group.dir, f.path()); err == nil {
+			byPath[filepath.ToSlash(rel)] = f
+		}
+	}
+
+	rank := make(map[*photoFile]int, len(files))
+	for _, f := range files {
+		rank[f] = len(names)
+	}
+	for i, name := range names {
+		f, ok := byPath[name]
+		if !ok {
+			entry.Warnf("File %v listed in %v not found", name, albumManifestFilename)
+			continue
+		}
+		if rank[f] == len(names) {
+			rank[f] = i
+		}
+	}
+
+	return rank, true
+}
+
+// readManifest reads the file names listed in an album manifest, one per
+// line; empty lines and lines starting with '#' are ignored. Returns nil (and
+// no error) if the manifest does not exist.
+func readManifest(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read %v: %w", path, err)
+	}
+	defer file.Close()
+
+	names := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, filepath.ToSlash(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %v: %w", path, err)
+	}
+
+	return names, nil
+}
@@ -0,0 +1,238 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// Name of the optional per-directory album override (sidecar) file
+	albumOverrideFilename = ".album.yaml"
+
+	// Date format used in the override file
+	overrideDateFormat = "2006-01-02"
+)
+
+// albumOverride represents the contents of an album directory's override file.
+// All the fields are optional; unset fields do not override anything.
+type albumOverride struct {
+	// Album title; replaces the name formed from the directory name
+	Title string `yaml:"title"`
+
+	// Album date in the format YYYY-MM-DD; takes precedence over Year
+	Date string `yaml:"date"`
+
+	// Album year; replaces the year parsed from the directory name
+	Year int `yaml:"year"`
+
+	// Album description
+	Description string `yaml:"description"`
+
+	// Glob patterns (matched against file names) of the files to include;
+	// if empty, all files are included
+	Include []string `yaml:"include"`
+
+	// Glob patterns (matched against file names) of the files to exclude
+	Exclude []string `yaml:"exclude"`
+
+	// File name of the photo to use as the album cover
+	Cover string `yaml:"cover"`
+
+	// Location enrichment to add to the album
+	Location *overrideLocation `yaml:"location"`
+
+	// Map enrichment (journey from origin to destination) to add to the album
+	Map *struct {
+		Origin      *overrideLocation `yaml:"origin"`
+		Destination *overrideLocation `yaml:"destination"`
+	} `yaml:"map"`
+
+	// Whether to share the album after creating it
+	Share bool `yaml:"share"`
+
+	// Whether to skip this directory altogether
+	Skip bool `yaml:"skip"`
+
+	// Order of the photos in the album (name, time or manifest)
+	Order string `yaml:"order"`
+
+	// Timezone the photos were taken in, eg. 'Asia/Tokyo'; applies to the
+	// subdirectories too
+	Timezone string `yaml:"timezone"`
+
+	// How much the camera clock was ahead of the correct time, eg. '1h23m' or
+	// '-30m'; subtracted from the camera times of the photos. Applies to the
+	// subdirectories too.
+	ClockOffset string `yaml:"clock_offset"`
+
+	// Parsed value of Date
+	date time.Time
+
+	// Parsed value of Timezone; nil if not set
+	location *time.Location
+
+	// Parsed value of ClockOffset; nil if not set
+	clockOffset *time.Duration
+}
+
+// overrideLocation is a named location in an override file
+type overrideLocation struct {
+	Name string  `yaml:"name"`
+	Lat  float64 `yaml:"lat"`
+	Lon  float64 `yaml:"lon"`
+}
+
+// readAlbumOverride reads the override file from an album directory. Returns
+// nil (and no error) if the directory has no override file.
+func readAlbumOverride(absoluteDirPath string) (*albumOverride, error) {
+	path := filepath.Join(absoluteDirPath, albumOverrideFilename)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read %v: %w", path, err)
+	}
+
+	return parseAlbumOverride(data)
+}
+
+// parseAlbumOverride parses and validates the contents of an override file.
+func parseAlbumOverride(data []byte) (*albumOverride, error) {
+	o := &albumOverride{}
+	if err := yaml.UnmarshalStrict(data, o); err != nil {
+		return nil, fmt.Errorf("invalid album override file: %w", err)
+	}
+
+	if o.Date != "" {
+		d, err := time.Parse(overrideDateFormat, o.Date)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date '%v' in album override file: %w",
+				o.Date, err)
+		}
+		o.date = d
+		o.Year = d.Year()
+	}
+
+	if o.Order != "" {
+		if err := ValidateOrder(o.Order); err != nil {
+			return nil, fmt.Errorf("invalid album override file: %w", err)
+		}
+	}
+
+	if o.Timezone != "" {
+		loc, err := time.LoadLocation(o.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone '%v' in album override file: %w",
+				o.Timezone, err)
+		}
+		o.location = loc
+	}
+
+	if o.ClockOffset != "" {
+		d, err := time.ParseDuration(o.ClockOffset)
+		if err != nil {
+			return nil, fmt.Errorf("invalid clock offset '%v' in album override file: %w",
+				o.ClockOffset, err)
+		}
+		o.clockOffset = &d
+	}
+
+	for _, p := range append(append([]string{}, o.Include...), o.Exclude...) {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob pattern '%v' in album override file: %w",
+				p, err)
+		}
+	}
+
+	if o.Map != nil && (o.Map.Origin == nil || o.Map.Destination == nil) {
+		return nil, fmt.Errorf("map in album override file must have both origin " +
+			"and destination")
+	}
+
+	o.Title = strings.TrimSpace(o.Title)
+
+	return o, nil
+}
+
+// includesFile tells whether a file (name) passes the include / exclude globs.
+func (o *albumOverride) includesFile(name string) bool {
+	if o == nil {
+		return true
+	}
+
+	for _, p := range o.Exclude {
+		if ok, _ := filepath.Match(p, name); ok {
+			return false
+		}
+	}
+
+	if len(o.Include) == 0 {
+		return true
+	}
+
+	for _, p := range o.Include {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// summary returns a human readable list of the overrides that are in effect.
+func (o *albumOverride) summary() []string {
+	s := []string{}
+
+	if o.Skip {
+		s = append(s, "skip")
+	}
+	if o.Title != "" {
+		s = append(s, fmt.Sprintf("title='%v'", o.Title))
+	}
+	if o.Date != "" {
+		s = append(s, fmt.Sprintf("date=%v", o.Date))
+	} else if o.Year > 0 {
+		s = append(s, fmt.Sprintf("year=%v", o.Year))
+	}
+	if o.Description != "" {
+		s = append(s, "description")
+	}
+	if len(o.Include) > 0 {
+		s = append(s, fmt.Sprintf("include=%v", strings.Join(o.Include, ",")))
+	}
+	if len(o.Exclude) > 0 {
+		s = append(s, fmt.Sprintf("exclude=%v", strings.Join(o.Exclude, ",")))
+	}
+	if o.Cover != "" {
+		s = append(s, fmt.Sprintf("cover=%v", o.Cover))
+	}
+	if o.Location != nil {
+		s = append(s, fmt.Sprintf("location=%v", o.Location.Name))
+	}
+	if o.Map != nil {
+		s = append(s, fmt.Sprintf("map=%v-%v", o.Map.Origin.Name, o.Map.Destination.Name))
+	}
+	if o.Share {
+		s = append(s, "share")
+	}
+	if o.Order != "" {
+		s = append(s, fmt.Sprintf("order=%v", o.Order))
+	}
+	if o.Timezone != "" {
+		s = append(s, fmt.Sprintf("timezone=%v", o.Timezone))
+	}
+	if o.ClockOffset != "" {
+		s = append(s, fmt.Sprintf("clock_offset=%v", o.ClockOffset))
+	}
+
+	return s
+}
@@ -0,0 +1,471 @@
+package files
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/matti777/google-photos-uploader/internal/logging"
+	"github.com/matti777/google-photos-uploader/internal/util"
+)
+
+// Version of the upload plan file format
+const planVersion = 1
+
+// DefaultPlanFile is the default name of the upload plan file
+const DefaultPlanFile = "upload-plan.yaml"
+
+// Comment written at the beginning of the plan file
+const planHeader = `# Upload plan made by photos-uploader. Review and edit it, then upload
+# exactly these albums and files with 'apply'. You may remove albums,
+# reorder the files, change the album titles and the file names, dates and
+# descriptions, or skip an album by giving a reason with 'skip'. To leave out
+# a file, move it under 'skipped_files' with a reason; 'apply' refuses to run
+# if the directories of the albums have files that are not listed.
+`
+
+// Plan is an upload plan: the albums and the files to upload into them with
+// their resolved titles, names and dates. It is written by 'plan' for
+// reviewing (and editing) and executed as such by 'apply'.
+type Plan struct {
+	Version int `yaml:"version"`
+
+	// Absolute path of the base directory
+	BaseDir string `yaml:"base_dir"`
+
+	// Time the plan was made
+	Created time.Time `yaml:"created"`
+
+	// Include / exclude patterns the plan was made with; the files they leave
+	// out are not new files
+	Include []string `yaml:"include,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty"`
+
+	Albums []*PlanAlbum `yaml:"albums"`
+}
+
+// PlanAlbum is an album of the upload plan
+type PlanAlbum struct {
+	// Album title; empty if the photos are only uploaded into the library
+	Title string `yaml:"title,omitempty"`
+
+	// Whether the photos are only uploaded into the library
+	Library bool `yaml:"library,omitempty"`
+
+	// Album directory relative to the base directory (slash separated);
+	// empty if the album does not correspond to a directory
+	Dir string `yaml:"dir,omitempty"`
+
+	// Album date (YYYY, YYYY-MM or YYYY-MM-DD)
+	Date string `yaml:"date,omitempty"`
+
+	// Reason for skipping the album; the album is uploaded if empty
+	Skip string `yaml:"skip,omitempty"`
+
+	// Files to upload in the album order
+	Files []*PlanFile `yaml:"files,omitempty"`
+
+	// Files of the album that are not uploaded, for reference
+	Skipped []*PlanSkippedFile `yaml:"skipped_files,omitempty"`
+}
+
+// PlanFile is a file to upload
+type PlanFile struct {
+	// Path relative to the base directory (slash separated)
+	Path string `yaml:"path"`
+
+	// File name of the uploaded photo
+	Name string `yaml:"name"`
+
+	// Date written into the uploaded photo, and where it came from
+	Date       time.Time `yaml:"date"`
+	DateSource string    `yaml:"date_source,omitempty"`
+
+	Description string `yaml:"description,omitempty"`
+
+	// Size and modification time of the file when the plan was made
+	Size    int64     `yaml:"size"`
+	ModTime time.Time `yaml:"mtime"`
+}
+
+// PlanSkippedFile is a file that is not uploaded, with the reason
+type PlanSkippedFile struct {
+	Path   string `yaml:"path"`
+	Reason string `yaml:"reason"`
+}
+
+// PlanChangedError is returned for a plan whose files have changed since it
+// was made
+type PlanChangedError struct {
+	// Changed files and how they changed, eg. 'Trip/a.jpg (modified)'
+	Files []string
+}
+
+func (e *PlanChangedError) Error() string {
+	return fmt.Sprintf("%v file(s) have changed since the plan was made: %v",
+		len(e.Files), strings.Join(e.Files, ", "))
+}
+
+// isAlbumMetadata tells whether a file holds metadata of the album and is
+// not listed as a skipped file
+func isAlbumMetadata(name string) bool {
+	return name == albumOverrideFilename || name == albumManifestFilename ||
+		name == albumReadmeFilename
+}
+
+// MakePlan scans the base directory and maps the files into albums the same
+// way as when uploading, returning the plan of the uploads.
+func MakePlan(absoluteDirPath string) *Plan {
+	p := &Plan{Version: planVersion, BaseDir: absoluteDirPath, Created: time.Now(),
+		Include: settings.Include, Exclude: settings.Exclude, Albums: []*PlanAlbum{}}
+
+	for _, g := range mustScanAlbumGroups(absoluteDirPath) {
+		a := &PlanAlbum{Library: g.key == ""}
+		if !a.Library {
+			a.Title = albumTitle(g)
+		}
+		if g.dir != "" {
+			rel, err := filepath.Rel(absoluteDirPath, g.dir)
+			if err != nil {
+				log.Fatalf("Failed to get relative path for '%v': %v", g.dir, err)
+			}
+			a.Dir = filepath.ToSlash(rel)
+		}
+		p.Albums = append(p.Albums, a)
+
+		if g.override != nil && g.override.Skip {
+			a.Skip = "skipped by " + albumOverrideFilename
+			continue
+		}
+
+		albumDate, ok := resolveAlbumDate(g)
+		if !ok {
+			a.Skip = "failed to parse the album date from the directory name"
+			continue
+		}
+		a.Date = albumDate.String()
+
+		for _, f := range g.files {
+			if reason := fileSkipReason(g, f); reason != "" && !isAlbumMetadata(f.info.Name()) {
+				a.Skipped = append(a.Skipped, &PlanSkippedFile{Path: ledgerPath(f),
+					Reason: reason})
+			}
+		}
+		sort.Slice(a.Skipped, func(i, j int) bool {
+			return util.NaturalLess(a.Skipped[i].Path, a.Skipped[j].Path)
+		})
+
+		for _, f := range uploadableFiles(g) {
+			date, source := resolveFileDate(albumDate, f)
+			a.Files = append(a.Files, &PlanFile{
+				Path:        ledgerPath(f),
+				Name:        f.info.Name(),
+				Date:        date,
+				DateSource:  source,
+				Description: itemDescription(f),
+				Size:        f.info.Size(),
+				ModTime:     f.info.ModTime(),
+			})
+		}
+	}
+
+	return p
+}
+
+// Write writes the plan into a file
+func (p *Plan) Write(filePath string) error {
+	data, err := yaml.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed to marshal upload plan: %w", err)
+	}
+
+	if err := os.WriteFile(filePath, append([]byte(planHeader), data...), 0644); err != nil {
+		return fmt.Errorf("failed to write upload plan: %w", err)
+	}
+
+	return nil
+}
+
+// ReadPlan reads and validates an upload plan file
+func ReadPlan(filePath string) (*Plan, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload plan: %w", err)
+	}
+
+	p := &Plan{}
+	if err := yaml.UnmarshalStrict(data, p); err != nil {
+		return nil, fmt.Errorf("invalid upload plan: %w", err)
+	}
+
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("invalid upload plan: %w", err)
+	}
+
+	return p, nil
+}
+
+// validate checks the contents of the plan
+func (p *Plan) validate() error {
+	if p.Version != planVersion {
+		return fmt.Errorf("unsupported version %v", p.Version)
+	}
+	if !filepath.IsAbs(p.BaseDir) {
+		return fmt.Errorf("base_dir must be an absolute path")
+	}
+
+	paths := map[string]bool{}
+	for _, a := range p.Albums {
+		if a.Skip != "" {
+			continue
+		}
+		if a.Title == "" && !a.Library {
+			return fmt.Errorf("album of directory '%v' has no title", a.Dir)
+		}
+		if a.Date != "" {
+			if d, err := util.ParseAlbumDate(a.Date); err != nil || d.String() != a.Date {
+				return fmt.Errorf("album '%v' has an invalid date '%v'", a.Title, a.Date)
+			}
+		}
+
+		for _, f := range a.Files {
+			if f.Path == "" || path.IsAbs(f.Path) || strings.HasPrefix(path.Clean(f.Path), "..") {
+				return fmt.Errorf("album '%v' has an invalid file path '%v'", a.Title, f.Path)
+			}
+			if paths[f.Path] {
+				return fmt.Errorf("file '%v' is listed more than once", f.Path)
+			}
+			paths[f.Path] = true
+
+			if f.Date.IsZero() {
+				return fmt.Errorf("file '%v' has no date", f.Path)
+			}
+		}
+	}
+
+	return nil
+}
+
+// path returns the absolute path of a planned file
+func (p *Plan) path(f *PlanFile) string {
+	return filepath.Join(p.BaseDir, filepath.FromSlash(f.Path))
+}
+
+// Check checks that the files of the albums to upload have not changed since
+// the plan was made, and that the directories of the albums have no new files
+// (not listed as files or skipped files); returns a *PlanChangedError if they
+// have.
+func (p *Plan) Check() error {
+	changed := []string{}
+
+	// Files listed in the plan and the directories of the albums to upload
+	listed := map[string]bool{}
+	dirs := []string{}
+	addDir := func(dir string) {
+		for _, d := range dirs {
+			if d == dir {
+				return
+			}
+		}
+		dirs = append(dirs, dir)
+	}
+
+	for _, a := range p.Albums {
+		for _, f := range a.Files {
+			listed[f.Path] = true
+		}
+		for _, f := range a.Skipped {
+			listed[f.Path] = true
+		}
+		if a.Skip != "" {
+			continue
+		}
+
+		if a.Dir != "" {
+			addDir(a.Dir)
+		}
+		for _, f := range a.Files {
+			addDir(path.Dir(f.Path))
+
+			info, err := os.Stat(p.path(f))
+			switch {
+			case err != nil:
+				changed = append(changed, f.Path+" (missing)")
+			case info.Size() != f.Size || !info.ModTime().Equal(f.ModTime):
+				changed = append(changed, f.Path+" (modified)")
+			}
+		}
+	}
+
+	// The files left out by the filter when making the plan are not listed
+	f, err := newPathFilter(p.BaseDir, p.Include, p.Exclude)
+	if err != nil {
+		return fmt.Errorf("invalid include / exclude patterns: %w", err)
+	}
+
+	for _, dir := range dirs {
+		names, err := p.dirFiles(f, dir)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if !listed[name] {
+				changed = append(changed, name+" (new)")
+			}
+		}
+	}
+
+	if len(changed) > 0 {
+		return &PlanChangedError{Files: changed}
+	}
+
+	return nil
+}
+
+// dirFiles returns the paths of the files in a directory (relative to the base
+// directory, slash separated) the same way as scanning for the plan; the files
+// excluded by the filter, the sidecars and the album metadata are left out.
+func (p *Plan) dirFiles(f *pathFilter, dir string) ([]string, error) {
+	absoluteDirPath := filepath.Join(p.BaseDir, filepath.FromSlash(dir))
+	entries, err := os.ReadDir(absoluteDirPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory '%v': %w", dir, err)
+	}
+
+	// The ignore files of the parent directories apply as well, the deepest
+	// ones last
+	parents := []string{}
+	for d := dir; d != "." && d != "/"; d = path.Dir(d) {
+		parents = append([]string{d}, parents...)
+	}
+	for _, d := range parents {
+		if err := f.loadIgnoreFile(filepath.Join(p.BaseDir, filepath.FromSlash(d))); err != nil {
+			return nil, fmt.Errorf("failed to read ignore file: %w", err)
+		}
+	}
+
+	paths := []string{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || isSidecar(name) || isAlbumMetadata(name) ||
+			f.isExcluded(filepath.Join(absoluteDirPath, name), false) {
+			continue
+		}
+		paths = append(paths, path.Join(dir, name))
+	}
+
+	return paths, nil
+}
+
+// Counts returns the number of albums and files to upload
+func (p *Plan) Counts() (albums, files int) {
+	for _, a := range p.Albums {
+		if a.Skip == "" {
+			albums++
+			files += len(a.Files)
+		}
+	}
+
+	return albums, files
+}
+
+// Print prints a summary of the plan
+func (p *Plan) Print() {
+	for _, a := range p.Albums {
+		title := a.Title
+		if a.Library {
+			title = "(library)"
+		}
+
+		if a.Skip != "" {
+			fmt.Printf("  %v: skipped (%v)\n", title, a.Skip)
+			continue
+		}
+
+		skipped := ""
+		if len(a.Skipped) > 0 {
+			skipped = fmt.Sprintf(", %v skipped", len(a.Skipped))
+		}
+		fmt.Printf("  %v [%v]: %v file(s)%v\n", title, a.Date, len(a.Files), skipped)
+	}
+
+	albums, files := p.Counts()
+	fmt.Printf("%v album(s) with %v file(s) to upload.\n", albums, files)
+}
+
+// groups returns the album groups of the albums to upload
+func (p *Plan) groups() ([]*albumGroup, error) {
+	groups := []*albumGroup{}
+
+	for _, a := range p.Albums {
+		if a.Skip != "" {
+			log.WithField(logging.FieldAlbum, a.Title).Infof("Skipping album: %v", a.Skip)
+			continue
+		}
+
+		g := &albumGroup{planned: true}
+		if !a.Library {
+			g.key, g.title = a.Title, a.Title
+		}
+		if a.Date != "" {
+			g.date, _ = util.ParseAlbumDate(a.Date)
+		}
+
+		if a.Dir != "" {
+			g.dir = filepath.Join(p.BaseDir, filepath.FromSlash(a.Dir))
+
+			// The album metadata (description, cover etc.) comes from the
+			// override file; the title and the files are as planned
+			override, err := readAlbumOverride(g.dir)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read album override for '%v': %w", a.Dir, err)
+			}
+			if override != nil {
+				o := *override
+				o.Skip, o.Title = false, ""
+				g.override = &o
+			}
+		}
+
+		for _, pf := range a.Files {
+			info, err := os.Stat(p.path(pf))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read file: %w", err)
+			}
+
+			relDir := path.Dir(pf.Path)
+			g.files = append(g.files, &photoFile{
+				dir:    filepath.Join(p.BaseDir, filepath.FromSlash(relDir)),
+				relDir: relDir,
+				info:   info,
+				plan:   pf,
+			})
+		}
+
+		groups = append(groups, g)
+	}
+
+	return groups, nil
+}
+
+// ApplyPlan uploads the albums and files of the plan into the targets exactly
+// as planned, without asking for a confirmation for each album. The plan
+// should be checked not to have changed first. Returns the number of failed
+// targets.
+func ApplyPlan(p *Plan, targets []*Target) int {
+	mustCheckConflictPolicy()
+
+	groups, err := p.groups()
+	if err != nil {
+		log.Fatalf("Failed to apply the upload plan: %v", err)
+	}
+
+	// The plan is the confirmation of the uploads
+	return processGroups(p.BaseDir, groups, targets, false)
+}
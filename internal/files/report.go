@@ -0,0 +1,123 @@
+package files
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	photos "github.com/matti777/google-photos-uploader/internal/googlephotos"
+)
+
+// AlbumReport records an album created (or managed) during the run
+type AlbumReport struct {
+	Title string
+
+	// Number of media items added to the album
+	Items int
+
+	// Whether the items were added to an existing album
+	Appended bool
+
+	// Google Photos URL of the album (for the owner)
+	URL string
+
+	// Sharing URL and token; empty if the album is not shared
+	ShareURL   string
+	ShareToken string
+}
+
+// NewAlbumReport creates a report entry for an album
+func NewAlbumReport(album *photos.Album, items int) *AlbumReport {
+	r := &AlbumReport{Title: album.Title, Items: items, URL: album.ProductURL}
+	if album.ShareInfo != nil {
+		r.ShareURL = album.ShareInfo.ShareableURL
+		r.ShareToken = album.ShareInfo.ShareToken
+	}
+
+	return r
+}
+
+// PrintReport prints the album report to stdout.
+func PrintReport(reports []*AlbumReport) {
+	for _, r := range reports {
+		if r.Appended {
+			fmt.Printf("  %v (%v items, appended)\n", r.Title, r.Items)
+		} else {
+			fmt.Printf("  %v (%v items)\n", r.Title, r.Items)
+		}
+		if r.URL != "" {
+			fmt.Printf("    URL:       %v\n", r.URL)
+		}
+		if r.ShareURL != "" {
+			fmt.Printf("    Share URL: %v\n", r.ShareURL)
+		}
+	}
+}
+
+// writeMarkdownIndex writes the album index as a Markdown document
+func writeMarkdownIndex(w io.Writer, reports []*AlbumReport) error {
+	if _, err := fmt.Fprintf(w, "# Albums\n\n| Album | Items | Link |\n|---|---|---|\n"); err != nil {
+		return err
+	}
+
+	for _, r := range reports {
+		link := r.ShareURL
+		if link == "" {
+			link = r.URL
+		}
+		title := strings.ReplaceAll(r.Title, "|", "\\|")
+		if _, err := fmt.Fprintf(w, "| %v | %v | %v |\n", title, r.Items, link); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeHTMLIndex writes the album index as an HTML document
+func writeHTMLIndex(w io.Writer, reports []*AlbumReport) error {
+	if _, err := fmt.Fprintf(w, "<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\">"+
+		"<title>Albums</title></head>\n<body>\n<h1>Albums</h1>\n<ul>\n"); err != nil {
+		return err
+	}
+
+	for _, r := range reports {
+		link := r.ShareURL
+		if link == "" {
+			link = r.URL
+		}
+		if _, err := fmt.Fprintf(w, "<li><a href=\"%v\">%v</a> (%v items)</li>\n",
+			html.EscapeString(link), html.EscapeString(r.Title), r.Items); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "</ul>\n</body>\n</html>\n")
+
+	return err
+}
+
+// WriteIndex writes an index of the albums with their (sharing) links into a
+// file; as HTML if the file name ends with .html or .htm, otherwise as Markdown.
+func WriteIndex(path string, reports []*AlbumReport) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create index file: %w", err)
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm":
+		err = writeHTMLIndex(f, reports)
+	default:
+		err = writeMarkdownIndex(f, reports)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write index file: %w", err)
+	}
+
+	return nil
+}
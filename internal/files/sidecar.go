@@ -0,0 +1,263 @@
+package files
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Extensions of the sidecar files
+const (
+	takeoutExt = ".json"
+	xmpExt     = ".xmp"
+)
+
+// Google Takeout truncates the names of its sidecars to this length
+const takeoutMaxNameLen = 51
+
+var (
+	// Copies of a photo in a Takeout export, eg. 'IMG_1(1).jpg'; their sidecar
+	// is named 'IMG_1.jpg(1).json'
+	takeoutCopyRegex = regexp.MustCompile(`^(.*)(\(\d+\))(\.[^.]*)$`)
+
+	// Suffix of the edited photos in a Takeout export; they share the sidecar
+	// of the original
+	takeoutEditedSuffix = "-edited"
+
+	// Formats of the XMP dates, in order of preference
+	xmpDateFormats = []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999",
+		"2006-01-02T15:04Z07:00", "2006-01-02T15:04", "2006-01-02"}
+
+	// XMP GPS coordinates, eg. '60,10.194N' or '60,10,11.64N'
+	xmpCoordinateRegex = regexp.MustCompile(`^(\d+),(\d+(?:\.\d+)?)(?:,(\d+(?:\.\d+)?))?([NSEW])$`)
+)
+
+// XMP namespaces
+const (
+	xmpNamespaceExif      = "http://ns.adobe.com/exif/1.0/"
+	xmpNamespacePhotoshop = "http://ns.adobe.com/photoshop/1.0/"
+	xmpNamespaceXMP       = "http://ns.adobe.com/xap/1.0/"
+	xmpNamespaceDC        = "http://purl.org/dc/elements/1.1/"
+	xmpNamespaceRDF       = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+)
+
+// sidecar is the metadata of a photo in a sidecar file; a Google Takeout
+// JSON file or an XMP file, eg. written by Lightroom.
+type sidecar struct {
+	// Capture time; zero if not known
+	date time.Time
+
+	// Whether the capture time has no timezone; it is then in the timezone
+	// of the photo
+	naive bool
+
+	description string
+
+	// GPS position; nil if not known
+	position *gpsPosition
+}
+
+// isSidecar tells whether a file is a sidecar file
+func isSidecar(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+
+	return ext == takeoutExt || ext == xmpExt
+}
+
+// sidecarNames returns the possible names of the sidecar files of a photo,
+// in order of preference
+func sidecarNames(name string) []string {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+
+	takeout := func(name string) []string {
+		res := []string{name + takeoutExt, name + ".supplemental-metadata" + takeoutExt}
+		if len(name+takeoutExt) > takeoutMaxNameLen {
+			res = append(res, name[:takeoutMaxNameLen-len(takeoutExt)]+takeoutExt)
+		}
+		return res
+	}
+
+	res := takeout(name)
+	if m := takeoutCopyRegex.FindStringSubmatch(name); m != nil {
+		res = append(res, m[1]+m[3]+m[2]+takeoutExt)
+	}
+	if strings.HasSuffix(stem, takeoutEditedSuffix) {
+		res = append(res, takeout(strings.TrimSuffix(stem, takeoutEditedSuffix)+ext)...)
+	}
+
+	return append(res, stem+xmpExt, name+xmpExt)
+}
+
+// findSidecar returns the name of the sidecar file of a photo among the
+// names of the files in its directory (by their lower case names); empty if
+// there is none.
+func findSidecar(name string, names map[string]string) string {
+	for _, n := range sidecarNames(name) {
+		if s, ok := names[strings.ToLower(n)]; ok {
+			return s
+		}
+	}
+
+	return ""
+}
+
+// readSidecar reads a sidecar file
+func readSidecar(path string) (*sidecar, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.EqualFold(filepath.Ext(path), takeoutExt) {
+		return parseTakeoutSidecar(data)
+	}
+
+	return parseXMPSidecar(data)
+}
+
+// takeoutSidecar is the structure of a Google Takeout JSON sidecar
+type takeoutSidecar struct {
+	Description    string `json:"description"`
+	PhotoTakenTime struct {
+		Timestamp string `json:"timestamp"`
+	} `json:"photoTakenTime"`
+	GeoData struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+	} `json:"geoData"`
+}
+
+// parseTakeoutSidecar parses a Google Takeout JSON sidecar
+func parseTakeoutSidecar(data []byte) (*sidecar, error) {
+	var t takeoutSidecar
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("failed to parse Takeout metadata: %w", err)
+	}
+
+	s := &sidecar{description: strings.TrimSpace(t.Description)}
+
+	if t.PhotoTakenTime.Timestamp != "" {
+		secs, err := strconv.ParseInt(t.PhotoTakenTime.Timestamp, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid photoTakenTime '%v'", t.PhotoTakenTime.Timestamp)
+		}
+		s.date = time.Unix(secs, 0).In(time.Local)
+	}
+
+	// Photos without a location have 0.0, 0.0
+	if t.GeoData.Latitude != 0 || t.GeoData.Longitude != 0 {
+		s.position = &gpsPosition{lat: t.GeoData.Latitude, lon: t.GeoData.Longitude}
+	}
+
+	return s, nil
+}
+
+// parseXMPDate parses an XMP date; dates without a timezone are in the local
+// timezone, and naive is true for them
+func parseXMPDate(s string) (d time.Time, naive bool, ok bool) {
+	for _, format := range xmpDateFormats {
+		if d, err := time.ParseInLocation(format, s, time.Local); err == nil {
+			return d, !strings.Contains(format, "Z07"), true
+		}
+	}
+
+	return time.Time{}, false, false
+}
+
+// parseXMPCoordinate parses an XMP GPS coordinate into decimal degrees
+func parseXMPCoordinate(s string) (float64, bool) {
+	m := xmpCoordinateRegex.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false
+	}
+
+	res := 0.0
+	for i, divisor := range []float64{1, 60, 3600} {
+		if m[i+1] != "" {
+			v, _ := strconv.ParseFloat(m[i+1], 64)
+			res += v / divisor
+		}
+	}
+	if m[4] == "S" || m[4] == "W" {
+		res = -res
+	}
+
+	return res, true
+}
+
+// xmpProperty returns the property an XML element belongs to; the values of
+// the list properties are in the rdf:li elements of an rdf:Alt, rdf:Seq or
+// rdf:Bag element.
+func xmpProperty(path []xml.Name) xml.Name {
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i].Space != xmpNamespaceRDF {
+			return path[i]
+		}
+	}
+
+	return xml.Name{}
+}
+
+// parseXMPSidecar parses an XMP sidecar. The properties may be given either
+// as attributes of rdf:Description or as its child elements.
+func parseXMPSidecar(data []byte) (*sidecar, error) {
+	values := map[xml.Name]string{}
+	set := func(name xml.Name, value string) {
+		if _, ok := values[name]; !ok && value != "" {
+			values[name] = value
+		}
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(data))
+	path := []xml.Name{}
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse XMP metadata: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			path = append(path, t.Name)
+			for _, a := range t.Attr {
+				set(a.Name, strings.TrimSpace(a.Value))
+			}
+		case xml.EndElement:
+			path = path[:len(path)-1]
+		case xml.CharData:
+			set(xmpProperty(path), strings.TrimSpace(string(t)))
+		}
+	}
+
+	s := &sidecar{description: values[xml.Name{Space: xmpNamespaceDC, Local: "description"}]}
+
+	for _, name := range []xml.Name{{Space: xmpNamespaceExif, Local: "DateTimeOriginal"},
+		{Space: xmpNamespacePhotoshop, Local: "DateCreated"},
+		{Space: xmpNamespaceXMP, Local: "CreateDate"}} {
+
+		if d, naive, ok := parseXMPDate(values[name]); ok {
+			s.date, s.naive = d, naive
+			break
+		}
+	}
+
+	lat, ok1 := parseXMPCoordinate(values[xml.Name{Space: xmpNamespaceExif, Local: "GPSLatitude"}])
+	lon, ok2 := parseXMPCoordinate(values[xml.Name{Space: xmpNamespaceExif, Local: "GPSLongitude"}])
+	if ok1 && ok2 {
+		s.position = &gpsPosition{lat: lat, lon: lon}
+	}
+
+	return s, nil
+}
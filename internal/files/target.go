@@ -0,0 +1,582 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/matti777/google-photos-uploader/internal/exiftool"
+	photos "github.com/matti777/google-photos-uploader/internal/googlephotos"
+	"github.com/matti777/google-photos-uploader/internal/imageproc"
+	"github.com/matti777/google-photos-uploader/internal/logging"
+	"github.com/matti777/google-photos-uploader/internal/metrics"
+	"github.com/matti777/google-photos-uploader/internal/privacy"
+	"github.com/matti777/google-photos-uploader/internal/util"
+)
+
+// Target is a destination the photos are uploaded into. A run may upload
+// into several targets at once; each has its own client, concurrency,
+// albums and ledger, and a failing target does not stop the others.
+type Target struct {
+	// Name of the target; used in messages and for naming its ledger file.
+	// Empty for the only target of a run, which uses the default ledger.
+	Name string
+
+	Client photos.Client
+
+	// Maximum number of simultaneous uploads; settings.MaxConcurrency if 0
+	Concurrency int
+
+	// Existing albums of the target; listed at the start of the run
+	albums []*photos.Album
+
+	// Ledger of the uploaded files; nil in dry run mode
+	ledger *ledger
+
+	// Albums created or appended to during the run
+	reports []*AlbumReport
+
+	// First error; the target is skipped for the rest of the run after it
+	err  error
+	lock sync.Mutex
+}
+
+// logger returns the logger of the target's events
+func (t *Target) logger() *logrus.Entry {
+	if t.Name == "" {
+		return logrus.NewEntry(log)
+	}
+
+	return log.WithField(logging.FieldTarget, t.Name)
+}
+
+// concurrency returns the maximum number of simultaneous uploads
+func (t *Target) concurrency() int {
+	if t.Concurrency > 0 {
+		return t.Concurrency
+	}
+
+	return settings.MaxConcurrency
+}
+
+// fail marks the target failed, recording the first error
+func (t *Target) fail(err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.err == nil {
+		t.err = err
+		t.logger().WithError(err).WithField(logging.FieldErrorClass, photos.ErrorClass(err)).
+			Error("Target failed -- skipping it for the rest of the run")
+	}
+}
+
+// failed tells whether the target has failed
+func (t *Target) failed() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.err != nil
+}
+
+// open lists the existing albums of the target and reads its ledger
+func (t *Target) open(baseDir string) error {
+	albums, err := t.Client.ListAlbums()
+	if err != nil {
+		metrics.ObserveError(t.Name, err)
+		return fmt.Errorf("failed to list albums: %w", err)
+	}
+	t.albums = albums
+
+	for _, a := range t.albums {
+		log.Debugf("Found existing album: '%v'", a.Title)
+	}
+
+	if !settings.DryRun {
+		if t.ledger, err = readLedger(baseDir, t.Name); err != nil {
+			return fmt.Errorf("failed to read the upload ledger: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// findAlbums returns the existing albums with the given name
+func (t *Target) findAlbums(name string) []*photos.Album {
+	res := []*photos.Album{}
+	for _, a := range t.albums {
+		if a.Title == name {
+			res = append(res, a)
+		}
+	}
+
+	return res
+}
+
+// createAlbum creates a new album and adds it to the list of albums
+func (t *Target) createAlbum(name string) (*photos.Album, error) {
+	t.logger().WithField(logging.FieldAlbum, name).Info("Creating new album")
+
+	album, err := t.Client.CreateAlbum(name)
+	if err != nil {
+		metrics.ObserveError(t.Name, err)
+		return nil, fmt.Errorf("failed to create album '%v': %w", name, err)
+	}
+	t.albums = append(t.albums, album)
+
+	return album, nil
+}
+
+// shareAlbum shares the album
+func (t *Target) shareAlbum(album *photos.Album) error {
+	entry := t.logger().WithField(logging.FieldAlbum, album.Title)
+	entry.Info("Sharing album")
+
+	info, err := t.Client.ShareAlbum(album, settings.ShareCollaborative,
+		settings.ShareCommentable)
+	if errors.Is(err, photos.ErrNotSupported) {
+		entry.Warn("Sharing albums is not supported by the destination")
+		return nil
+	}
+	if err != nil {
+		metrics.ObserveError(t.Name, err)
+		return fmt.Errorf("failed to share album '%v': %w", album.Title, err)
+	}
+	album.ShareInfo = info
+
+	return nil
+}
+
+// notInAlbum returns the files that are not recorded in the ledger as
+// already being in the album (nil for the library), unchanged.
+func (t *Target) notInAlbum(files []*photoFile, album *photos.Album) []*photoFile {
+	albumID, entry := "", t.logger()
+	if album != nil {
+		albumID, entry = album.ID, entry.WithField(logging.FieldAlbum, album.Title)
+	}
+
+	res := make([]*photoFile, 0, len(files))
+	for _, f := range files {
+		if e := t.ledger.lookup(f); e != nil && e.AlbumID == albumID && e.matches(f.info) {
+			log.Debugf("Skipping %v; already uploaded", ledgerPath(f))
+			continue
+		}
+		res = append(res, f)
+	}
+
+	if skipped := len(files) - len(res); skipped > 0 {
+		metrics.FilesTotal.Add(float64(skipped), t.Name, metrics.StatusSkipped)
+		entry.Infof("Skipping %v file(s) already in the album", skipped)
+	}
+
+	return res
+}
+
+// Prepares a file for uploading; replaced in tests
+var prepareFileFunc = prepareFile
+
+// preparedFile is a file rewritten for uploading, with its dates fixed
+type preparedFile struct {
+	once sync.Once
+	path string
+	err  error
+}
+
+// preparedFiles prepares the files of an album for uploading on demand. Each
+// file is prepared once and shared by all the targets.
+type preparedFiles struct {
+	albumDate util.AlbumDate
+	files     map[*photoFile]*preparedFile
+	lock      sync.Mutex
+}
+
+func newPreparedFiles(albumDate util.AlbumDate) *preparedFiles {
+	return &preparedFiles{albumDate: albumDate, files: map[*photoFile]*preparedFile{}}
+}
+
+// get returns the path of the prepared file, preparing it if needed
+func (p *preparedFiles) get(f *photoFile) (string, error) {
+	p.lock.Lock()
+	pf := p.files[f]
+	if pf == nil {
+		pf = &preparedFile{}
+		p.files[f] = pf
+	}
+	p.lock.Unlock()
+
+	pf.once.Do(func() {
+		pf.path, pf.err = prepareFileFunc(f, p.albumDate)
+	})
+
+	return pf.path, pf.err
+}
+
+// cleanup removes the prepared files
+func (p *preparedFiles) cleanup() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, pf := range p.files {
+		if pf.path != "" {
+			os.Remove(pf.path)
+		}
+	}
+	p.files = map[*photoFile]*preparedFile{}
+}
+
+// prepareFile writes the creation date into the EXIF data of a copy of the
+// file, so that the photo gets a proper date in the album, processing the
+// image first if settings.Processing is enabled. The tags redacted by
+// settings.Privacy are removed from the copy, and the copy is checked not to
+// contain denied tags. Returns the path of the copy.
+func prepareFile(photo *photoFile, albumDate util.AlbumDate) (string, error) {
+	var redaction privacy.Redaction
+	if settings.Privacy.Enabled() {
+		tags, err := exiftool.ReadTags(photo.path())
+		if err != nil {
+			return "", fmt.Errorf("failed to read metadata: %w", err)
+		}
+		redaction = settings.Privacy.Redaction(tags)
+	}
+
+	srcPath := photo.path()
+	if settings.Processing.Enabled() {
+		processed, err := processFile(photo)
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(processed)
+		srcPath = processed
+	}
+
+	tempFile, err := os.CreateTemp("", "*.jpeg")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tempFile.Close()
+	os.Remove(tempFile.Name()) // exiftool refuses to overwrite existing files
+
+	fileDate := getDateForFile(albumDate, photo)
+	log.Debugf("Writing file date %v for image: %v to tempFile: %v, removing tags: %v",
+		fileDate, photo.path(), tempFile.Name(), redaction.Delete)
+	if err := exiftool.WriteTags(srcPath, tempFile.Name(), fileDate,
+		redaction.Delete); err != nil {
+
+		os.Remove(tempFile.Name())
+		return "", fmt.Errorf("failed to call exiftool.WriteTags: %w", err)
+	}
+
+	if settings.Privacy.Enabled() {
+		if err := checkLeaks(tempFile.Name(), redaction); err != nil {
+			os.Remove(tempFile.Name())
+			return "", err
+		}
+	}
+
+	return tempFile.Name(), nil
+}
+
+// checkLeaks returns a *privacy.LeakError if the file contains tags denied
+// by the redaction
+func checkLeaks(path string, redaction privacy.Redaction) error {
+	tags, err := exiftool.ReadTags(path)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	if leaks := redaction.Leaks(tags); len(leaks) > 0 {
+		return &privacy.LeakError{Tags: leaks}
+	}
+
+	return nil
+}
+
+// processFile writes a copy of the image processed according to
+// settings.Processing into a temp file. Returns the path of the copy.
+func processFile(photo *photoFile) (string, error) {
+	tempFile, err := os.CreateTemp("", "*.processed.jpeg")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tempFile.Close()
+
+	log.Debugf("Processing image %v into %v", photo.path(), tempFile.Name())
+	if err := imageproc.ProcessFile(photo.path(), tempFile.Name(),
+		settings.Processing); err != nil {
+
+		os.Remove(tempFile.Name())
+		return "", fmt.Errorf("failed to process image: %w", err)
+	}
+
+	return tempFile.Name(), nil
+}
+
+// albumUpload is the upload of an album's files into a target
+type albumUpload struct {
+	target *Target
+
+	// Album to add the files into; nil for the library
+	album *photos.Album
+
+	// Whether the album was created during the run
+	created bool
+
+	// Files not yet in the album
+	files []*photoFile
+}
+
+// logger returns the logger of the upload's events
+func (u *albumUpload) logger() *logrus.Entry {
+	if u.album == nil {
+		return u.target.logger()
+	}
+
+	return u.target.logger().WithField(logging.FieldAlbum, u.album.Title)
+}
+
+// Delay before the first retry of a failed upload; doubled for each retry
+var retryDelay = time.Second
+
+// isTransient tells whether an upload failing with the error class may
+// succeed if retried
+func isTransient(errorClass string) bool {
+	switch errorClass {
+	case photos.ErrorClassRateLimit, photos.ErrorClassServer, photos.ErrorClassNetwork,
+		photos.ErrorClassTimeout:
+		return true
+	}
+
+	return false
+}
+
+// upload synchronously uploads a prepared file, displaying its progress in
+// the dashboard. Transient failures are retried up to settings.UploadRetries
+// times. Returns the upload token.
+func (u *albumUpload) upload(photo *photoFile, prepared *preparedFiles) (string, error) {
+	t := u.target
+	label := photo.info.Name()
+	if t.Name != "" {
+		label = t.Name + ": " + label
+	}
+	entry := u.logger().WithField(logging.FieldFile, ledgerPath(photo))
+
+	fail := func(err error) (string, error) {
+		board.StartTargetUpload(t.Name, label, 0).Done(err)
+		metrics.FilesTotal.Inc(t.Name, metrics.StatusFailed)
+		entry.WithError(err).WithField(logging.FieldErrorClass, photos.ErrorClass(err)).
+			Warn("Upload failed")
+		return "", err
+	}
+
+	filePath, err := prepared.get(photo)
+	if err != nil {
+		return fail(err)
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return fail(fmt.Errorf("failed to get file size: %w", err))
+	}
+
+	return u.uploadPrepared(filePath, info.Size(), label, entry)
+}
+
+// uploadPrepared uploads a prepared file of the given size, retrying it after
+// transient failures
+func (u *albumUpload) uploadPrepared(filePath string, size int64, label string,
+	entry *logrus.Entry) (string, error) {
+
+	t := u.target
+	progress := board.StartTargetUpload(t.Name, label, size)
+	entry = entry.WithField(logging.FieldBytes, size)
+
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		uploadToken, err := t.Client.UploadPhoto(filePath, progress.Set)
+		duration := time.Since(start)
+		metrics.UploadDuration.Observe(duration.Seconds(), t.Name)
+
+		entry := entry.WithFields(logrus.Fields{
+			logging.FieldAttempt:  attempt,
+			logging.FieldDuration: duration.Seconds(),
+		})
+
+		if err == nil {
+			progress.Done(nil)
+			metrics.FilesTotal.Inc(t.Name, metrics.StatusUploaded)
+			metrics.BytesUploaded.Add(float64(size), t.Name)
+			if board.Live() {
+				// The live view shows the uploads
+				entry.Debug("Uploaded file")
+			} else {
+				entry.Info("Uploaded file")
+			}
+			return uploadToken, nil
+		}
+
+		metrics.ObserveError(t.Name, err)
+		errorClass := photos.ErrorClass(err)
+		entry = entry.WithError(err).WithField(logging.FieldErrorClass, errorClass)
+
+		if attempt > settings.UploadRetries || !isTransient(errorClass) {
+			progress.Done(err)
+			metrics.FilesTotal.Inc(t.Name, metrics.StatusFailed)
+			entry.Warn("Upload failed")
+			return "", err
+		}
+
+		delay := retryDelay << (attempt - 1)
+		entry.Warnf("Upload failed -- retrying in %v", delay)
+		metrics.UploadRetries.Inc(t.Name)
+		progress.Set(0)
+		time.Sleep(delay)
+	}
+}
+
+// uploadAll uploads the files and returns the media items to be created out of
+// the uploaded photos in the order of the files regardless of the order the
+// uploads complete in, along with the uploaded files by their upload tokens.
+// Stops at the first failed upload.
+func (u *albumUpload) uploadAll(prepared *preparedFiles) ([]*photos.NewMediaItem,
+	map[string]*photoFile, error) {
+
+	// Media items by the index of their file
+	results := make([]*photos.NewMediaItem, len(u.files))
+	uploaded := map[string]*photoFile{}
+	var uploadErr error
+	var lock sync.Mutex
+
+	// Create a concurrency execution queue for the uploads
+	q, err := util.NewOperationQueue(u.target.concurrency(), 100)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create operation queue: %w", err)
+	}
+	defer metrics.TrackQueue(q)()
+
+	for i, f := range u.files {
+		i, file := i, f
+
+		q.Add(func() {
+			lock.Lock()
+			failed := uploadErr != nil
+			lock.Unlock()
+			if failed {
+				return
+			}
+
+			uploadToken, err := u.upload(file, prepared)
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			if err != nil {
+				if uploadErr == nil {
+					uploadErr = fmt.Errorf("upload of %v failed: %w", ledgerPath(file), err)
+				}
+				return
+			}
+
+			if uploadToken != "" {
+				results[i] = &photos.NewMediaItem{
+					UploadToken: uploadToken,
+					FileName:    file.uploadName(),
+					Description: itemDescription(file),
+				}
+				uploaded[uploadToken] = file
+			} else {
+				log.Debugf("Uploaded photo didn't receive upload token " +
+					"-- it has already been uploaded with another token.")
+			}
+		})
+	}
+
+	// Wait for all the operations to complete
+	q.GracefulShutdown()
+	log.Debugf("All uploads to target %v finished.", u.target.Name)
+
+	mediaItems := make([]*photos.NewMediaItem, 0, len(results))
+	for _, item := range results {
+		if item != nil {
+			mediaItems = append(mediaItems, item)
+		}
+	}
+
+	return mediaItems, uploaded, uploadErr
+}
+
+// uploadFiles uploads the files and adds them into the album; if the album is
+// nil, the files are only uploaded into the library. The files uploaded
+// before a failure are still added to the album. Returns the created media
+// items.
+func (u *albumUpload) uploadFiles(prepared *preparedFiles) ([]*photos.MediaItem, error) {
+	t := u.target
+	mediaItems, uploaded, uploadErr := u.uploadAll(prepared)
+
+	created := make([]*photos.MediaItem, 0, len(mediaItems))
+
+	// We must split the items into groups of max MaxAddPhotosPerCall items;
+	// the groups are added in order, each to the end of the album, to keep
+	// the album order
+	for _, c := range util.Chunked(mediaItems, photos.MaxAddPhotosPerCall) {
+		// Create n media items at a time in the album
+		items, err := t.Client.AddToAlbum(u.album, c)
+		if err != nil {
+			metrics.ObserveError(t.Name, err)
+			return created, fmt.Errorf("failed to add photos to album: %w", err)
+		}
+		created = append(created, items...)
+
+		for _, item := range items {
+			if f := uploaded[item.UploadToken]; f != nil {
+				t.ledger.record(f, u.album, item)
+			}
+		}
+
+		if err := t.ledger.save(); err != nil {
+			log.Errorf("Failed to save the upload ledger: %v", err)
+		}
+	}
+
+	return created, uploadErr
+}
+
+// run uploads the files of the album into the target and applies the album
+// metadata, recording a report of the album. Marks the target failed on error.
+func (u *albumUpload) run(group *albumGroup, prepared *preparedFiles) {
+	t := u.target
+
+	items := []*photos.MediaItem{}
+	var err error
+	if len(u.files) > 0 {
+		log.Debugf("Uploading %v photos to album %v of target %v", len(u.files),
+			albumTitle(group), t.Name)
+		items, err = u.uploadFiles(prepared)
+	}
+
+	// The metadata of an existing album has been applied when creating it
+	if err == nil && u.created && len(items) > 0 {
+		err = t.applyAlbumMetadata(group, u.album, items)
+	}
+
+	override := group.override
+	if err == nil && u.created && (settings.Share || (override != nil && override.Share)) {
+		err = t.shareAlbum(u.album)
+	}
+
+	if u.album != nil {
+		report := NewAlbumReport(u.album, len(items))
+		report.Appended = !u.created
+
+		t.lock.Lock()
+		t.reports = append(t.reports, report)
+		t.lock.Unlock()
+	}
+
+	if err != nil {
+		t.fail(fmt.Errorf("album '%v': %w", albumTitle(group), err))
+	}
+}
@@ -0,0 +1,165 @@
+package files
+
+import (
+	"fmt"
+
+	photos "github.com/matti777/google-photos-uploader/internal/googlephotos"
+)
+
+// albumDiff lists the differences between a local album and its online
+// counterpart
+type albumDiff struct {
+	Title string
+
+	// Whether no album with the title was found online
+	AlbumMissing bool
+
+	// Number of local files and online media items
+	Local  int
+	Online int
+
+	// Local files (paths relative to the base directory) not found online
+	MissingOnline []string
+
+	// File names of the online media items not found locally
+	ExtraOnline []string
+
+	// Local files modified after they were uploaded, according to the ledger
+	Changed []string
+}
+
+// ok tells whether the local and online albums match
+func (d *albumDiff) ok() bool {
+	return !d.AlbumMissing && d.Local == d.Online && len(d.MissingOnline) == 0 &&
+		len(d.ExtraOnline) == 0 && len(d.Changed) == 0
+}
+
+// diffAlbum matches the local files of an album with its online media items;
+// first by the media item IDs stored in the ledger, then by file name.
+func diffAlbum(title string, files []*photoFile, items []*photos.MediaItem,
+	l *ledger) *albumDiff {
+
+	d := &albumDiff{Title: title, Local: len(files), Online: len(items)}
+
+	byID := map[string]*photos.MediaItem{}
+	byName := map[string][]*photos.MediaItem{}
+	for _, item := range items {
+		byID[item.ID] = item
+		byName[item.FileName] = append(byName[item.FileName], item)
+	}
+
+	matched := map[*photos.MediaItem]bool{}
+
+	for _, f := range files {
+		if e := l.lookup(f); e != nil {
+			if item := byID[e.MediaItemID]; item != nil && !matched[item] {
+				matched[item] = true
+				if !e.matches(f.info) {
+					d.Changed = append(d.Changed, ledgerPath(f))
+				}
+				continue
+			}
+		}
+
+		found := false
+		for _, item := range byName[f.info.Name()] {
+			if !matched[item] {
+				matched[item] = true
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			d.MissingOnline = append(d.MissingOnline, ledgerPath(f))
+		}
+	}
+
+	for _, item := range items {
+		if !matched[item] {
+			d.ExtraOnline = append(d.ExtraOnline, item.FileName)
+		}
+	}
+
+	return d
+}
+
+// print prints the album diff
+func (d *albumDiff) print() {
+	if d.AlbumMissing {
+		fmt.Printf("MISSING   %v: album not found (%v local files)\n", d.Title, d.Local)
+		return
+	}
+
+	if d.ok() {
+		fmt.Printf("OK        %v: %v items\n", d.Title, d.Local)
+		return
+	}
+
+	fmt.Printf("MISMATCH  %v: %v local files, %v online items\n", d.Title, d.Local, d.Online)
+	for _, p := range d.MissingOnline {
+		fmt.Printf("  - %v (missing online)\n", p)
+	}
+	for _, n := range d.ExtraOnline {
+		fmt.Printf("  + %v (extra online)\n", n)
+	}
+	for _, p := range d.Changed {
+		fmt.Printf("  ~ %v (modified after upload)\n", p)
+	}
+}
+
+// mustVerifyAlbum compares the local files of an album against the items of
+// the album(s) with the same title online.
+func mustVerifyAlbum(group *albumGroup, l *ledger) *albumDiff {
+	title := albumTitle(group)
+	files := uploadableFiles(group)
+
+	albums := settings.FindAlbums(title)
+	if len(albums) == 0 {
+		return &albumDiff{Title: title, AlbumMissing: true, Local: len(files)}
+	}
+
+	items := []*photos.MediaItem{}
+	for _, a := range albums {
+		albumItems, err := photos.MustGetClient().ListAlbumItems(a)
+		if err != nil {
+			log.Fatalf("Failed to list the items of album '%v': %v", a.Title, err)
+		}
+		items = append(items, albumItems...)
+	}
+
+	return diffAlbum(title, files, items, l)
+}
+
+// VerifyBaseDir audits the local albums of the base directory against Google
+// Photos, printing the differences. Returns the number of albums with
+// discrepancies.
+func VerifyBaseDir(absoluteDirPath string) int {
+	groups := mustScanAlbumGroups(absoluteDirPath)
+
+	l, err := readLedger(absoluteDirPath, "")
+	if err != nil {
+		log.Fatalf("Failed to read the upload ledger: %v", err)
+	}
+
+	verified := 0
+	failed := 0
+
+	for _, g := range groups {
+		if g.key == "" || (g.override != nil && g.override.Skip) {
+			continue
+		}
+
+		d := mustVerifyAlbum(g, l)
+		d.print()
+
+		verified++
+		if !d.ok() {
+			failed++
+		}
+	}
+
+	fmt.Printf("%v album(s) verified, %v with discrepancies.\n", verified, failed)
+
+	return failed
+}
@@ -0,0 +1,50 @@
+package googlephotos
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrNotSupported is returned by the clients of destinations not supporting
+// a feature, eg. sharing
+var ErrNotSupported = errors.New("not supported by the destination")
+
+// Client is the interface of the Google Photos client; implemented by the
+// Google Photos API client, the local mirror and the other destinations.
+type Client interface {
+	// ListAlbums lists all the albums
+	ListAlbums() ([]*Album, error)
+
+	// ListSharedAlbums lists all the shared albums
+	ListSharedAlbums() ([]*Album, error)
+
+	// CreateAlbum creates a new album
+	CreateAlbum(name string) (*Album, error)
+
+	// ShareAlbum shares the album; sets and returns the album's sharing information
+	ShareAlbum(album *Album, collaborative, commentable bool) (*ShareInfo, error)
+
+	// UploadPhoto uploads a file, calling the callback with the number of bytes
+	// sent so far; returns the upload token
+	UploadPhoto(path string, callback func(int64)) (string, error)
+
+	// AddToAlbum creates media items out of uploaded photos and adds them to
+	// the album (nil for the library only)
+	AddToAlbum(album *Album, items []*NewMediaItem) ([]*MediaItem, error)
+
+	// AddEnrichment adds an enrichment to the beginning (first) or the end of
+	// the album
+	AddEnrichment(album *Album, e *Enrichment, first bool) error
+
+	// SetAlbumCover sets the cover photo of the album
+	SetAlbumCover(album *Album, mediaItemID string) error
+
+	// SearchMediaItems searches for media items
+	SearchMediaItems(f *SearchFilter) ([]*MediaItem, error)
+
+	// ListAlbumItems lists all the media items of the album
+	ListAlbumItems(album *Album) ([]*MediaItem, error)
+
+	// DownloadMediaItem writes the original bytes of the media item into w
+	DownloadMediaItem(item *MediaItem, w io.Writer) (int64, error)
+}
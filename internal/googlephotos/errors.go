@@ -0,0 +1,104 @@
+package googlephotos
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+)
+
+// Error classes of the failed operations; see ErrorClass
+const (
+	ErrorClassAuth        = "auth"
+	ErrorClassNotFound    = "not_found"
+	ErrorClassRateLimit   = "rate_limit"
+	ErrorClassClient      = "client"
+	ErrorClassServer      = "server"
+	ErrorClassTimeout     = "timeout"
+	ErrorClassNetwork     = "network"
+	ErrorClassFile        = "file"
+	ErrorClassUnsupported = "unsupported"
+	ErrorClassOther       = "other"
+)
+
+// apiError is an error response of the Photos API
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+// StatusCode returns the HTTP status code of the response
+func (e *apiError) StatusCode() int {
+	return e.status
+}
+
+// StatusCode returns the HTTP status code of an error response of a
+// destination's API; 0 if the error is not an error response.
+func StatusCode(err error) int {
+	var se interface{ StatusCode() int }
+	if errors.As(err, &se) {
+		return se.StatusCode()
+	}
+
+	return 0
+}
+
+// ErrorClass classifies an error for logging, eg. "auth", "rate_limit" or
+// "network"
+func ErrorClass(err error) string {
+	switch status := StatusCode(err); {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrorClassAuth
+	case status == http.StatusNotFound:
+		return ErrorClassNotFound
+	case status == http.StatusTooManyRequests:
+		return ErrorClassRateLimit
+	case status >= 500:
+		return ErrorClassServer
+	case status >= 400:
+		return ErrorClassClient
+	}
+
+	// Checked before net.Error, which fs.PathError also implements
+	var pathErr *fs.PathError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrNotSupported):
+		return ErrorClassUnsupported
+	case errors.As(err, &pathErr):
+		return ErrorClassFile
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorClassTimeout
+	case netErr != nil:
+		return ErrorClassNetwork
+	}
+
+	return ErrorClassOther
+}
+
+// rpcErrorClass classifies the status code of a failed batch operation; the
+// codes are gRPC codes
+func rpcErrorClass(code int) string {
+	switch code {
+	case 5: // NOT_FOUND
+		return ErrorClassNotFound
+	case 7, 16: // PERMISSION_DENIED, UNAUTHENTICATED
+		return ErrorClassAuth
+	case 8: // RESOURCE_EXHAUSTED
+		return ErrorClassRateLimit
+	case 3, 9: // INVALID_ARGUMENT, FAILED_PRECONDITION
+		return ErrorClassClient
+	case 13, 14: // INTERNAL, UNAVAILABLE
+		return ErrorClassServer
+	case 4: // DEADLINE_EXCEEDED
+		return ErrorClassTimeout
+	}
+
+	return ErrorClassOther
+}
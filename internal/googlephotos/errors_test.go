@@ -0,0 +1,39 @@
+package googlephotos
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestErrorClass(t *testing.T) {
+	_, pathErr := os.Open("/nonexistent")
+
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{&apiError{status: 401}, ErrorClassAuth},
+		{fmt.Errorf("failed: %w", &apiError{status: 429}), ErrorClassRateLimit},
+		{&apiError{status: 404}, ErrorClassNotFound},
+		{&apiError{status: 400}, ErrorClassClient},
+		{&apiError{status: 503}, ErrorClassServer},
+		{fmt.Errorf("failed: %w", context.DeadlineExceeded), ErrorClassTimeout},
+		{&net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}, ErrorClassNetwork},
+		{pathErr, ErrorClassFile},
+		{fmt.Errorf("sharing: %w", ErrNotSupported), ErrorClassUnsupported},
+		{fmt.Errorf("something else"), ErrorClassOther},
+	}
+
+	for _, test := range tests {
+		if class := ErrorClass(test.err); class != test.expected {
+			t.Errorf("%v: expected %v, got %v", test.err, test.expected, class)
+		}
+	}
+
+	if status := StatusCode(fmt.Errorf("wrapped: %w", &apiError{status: 500})); status != 500 {
+		t.Errorf("expected status 500, got %v", status)
+	}
+}
@@ -3,16 +3,19 @@
 package googlephotos
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"strings"
 	"sync"
 
 	"github.com/matti777/google-photos-uploader/internal/googlephotos/util"
+	"github.com/matti777/google-photos-uploader/internal/logging"
 
+	"github.com/sirupsen/logrus"
 	"golang.org/x/oauth2"
 	"google.golang.org/api/photoslibrary/v1"
 )
@@ -21,22 +24,33 @@ const (
 	// MaxAddPhotosPerCall is the maximum number of photos to add to
 	// an album in one single call.
 	MaxAddPhotosPerCall = 50
+
+	// MaxDescriptionLength is the maximum length of a media item description
+	MaxDescriptionLength = 1000
+
+	// Media item batch creation endpoint URL
+	mediaItemsBatchCreateURL = "https://photoslibrary.googleapis.com/v1/mediaItems:batchCreate"
+
+	// Album patch endpoint URL format; used for setting the cover photo
+	albumPatchURLFmt = "https://photoslibrary.googleapis.com/v1/albums/%v" +
+		"?updateMask=coverPhotoMediaItemId"
 )
 
-// Client is Our API client type. Create with NewClient().
-type Client struct {
+// apiClient is the Google Photos API client. Create with MustCreateClient().
+type apiClient struct {
 	httpClient   *http.Client
 	photosClient *photoslibrary.Service
 }
 
 var (
-	client     *Client
+	log        = logging.MustGetLogger()
+	client     Client
 	clientOnce sync.Once
 )
 
 // newClient creates a new API client using an OAuth2 token. To acquire the
 // token, run the authorization flow with util.Authenticator.
-func newClient(clientID, clientSecret string, token *oauth2.Token) (*Client, error) {
+func newClient(clientID, clientSecret string, token *oauth2.Token) (*apiClient, error) {
 	config := util.NewOAuth2Config(clientID, clientSecret)
 	httpClient := config.Client(context.Background(), token)
 
@@ -45,25 +59,42 @@ func newClient(clientID, clientSecret string, token *oauth2.Token) (*Client, err
 		return nil, err
 	}
 
-	return &Client{photosClient: photosClient, httpClient: httpClient}, nil
+	return &apiClient{photosClient: photosClient, httpClient: httpClient}, nil
+}
+
+// NewClient creates a new, non-cached Photos client; eg. for a second
+// Google account.
+func NewClient(clientID, clientSecret string, token *oauth2.Token) (Client, error) {
+	c, err := newClient(clientID, clientSecret, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
 }
 
 // Returns a cached Photos client
-func MustCreateClient(clientID, clientSecret string, token *oauth2.Token) *Client {
+func MustCreateClient(clientID, clientSecret string, token *oauth2.Token) Client {
 	clientOnce.Do(func() {
-		var err error
-
-		client, err = newClient(clientID, clientSecret, token)
+		c, err := newClient(clientID, clientSecret, token)
 		if err != nil {
-			log.Fatal("Failed to create Google Photos client", err)
+			log.Fatalf("Failed to create Google Photos client: %v", err)
 		}
+		client = c
 	})
 
 	return client
 }
 
+// SetClient sets the client returned by MustGetClient(); eg. a local mirror
+// to be used instead of Google Photos.
+func SetClient(c Client) {
+	clientOnce.Do(func() {})
+	client = c
+}
+
 // Returns a cached, previously created Photos client
-func MustGetClient() *Client {
+func MustGetClient() Client {
 	if client == nil {
 		log.Fatalf("Photos client not created")
 	}
@@ -71,8 +102,30 @@ func MustGetClient() *Client {
 	return client
 }
 
+// toAlbum converts an API album into an Album
+func toAlbum(a *photoslibrary.Album) *Album {
+	return &Album{ID: a.Id, Title: a.Title, ProductURL: a.ProductUrl,
+		IsWriteable: a.IsWriteable, ShareInfo: toShareInfo(a.ShareInfo),
+		MediaItemsCount: a.TotalMediaItems}
+}
+
+// toShareInfo converts API album sharing information into ShareInfo
+func toShareInfo(s *photoslibrary.ShareInfo) *ShareInfo {
+	if s == nil {
+		return nil
+	}
+
+	info := &ShareInfo{ShareableURL: s.ShareableUrl, ShareToken: s.ShareToken}
+	if s.SharedAlbumOptions != nil {
+		info.IsCollaborative = s.SharedAlbumOptions.IsCollaborative
+		info.IsCommentable = s.SharedAlbumOptions.IsCommentable
+	}
+
+	return info
+}
+
 // ListAlbums Lists all the Albums
-func (c *Client) ListAlbums() ([]*Album, error) {
+func (c *apiClient) ListAlbums() ([]*Album, error) {
 	var res *photoslibrary.ListAlbumsResponse
 	done := false
 	albums := make([]*Album, 0)
@@ -91,7 +144,38 @@ func (c *Client) ListAlbums() ([]*Album, error) {
 		}
 
 		for _, a := range res.Albums {
-			albums = append(albums, &Album{ID: a.Id, Title: a.Title})
+			albums = append(albums, toAlbum(a))
+		}
+
+		if res.NextPageToken == "" {
+			done = true
+		}
+	}
+
+	return albums, nil
+}
+
+// ListSharedAlbums lists all the shared albums
+func (c *apiClient) ListSharedAlbums() ([]*Album, error) {
+	var res *photoslibrary.ListSharedAlbumsResponse
+	done := false
+	albums := make([]*Album, 0)
+
+	for !done {
+		req := c.photosClient.SharedAlbums.List().PageSize(50)
+		if res != nil && res.NextPageToken != "" {
+			req = req.PageToken(res.NextPageToken)
+		}
+
+		var err error
+
+		res, err = req.Do()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get shared albums: %w", err)
+		}
+
+		for _, a := range res.SharedAlbums {
+			albums = append(albums, toAlbum(a))
 		}
 
 		if res.NextPageToken == "" {
@@ -102,8 +186,28 @@ func (c *Client) ListAlbums() ([]*Album, error) {
 	return albums, nil
 }
 
+// ShareAlbum shares the album; only albums created by this app can be shared.
+// Sets and returns the album's sharing information.
+func (c *apiClient) ShareAlbum(album *Album, collaborative, commentable bool) (*ShareInfo, error) {
+	req := &photoslibrary.ShareAlbumRequest{
+		SharedAlbumOptions: &photoslibrary.SharedAlbumOptions{
+			IsCollaborative: collaborative,
+			IsCommentable:   commentable,
+		},
+	}
+
+	res, err := c.photosClient.Albums.Share(album.ID, req).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to share album: %w", err)
+	}
+
+	album.ShareInfo = toShareInfo(res.ShareInfo)
+
+	return album.ShareInfo, nil
+}
+
 // CreateAlbum creates a new album
-func (c *Client) CreateAlbum(name string) (*Album, error) {
+func (c *apiClient) CreateAlbum(name string) (*Album, error) {
 	req := &photoslibrary.CreateAlbumRequest{
 		Album: &photoslibrary.Album{
 			Title: name,
@@ -115,59 +219,228 @@ func (c *Client) CreateAlbum(name string) (*Album, error) {
 		return nil, err
 	}
 
-	return &Album{ID: album.Id, Title: album.Title}, nil
+	return toAlbum(album), nil
 }
 
-// AddToAlbum adds the photos identified by their upload tokens to the album
-func (c *Client) AddToAlbum(album *Album, uploadTokens []string) error {
-	if len(uploadTokens) > MaxAddPhotosPerCall {
-		return fmt.Errorf("Maximum number of photos to add per call is %v",
-			MaxAddPhotosPerCall)
+// JSON types of the media item batch creation API. These are used instead of
+// the photoslibrary types since those lack SimpleMediaItem.fileName.
+type batchCreateRequest struct {
+	AlbumID       string                 `json:"albumId,omitempty"`
+	NewMediaItems []batchCreateMediaItem `json:"newMediaItems"`
+	AlbumPosition *albumPosition         `json:"albumPosition,omitempty"`
+}
+
+type albumPosition struct {
+	Position string `json:"position"`
+}
+
+type batchCreateMediaItem struct {
+	Description     string `json:"description,omitempty"`
+	SimpleMediaItem struct {
+		UploadToken string `json:"uploadToken"`
+		FileName    string `json:"fileName,omitempty"`
+	} `json:"simpleMediaItem"`
+}
+
+type batchCreateResponse struct {
+	NewMediaItemResults []struct {
+		UploadToken string `json:"uploadToken"`
+		Status      struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"status"`
+		MediaItem *struct {
+			ID          string `json:"id"`
+			Filename    string `json:"filename"`
+			Description string `json:"description"`
+		} `json:"mediaItem"`
+	} `json:"newMediaItemResults"`
+}
+
+// doJSON makes an API call with a JSON request body and decodes the JSON
+// response into res (if not nil).
+func (c *apiClient) doJSON(method, url string, body, res interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	mediaItems := make([]*photoslibrary.NewMediaItem, len(uploadTokens))
-	for i, tok := range uploadTokens {
-		mediaItems[i] = &photoslibrary.NewMediaItem{
-			SimpleMediaItem: &photoslibrary.SimpleMediaItem{
-				UploadToken: tok,
-			},
-		}
+	req, err := http.NewRequest(method, url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
 	}
+	req.Header.Set("Content-Type", "application/json")
 
-	req := &photoslibrary.BatchCreateMediaItemsRequest{
-		AlbumId:       album.ID,
-		NewMediaItems: mediaItems,
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call %v %v: %w", method, url, err)
 	}
+	defer resp.Body.Close()
 
-	res, err := c.photosClient.MediaItems.BatchCreate(req).Do()
+	contents, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to read Photos API response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &apiError{status: resp.StatusCode, message: fmt.Sprintf("%v %v failed: %v: %v",
+			method, url, resp.Status, strings.TrimSpace(string(contents)))}
 	}
 
-	numFailed := 0
+	if res != nil {
+		if err := json.Unmarshal(contents, res); err != nil {
+			return fmt.Errorf("failed to unmarshal Photos API response: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// AddToAlbum creates media items out of uploaded photos and adds them to
+// the album. If album is nil, the photos are only added to the library.
+// Returns the created media items.
+func (c *apiClient) AddToAlbum(album *Album, items []*NewMediaItem) ([]*MediaItem, error) {
+	if len(items) > MaxAddPhotosPerCall {
+		return nil, fmt.Errorf("Maximum number of photos to add per call is %v",
+			MaxAddPhotosPerCall)
+	}
+
+	req := batchCreateRequest{NewMediaItems: make([]batchCreateMediaItem, len(items))}
+	if album != nil {
+		// The items are added in the order given, after the existing ones
+		req.AlbumID = album.ID
+		req.AlbumPosition = &albumPosition{Position: "LAST_IN_ALBUM"}
+	}
+
+	for i, item := range items {
+		req.NewMediaItems[i].Description = truncate(item.Description, MaxDescriptionLength)
+		req.NewMediaItems[i].SimpleMediaItem.UploadToken = item.UploadToken
+		req.NewMediaItems[i].SimpleMediaItem.FileName = item.FileName
+	}
+
+	var res batchCreateResponse
+	if err := c.doJSON("POST", mediaItemsBatchCreateURL, &req, &res); err != nil {
+		return nil, err
+	}
+
+	created := make([]*MediaItem, 0, len(items))
+
+	fileNames := map[string]string{}
+	for _, item := range items {
+		fileNames[item.UploadToken] = item.FileName
+	}
 
 	for _, r := range res.NewMediaItemResults {
 		if r.MediaItem == nil {
-			fmt.Printf("Failed to add a photo to the album with token: %v: %v\n",
-				r.UploadToken, r.Status.Message)
-			numFailed++
+			entry := log.WithFields(logrus.Fields{
+				logging.FieldFile:       fileNames[r.UploadToken],
+				logging.FieldErrorClass: rpcErrorClass(r.Status.Code),
+			})
+			if album != nil {
+				entry = entry.WithField(logging.FieldAlbum, album.Title)
+			}
+			entry.Warnf("Failed to add a photo to the album: %v", r.Status.Message)
+			continue
 		}
+
+		created = append(created, &MediaItem{ID: r.MediaItem.ID,
+			FileName: r.MediaItem.Filename, Description: r.MediaItem.Description,
+			UploadToken: r.UploadToken})
 	}
 
-	if numFailed == len(uploadTokens) {
+	if len(created) == 0 {
 		// All failed to add
-		return fmt.Errorf("Failed to add all of the photos to album")
+		return nil, fmt.Errorf("Failed to add all of the photos to album")
 	}
 
 	// At least some photos added successfully
+	return created, nil
+}
+
+// toPhotosLocation converts a Location to its API representation
+func toPhotosLocation(l *Location) *photoslibrary.Location {
+	if l == nil {
+		return nil
+	}
+
+	return &photoslibrary.Location{
+		LocationName: l.Name,
+		Latlng: &photoslibrary.LatLng{
+			Latitude:  l.Latitude,
+			Longitude: l.Longitude,
+		},
+	}
+}
+
+// AddEnrichment adds an enrichment (text, location or map) to the album. If
+// first is true, the enrichment is added to the beginning of the album;
+// otherwise to the end.
+func (c *apiClient) AddEnrichment(album *Album, e *Enrichment, first bool) error {
+	item := &photoslibrary.NewEnrichmentItem{}
+
+	switch {
+	case e.Text != "":
+		item.TextEnrichment = &photoslibrary.TextEnrichment{Text: e.Text}
+	case e.Location != nil:
+		item.LocationEnrichment = &photoslibrary.LocationEnrichment{
+			Location: toPhotosLocation(e.Location),
+		}
+	case e.MapOrigin != nil && e.MapDestination != nil:
+		item.MapEnrichment = &photoslibrary.MapEnrichment{
+			Origin:      toPhotosLocation(e.MapOrigin),
+			Destination: toPhotosLocation(e.MapDestination),
+		}
+	default:
+		return fmt.Errorf("empty or incomplete enrichment")
+	}
+
+	position := "LAST_IN_ALBUM"
+	if first {
+		position = "FIRST_IN_ALBUM"
+	}
+
+	req := &photoslibrary.AddEnrichmentToAlbumRequest{
+		NewEnrichmentItem: item,
+		AlbumPosition:     &photoslibrary.AlbumPosition{Position: position},
+	}
+
+	if _, err := c.photosClient.Albums.AddEnrichment(album.ID, req).Do(); err != nil {
+		return fmt.Errorf("failed to add enrichment to album: %w", err)
+	}
+
+	return nil
+}
+
+// SetAlbumCover sets the cover photo of the album; the media item must be
+// in the album.
+func (c *apiClient) SetAlbumCover(album *Album, mediaItemID string) error {
+	req := struct {
+		CoverPhotoMediaItemID string `json:"coverPhotoMediaItemId"`
+	}{CoverPhotoMediaItemID: mediaItemID}
+
+	url := fmt.Sprintf(albumPatchURLFmt, album.ID)
+	if err := c.doJSON("PATCH", url, &req, nil); err != nil {
+		return fmt.Errorf("failed to set album cover photo: %w", err)
+	}
+
 	return nil
 }
 
+// truncate shortens a string to at most n characters
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+
+	return string(r[:n])
+}
+
 // UploadPhoto uploads a photo to an album synchronously.
 // If callback parameter is specified,
 // it will get called when data has been submitted.
 // Returns either an upload token or an error.
-func (c *Client) UploadPhoto(path string,
+func (c *apiClient) UploadPhoto(path string,
 	callback func(int64)) (string, error) {
 
 	req, err := util.NewImageUploadRequestFromFile(path, callback)
@@ -177,13 +450,14 @@ func (c *Client) UploadPhoto(path string,
 
 	res, err := c.httpClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("Failed to POST new image: %v", err)
+		return "", fmt.Errorf("Failed to POST new image: %w", err)
 	}
 
 	defer res.Body.Close()
 
 	if res.StatusCode < 200 || res.StatusCode >= 300 {
-		return "", fmt.Errorf("Photo upload failed: %v", res.Status)
+		return "", &apiError{status: res.StatusCode,
+			message: fmt.Sprintf("Photo upload failed: %v", res.Status)}
 	}
 
 	// In a success response, the response body should hold a single line of
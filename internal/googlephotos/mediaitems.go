@@ -0,0 +1,219 @@
+package googlephotos
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	// Media item search endpoint URL
+	mediaItemsSearchURL = "https://photoslibrary.googleapis.com/v1/mediaItems:search"
+
+	// Maximum page size of the media item search
+	mediaItemsPageSize = 100
+)
+
+// ContentCategories lists the content categories that can be searched for
+var ContentCategories = []string{"ANIMALS", "ARTS", "BIRTHDAYS", "CITYSCAPES",
+	"CRAFTS", "DOCUMENTS", "FASHION", "FLOWERS", "FOOD", "GARDENS", "HOLIDAYS",
+	"HOUSES", "LANDMARKS", "LANDSCAPES", "NIGHT", "PEOPLE", "PERFORMANCES", "PETS",
+	"RECEIPTS", "SCREENSHOTS", "SELFIES", "SPORT", "TRAVEL", "UTILITY", "WEDDINGS",
+	"WHITEBOARDS"}
+
+// SearchFilter defines the media items to search for. Note that the API does
+// not allow combining AlbumID with the other filters.
+type SearchFilter struct {
+	// List the items of this album
+	AlbumID string
+
+	// Date range (inclusive); either end may be left zero for an open range
+	StartDate time.Time
+	EndDate   time.Time
+
+	// Content categories to include, eg. LANDSCAPES, PETS, SELFIES
+	ContentCategories []string
+
+	// Media type: ALL_MEDIA, PHOTO or VIDEO; empty for all
+	MediaType string
+}
+
+// JSON types of the media item search API. These are used instead of the
+// photoslibrary types since those lack MediaItem.filename.
+type searchDate struct {
+	Year  int `json:"year"`
+	Month int `json:"month"`
+	Day   int `json:"day"`
+}
+
+type searchDateRange struct {
+	StartDate searchDate `json:"startDate"`
+	EndDate   searchDate `json:"endDate"`
+}
+
+type searchFilters struct {
+	DateFilter *struct {
+		Ranges []*searchDateRange `json:"ranges"`
+	} `json:"dateFilter,omitempty"`
+	ContentFilter *struct {
+		IncludedContentCategories []string `json:"includedContentCategories"`
+	} `json:"contentFilter,omitempty"`
+	MediaTypeFilter *struct {
+		MediaTypes []string `json:"mediaTypes"`
+	} `json:"mediaTypeFilter,omitempty"`
+}
+
+type searchRequest struct {
+	AlbumID   string         `json:"albumId,omitempty"`
+	PageSize  int            `json:"pageSize"`
+	PageToken string         `json:"pageToken,omitempty"`
+	Filters   *searchFilters `json:"filters,omitempty"`
+}
+
+type mediaItemJSON struct {
+	ID            string `json:"id"`
+	Description   string `json:"description"`
+	ProductURL    string `json:"productUrl"`
+	BaseURL       string `json:"baseUrl"`
+	MimeType      string `json:"mimeType"`
+	Filename      string `json:"filename"`
+	MediaMetadata struct {
+		CreationTime string    `json:"creationTime"`
+		Width        string    `json:"width"`
+		Height       string    `json:"height"`
+		Video        *struct{} `json:"video"`
+	} `json:"mediaMetadata"`
+}
+
+type searchResponse struct {
+	MediaItems    []*mediaItemJSON `json:"mediaItems"`
+	NextPageToken string           `json:"nextPageToken"`
+}
+
+// toSearchDate converts a time into an API date
+func toSearchDate(t time.Time) searchDate {
+	return searchDate{Year: t.Year(), Month: int(t.Month()), Day: t.Day()}
+}
+
+// toMediaItem converts an API media item into a MediaItem
+func (m *mediaItemJSON) toMediaItem() *MediaItem {
+	item := &MediaItem{
+		ID:          m.ID,
+		FileName:    m.Filename,
+		Description: m.Description,
+		MimeType:    m.MimeType,
+		ProductURL:  m.ProductURL,
+		BaseURL:     m.BaseURL,
+		IsVideo:     m.MediaMetadata.Video != nil,
+	}
+
+	if t, err := time.Parse(time.RFC3339, m.MediaMetadata.CreationTime); err == nil {
+		item.CreationTime = t
+	}
+	item.Width, _ = strconv.ParseInt(m.MediaMetadata.Width, 10, 64)
+	item.Height, _ = strconv.ParseInt(m.MediaMetadata.Height, 10, 64)
+
+	return item
+}
+
+// newSearchRequest forms the API request out of a search filter
+func newSearchRequest(f *SearchFilter) *searchRequest {
+	req := &searchRequest{AlbumID: f.AlbumID, PageSize: mediaItemsPageSize}
+	if f.AlbumID != "" {
+		return req
+	}
+
+	hasDates := !f.StartDate.IsZero() || !f.EndDate.IsZero()
+	if !hasDates && len(f.ContentCategories) == 0 && f.MediaType == "" {
+		return req
+	}
+
+	req.Filters = &searchFilters{}
+
+	if hasDates {
+		start, end := f.StartDate, f.EndDate
+		if start.IsZero() {
+			start = time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+		}
+		if end.IsZero() {
+			end = time.Now()
+		}
+
+		req.Filters.DateFilter = &struct {
+			Ranges []*searchDateRange `json:"ranges"`
+		}{Ranges: []*searchDateRange{
+			{StartDate: toSearchDate(start), EndDate: toSearchDate(end)},
+		}}
+	}
+
+	if len(f.ContentCategories) > 0 {
+		req.Filters.ContentFilter = &struct {
+			IncludedContentCategories []string `json:"includedContentCategories"`
+		}{IncludedContentCategories: f.ContentCategories}
+	}
+
+	if f.MediaType != "" {
+		req.Filters.MediaTypeFilter = &struct {
+			MediaTypes []string `json:"mediaTypes"`
+		}{MediaTypes: []string{f.MediaType}}
+	}
+
+	return req
+}
+
+// SearchMediaItems searches for media items, going through all the result pages.
+func (c *apiClient) SearchMediaItems(f *SearchFilter) ([]*MediaItem, error) {
+	req := newSearchRequest(f)
+	items := make([]*MediaItem, 0)
+
+	for {
+		var res searchResponse
+		if err := c.doJSON("POST", mediaItemsSearchURL, req, &res); err != nil {
+			return nil, fmt.Errorf("failed to search media items: %w", err)
+		}
+
+		for _, m := range res.MediaItems {
+			items = append(items, m.toMediaItem())
+		}
+
+		if res.NextPageToken == "" {
+			break
+		}
+		req.PageToken = res.NextPageToken
+	}
+
+	return items, nil
+}
+
+// ListAlbumItems lists all the media items of the album
+func (c *apiClient) ListAlbumItems(album *Album) ([]*MediaItem, error) {
+	return c.SearchMediaItems(&SearchFilter{AlbumID: album.ID})
+}
+
+// DownloadMediaItem writes the original bytes of the media item into w; using
+// the base URL parameter d for photos and dv for videos. The base URL expires
+// 60 minutes after the item was listed.
+func (c *apiClient) DownloadMediaItem(item *MediaItem, w io.Writer) (int64, error) {
+	url := item.BaseURL + "=d"
+	if item.IsVideo {
+		url = item.BaseURL + "=dv"
+	}
+
+	resp, err := c.httpClient.Get(url)
+	if err != nil {
+		return 0, fmt.Errorf("failed to download %v: %w", item.FileName, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return 0, fmt.Errorf("failed to download %v: %v", item.FileName, resp.Status)
+	}
+
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return n, fmt.Errorf("failed to download %v: %w", item.FileName, err)
+	}
+
+	return n, nil
+}
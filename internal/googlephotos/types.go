@@ -2,6 +2,8 @@
 // with Google Photos API
 package googlephotos
 
+import "time"
+
 // Feed type (eg. list of Albums)
 // type AlbumList struct {
 // 	Entries []Album
@@ -11,6 +13,92 @@ package googlephotos
 type Album struct {
 	ID    string
 	Title string
+
+	// Google Photos URL of the album
+	ProductURL string
+
+	// Whether media items can be added to the album; only albums created by
+	// this app are writeable
+	IsWriteable bool
+
+	// Sharing information; nil if the album is not shared
+	ShareInfo *ShareInfo
+
+	// Number of media items in the album
+	MediaItemsCount int64
+}
+
+// ShareInfo holds the sharing information of a shared album
+type ShareInfo struct {
+	// URL for sharing the album with others
+	ShareableURL string
+
+	// Token for joining the album
+	ShareToken string
+
+	// Whether others can add media items to the album
+	IsCollaborative bool
+
+	// Whether others can comment the album
+	IsCommentable bool
+}
+
+// NewMediaItem is a media item to be created out of an uploaded file
+type NewMediaItem struct {
+	// Upload token received from UploadPhoto()
+	UploadToken string
+
+	// File name shown to the user in Google Photos
+	FileName string
+
+	// Description of the item; at most MaxDescriptionLength characters
+	Description string
+}
+
+// MediaItem is a media item (photo or video) in Google Photos
+type MediaItem struct {
+	ID          string
+	FileName    string
+	Description string
+
+	MimeType string
+	IsVideo  bool
+
+	// Google Photos URL of the item
+	ProductURL string
+
+	// URL for accessing the item bytes; valid for 60 minutes after listing
+	BaseURL string
+
+	// Time when the photo or video was taken; zero if unknown
+	CreationTime time.Time
+
+	// Original dimensions of the item
+	Width  int64
+	Height int64
+
+	// Upload token the item was created out of; only set by AddToAlbum
+	UploadToken string
+}
+
+// Location is a geographic location with a name
+type Location struct {
+	Name      string
+	Latitude  float64
+	Longitude float64
+}
+
+// Enrichment is an album enrichment; exactly one of the fields should be set.
+type Enrichment struct {
+	// Text enrichment
+	Text string
+
+	// Location enrichment
+	Location *Location
+
+	// Map enrichment; both origin and destination must be set
+	MapOrigin      *Location
+	MapDestination *Location
 }
 
 // Count Returns the number of entries in the feed
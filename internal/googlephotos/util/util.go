@@ -13,8 +13,12 @@ import (
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	photoslibrary "google.golang.org/api/photoslibrary/v1"
+
+	"github.com/matti777/google-photos-uploader/internal/logging"
 )
 
+var log = logging.MustGetLogger()
+
 const (
 	// Google's user info endpoint URL
 	userInfoEndpointURLFmt = "https://www.googleapis.com/oauth2/v3/userinfo" +
@@ -66,6 +70,7 @@ func NewOAuth2Config(clientID, clientSecret string) oauth2.Config {
 	scopes := []string{
 		"https://www.googleapis.com/auth/userinfo.profile",
 		photoslibrary.PhotoslibraryScope,
+		photoslibrary.PhotoslibrarySharingScope,
 	}
 
 	return oauth2.Config{
@@ -96,7 +101,7 @@ func GetUserInfo(token *oauth2.Token) (*UserInfo, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to read response body")
 	}
-	fmt.Printf("Read userinfo contents: %v\n", string(contents))
+	log.Debugf("Read userinfo contents: %v", string(contents))
 
 	if res.StatusCode < 200 || res.StatusCode >= 300 {
 		return nil, errors.Errorf("failed to call GetUserInfo: %v", string(contents))
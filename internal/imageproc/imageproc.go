@@ -0,0 +1,335 @@
+// Package imageproc processes JPEG images before they are uploaded: scales
+// them down, re-encodes them, rotates them upright and strips private
+// metadata. The processing is done in pure Go.
+package imageproc
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"os"
+)
+
+// Quality of the re-encoded images if none is given
+const defaultQuality = 90
+
+// Options of the processing; the zero value leaves the images as they are.
+type Options struct {
+	// Maximum width and height of the images; larger images are scaled down,
+	// keeping the aspect ratio. 0 for no limit.
+	MaxDimension int
+
+	// JPEG quality (1-100) to re-encode the images with; 0 to re-encode only
+	// if the image is resized or rotated
+	Quality int
+
+	// Whether to rotate the images upright according to their EXIF orientation
+	AutoOrient bool
+
+	// Whether to remove the GPS position from the metadata
+	StripGPS bool
+
+	// Whether to remove the personal metadata; the owner and author names, the
+	// camera and lens serial numbers and the maker notes
+	StripPersonal bool
+}
+
+// Enabled tells whether the options change the images
+func (o Options) Enabled() bool {
+	return o.MaxDimension > 0 || o.Quality > 0 || o.AutoOrient || o.StripGPS ||
+		o.StripPersonal
+}
+
+// Validate checks the option values
+func (o Options) Validate() error {
+	if o.MaxDimension < 0 {
+		return fmt.Errorf("invalid maximum dimension: %v", o.MaxDimension)
+	}
+	if o.Quality < 0 || o.Quality > 100 {
+		return fmt.Errorf("invalid JPEG quality %v; must be 1-100", o.Quality)
+	}
+
+	return nil
+}
+
+// stripsMetadata tells whether the options remove any metadata
+func (o Options) stripsMetadata() bool {
+	return o.StripGPS || o.StripPersonal
+}
+
+// ProcessFile processes the JPEG image at inPath, writing the result into
+// outPath.
+func ProcessFile(inPath, outPath string, o Options) error {
+	data, err := os.ReadFile(inPath)
+	if err != nil {
+		return fmt.Errorf("failed to read image: %w", err)
+	}
+
+	res, err := Process(data, o)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(outPath, res, 0600); err != nil {
+		return fmt.Errorf("failed to write image: %w", err)
+	}
+
+	return nil
+}
+
+// Process processes a JPEG image. The image is re-encoded only if it is
+// resized or rotated, or a quality is given; otherwise only the metadata is
+// rewritten. The dates in the metadata are always kept.
+func Process(data []byte, o Options) ([]byte, error) {
+	segments, scan, err := parseSegments(data)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := readMetadata(segments)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image size: %w", err)
+	}
+
+	orientation := 1
+	if o.AutoOrient && meta != nil {
+		orientation = meta.orientation()
+	}
+	w, h := scaledSize(config.Width, config.Height, o.MaxDimension)
+	resized := w != config.Width || h != config.Height
+	reencode := resized || orientation != 1 || o.Quality > 0
+
+	if meta != nil && (reencode || o.stripsMetadata()) {
+		if o.StripGPS {
+			meta.stripGPS()
+		}
+		if o.StripPersonal {
+			meta.stripPersonal()
+		}
+		if orientation != 1 {
+			meta.setUpright()
+		}
+		if resized {
+			meta.removeDimensions()
+		}
+		if reencode {
+			// The thumbnail would show the original image
+			meta.removeThumbnail()
+		}
+
+		exifSegment, err := meta.encode()
+		if err != nil {
+			return nil, err
+		}
+		segments = replaceExif(segments, exifSegment)
+	}
+
+	if o.stripsMetadata() {
+		segments = removePrivateSegments(segments)
+	}
+
+	if !reencode {
+		return writeSegments(segments, scan), nil
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	rgba := orient(toRGBA(img), orientation)
+	if resized {
+		if orientation >= 5 {
+			w, h = h, w
+		}
+		rgba = resize(rgba, w, h)
+	}
+
+	quality := o.Quality
+	if quality == 0 {
+		quality = defaultQuality
+	}
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, rgba, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+
+	imageSegments, imageScan, err := parseSegments(encoded.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the metadata of the original, followed by the tables of the
+	// re-encoded image
+	return writeSegments(append(metadataSegments(segments), imageSegments...), imageScan), nil
+}
+
+// scaledSize returns the size of the image scaled down to fit maxDimension
+func scaledSize(w, h, maxDimension int) (int, int) {
+	if maxDimension <= 0 || (w <= maxDimension && h <= maxDimension) {
+		return w, h
+	}
+
+	if w >= h {
+		return maxDimension, max(1, (h*maxDimension+w/2)/w)
+	}
+
+	return max(1, (w*maxDimension+h/2)/h), maxDimension
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+// toRGBA converts the image into RGBA, with its origin at (0, 0)
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	res := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(res, res.Bounds(), img, b.Min, draw.Src)
+
+	return res
+}
+
+// orient transforms the image upright according to its EXIF orientation (1-8)
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		// Rotated by 90 degrees
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for dy := 0; dy < dh; dy++ {
+		for dx := 0; dx < dw; dx++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // Mirrored horizontally
+				sx, sy = w-1-dx, dy
+			case 3: // Rotated by 180 degrees
+				sx, sy = w-1-dx, h-1-dy
+			case 4: // Mirrored vertically
+				sx, sy = dx, h-1-dy
+			case 5: // Transposed
+				sx, sy = dy, dx
+			case 6: // Needs rotating 90 degrees clockwise
+				sx, sy = dy, h-1-dx
+			case 7: // Transversed
+				sx, sy = w-1-dy, h-1-dx
+			case 8: // Needs rotating 90 degrees counter-clockwise
+				sx, sy = w-1-dy, dx
+			}
+
+			d, s := dst.PixOffset(dx, dy), src.PixOffset(sx, sy)
+			copy(dst.Pix[d:d+4], src.Pix[s:s+4])
+		}
+	}
+
+	return dst
+}
+
+// weight is the share of a source pixel in a scaled pixel
+type weight struct {
+	index int
+	share float32
+}
+
+// boxWeights returns the source pixels covered by each pixel of an axis
+// scaled down from srcLen to dstLen pixels, weighted by their coverage
+func boxWeights(srcLen, dstLen int) [][]weight {
+	scale := float64(srcLen) / float64(dstLen)
+	res := make([][]weight, dstLen)
+
+	for i := range res {
+		start, end := float64(i)*scale, float64(i+1)*scale
+		for j := int(start); j < srcLen && float64(j) < end; j++ {
+			cover := minFloat(end, float64(j+1)) - maxFloat(start, float64(j))
+			if cover > 0 {
+				res[i] = append(res[i], weight{index: j, share: float32(cover / scale)})
+			}
+		}
+	}
+
+	return res
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+// resize scales the image down to w x h pixels, averaging the source pixels
+// covered by each pixel (a box filter). The image is scaled horizontally
+// first, then vertically.
+func resize(src *image.RGBA, w, h int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+
+	tmp := image.NewRGBA(image.Rect(0, 0, w, sh))
+	for x, weights := range boxWeights(sw, w) {
+		for y := 0; y < sh; y++ {
+			var sum [4]float32
+			for _, wt := range weights {
+				s := src.PixOffset(wt.index, y)
+				for c := 0; c < 4; c++ {
+					sum[c] += float32(src.Pix[s+c]) * wt.share
+				}
+			}
+			setPixel(tmp, x, y, sum)
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y, weights := range boxWeights(sh, h) {
+		for x := 0; x < w; x++ {
+			var sum [4]float32
+			for _, wt := range weights {
+				s := tmp.PixOffset(x, wt.index)
+				for c := 0; c < 4; c++ {
+					sum[c] += float32(tmp.Pix[s+c]) * wt.share
+				}
+			}
+			setPixel(dst, x, y, sum)
+		}
+	}
+
+	return dst
+}
+
+// setPixel sets a pixel from channel sums, rounding and clamping them
+func setPixel(img *image.RGBA, x, y int, sum [4]float32) {
+	d := img.PixOffset(x, y)
+	for c, v := range sum {
+		v += 0.5
+		if v > 255 {
+			v = 255
+		}
+		img.Pix[d+c] = uint8(v)
+	}
+}
@@ -0,0 +1,264 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	exif "github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
+)
+
+// testImage returns a JPEG image of the given size, red on the left half and
+// blue on the right, with EXIF data of the given orientation, a date, a GPS
+// position and a serial number.
+func testImage(t *testing.T, w, h int, orientation uint16) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	im, err := exifcommon.NewIfdMappingWithStandard()
+	if err != nil {
+		t.Fatalf("failed to create IFD mapping: %v", err)
+	}
+	ti := exif.NewTagIndex()
+
+	root := exif.NewIfdBuilder(im, ti, exifcommon.IfdStandardIfdIdentity, binary.BigEndian)
+	exifIb := exif.NewIfdBuilder(im, ti, exifcommon.IfdExifStandardIfdIdentity,
+		binary.BigEndian)
+	gpsIb := exif.NewIfdBuilder(im, ti, exifcommon.IfdGpsInfoStandardIfdIdentity,
+		binary.BigEndian)
+
+	for _, err := range []error{
+		root.AddStandardWithName("Orientation", []uint16{orientation}),
+		root.AddStandardWithName("Artist", "Jane Doe"),
+		exifIb.AddStandardWithName("DateTimeOriginal", "2019:06:14 10:11:12"),
+		exifIb.AddStandardWithName("BodySerialNumber", "123456"),
+		gpsIb.AddStandardWithName("GPSLatitudeRef", "N"),
+		gpsIb.AddStandardWithName("GPSLatitude", []exifcommon.Rational{
+			{Numerator: 60, Denominator: 1}, {Numerator: 10, Denominator: 1},
+			{Numerator: 0, Denominator: 1}}),
+		root.AddChildIb(exifIb),
+		root.AddChildIb(gpsIb),
+	} {
+		if err != nil {
+			t.Fatalf("failed to build EXIF data: %v", err)
+		}
+	}
+
+	data, err := exif.NewIfdByteEncoder().EncodeToExif(root)
+	if err != nil {
+		t.Fatalf("failed to encode EXIF data: %v", err)
+	}
+	s, err := newSegment(markerAPP1, append(append([]byte{}, exifPrefix...), data...))
+	if err != nil {
+		t.Fatalf("failed to create EXIF segment: %v", err)
+	}
+
+	segments, scan, err := parseSegments(encoded.Bytes())
+	if err != nil {
+		t.Fatalf("failed to parse image: %v", err)
+	}
+
+	return writeSegments(append([]segment{s}, segments...), scan)
+}
+
+// tags returns the EXIF tags of an image by their names
+func tags(t *testing.T, data []byte) map[string]interface{} {
+	rawExif, err := exif.SearchAndExtractExif(data)
+	if err != nil {
+		t.Fatalf("failed to find EXIF data: %v", err)
+	}
+
+	entries, _, err := exif.GetFlatExifData(rawExif, nil)
+	if err != nil {
+		t.Fatalf("failed to read EXIF data: %v", err)
+	}
+
+	res := map[string]interface{}{}
+	for _, e := range entries {
+		res[e.TagName] = e.Value
+	}
+
+	return res
+}
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name        string
+		w, h        int
+		orientation uint16
+		options     Options
+		expectW     int
+		expectH     int
+		stripped    []string
+		kept        []string
+	}{
+		{name: "metadata only", w: 40, h: 20, orientation: 6,
+			options: Options{StripGPS: true}, expectW: 40, expectH: 20,
+			stripped: []string{"GPSLatitude"},
+			kept:     []string{"DateTimeOriginal", "Artist", "BodySerialNumber", "Orientation"}},
+		{name: "personal", w: 40, h: 20, orientation: 1,
+			options: Options{StripPersonal: true}, expectW: 40, expectH: 20,
+			stripped: []string{"Artist", "BodySerialNumber"},
+			kept:     []string{"DateTimeOriginal", "GPSLatitude"}},
+		{name: "resize", w: 400, h: 200, orientation: 1,
+			options: Options{MaxDimension: 100}, expectW: 100, expectH: 50,
+			kept: []string{"DateTimeOriginal", "GPSLatitude", "Artist"}},
+		{name: "small enough", w: 40, h: 20, orientation: 1,
+			options: Options{MaxDimension: 100}, expectW: 40, expectH: 20},
+		{name: "orient and resize", w: 400, h: 200, orientation: 6,
+			options: Options{MaxDimension: 100, AutoOrient: true, StripGPS: true,
+				StripPersonal: true},
+			expectW: 50, expectH: 100,
+			stripped: []string{"GPSLatitude", "Artist", "BodySerialNumber"},
+			kept:     []string{"DateTimeOriginal"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := Process(testImage(t, test.w, test.h, test.orientation), test.options)
+			if err != nil {
+				t.Fatalf("failed to process: %v", err)
+			}
+
+			config, err := jpeg.DecodeConfig(bytes.NewReader(res))
+			if err != nil {
+				t.Fatalf("failed to decode result: %v", err)
+			}
+			if config.Width != test.expectW || config.Height != test.expectH {
+				t.Errorf("expected %vx%v, got %vx%v", test.expectW, test.expectH,
+					config.Width, config.Height)
+			}
+
+			tags := tags(t, res)
+			for _, name := range test.stripped {
+				if _, ok := tags[name]; ok {
+					t.Errorf("expected %v to be stripped", name)
+				}
+			}
+			for _, name := range test.kept {
+				if _, ok := tags[name]; !ok {
+					t.Errorf("expected %v to be kept", name)
+				}
+			}
+			if d := tags["DateTimeOriginal"]; d != "2019:06:14 10:11:12" {
+				t.Errorf("unexpected DateTimeOriginal %v", d)
+			}
+			if test.options.AutoOrient {
+				if o, ok := tags["Orientation"].([]uint16); !ok || o[0] != 1 {
+					t.Errorf("expected the orientation to be reset, got %v", tags["Orientation"])
+				}
+			}
+		})
+	}
+}
+
+func TestProcessOrientsPixels(t *testing.T) {
+	// Red on the left, blue on the right; rotated clockwise red is on top
+	res, err := Process(testImage(t, 40, 20, 6), Options{AutoOrient: true})
+	if err != nil {
+		t.Fatalf("failed to process: %v", err)
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(res))
+	if err != nil {
+		t.Fatalf("failed to decode result: %v", err)
+	}
+
+	top, bottom := img.At(10, 5), img.At(10, 35)
+	if r, _, b, _ := top.RGBA(); r < 0xc000 || b > 0x4000 {
+		t.Errorf("expected red on top, got %v", top)
+	}
+	if r, _, b, _ := bottom.RGBA(); b < 0xc000 || r > 0x4000 {
+		t.Errorf("expected blue at the bottom, got %v", bottom)
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// 3x2 image with the pixel values 0..5 row by row
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i := 0; i < 6; i++ {
+		src.Pix[i*4] = uint8(i)
+	}
+
+	tests := []struct {
+		orientation int
+		expected    [][]uint8
+	}{
+		{1, [][]uint8{{0, 1, 2}, {3, 4, 5}}},
+		{2, [][]uint8{{2, 1, 0}, {5, 4, 3}}},
+		{3, [][]uint8{{5, 4, 3}, {2, 1, 0}}},
+		{4, [][]uint8{{3, 4, 5}, {0, 1, 2}}},
+		{5, [][]uint8{{0, 3}, {1, 4}, {2, 5}}},
+		{6, [][]uint8{{3, 0}, {4, 1}, {5, 2}}},
+		{7, [][]uint8{{5, 2}, {4, 1}, {3, 0}}},
+		{8, [][]uint8{{2, 5}, {1, 4}, {0, 3}}},
+	}
+
+	for _, test := range tests {
+		dst := orient(src, test.orientation)
+		for y, row := range test.expected {
+			for x, v := range row {
+				if got := dst.Pix[dst.PixOffset(x, y)]; got != v {
+					t.Errorf("orientation %v: expected %v at (%v, %v), got %v",
+						test.orientation, v, x, y, got)
+				}
+			}
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	// Averages the pixels of each 2x2 block
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for i, v := range []uint8{0, 100, 200, 200, 100, 200, 200, 200} {
+		src.Pix[i*4] = v
+	}
+
+	dst := resize(src, 2, 1)
+	if dst.Pix[0] != 100 || dst.Pix[4] != 200 {
+		t.Errorf("unexpected pixels %v, %v", dst.Pix[0], dst.Pix[4])
+	}
+}
+
+func TestScaledSize(t *testing.T) {
+	tests := []struct {
+		w, h, max, expectW, expectH int
+	}{
+		{4000, 3000, 2048, 2048, 1536},
+		{3000, 4000, 2048, 1536, 2048},
+		{1000, 800, 2048, 1000, 800},
+		{1000, 800, 0, 1000, 800},
+		{5000, 1, 100, 100, 1},
+	}
+
+	for _, test := range tests {
+		w, h := scaledSize(test.w, test.h, test.max)
+		if w != test.expectW || h != test.expectH {
+			t.Errorf("%vx%v max %v: expected %vx%v, got %vx%v", test.w, test.h, test.max,
+				test.expectW, test.expectH, w, h)
+		}
+	}
+}
+
+func TestNotJPEG(t *testing.T) {
+	if _, err := Process([]byte("GIF89a"), Options{StripGPS: true}); err != errNotJPEG {
+		t.Errorf("expected errNotJPEG, got %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package imageproc
+
+import (
+	"fmt"
+
+	exif "github.com/dsoprea/go-exif/v3"
+	exifcommon "github.com/dsoprea/go-exif/v3/common"
+)
+
+// EXIF tag IDs
+const (
+	tagImageWidth       = 0x0100
+	tagImageLength      = 0x0101
+	tagOrientation      = 0x0112
+	tagArtist           = 0x013b
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagXPAuthor         = 0x9c9d
+	tagMakerNote        = 0x927c
+	tagPixelXDimension  = 0xa002
+	tagPixelYDimension  = 0xa003
+	tagImageUniqueID    = 0xa420
+	tagCameraOwnerName  = 0xa430
+	tagBodySerialNumber = 0xa431
+	tagLensSerialNumber = 0xa435
+)
+
+var (
+	// Personal tags of IFD0
+	personalTags = []uint16{tagArtist, tagXPAuthor}
+
+	// Personal tags of the EXIF IFD; the maker notes may contain eg. the
+	// serial numbers
+	personalExifTags = []uint16{tagMakerNote, tagImageUniqueID, tagCameraOwnerName,
+		tagBodySerialNumber, tagLensSerialNumber}
+)
+
+// metadata is the EXIF data of an image being rewritten
+type metadata struct {
+	index exif.IfdIndex
+	root  *exif.IfdBuilder
+
+	// First error of the changes; returned by encode
+	err error
+}
+
+// readMetadata reads the EXIF data of an image; nil if it has none
+func readMetadata(segments []segment) (m *metadata, err error) {
+	s := findExif(segments)
+	if s == nil {
+		return nil, nil
+	}
+
+	im, err := exifcommon.NewIfdMappingWithStandard()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IFD mapping: %w", err)
+	}
+
+	_, index, err := exif.Collect(im, exif.NewTagIndex(), s.payload()[len(exifPrefix):])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse EXIF data: %w", err)
+	}
+
+	// The builder panics on invalid data
+	defer func() {
+		if r := recover(); r != nil {
+			m, err = nil, fmt.Errorf("failed to read EXIF data: %v", r)
+		}
+	}()
+
+	return &metadata{index: index, root: exif.NewIfdBuilderFromExistingChain(index.RootIfd)}, nil
+}
+
+// orientation returns the EXIF orientation (1-8) of the image
+func (m *metadata) orientation() int {
+	entries, err := m.index.RootIfd.FindTagWithId(tagOrientation)
+	if err != nil || len(entries) == 0 {
+		return 1
+	}
+
+	value, err := entries[0].Value()
+	if err != nil {
+		return 1
+	}
+
+	if v, ok := value.([]uint16); ok && len(v) > 0 && v[0] >= 1 && v[0] <= 8 {
+		return int(v[0])
+	}
+
+	return 1
+}
+
+// delete removes the tags from the IFD, if present
+func (m *metadata) delete(ib *exif.IfdBuilder, tagIDs ...uint16) {
+	for _, id := range tagIDs {
+		if _, err := ib.DeleteAll(id); err != nil && m.err == nil {
+			m.err = fmt.Errorf("failed to remove EXIF tag 0x%04x: %w", id, err)
+		}
+	}
+}
+
+// exifIFD returns the EXIF IFD; nil if there is none
+func (m *metadata) exifIFD() *exif.IfdBuilder {
+	ib, err := m.root.ChildWithTagId(tagExifIFD)
+	if err != nil {
+		return nil
+	}
+
+	return ib
+}
+
+// stripGPS removes the GPS IFD
+func (m *metadata) stripGPS() {
+	m.delete(m.root, tagGPSIFD)
+}
+
+// stripPersonal removes the names of the owner and the author, the serial
+// numbers and the maker notes
+func (m *metadata) stripPersonal() {
+	m.delete(m.root, personalTags...)
+	if ib := m.exifIFD(); ib != nil {
+		m.delete(ib, personalExifTags...)
+	}
+}
+
+// setUpright sets the orientation of the rotated image
+func (m *metadata) setUpright() {
+	if err := m.root.SetStandard(tagOrientation, []uint16{1}); err != nil && m.err == nil {
+		m.err = fmt.Errorf("failed to set the EXIF orientation: %w", err)
+	}
+}
+
+// removeDimensions removes the image size tags of the resized image
+func (m *metadata) removeDimensions() {
+	m.delete(m.root, tagImageWidth, tagImageLength)
+	if ib := m.exifIFD(); ib != nil {
+		m.delete(ib, tagPixelXDimension, tagPixelYDimension)
+	}
+}
+
+// removeThumbnail removes IFD1, which holds the thumbnail
+func (m *metadata) removeThumbnail() {
+	if err := m.root.SetNextIb(nil); err != nil && m.err == nil {
+		m.err = fmt.Errorf("failed to remove the EXIF thumbnail: %w", err)
+	}
+}
+
+// encode returns the EXIF segment of the metadata
+func (m *metadata) encode() (segment, error) {
+	if m.err != nil {
+		return segment{}, m.err
+	}
+
+	data, err := exif.NewIfdByteEncoder().EncodeToExif(m.root)
+	if err != nil {
+		return segment{}, fmt.Errorf("failed to encode EXIF data: %w", err)
+	}
+
+	return newSegment(markerAPP1, append(append([]byte{}, exifPrefix...), data...))
+}
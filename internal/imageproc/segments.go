@@ -0,0 +1,161 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// JPEG markers
+const (
+	markerSOI   = 0xd8
+	markerSOS   = 0xda
+	markerAPP1  = 0xe1
+	markerAPP2  = 0xe2
+	markerAPP13 = 0xed
+	markerCOM   = 0xfe
+)
+
+var (
+	errNotJPEG = errors.New("not a JPEG image")
+
+	exifPrefix        = []byte("Exif\x00\x00")
+	xmpPrefix         = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	xmpExtendedPrefix = []byte("http://ns.adobe.com/xmp/extension/\x00")
+)
+
+// segment is a JPEG segment preceding the image data
+type segment struct {
+	marker byte
+
+	// The whole segment, including the marker and the length
+	data []byte
+}
+
+// payload returns the contents of the segment
+func (s segment) payload() []byte {
+	return s.data[4:]
+}
+
+// isExif tells whether the segment holds the EXIF data
+func (s segment) isExif() bool {
+	return s.marker == markerAPP1 && bytes.HasPrefix(s.payload(), exifPrefix)
+}
+
+// isXMP tells whether the segment holds XMP metadata
+func (s segment) isXMP() bool {
+	return s.marker == markerAPP1 && (bytes.HasPrefix(s.payload(), xmpPrefix) ||
+		bytes.HasPrefix(s.payload(), xmpExtendedPrefix))
+}
+
+// newSegment creates a segment with the given payload
+func newSegment(marker byte, payload []byte) (segment, error) {
+	if len(payload)+2 > 0xffff {
+		return segment{}, fmt.Errorf("JPEG segment too large: %v bytes", len(payload))
+	}
+
+	data := make([]byte, 4, len(payload)+4)
+	data[0], data[1] = 0xff, marker
+	binary.BigEndian.PutUint16(data[2:], uint16(len(payload)+2))
+
+	return segment{marker: marker, data: append(data, payload...)}, nil
+}
+
+// parseSegments splits a JPEG image into the segments preceding the image
+// data and the image data (starting with the SOS segment).
+func parseSegments(data []byte) ([]segment, []byte, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != markerSOI {
+		return nil, nil, errNotJPEG
+	}
+
+	segments := []segment{}
+	for i := 2; ; {
+		if i+4 > len(data) || data[i] != 0xff {
+			return nil, nil, fmt.Errorf("invalid JPEG segment at offset %v", i)
+		}
+
+		marker := data[i+1]
+		if marker == 0xff {
+			// Fill byte
+			i++
+			continue
+		}
+		if marker == markerSOS {
+			return segments, data[i:], nil
+		}
+
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) || end < i+4 {
+			return nil, nil, fmt.Errorf("truncated JPEG segment at offset %v", i)
+		}
+
+		segments = append(segments, segment{marker: marker, data: data[i:end]})
+		i = end
+	}
+}
+
+// writeSegments assembles a JPEG image out of its segments and image data
+func writeSegments(segments []segment, scan []byte) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{0xff, markerSOI})
+	for _, s := range segments {
+		b.Write(s.data)
+	}
+	b.Write(scan)
+
+	return b.Bytes()
+}
+
+// findExif returns the EXIF segment, if any
+func findExif(segments []segment) *segment {
+	for i := range segments {
+		if segments[i].isExif() {
+			return &segments[i]
+		}
+	}
+
+	return nil
+}
+
+// replaceExif replaces the EXIF segment
+func replaceExif(segments []segment, exif segment) []segment {
+	res := make([]segment, 0, len(segments))
+	for _, s := range segments {
+		if s.isExif() {
+			s = exif
+		}
+		res = append(res, s)
+	}
+
+	return res
+}
+
+// removePrivateSegments removes the metadata segments that may contain
+// private data but are not rewritten; the XMP and the IPTC (Photoshop) data
+// may include eg. the location and the author.
+func removePrivateSegments(segments []segment) []segment {
+	res := make([]segment, 0, len(segments))
+	for _, s := range segments {
+		if s.isXMP() || s.marker == markerAPP13 {
+			continue
+		}
+		res = append(res, s)
+	}
+
+	return res
+}
+
+// metadataSegments returns the segments to carry over into a re-encoded
+// image; the EXIF, XMP, ICC profile, IPTC and comment segments.
+func metadataSegments(segments []segment) []segment {
+	res := []segment{}
+	for _, s := range segments {
+		switch s.marker {
+		case markerAPP1, markerAPP2, markerAPP13, markerCOM:
+			res = append(res, s)
+		}
+	}
+
+	return res
+}
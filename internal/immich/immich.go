@@ -0,0 +1,505 @@
+// Package immich implements an upload destination on an Immich server using
+// its REST API. Uploaded files become assets, which are added to Immich albums.
+package immich
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	photos "github.com/matti777/google-photos-uploader/internal/googlephotos"
+	"github.com/matti777/google-photos-uploader/internal/logging"
+)
+
+const (
+	// Device ID reported for the uploaded assets
+	deviceID = "photos-uploader"
+
+	// Page size of the metadata search
+	searchPageSize = 250
+)
+
+// Config holds the connection parameters of the Immich server
+type Config struct {
+	// Server URL, eg. http://immich.local:2283
+	URL string
+
+	APIKey string
+}
+
+// Client is the Immich API client; implements photos.Client.
+type Client struct {
+	config     Config
+	server     string
+	httpClient *http.Client
+}
+
+var _ photos.Client = (*Client)(nil)
+
+var log = logging.MustGetLogger()
+
+// album is an album in the API
+type album struct {
+	ID                    string   `json:"id"`
+	AlbumName             string   `json:"albumName"`
+	Description           string   `json:"description"`
+	AssetCount            int64    `json:"assetCount"`
+	AlbumThumbnailAssetID string   `json:"albumThumbnailAssetId,omitempty"`
+	Shared                bool     `json:"shared"`
+	Assets                []*asset `json:"assets"`
+}
+
+// asset is an asset in the API
+type asset struct {
+	ID               string    `json:"id"`
+	Type             string    `json:"type"`
+	OriginalFileName string    `json:"originalFileName"`
+	OriginalMimeType string    `json:"originalMimeType"`
+	FileCreatedAt    time.Time `json:"fileCreatedAt"`
+	ExifInfo         *struct {
+		Description     string `json:"description"`
+		ExifImageWidth  int64  `json:"exifImageWidth"`
+		ExifImageHeight int64  `json:"exifImageHeight"`
+	} `json:"exifInfo"`
+}
+
+// New creates an Immich client
+func New(config Config) (*Client, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid Immich URL '%v'", config.URL)
+	}
+	if config.APIKey == "" {
+		return nil, fmt.Errorf("Immich API key must be defined")
+	}
+
+	return &Client{config: config, server: strings.TrimSuffix(u.String(), "/"),
+		httpClient: http.DefaultClient}, nil
+}
+
+// apiError is an error response of the API
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+// StatusCode returns the HTTP status code of the response
+func (e *apiError) StatusCode() int {
+	return e.status
+}
+
+// send sends an authenticated request to the API path and decodes the JSON
+// response into res, if not nil.
+func (c *Client) send(method, apiPath, contentType string, body io.Reader, res any) error {
+	req, err := http.NewRequest(method, c.server+"/api"+apiPath, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("x-api-key", c.config.APIKey)
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call %v %v: %w", method, apiPath, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		contents, _ := io.ReadAll(resp.Body)
+		return &apiError{status: resp.StatusCode, message: fmt.Sprintf("%v %v failed: %v: %v",
+			method, apiPath, resp.Status, strings.TrimSpace(string(contents)))}
+	}
+
+	if res == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return fmt.Errorf("failed to decode %v response: %w", apiPath, err)
+	}
+
+	return nil
+}
+
+// doJSON calls the API with a JSON body (if not nil)
+func (c *Client) doJSON(method, apiPath string, body, res any) error {
+	if body == nil {
+		return c.send(method, apiPath, "", nil, res)
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	return c.send(method, apiPath, "application/json", bytes.NewReader(data), res)
+}
+
+// toAlbum converts an API album into an Album
+func (c *Client) toAlbum(a *album) *photos.Album {
+	return &photos.Album{ID: a.ID, Title: a.AlbumName,
+		ProductURL: c.server + "/albums/" + a.ID, IsWriteable: true,
+		MediaItemsCount: a.AssetCount}
+}
+
+// toMediaItem converts an API asset into a MediaItem
+func (c *Client) toMediaItem(a *asset) *photos.MediaItem {
+	m := &photos.MediaItem{ID: a.ID, FileName: a.OriginalFileName,
+		MimeType: a.OriginalMimeType, IsVideo: a.Type == "VIDEO",
+		ProductURL: c.server + "/photos/" + a.ID, CreationTime: a.FileCreatedAt}
+	if a.ExifInfo != nil {
+		m.Description = a.ExifInfo.Description
+		m.Width = a.ExifInfo.ExifImageWidth
+		m.Height = a.ExifInfo.ExifImageHeight
+	}
+
+	return m
+}
+
+// listAlbums lists the albums; shared or not
+func (c *Client) listAlbums(shared bool) ([]*photos.Album, error) {
+	var res []*album
+	if err := c.doJSON("GET", fmt.Sprintf("/albums?shared=%v", shared), nil, &res); err != nil {
+		return nil, fmt.Errorf("failed to list albums: %w", err)
+	}
+
+	albums := make([]*photos.Album, 0, len(res))
+	for _, a := range res {
+		albums = append(albums, c.toAlbum(a))
+	}
+
+	return albums, nil
+}
+
+// ListAlbums lists the albums of the user
+func (c *Client) ListAlbums() ([]*photos.Album, error) {
+	return c.listAlbums(false)
+}
+
+// ListSharedAlbums lists the shared albums of the user
+func (c *Client) ListSharedAlbums() ([]*photos.Album, error) {
+	return c.listAlbums(true)
+}
+
+// CreateAlbum creates a new album
+func (c *Client) CreateAlbum(name string) (*photos.Album, error) {
+	var res album
+	if err := c.doJSON("POST", "/albums", map[string]string{"albumName": name}, &res); err != nil {
+		return nil, fmt.Errorf("failed to create album: %w", err)
+	}
+
+	return c.toAlbum(&res), nil
+}
+
+// ShareAlbum creates a shared link to the album. Collaborative albums allow
+// uploads through the link; comments are not supported by shared links.
+func (c *Client) ShareAlbum(album *photos.Album, collaborative,
+	commentable bool) (*photos.ShareInfo, error) {
+
+	req := map[string]any{"type": "ALBUM", "albumId": album.ID,
+		"allowUpload": collaborative, "showMetadata": true}
+	var res struct {
+		Key string `json:"key"`
+	}
+	if err := c.doJSON("POST", "/shared-links", req, &res); err != nil {
+		return nil, fmt.Errorf("failed to share album: %w", err)
+	}
+
+	return &photos.ShareInfo{ShareableURL: c.server + "/share/" + res.Key,
+		ShareToken: res.Key, IsCollaborative: collaborative}, nil
+}
+
+// progressReader reports the number of bytes read to a callback
+type progressReader struct {
+	r        io.Reader
+	count    int64
+	callback func(int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.count += int64(n)
+	if r.callback != nil {
+		r.callback(r.count)
+	}
+
+	return n, err
+}
+
+// writeUploadForm writes the multipart asset upload form of the file
+func writeUploadForm(mw *multipart.Writer, f *os.File, info os.FileInfo,
+	callback func(int64)) error {
+
+	modified := info.ModTime().UTC().Format(time.RFC3339)
+	fields := [][2]string{
+		{"deviceAssetId", fmt.Sprintf("%v-%v", info.Name(), info.Size())},
+		{"deviceId", deviceID},
+		{"fileCreatedAt", modified},
+		{"fileModifiedAt", modified},
+	}
+	for _, field := range fields {
+		if err := mw.WriteField(field[0], field[1]); err != nil {
+			return err
+		}
+	}
+
+	part, err := mw.CreateFormFile("assetData", info.Name())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, &progressReader{r: f, callback: callback}); err != nil {
+		return err
+	}
+
+	return mw.Close()
+}
+
+// UploadPhoto uploads the file as an asset; the asset ID is used as the
+// upload token. An asset already on the server (by checksum) is reused.
+func (c *Client) UploadPhoto(filePath string, callback func(int64)) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeUploadForm(mw, f, info, callback))
+	}()
+
+	var res struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+	err = c.send("POST", "/assets", mw.FormDataContentType(), pr, &res)
+	pr.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to upload %v: %w", filepath.Base(filePath), err)
+	}
+
+	return res.ID, nil
+}
+
+// getAsset fetches an asset
+func (c *Client) getAsset(id string) (*asset, error) {
+	var res asset
+	if err := c.doJSON("GET", "/assets/"+id, nil, &res); err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// AddToAlbum sets the descriptions of the uploaded assets and adds them into
+// the album (if not nil).
+func (c *Client) AddToAlbum(album *photos.Album,
+	items []*photos.NewMediaItem) ([]*photos.MediaItem, error) {
+
+	failed := map[string]bool{}
+
+	if album != nil {
+		ids := make([]string, 0, len(items))
+		fileNames := map[string]string{}
+		for _, item := range items {
+			ids = append(ids, item.UploadToken)
+			fileNames[item.UploadToken] = item.FileName
+		}
+
+		var res []struct {
+			ID      string `json:"id"`
+			Success bool   `json:"success"`
+			Error   string `json:"error"`
+		}
+		if err := c.doJSON("PUT", "/albums/"+album.ID+"/assets",
+			map[string]any{"ids": ids}, &res); err != nil {
+			return nil, fmt.Errorf("failed to add assets to album: %w", err)
+		}
+
+		for _, r := range res {
+			// An asset already in the album is not an error
+			if !r.Success && r.Error != "duplicate" {
+				log.WithField(logging.FieldFile, fileNames[r.ID]).
+					Warnf("Failed to add a photo to the album: %v", r.Error)
+				failed[r.ID] = true
+			}
+		}
+	}
+
+	created := make([]*photos.MediaItem, 0, len(items))
+
+	for _, item := range items {
+		if failed[item.UploadToken] {
+			continue
+		}
+
+		if item.Description != "" {
+			if err := c.doJSON("PUT", "/assets/"+item.UploadToken,
+				map[string]string{"description": item.Description}, nil); err != nil {
+				log.WithFields(logrus.Fields{logging.FieldFile: item.FileName,
+					logging.FieldErrorClass: photos.ErrorClass(err)}).WithError(err).
+					Warn("Failed to set the description")
+			}
+		}
+
+		a, err := c.getAsset(item.UploadToken)
+		if err != nil {
+			log.WithFields(logrus.Fields{logging.FieldFile: item.FileName,
+				logging.FieldErrorClass: photos.ErrorClass(err)}).WithError(err).
+				Warn("Failed to add a photo to the album")
+			continue
+		}
+
+		m := c.toMediaItem(a)
+		m.Description = item.Description
+		m.UploadToken = item.UploadToken
+		created = append(created, m)
+	}
+
+	if len(created) == 0 {
+		return nil, fmt.Errorf("Failed to add all of the photos to album")
+	}
+
+	return created, nil
+}
+
+// AddEnrichment adds a text enrichment into the album description; location
+// and map enrichments are not supported.
+func (c *Client) AddEnrichment(album *photos.Album, e *photos.Enrichment, first bool) error {
+	if e.Text == "" {
+		return photos.ErrNotSupported
+	}
+
+	var a struct {
+		Description string `json:"description"`
+	}
+	if err := c.doJSON("GET", "/albums/"+album.ID+"?withoutAssets=true", nil, &a); err != nil {
+		return fmt.Errorf("failed to get album: %w", err)
+	}
+
+	description := e.Text
+	if a.Description != "" && first {
+		description = e.Text + "\n\n" + a.Description
+	} else if a.Description != "" {
+		description = a.Description + "\n\n" + e.Text
+	}
+
+	return c.doJSON("PATCH", "/albums/"+album.ID,
+		map[string]string{"description": description}, nil)
+}
+
+// SetAlbumCover sets the thumbnail asset of the album
+func (c *Client) SetAlbumCover(album *photos.Album, mediaItemID string) error {
+	return c.doJSON("PATCH", "/albums/"+album.ID,
+		map[string]string{"albumThumbnailAssetId": mediaItemID}, nil)
+}
+
+// SearchMediaItems searches for assets by their date and type, or lists the
+// assets of an album. Content categories are not supported.
+func (c *Client) SearchMediaItems(f *photos.SearchFilter) ([]*photos.MediaItem, error) {
+	if len(f.ContentCategories) > 0 {
+		return nil, fmt.Errorf("content categories are %w", photos.ErrNotSupported)
+	}
+
+	if f.AlbumID != "" {
+		var a album
+		if err := c.doJSON("GET", "/albums/"+f.AlbumID, nil, &a); err != nil {
+			return nil, fmt.Errorf("failed to get album: %w", err)
+		}
+
+		items := make([]*photos.MediaItem, 0, len(a.Assets))
+		for _, as := range a.Assets {
+			items = append(items, c.toMediaItem(as))
+		}
+		return items, nil
+	}
+
+	req := map[string]any{"size": searchPageSize}
+	if !f.StartDate.IsZero() {
+		req["takenAfter"] = f.StartDate.Format(time.RFC3339)
+	}
+	if !f.EndDate.IsZero() {
+		req["takenBefore"] = f.EndDate.AddDate(0, 0, 1).Format(time.RFC3339)
+	}
+	switch f.MediaType {
+	case "PHOTO":
+		req["type"] = "IMAGE"
+	case "VIDEO":
+		req["type"] = "VIDEO"
+	}
+
+	items := []*photos.MediaItem{}
+
+	for page := 1; ; page++ {
+		req["page"] = page
+
+		var res struct {
+			Assets struct {
+				Items    []*asset `json:"items"`
+				NextPage *string  `json:"nextPage"`
+			} `json:"assets"`
+		}
+		if err := c.doJSON("POST", "/search/metadata", req, &res); err != nil {
+			return nil, fmt.Errorf("failed to search assets: %w", err)
+		}
+
+		for _, a := range res.Assets.Items {
+			items = append(items, c.toMediaItem(a))
+		}
+
+		if res.Assets.NextPage == nil || *res.Assets.NextPage == "" {
+			break
+		}
+	}
+
+	return items, nil
+}
+
+// ListAlbumItems lists the assets of the album
+func (c *Client) ListAlbumItems(album *photos.Album) ([]*photos.MediaItem, error) {
+	return c.SearchMediaItems(&photos.SearchFilter{AlbumID: album.ID})
+}
+
+// DownloadMediaItem writes the original file of the asset into w
+func (c *Client) DownloadMediaItem(item *photos.MediaItem, w io.Writer) (int64, error) {
+	req, err := http.NewRequest("GET", c.server+"/api/assets/"+item.ID+"/original", nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("x-api-key", c.config.APIKey)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to download %v: %w", item.FileName, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, &apiError{status: resp.StatusCode,
+			message: fmt.Sprintf("download of %v failed: %v", item.FileName, resp.Status)}
+	}
+
+	return io.Copy(w, resp.Body)
+}
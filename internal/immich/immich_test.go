@@ -0,0 +1,279 @@
+package immich
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	photos "github.com/matti777/google-photos-uploader/internal/googlephotos"
+)
+
+// fakeServer is a minimal in-memory Immich API server
+type fakeServer struct {
+	albums   []*album
+	assets   map[string]*asset
+	contents map[string][]byte
+	links    int
+	lock     sync.Mutex
+}
+
+func (s *fakeServer) findAlbum(id string) *album {
+	for _, a := range s.albums {
+		if a.ID == id {
+			return a
+		}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *fakeServer) router() http.Handler {
+	r := mux.NewRouter()
+	api := r.PathPrefix("/api").Subrouter()
+
+	api.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("x-api-key") != "secret" {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	api.HandleFunc("/albums", func(w http.ResponseWriter, r *http.Request) {
+		res := []*album{}
+		for _, a := range s.albums {
+			if a.Shared == (r.URL.Query().Get("shared") == "true") {
+				res = append(res, &album{ID: a.ID, AlbumName: a.AlbumName,
+					AssetCount: int64(len(a.Assets)), Shared: a.Shared})
+			}
+		}
+		writeJSON(w, res)
+	}).Methods("GET")
+
+	api.HandleFunc("/albums", func(w http.ResponseWriter, r *http.Request) {
+		a := &album{}
+		json.NewDecoder(r.Body).Decode(a)
+		a.ID = fmt.Sprintf("album-%v", len(s.albums)+1)
+		s.albums = append(s.albums, a)
+		writeJSON(w, a)
+	}).Methods("POST")
+
+	api.HandleFunc("/albums/{id}", func(w http.ResponseWriter, r *http.Request) {
+		a := s.findAlbum(mux.Vars(r)["id"])
+		if a == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == "PATCH" {
+			json.NewDecoder(r.Body).Decode(a)
+		}
+		writeJSON(w, a)
+	}).Methods("GET", "PATCH")
+
+	api.HandleFunc("/albums/{id}/assets", func(w http.ResponseWriter, r *http.Request) {
+		a := s.findAlbum(mux.Vars(r)["id"])
+		var req struct {
+			IDs []string `json:"ids"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		res := []map[string]any{}
+		for _, id := range req.IDs {
+			if s.assets[id] == nil {
+				res = append(res, map[string]any{"id": id, "success": false, "error": "not_found"})
+				continue
+			}
+			a.Assets = append(a.Assets, s.assets[id])
+			res = append(res, map[string]any{"id": id, "success": true})
+		}
+		writeJSON(w, res)
+	}).Methods("PUT")
+
+	api.HandleFunc("/assets", func(w http.ResponseWriter, r *http.Request) {
+		f, header, err := r.FormFile("assetData")
+		if err != nil || r.FormValue("deviceId") != deviceID {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(f)
+		created, _ := time.Parse(time.RFC3339, r.FormValue("fileCreatedAt"))
+
+		id := fmt.Sprintf("asset-%v", len(s.assets)+1)
+		s.assets[id] = &asset{ID: id, Type: "IMAGE", OriginalFileName: header.Filename,
+			OriginalMimeType: "image/jpeg", FileCreatedAt: created}
+		s.contents[id] = data
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, map[string]string{"id": id, "status": "created"})
+	}).Methods("POST")
+
+	api.HandleFunc("/assets/{id}", func(w http.ResponseWriter, r *http.Request) {
+		a := s.assets[mux.Vars(r)["id"]]
+		if a == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == "PUT" {
+			var req struct {
+				Description string `json:"description"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			a.ExifInfo = &struct {
+				Description     string `json:"description"`
+				ExifImageWidth  int64  `json:"exifImageWidth"`
+				ExifImageHeight int64  `json:"exifImageHeight"`
+			}{Description: req.Description}
+		}
+		writeJSON(w, a)
+	}).Methods("GET", "PUT")
+
+	api.HandleFunc("/assets/{id}/original", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(s.contents[mux.Vars(r)["id"]])
+	}).Methods("GET")
+
+	api.HandleFunc("/shared-links", func(w http.ResponseWriter, r *http.Request) {
+		s.links++
+		writeJSON(w, map[string]string{"key": fmt.Sprintf("key%v", s.links)})
+	}).Methods("POST")
+
+	api.HandleFunc("/search/metadata", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Page int `json:"page"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		// One asset per page
+		var res struct {
+			Assets struct {
+				Items    []*asset `json:"items"`
+				NextPage *string  `json:"nextPage"`
+			} `json:"assets"`
+		}
+		res.Assets.Items = []*asset{}
+		if a := s.assets[fmt.Sprintf("asset-%v", req.Page)]; a != nil {
+			res.Assets.Items = append(res.Assets.Items, a)
+			next := fmt.Sprint(req.Page + 1)
+			res.Assets.NextPage = &next
+		}
+		writeJSON(w, res)
+	}).Methods("POST")
+
+	return r
+}
+
+func TestClient(t *testing.T) {
+	s := &fakeServer{assets: map[string]*asset{}, contents: map[string][]byte{}}
+	server := httptest.NewServer(s.router())
+	defer server.Close()
+
+	if _, err := New(Config{URL: server.URL}); err == nil {
+		t.Errorf("expected an error without an API key")
+	}
+
+	c, err := New(Config{URL: server.URL + "/", APIKey: "secret"})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	album, err := c.CreateAlbum("Trip To Japan")
+	if err != nil || album.ID != "album-1" || album.Title != "Trip To Japan" {
+		t.Fatalf("unexpected album %+v: %v", album, err)
+	}
+
+	srcDir := t.TempDir()
+	tokens := []string{}
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		p := filepath.Join(srcDir, name)
+		if err := os.WriteFile(p, []byte("contents of "+name), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+
+		sent := int64(0)
+		token, err := c.UploadPhoto(p, func(n int64) { sent = n })
+		if err != nil {
+			t.Fatalf("failed to upload: %v", err)
+		}
+		if sent != int64(len("contents of "+name)) {
+			t.Errorf("unexpected progress %v", sent)
+		}
+		tokens = append(tokens, token)
+	}
+
+	items, err := c.AddToAlbum(album, []*photos.NewMediaItem{
+		{UploadToken: tokens[0], FileName: "a.jpg", Description: "First"},
+		{UploadToken: tokens[1], FileName: "b.jpg"},
+		{UploadToken: "unknown", FileName: "c.jpg"},
+	})
+	if err != nil || len(items) != 2 {
+		t.Fatalf("expected 2 items to be added, got %v: %v", len(items), err)
+	}
+	if items[0].ID != tokens[0] || items[0].FileName != "a.jpg" ||
+		items[0].Description != "First" || items[0].UploadToken != tokens[0] {
+		t.Errorf("unexpected media item: %+v", items[0])
+	}
+	if s.assets[tokens[0]].ExifInfo == nil || s.assets[tokens[0]].ExifInfo.Description != "First" {
+		t.Errorf("expected the description to be set")
+	}
+
+	if err := c.AddEnrichment(album, &photos.Enrichment{Text: "Second"}, false); err != nil {
+		t.Errorf("failed to add enrichment: %v", err)
+	}
+	if err := c.AddEnrichment(album, &photos.Enrichment{Text: "First"}, true); err != nil {
+		t.Errorf("failed to add enrichment: %v", err)
+	}
+	if s.albums[0].Description != "First\n\nSecond" {
+		t.Errorf("unexpected album description %q", s.albums[0].Description)
+	}
+	if err := c.AddEnrichment(album, &photos.Enrichment{
+		Location: &photos.Location{Name: "Tokyo"}}, false); err != photos.ErrNotSupported {
+		t.Errorf("expected location enrichment not to be supported, got %v", err)
+	}
+
+	if err := c.SetAlbumCover(album, tokens[1]); err != nil ||
+		s.albums[0].AlbumThumbnailAssetID != tokens[1] {
+		t.Errorf("failed to set album cover: %v", err)
+	}
+
+	info, err := c.ShareAlbum(album, false, false)
+	if err != nil || info.ShareableURL != server.URL+"/share/key1" {
+		t.Errorf("unexpected share info %+v: %v", info, err)
+	}
+
+	albums, err := c.ListAlbums()
+	if err != nil || len(albums) != 1 || albums[0].MediaItemsCount != 2 {
+		t.Errorf("unexpected albums %v: %v", albums, err)
+	}
+
+	albumItems, err := c.ListAlbumItems(album)
+	if err != nil || len(albumItems) != 2 {
+		t.Fatalf("expected 2 album items, got %v: %v", len(albumItems), err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := c.DownloadMediaItem(albumItems[1], &buf); err != nil ||
+		buf.String() != "contents of b.jpg" {
+		t.Errorf("unexpected download %q: %v", buf.String(), err)
+	}
+
+	all, err := c.SearchMediaItems(&photos.SearchFilter{MediaType: "PHOTO"})
+	if err != nil || len(all) != 2 {
+		t.Errorf("expected 2 items in total, got %v: %v", len(all), err)
+	}
+}
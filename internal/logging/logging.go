@@ -1,6 +1,10 @@
 package logging
 
 import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
 	"sync"
 
 	"github.com/sirupsen/logrus"
@@ -9,12 +13,122 @@ import (
 var (
 	logger     *logrus.Logger
 	loggerOnce sync.Once
+
+	// Where the log is written unless a log file is configured
+	console = &consoleWriter{out: os.Stderr}
+)
+
+// Field names of the log events; durations are in seconds
+const (
+	FieldAlbum      = "album"
+	FieldFile       = "file"
+	FieldTarget     = "target"
+	FieldBytes      = "bytes"
+	FieldFiles      = "files"
+	FieldFailed     = "failed"
+	FieldAttempt    = "attempt"
+	FieldDuration   = "duration"
+	FieldErrorClass = "error_class"
 )
 
+// Log formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Options configure the logger
+type Options struct {
+	// Output format; FormatText (the default) or FormatJSON
+	Format string
+
+	// Minimum level of the logged events, eg. "info" or "debug"
+	Level string
+
+	// File to write the log into instead of stderr
+	File string
+
+	// Maximum size of the log file in bytes before it is rotated; 0 disables
+	// rotation
+	MaxSize int64
+
+	// Number of rotated log files to keep
+	MaxBackups int
+}
+
 func MustGetLogger() *logrus.Logger {
 	loggerOnce.Do(func() {
 		logger = logrus.New()
+		logger.SetOutput(console)
 	})
 
 	return logger
 }
+
+// Configure sets the format, level and output of the logger
+func Configure(o Options) error {
+	log := MustGetLogger()
+
+	switch strings.ToLower(o.Format) {
+	case "", FormatText:
+		log.SetFormatter(&logrus.TextFormatter{})
+	case FormatJSON:
+		log.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("invalid log format '%v'; must be %v or %v", o.Format,
+			FormatText, FormatJSON)
+	}
+
+	level := logrus.InfoLevel
+	if o.Level != "" {
+		var err error
+		if level, err = logrus.ParseLevel(o.Level); err != nil {
+			return fmt.Errorf("invalid log level '%v'", o.Level)
+		}
+	}
+	log.SetLevel(level)
+
+	if o.File == "" {
+		log.SetOutput(console)
+		return nil
+	}
+
+	f, err := openRotatingFile(o.File, o.MaxSize, o.MaxBackups)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	log.SetOutput(f)
+
+	return nil
+}
+
+// consoleWriter writes the console log; the output may be redirected while
+// eg. a live view is displayed.
+type consoleWriter struct {
+	out  io.Writer
+	lock sync.Mutex
+}
+
+func (c *consoleWriter) Write(p []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.out.Write(p)
+}
+
+// RedirectConsole writes the console log into w instead of stderr until the
+// returned function is called. Does not affect logging into a file.
+func RedirectConsole(w io.Writer) (restore func()) {
+	console.lock.Lock()
+	defer console.lock.Unlock()
+
+	prev := console.out
+	console.out = w
+
+	return func() {
+		console.lock.Lock()
+		defer console.lock.Unlock()
+
+		console.out = prev
+	}
+}
@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "uploader.log")
+
+	f, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("failed to open log file: %v", err)
+	}
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+	f.file.Close()
+
+	expected := map[string]string{path: "fourth\n", path + ".1": "third\n",
+		path + ".2": "second\n"}
+	for p, contents := range expected {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Errorf("failed to read %v: %v", p, err)
+		} else if string(data) != contents {
+			t.Errorf("%v: expected %q, got %q", p, contents, string(data))
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected only 2 backups")
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	log := MustGetLogger()
+	defer Configure(Options{})
+
+	var out bytes.Buffer
+	restore := RedirectConsole(&out)
+	defer restore()
+
+	if err := Configure(Options{Format: "json", Level: "warn"}); err != nil {
+		t.Fatalf("failed to configure: %v", err)
+	}
+	log.WithField(FieldAlbum, "Trip").Info("not logged")
+	log.WithFields(map[string]any{FieldAlbum: "Trip", FieldBytes: 100}).Warn("logged")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line, got %v", lines)
+	}
+	var event map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &event); err != nil {
+		t.Fatalf("failed to parse %v: %v", lines[0], err)
+	}
+	if event["msg"] != "logged" || event[FieldAlbum] != "Trip" || event[FieldBytes] != 100.0 {
+		t.Errorf("unexpected event: %v", event)
+	}
+
+	path := filepath.Join(t.TempDir(), "uploader.log")
+	if err := Configure(Options{Level: "debug", File: path}); err != nil {
+		t.Fatalf("failed to configure: %v", err)
+	}
+	log.Debug("to file")
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "msg=\"to file\"") {
+		t.Errorf("expected the event in the log file, got %q", string(data))
+	}
+
+	for _, o := range []Options{{Format: "xml"}, {Level: "loud"}} {
+		if err := Configure(o); err == nil {
+			t.Errorf("%+v: expected an error", o)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+// Package metrics exposes the metrics of the uploads in the Prometheus text
+// format, along with a JSON status of the run, over HTTP.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metric is a metric that can be written in the Prometheus text format
+type metric interface {
+	write(w io.Writer)
+}
+
+// Registry is a set of metrics
+type Registry struct {
+	metrics []metric
+	lock    sync.Mutex
+}
+
+// register adds a metric to the registry
+func (r *Registry) register(m metric) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes the metrics in the Prometheus text exposition format
+func (r *Registry) Write(w io.Writer) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, m := range r.metrics {
+		m.write(w)
+	}
+}
+
+// series holds the values of a metric by their label values
+type series struct {
+	name, help, kind string
+	labels           []string
+	lock             sync.Mutex
+}
+
+func (s *series) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", s.name, s.help, s.name, s.kind)
+}
+
+// key returns the map key of the label values
+func (s *series) key(values []string) string {
+	if len(values) != len(s.labels) {
+		panic(fmt.Sprintf("metric %v: expected %v label values, got %v", s.name,
+			len(s.labels), len(values)))
+	}
+
+	return strings.Join(values, "\xff")
+}
+
+// format formats the labels of a key, with an extra label if name is not empty
+func (s *series) format(key, name, value string) string {
+	pairs := []string{}
+	if len(s.labels) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			pairs = append(pairs, fmt.Sprintf("%v=\"%v\"", s.labels[i], escapeLabel(v)))
+		}
+	}
+	if name != "" {
+		pairs = append(pairs, fmt.Sprintf("%v=\"%v\"", name, escapeLabel(value)))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Escapes a label value; backslashes, double quotes and newlines are escaped
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+// sortedKeys returns the keys of the map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter is a monotonically increasing value, eg. the number of bytes uploaded
+type Counter struct {
+	series
+	values map[string]float64
+}
+
+// NewCounter creates a counter with the given label names and registers it
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{series: series{name: name, help: help, kind: "counter", labels: labels},
+		values: map[string]float64{}}
+	r.register(c)
+
+	return c
+}
+
+// Add adds v to the counter of the label values
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := c.key(labelValues)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.values[key] += v
+}
+
+// Inc increments the counter of the label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Value returns the value of the counter of the label values
+func (c *Counter) Value(labelValues ...string) float64 {
+	key := c.key(labelValues)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.values[key]
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.header(w)
+	for _, k := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%v%v %v\n", c.name, c.format(k, "", ""), formatFloat(c.values[k]))
+	}
+}
+
+// Histogram counts observations, eg. upload durations, into buckets
+type Histogram struct {
+	series
+	buckets []float64
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram creates a histogram with the given bucket upper bounds and
+// label names and registers it
+func (r *Registry) NewHistogram(name, help string, buckets []float64,
+	labels ...string) *Histogram {
+
+	h := &Histogram{series: series{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets, values: map[string]*histogramValue{}}
+	r.register(h)
+
+	return h
+}
+
+// Observe adds an observation to the histogram of the label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := h.key(labelValues)
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	hv := h.values[key]
+	if hv == nil {
+		hv = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hv
+	}
+
+	for i, b := range h.buckets {
+		if v <= b {
+			hv.counts[i]++
+		}
+	}
+	hv.count++
+	hv.sum += v
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.header(w)
+	for _, k := range sortedKeys(h.values) {
+		hv := h.values[k]
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%v_bucket%v %v\n", h.name, h.format(k, "le", formatFloat(b)),
+				hv.counts[i])
+		}
+		fmt.Fprintf(w, "%v_bucket%v %v\n", h.name, h.format(k, "le", "+Inf"), hv.count)
+		fmt.Fprintf(w, "%v_sum%v %v\n", h.name, h.format(k, "", ""), formatFloat(hv.sum))
+		fmt.Fprintf(w, "%v_count%v %v\n", h.name, h.format(k, "", ""), hv.count)
+	}
+}
+
+// GaugeFunc is a value read at the time of scraping, eg. a queue length
+type GaugeFunc struct {
+	series
+	value func() float64
+}
+
+// NewGaugeFunc creates a gauge reading its value from the function and
+// registers it
+func (r *Registry) NewGaugeFunc(name, help string, value func() float64) *GaugeFunc {
+	g := &GaugeFunc{series: series{name: name, help: help, kind: "gauge"}, value: value}
+	r.register(g)
+
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.header(w)
+	fmt.Fprintf(w, "%v %v\n", g.name, formatFloat(g.value()))
+}
@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	r := &Registry{}
+	c := r.NewCounter("test_files_total", "Files", "target", "status")
+	h := r.NewHistogram("test_duration_seconds", "Durations", []float64{1, 5}, "target")
+	r.NewGaugeFunc("test_depth", "Depth", func() float64 { return 3 })
+
+	c.Inc("nas", "uploaded")
+	c.Add(2, `a"b`, "failed")
+	h.Observe(0.5, "nas")
+	h.Observe(2, "nas")
+
+	var b strings.Builder
+	r.Write(&b)
+
+	expected := `# HELP test_files_total Files
+# TYPE test_files_total counter
+test_files_total{target="a\"b",status="failed"} 2
+test_files_total{target="nas",status="uploaded"} 1
+# HELP test_duration_seconds Durations
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{target="nas",le="1"} 1
+test_duration_seconds_bucket{target="nas",le="5"} 2
+test_duration_seconds_bucket{target="nas",le="+Inf"} 2
+test_duration_seconds_sum{target="nas"} 2.5
+test_duration_seconds_count{target="nas"} 2
+# HELP test_depth Depth
+# TYPE test_depth gauge
+test_depth 3
+`
+	if b.String() != expected {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, b.String())
+	}
+}
+
+// queue is a fake operation queue
+type queue int
+
+func (q queue) Len() int {
+	return int(q)
+}
+
+func get(t *testing.T, url string) string {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("failed to GET %v: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %v failed: %v: %v", url, resp.Status, err)
+	}
+
+	return string(body)
+}
+
+func TestServe(t *testing.T) {
+	untrack := TrackQueue(queue(4))
+	defer untrack()
+	BytesUploaded.Add(1000, "serve")
+	ObserveError("serve", fmt.Errorf("failed"))
+
+	type status struct {
+		Album string `json:"album"`
+	}
+	s, err := Serve("127.0.0.1:0", Default, func() any { return status{Album: "Trip"} })
+	if err != nil {
+		t.Fatalf("failed to serve: %v", err)
+	}
+	defer s.Close()
+
+	body := get(t, "http://"+s.Addr()+"/metrics")
+	for _, expected := range []string{
+		`photos_uploader_uploaded_bytes_total{target="serve"} 1000`,
+		`photos_uploader_api_errors_total{target="serve",code="other"} 1`,
+		"photos_uploader_queue_depth 4",
+		"# TYPE photos_uploader_upload_duration_seconds histogram",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected the metrics to contain %q, got:\n%v", expected, body)
+		}
+	}
+
+	var st status
+	if err := json.Unmarshal([]byte(get(t, "http://"+s.Addr()+"/status")), &st); err != nil ||
+		st.Album != "Trip" {
+		t.Errorf("unexpected status %+v: %v", st, err)
+	}
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/matti777/google-photos-uploader/internal/logging"
+)
+
+var log = logging.MustGetLogger()
+
+// Server serves the metrics at /metrics and the status of the run at /status
+type Server struct {
+	server   *http.Server
+	listener net.Listener
+}
+
+// Serve starts serving the metrics of the registry at addr (eg. ':9090');
+// /status responds with the JSON of the value returned by status.
+func Serve(addr string, registry *Registry, status func() any) (*Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		registry.Write(w)
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(status()); err != nil {
+			log.Errorf("Failed to write status: %v", err)
+		}
+	})
+
+	s := &Server{server: &http.Server{Handler: mux}, listener: listener}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Errorf("Metrics server failed: %v", err)
+		}
+	}()
+
+	return s, nil
+}
+
+// Addr returns the address the server listens at
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the server
+func (s *Server) Close() error {
+	return s.server.Close()
+}
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"strconv"
+	"sync"
+
+	photos "github.com/matti777/google-photos-uploader/internal/googlephotos"
+)
+
+// Statuses of the files in FilesTotal
+const (
+	StatusUploaded = "uploaded"
+	StatusFailed   = "failed"
+	StatusSkipped  = "skipped"
+)
+
+// Default is the registry of the uploader's metrics
+var Default = &Registry{}
+
+// The uploader's metrics; labeled by the target, which is empty if the run
+// has only one
+var (
+	BytesUploaded = Default.NewCounter("photos_uploader_uploaded_bytes_total",
+		"Bytes uploaded", "target")
+
+	FilesTotal = Default.NewCounter("photos_uploader_files_total",
+		"Files processed by status (uploaded, failed or skipped)", "target", "status")
+
+	UploadDuration = Default.NewHistogram("photos_uploader_upload_duration_seconds",
+		"Duration of the file uploads",
+		[]float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}, "target")
+
+	UploadRetries = Default.NewCounter("photos_uploader_upload_retries_total",
+		"Uploads retried after a transient error", "target")
+
+	APIErrors = Default.NewCounter("photos_uploader_api_errors_total",
+		"Failed API calls by the HTTP status code, or the error class if the call "+
+			"got no response", "target", "code")
+
+	_ = Default.NewGaugeFunc("photos_uploader_queue_depth",
+		"Operations queued or in progress in the upload queues", queueDepth)
+)
+
+// Queue is an operation queue whose depth is reported
+type Queue interface {
+	// Len returns the number of operations queued or in progress
+	Len() int
+}
+
+var (
+	queues    = map[Queue]bool{}
+	queueLock sync.Mutex
+)
+
+// TrackQueue reports the depth of the queue until the returned function is
+// called
+func TrackQueue(q Queue) (untrack func()) {
+	queueLock.Lock()
+	defer queueLock.Unlock()
+
+	queues[q] = true
+
+	return func() {
+		queueLock.Lock()
+		defer queueLock.Unlock()
+
+		delete(queues, q)
+	}
+}
+
+func queueDepth() float64 {
+	queueLock.Lock()
+	defer queueLock.Unlock()
+
+	depth := 0
+	for q := range queues {
+		depth += q.Len()
+	}
+
+	return float64(depth)
+}
+
+// ObserveError records a failed API call of the target
+func ObserveError(target string, err error) {
+	code := photos.ErrorClass(err)
+	if status := photos.StatusCode(err); status != 0 {
+		code = strconv.Itoa(status)
+	}
+
+	APIErrors.Inc(target, code)
+}
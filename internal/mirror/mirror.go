@@ -0,0 +1,567 @@
+// Package mirror implements a local mirror that emulates Google Photos on the
+// filesystem. Uploaded files are stored as content-addressed blobs, each album
+// is a folder holding links to the blobs of its media items and a JSON
+// manifest records the albums and media items.
+package mirror
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	exif "github.com/dsoprea/go-exif/v3"
+	"github.com/google/uuid"
+
+	photos "github.com/matti777/google-photos-uploader/internal/googlephotos"
+	"github.com/matti777/google-photos-uploader/internal/logging"
+)
+
+const (
+	// Name of the manifest file in the mirror directory
+	manifestFilename = "manifest.json"
+
+	// Directories of the blobs and the albums in the mirror directory
+	blobsDir  = "blobs"
+	albumsDir = "albums"
+
+	// EXIF date format (YYYY:MM:DD HH:mm:ss)
+	exifDateFormat = "2006:01:02 15:04:05"
+)
+
+// albumItem is a media item in an album
+type albumItem struct {
+	MediaItemID string `json:"mediaItemId"`
+
+	// Name of the file (link to the blob) in the album folder
+	File string `json:"file"`
+}
+
+// album is an album in the manifest
+type album struct {
+	ID               string               `json:"id"`
+	Title            string               `json:"title"`
+	Dir              string               `json:"dir"`
+	ShareInfo        *photos.ShareInfo    `json:"shareInfo,omitempty"`
+	Items            []*albumItem         `json:"items"`
+	Enrichments      []*photos.Enrichment `json:"enrichments,omitempty"`
+	CoverMediaItemID string               `json:"coverMediaItemId,omitempty"`
+}
+
+// mediaItem is a media item in the manifest
+type mediaItem struct {
+	ID           string    `json:"id"`
+	FileName     string    `json:"fileName"`
+	Description  string    `json:"description,omitempty"`
+	MimeType     string    `json:"mimeType"`
+	CreationTime time.Time `json:"creationTime"`
+
+	// SHA-256 of the contents; the name of the blob
+	Blob string `json:"blob"`
+	Size int64  `json:"size"`
+}
+
+// manifest lists the albums and media items of the mirror
+type manifest struct {
+	Albums     []*album     `json:"albums"`
+	MediaItems []*mediaItem `json:"mediaItems"`
+}
+
+// Mirror is a local mirror of Google Photos; implements photos.Client.
+type Mirror struct {
+	dir      string
+	lock     sync.Mutex
+	manifest manifest
+}
+
+var _ photos.Client = (*Mirror)(nil)
+
+var log = logging.MustGetLogger()
+
+// New opens (or creates) the mirror in the directory.
+func New(dir string) (*Mirror, error) {
+	for _, d := range []string{dir, filepath.Join(dir, blobsDir),
+		filepath.Join(dir, albumsDir)} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create mirror directory: %w", err)
+		}
+	}
+
+	m := &Mirror{dir: dir}
+
+	data, err := os.ReadFile(filepath.Join(dir, manifestFilename))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read mirror manifest: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &m.manifest); err != nil {
+			return nil, fmt.Errorf("invalid mirror manifest: %w", err)
+		}
+	}
+
+	return m, nil
+}
+
+// Dir returns the mirror directory
+func (m *Mirror) Dir() string {
+	return m.dir
+}
+
+// save writes the manifest; must be called with the lock held.
+func (m *Mirror) save() error {
+	data, err := json.MarshalIndent(&m.manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal mirror manifest: %w", err)
+	}
+
+	path := filepath.Join(m.dir, manifestFilename)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write mirror manifest: %w", err)
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// blobPath returns the path of a blob
+func (m *Mirror) blobPath(hash string) string {
+	return filepath.Join(m.dir, blobsDir, hash[:2], hash)
+}
+
+// sanitizeName replaces the characters not allowed in file names
+func sanitizeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		if r < ' ' {
+			return -1
+		}
+		return r
+	}, name)
+
+	name = strings.Trim(strings.TrimSpace(name), ".")
+	if name == "" {
+		return "Untitled"
+	}
+
+	return name
+}
+
+// uniqueName returns a name not yet in the directory, adding a numeric suffix
+// (eg. 'IMG_1 (2).jpg') if needed.
+func uniqueName(dir, name string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	res := name
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(filepath.Join(dir, res)); errors.Is(err, fs.ErrNotExist) {
+			return res
+		}
+		res = fmt.Sprintf("%v (%v)%v", base, i, ext)
+	}
+}
+
+// findAlbum returns the manifest album by ID; must be called with the lock held.
+func (m *Mirror) findAlbum(id string) *album {
+	for _, a := range m.manifest.Albums {
+		if a.ID == id {
+			return a
+		}
+	}
+
+	return nil
+}
+
+// findItem returns the manifest media item by ID; must be called with the lock held.
+func (m *Mirror) findItem(id string) *mediaItem {
+	for _, item := range m.manifest.MediaItems {
+		if item.ID == id {
+			return item
+		}
+	}
+
+	return nil
+}
+
+// toAlbum converts a manifest album into an Album
+func (m *Mirror) toAlbum(a *album) *photos.Album {
+	return &photos.Album{ID: a.ID, Title: a.Title,
+		ProductURL:      "file://" + filepath.Join(m.dir, albumsDir, a.Dir),
+		IsWriteable:     true,
+		ShareInfo:       a.ShareInfo,
+		MediaItemsCount: int64(len(a.Items))}
+}
+
+// toMediaItem converts a manifest media item into a MediaItem
+func (m *Mirror) toMediaItem(item *mediaItem) *photos.MediaItem {
+	url := "file://" + m.blobPath(item.Blob)
+
+	return &photos.MediaItem{ID: item.ID, FileName: item.FileName,
+		Description: item.Description, MimeType: item.MimeType,
+		IsVideo:    strings.HasPrefix(item.MimeType, "video/"),
+		ProductURL: url, BaseURL: url, CreationTime: item.CreationTime}
+}
+
+// ListAlbums lists all the albums
+func (m *Mirror) ListAlbums() ([]*photos.Album, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	albums := make([]*photos.Album, 0, len(m.manifest.Albums))
+	for _, a := range m.manifest.Albums {
+		albums = append(albums, m.toAlbum(a))
+	}
+
+	return albums, nil
+}
+
+// ListSharedAlbums lists all the shared albums
+func (m *Mirror) ListSharedAlbums() ([]*photos.Album, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	albums := []*photos.Album{}
+	for _, a := range m.manifest.Albums {
+		if a.ShareInfo != nil {
+			albums = append(albums, m.toAlbum(a))
+		}
+	}
+
+	return albums, nil
+}
+
+// CreateAlbum creates a new album folder
+func (m *Mirror) CreateAlbum(name string) (*photos.Album, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	albumsPath := filepath.Join(m.dir, albumsDir)
+	a := &album{ID: uuid.New().String(), Title: name,
+		Dir: uniqueName(albumsPath, sanitizeName(name)), Items: []*albumItem{}}
+
+	if err := os.Mkdir(filepath.Join(albumsPath, a.Dir), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create album folder: %w", err)
+	}
+
+	m.manifest.Albums = append(m.manifest.Albums, a)
+
+	return m.toAlbum(a), m.save()
+}
+
+// ShareAlbum marks the album as shared
+func (m *Mirror) ShareAlbum(target *photos.Album, collaborative,
+	commentable bool) (*photos.ShareInfo, error) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	a := m.findAlbum(target.ID)
+	if a == nil {
+		return nil, fmt.Errorf("album %v not found", target.ID)
+	}
+
+	a.ShareInfo = &photos.ShareInfo{
+		ShareableURL:    "file://" + filepath.Join(m.dir, albumsDir, a.Dir),
+		ShareToken:      uuid.New().String(),
+		IsCollaborative: collaborative,
+		IsCommentable:   commentable,
+	}
+	target.ShareInfo = a.ShareInfo
+
+	return a.ShareInfo, m.save()
+}
+
+// countingWriter reports the number of bytes written to a callback
+type countingWriter struct {
+	count    int64
+	callback func(int64)
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.count += int64(len(p))
+	if w.callback != nil {
+		w.callback(w.count)
+	}
+
+	return len(p), nil
+}
+
+// UploadPhoto stores the file as a blob named by the SHA-256 of its contents;
+// the hash is used as the upload token.
+func (m *Mirror) UploadPhoto(path string, callback func(int64)) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	tmp, err := os.CreateTemp(filepath.Join(m.dir, blobsDir), "upload-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create blob: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h, &countingWriter{callback: callback}), f)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write blob: %w", err)
+	}
+
+	hash := hex.EncodeToString(h.Sum(nil))
+	blob := m.blobPath(hash)
+	if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+		return "", fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), blob); err != nil {
+		return "", fmt.Errorf("failed to store blob: %w", err)
+	}
+
+	return hash, nil
+}
+
+// creationTime reads the capture time of a photo from its EXIF data; returns
+// the zero time if not available.
+func creationTime(path string) time.Time {
+	rawExif, err := exif.SearchFileAndExtractExif(path)
+	if err != nil {
+		return time.Time{}
+	}
+
+	tags, _, err := exif.GetFlatExifData(rawExif, nil)
+	if err != nil {
+		return time.Time{}
+	}
+
+	for _, name := range []string{"DateTimeOriginal", "DateTimeDigitized", "DateTime"} {
+		for _, tag := range tags {
+			if tag.TagName != name {
+				continue
+			}
+			if s, ok := tag.Value.(string); ok {
+				if t, err := time.ParseInLocation(exifDateFormat, s, time.Local); err == nil {
+					return t
+				}
+			}
+		}
+	}
+
+	return time.Time{}
+}
+
+// linkFile links (or copies, if linking is not possible) a blob into a folder
+func linkFile(blob, path string) error {
+	if err := os.Link(blob, path); err == nil {
+		return nil
+	}
+
+	src, err := os.Open(blob)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// AddToAlbum creates media items out of uploaded blobs and links them into
+// the album folder (unless album is nil).
+func (m *Mirror) AddToAlbum(target *photos.Album,
+	items []*photos.NewMediaItem) ([]*photos.MediaItem, error) {
+
+	if len(items) > photos.MaxAddPhotosPerCall {
+		return nil, fmt.Errorf("Maximum number of photos to add per call is %v",
+			photos.MaxAddPhotosPerCall)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var a *album
+	if target != nil {
+		if a = m.findAlbum(target.ID); a == nil {
+			return nil, fmt.Errorf("album %v not found", target.ID)
+		}
+	}
+
+	created := make([]*photos.MediaItem, 0, len(items))
+
+	for _, newItem := range items {
+		blob := ""
+		if len(newItem.UploadToken) > 2 {
+			blob = m.blobPath(newItem.UploadToken)
+		}
+		info, err := os.Stat(blob)
+		if err != nil {
+			log.WithField(logging.FieldFile, newItem.FileName).
+				Warn("Failed to add a photo to the album: unknown upload token")
+			continue
+		}
+
+		description := newItem.Description
+		if len(description) > photos.MaxDescriptionLength {
+			description = description[:photos.MaxDescriptionLength]
+		}
+
+		item := &mediaItem{ID: uuid.New().String(), FileName: newItem.FileName,
+			Description: description, Blob: newItem.UploadToken, Size: info.Size(),
+			MimeType:     mime.TypeByExtension(strings.ToLower(filepath.Ext(newItem.FileName))),
+			CreationTime: creationTime(blob)}
+		if item.CreationTime.IsZero() {
+			item.CreationTime = time.Now()
+		}
+		m.manifest.MediaItems = append(m.manifest.MediaItems, item)
+
+		if a != nil {
+			albumPath := filepath.Join(m.dir, albumsDir, a.Dir)
+			name := uniqueName(albumPath, sanitizeName(newItem.FileName))
+			if err := linkFile(blob, filepath.Join(albumPath, name)); err != nil {
+				return nil, fmt.Errorf("failed to add file to album folder: %w", err)
+			}
+			a.Items = append(a.Items, &albumItem{MediaItemID: item.ID, File: name})
+		}
+
+		res := m.toMediaItem(item)
+		res.UploadToken = newItem.UploadToken
+		created = append(created, res)
+	}
+
+	if len(created) == 0 {
+		return nil, fmt.Errorf("Failed to add all of the photos to album")
+	}
+
+	return created, m.save()
+}
+
+// AddEnrichment records the enrichment in the album
+func (m *Mirror) AddEnrichment(target *photos.Album, e *photos.Enrichment, first bool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	a := m.findAlbum(target.ID)
+	if a == nil {
+		return fmt.Errorf("album %v not found", target.ID)
+	}
+
+	if first {
+		a.Enrichments = append([]*photos.Enrichment{e}, a.Enrichments...)
+	} else {
+		a.Enrichments = append(a.Enrichments, e)
+	}
+
+	return m.save()
+}
+
+// SetAlbumCover records the cover photo of the album
+func (m *Mirror) SetAlbumCover(target *photos.Album, mediaItemID string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	a := m.findAlbum(target.ID)
+	if a == nil {
+		return fmt.Errorf("album %v not found", target.ID)
+	}
+
+	for _, item := range a.Items {
+		if item.MediaItemID == mediaItemID {
+			a.CoverMediaItemID = mediaItemID
+			return m.save()
+		}
+	}
+
+	return fmt.Errorf("media item %v is not in album %v", mediaItemID, a.Title)
+}
+
+// SearchMediaItems searches for media items. Content categories are not
+// supported since the mirror does not classify the photos.
+func (m *Mirror) SearchMediaItems(f *photos.SearchFilter) ([]*photos.MediaItem, error) {
+	if len(f.ContentCategories) > 0 {
+		return nil, fmt.Errorf("content categories are not supported by the local mirror")
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	res := []*photos.MediaItem{}
+
+	if f.AlbumID != "" {
+		a := m.findAlbum(f.AlbumID)
+		if a == nil {
+			return nil, fmt.Errorf("album %v not found", f.AlbumID)
+		}
+		for _, ai := range a.Items {
+			if item := m.findItem(ai.MediaItemID); item != nil {
+				res = append(res, m.toMediaItem(item))
+			}
+		}
+		return res, nil
+	}
+
+	day := func(t time.Time) string { return t.Format("2006-01-02") }
+
+	for _, item := range m.manifest.MediaItems {
+		created := day(item.CreationTime)
+		if !f.StartDate.IsZero() && created < day(f.StartDate) {
+			continue
+		}
+		if !f.EndDate.IsZero() && created > day(f.EndDate) {
+			continue
+		}
+
+		isVideo := strings.HasPrefix(item.MimeType, "video/")
+		if (f.MediaType == "PHOTO" && isVideo) || (f.MediaType == "VIDEO" && !isVideo) {
+			continue
+		}
+
+		res = append(res, m.toMediaItem(item))
+	}
+
+	return res, nil
+}
+
+// ListAlbumItems lists all the media items of the album
+func (m *Mirror) ListAlbumItems(a *photos.Album) ([]*photos.MediaItem, error) {
+	return m.SearchMediaItems(&photos.SearchFilter{AlbumID: a.ID})
+}
+
+// DownloadMediaItem writes the blob of the media item into w
+func (m *Mirror) DownloadMediaItem(item *photos.MediaItem, w io.Writer) (int64, error) {
+	m.lock.Lock()
+	mi := m.findItem(item.ID)
+	m.lock.Unlock()
+
+	if mi == nil {
+		return 0, fmt.Errorf("media item %v not found", item.ID)
+	}
+
+	f, err := os.Open(m.blobPath(mi.Blob))
+	if err != nil {
+		return 0, fmt.Errorf("failed to open blob: %w", err)
+	}
+	defer f.Close()
+
+	return io.Copy(w, f)
+}
@@ -0,0 +1,112 @@
+package mirror
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	photos "github.com/matti777/google-photos-uploader/internal/googlephotos"
+)
+
+func TestMirror(t *testing.T) {
+	dir := t.TempDir()
+	m, err := New(dir)
+	if err != nil {
+		t.Fatalf("failed to create mirror: %v", err)
+	}
+
+	album, err := m.CreateAlbum("Trip: Japan")
+	if err != nil {
+		t.Fatalf("failed to create album: %v", err)
+	}
+
+	srcDir := t.TempDir()
+	tokens := []string{}
+	for _, name := range []string{"a.jpg", "b.jpg", "copy-of-a.jpg"} {
+		contents := []byte(name)
+		if name == "copy-of-a.jpg" {
+			contents = []byte("a.jpg")
+		}
+		path := filepath.Join(srcDir, name)
+		if err := os.WriteFile(path, contents, 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+
+		sent := int64(0)
+		token, err := m.UploadPhoto(path, func(n int64) { sent = n })
+		if err != nil {
+			t.Fatalf("failed to upload: %v", err)
+		}
+		if sent != int64(len(contents)) {
+			t.Errorf("expected progress %v, got %v", len(contents), sent)
+		}
+		tokens = append(tokens, token)
+	}
+
+	if tokens[0] != tokens[2] || tokens[0] == tokens[1] {
+		t.Errorf("blobs should be content-addressed: %v", tokens)
+	}
+
+	items, err := m.AddToAlbum(album, []*photos.NewMediaItem{
+		{UploadToken: tokens[0], FileName: "a.jpg", Description: "First"},
+		{UploadToken: tokens[1], FileName: "b.jpg"},
+		{UploadToken: "unknown", FileName: "c.jpg"},
+	})
+	if err != nil || len(items) != 2 {
+		t.Fatalf("expected 2 items to be added, got %v: %v", len(items), err)
+	}
+	if items[0].UploadToken != tokens[0] || items[0].MimeType != "image/jpeg" {
+		t.Errorf("unexpected media item: %+v", items[0])
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, albumsDir, "Trip_ Japan", "b.jpg")); err != nil {
+		t.Errorf("album folder should contain the file: %v", err)
+	}
+
+	if _, err := m.ShareAlbum(album, false, true); err != nil || album.ShareInfo == nil {
+		t.Errorf("failed to share album: %v", err)
+	}
+	if err := m.SetAlbumCover(album, items[1].ID); err != nil {
+		t.Errorf("failed to set cover: %v", err)
+	}
+	if err := m.SetAlbumCover(album, "foo"); err == nil {
+		t.Errorf("should have failed to set cover to an item not in the album")
+	}
+	if err := m.AddEnrichment(album, &photos.Enrichment{Text: "Hello"}, true); err != nil {
+		t.Errorf("failed to add enrichment: %v", err)
+	}
+
+	// Reopen the mirror from its manifest
+	m, err = New(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen mirror: %v", err)
+	}
+
+	albums, _ := m.ListAlbums()
+	if len(albums) != 1 || albums[0].MediaItemsCount != 2 || albums[0].ShareInfo == nil {
+		t.Fatalf("unexpected albums: %+v", albums)
+	}
+	if shared, _ := m.ListSharedAlbums(); len(shared) != 1 {
+		t.Errorf("expected 1 shared album, got %v", len(shared))
+	}
+
+	albumItems, err := m.ListAlbumItems(albums[0])
+	if err != nil || len(albumItems) != 2 || albumItems[0].Description != "First" {
+		t.Fatalf("unexpected album items: %v, %v", albumItems, err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := m.DownloadMediaItem(albumItems[1], &buf); err != nil ||
+		buf.String() != "b.jpg" {
+		t.Errorf("unexpected download: '%v', %v", buf.String(), err)
+	}
+
+	if _, err := m.SearchMediaItems(&photos.SearchFilter{
+		ContentCategories: []string{"PETS"}}); err == nil {
+		t.Errorf("content category search should not be supported")
+	}
+	if found, _ := m.SearchMediaItems(&photos.SearchFilter{MediaType: "VIDEO"}); len(found) != 0 {
+		t.Errorf("expected no videos, got %v", len(found))
+	}
+}
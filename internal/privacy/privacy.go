@@ -0,0 +1,219 @@
+// Package privacy implements the privacy policy of the uploads: which
+// metadata tags are removed from the uploaded files, and which tags must not
+// be found in them. The tags are read and removed with exiftool.
+package privacy
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/matti777/google-photos-uploader/internal/exiftool"
+)
+
+const (
+	// Earth's mean radius in kilometers
+	earthRadiusKm = 6371.0
+)
+
+var (
+	// Tags removed to strip the location; the GPS IFD and the GPS tags of
+	// other groups such as XMP
+	gpsTags = []string{"GPS:all", "GPSLatitude", "GPSLongitude", "GPSAltitude",
+		"GPSPosition"}
+
+	// Patterns of the location tags that must not remain after stripping
+	gpsPatterns = []string{"GPS:*", "GPSLatitude", "GPSLongitude", "GPSPosition"}
+
+	// Tags identifying the owner or the camera
+	personalTags = []string{"SerialNumber", "BodySerialNumber", "LensSerialNumber",
+		"InternalSerialNumber", "CameraSerialNumber", "OwnerName", "CameraOwnerName"}
+)
+
+// Location is a position in decimal degrees
+type Location struct {
+	Lat float64
+	Lon float64
+}
+
+// ParseLocation parses a location given as 'latitude,longitude' in decimal
+// degrees, eg. '60.1699,24.9384'
+func ParseLocation(s string) (Location, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return Location{}, fmt.Errorf("invalid location '%v'; expected 'latitude,longitude'", s)
+	}
+
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return Location{}, fmt.Errorf("invalid latitude '%v'", parts[0])
+	}
+
+	lon, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return Location{}, fmt.Errorf("invalid longitude '%v'", parts[1])
+	}
+
+	return Location{Lat: lat, Lon: lon}, nil
+}
+
+// distanceKm returns the great-circle distance between two locations in
+// kilometers, using the haversine formula.
+func distanceKm(l1, l2 Location) float64 {
+	lat1 := l1.Lat * math.Pi / 180
+	lat2 := l2.Lat * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (l2.Lon - l1.Lon) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadiusKm * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// Policy is the privacy policy of the uploaded files
+type Policy struct {
+	// Whether to strip the location of all the files
+	StripGPS bool
+
+	// If set, the location is stripped of the files taken within HomeRadiusKm
+	// of this location
+	Home         *Location
+	HomeRadiusKm float64
+
+	// Whether to remove the camera serial numbers and the owner names
+	StripPersonal bool
+
+	// Patterns of the tags that must not be found in the uploaded files; a
+	// tag name (eg. 'SerialNumber'), or a group and a name (eg.
+	// 'XMP-dc:Creator'). '*' matches any characters; the case is ignored.
+	Denylist []string
+}
+
+// Enabled tells whether the policy does anything
+func (p Policy) Enabled() bool {
+	return p.StripGPS || p.Home != nil || p.StripPersonal || len(p.Denylist) > 0
+}
+
+// Validate checks the policy
+func (p Policy) Validate() error {
+	if p.Home != nil && p.HomeRadiusKm <= 0 {
+		return fmt.Errorf("invalid home radius: %v km", p.HomeRadiusKm)
+	}
+
+	for _, pattern := range p.Denylist {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid denylist pattern '%v': %w", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// Redaction lists the tags to remove from a file, and the tags that must not
+// remain in it afterwards
+type Redaction struct {
+	// Tags to remove; exiftool tag names, optionally with their group
+	Delete []string
+
+	// Patterns of the denied tags; the denylist and the removed tags
+	Deny []string
+}
+
+// Redaction returns the redaction of a file with the given tags
+func (p Policy) Redaction(tags exiftool.Tags) Redaction {
+	r := Redaction{Deny: append([]string{}, p.Denylist...)}
+
+	if p.stripsLocation(tags) {
+		r.Delete = append(r.Delete, gpsTags...)
+		r.Deny = append(r.Deny, gpsPatterns...)
+	}
+
+	if p.StripPersonal {
+		r.Delete = append(r.Delete, personalTags...)
+		r.Deny = append(r.Deny, personalTags...)
+	}
+
+	return r
+}
+
+// stripsLocation tells whether the location of a file with the given tags
+// is stripped
+func (p Policy) stripsLocation(tags exiftool.Tags) bool {
+	if p.StripGPS {
+		return true
+	}
+	if p.Home == nil {
+		return false
+	}
+
+	l, ok := location(tags)
+
+	return ok && distanceKm(*p.Home, l) <= p.HomeRadiusKm
+}
+
+// location returns the location of a file; the composite tags hold the
+// location parsed by exiftool from the GPS or XMP tags
+func location(tags exiftool.Tags) (Location, bool) {
+	for _, group := range []string{"Composite", "XMP-exif"} {
+		lat, ok1 := tags[group+":GPSLatitude"].(float64)
+		lon, ok2 := tags[group+":GPSLongitude"].(float64)
+		if ok1 && ok2 {
+			return Location{Lat: lat, Lon: lon}, true
+		}
+	}
+
+	// The GPS tags are unsigned; the reference tells the hemisphere
+	lat, ok1 := tags["GPS:GPSLatitude"].(float64)
+	lon, ok2 := tags["GPS:GPSLongitude"].(float64)
+	if !ok1 || !ok2 {
+		return Location{}, false
+	}
+	if tags["GPS:GPSLatitudeRef"] == "S" {
+		lat = -lat
+	}
+	if tags["GPS:GPSLongitudeRef"] == "W" {
+		lon = -lon
+	}
+
+	return Location{Lat: lat, Lon: lon}, true
+}
+
+// Leaks returns the tags matching the denied patterns, in order
+func (r Redaction) Leaks(tags exiftool.Tags) []string {
+	res := []string{}
+	for key := range tags {
+		name := key
+		if i := strings.LastIndex(key, ":"); i >= 0 {
+			name = key[i+1:]
+		}
+
+		for _, pattern := range r.Deny {
+			subject := name
+			if strings.Contains(pattern, ":") {
+				subject = key
+			}
+
+			if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(subject)); ok {
+				res = append(res, key)
+				break
+			}
+		}
+	}
+	sort.Strings(res)
+
+	return res
+}
+
+// LeakError is returned for a file that would leak denied tags
+type LeakError struct {
+	Tags []string
+}
+
+func (e *LeakError) Error() string {
+	return fmt.Sprintf("the file contains denied metadata tags: %v",
+		strings.Join(e.Tags, ", "))
+}
@@ -0,0 +1,211 @@
+package privacy
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/matti777/google-photos-uploader/internal/exiftool"
+)
+
+// readTags reads the tags of a sample file from the output of
+// 'exiftool -json -n -G1' in testdata
+func readTags(t *testing.T, name string) exiftool.Tags {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("failed to read %v: %v", name, err)
+	}
+
+	tags, err := exiftool.ParseTags(data)
+	if err != nil {
+		t.Fatalf("failed to parse %v: %v", name, err)
+	}
+
+	return tags
+}
+
+func TestParseLocation(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected Location
+		err      bool
+	}{
+		{s: "60.1699,24.9384", expected: Location{Lat: 60.1699, Lon: 24.9384}},
+		{s: "-33.8568, 151.2153", expected: Location{Lat: -33.8568, Lon: 151.2153}},
+		{s: "60.1699", err: true},
+		{s: "91,24", err: true},
+		{s: "60,181", err: true},
+		{s: "north,east", err: true},
+	}
+
+	for _, test := range tests {
+		l, err := ParseLocation(test.s)
+		if (err != nil) != test.err || l != test.expected {
+			t.Errorf("%v: expected %v (error: %v), got %v: %v", test.s, test.expected,
+				test.err, l, err)
+		}
+	}
+}
+
+func TestLocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     exiftool.Tags
+		expected Location
+		ok       bool
+	}{
+		{name: "composite", tags: readTags(t, "sample.json"),
+			expected: Location{Lat: 60.1699, Lon: 24.9384}, ok: true},
+		{name: "xmp", tags: readTags(t, "leaky.json"),
+			expected: Location{Lat: -33.8568, Lon: 151.2153}, ok: true},
+		{name: "gps", tags: exiftool.Tags{"GPS:GPSLatitude": 33.8568,
+			"GPS:GPSLatitudeRef": "S", "GPS:GPSLongitude": 70.5, "GPS:GPSLongitudeRef": "W"},
+			expected: Location{Lat: -33.8568, Lon: -70.5}, ok: true},
+		{name: "none", tags: readTags(t, "redacted.json")},
+	}
+
+	for _, test := range tests {
+		l, ok := location(test.tags)
+		if ok != test.ok || l != test.expected {
+			t.Errorf("%v: expected %v (%v), got %v (%v)", test.name, test.expected, test.ok,
+				l, ok)
+		}
+	}
+}
+
+func TestDistanceKm(t *testing.T) {
+	// Helsinki - Tampere
+	d := distanceKm(Location{Lat: 60.1699, Lon: 24.9384}, Location{Lat: 61.4978, Lon: 23.7610})
+	if math.Abs(d-160) > 5 {
+		t.Errorf("unexpected distance %v", d)
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	near := &Location{Lat: 60.17, Lon: 24.94}
+	far := &Location{Lat: 61.4978, Lon: 23.7610}
+
+	tests := []struct {
+		name     string
+		policy   Policy
+		tags     exiftool.Tags
+		expected []string
+	}{
+		{name: "nothing", policy: Policy{}, tags: readTags(t, "sample.json")},
+		{name: "strip GPS", policy: Policy{StripGPS: true}, tags: readTags(t, "sample.json"),
+			expected: gpsTags},
+		{name: "strip GPS of no location", policy: Policy{StripGPS: true},
+			tags: readTags(t, "redacted.json"), expected: gpsTags},
+		{name: "near home", policy: Policy{Home: near, HomeRadiusKm: 1},
+			tags: readTags(t, "sample.json"), expected: gpsTags},
+		{name: "away from home", policy: Policy{Home: far, HomeRadiusKm: 50},
+			tags: readTags(t, "sample.json")},
+		{name: "home of no location", policy: Policy{Home: near, HomeRadiusKm: 1},
+			tags: readTags(t, "redacted.json")},
+		{name: "personal", policy: Policy{StripPersonal: true},
+			tags: readTags(t, "sample.json"), expected: personalTags},
+	}
+
+	for _, test := range tests {
+		r := test.policy.Redaction(test.tags)
+		if !reflect.DeepEqual(r.Delete, test.expected) {
+			t.Errorf("%v: expected to delete %v, got %v", test.name, test.expected, r.Delete)
+		}
+	}
+}
+
+func TestLeaks(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   Policy
+		file     string
+		expected []string
+	}{
+		{name: "redacted", policy: Policy{StripGPS: true, StripPersonal: true},
+			file: "redacted.json", expected: []string{}},
+		{name: "not redacted", policy: Policy{StripGPS: true, StripPersonal: true},
+			file: "sample.json", expected: []string{"Composite:GPSLatitude",
+				"Composite:GPSLongitude", "Composite:GPSPosition", "ExifIFD:OwnerName",
+				"ExifIFD:SerialNumber", "GPS:GPSLatitude", "GPS:GPSLatitudeRef",
+				"GPS:GPSLongitude", "GPS:GPSLongitudeRef"}},
+		{name: "leaky", policy: Policy{StripGPS: true, StripPersonal: true},
+			file: "leaky.json", expected: []string{"Canon:SerialNumber",
+				"XMP-exif:GPSLatitude", "XMP-exif:GPSLongitude"}},
+		{name: "denylist", policy: Policy{Denylist: []string{"xmp-dc:creator", "*serial*"}},
+			file: "leaky.json", expected: []string{"Canon:SerialNumber", "XMP-dc:Creator"}},
+		{name: "group pattern", policy: Policy{Denylist: []string{"XMP-*:*"}},
+			file: "leaky.json", expected: []string{"XMP-dc:Creator", "XMP-exif:GPSLatitude",
+				"XMP-exif:GPSLongitude"}},
+		{name: "denylist not matching", policy: Policy{Denylist: []string{"Creator"}},
+			file: "redacted.json", expected: []string{}},
+	}
+
+	for _, test := range tests {
+		tags := readTags(t, test.file)
+		leaks := test.policy.Redaction(tags).Leaks(tags)
+		if !reflect.DeepEqual(leaks, test.expected) {
+			t.Errorf("%v: expected leaks %v, got %v", test.name, test.expected, leaks)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		policy Policy
+		err    bool
+	}{
+		{policy: Policy{Home: &Location{}, HomeRadiusKm: 1}},
+		{policy: Policy{Home: &Location{}}, err: true},
+		{policy: Policy{Denylist: []string{"GPS*"}}},
+		{policy: Policy{Denylist: []string{"[GPS"}}, err: true},
+	}
+
+	for _, test := range tests {
+		if err := test.policy.Validate(); (err != nil) != test.err {
+			t.Errorf("%+v: expected error: %v, got %v", test.policy, test.err, err)
+		}
+	}
+}
+
+// Redacts the sample file with exiftool
+func TestRedactSample(t *testing.T) {
+	if !exiftool.IsInstalled() {
+		t.Skip("exiftool is not installed")
+	}
+
+	sample := filepath.Join("testdata", "sample.jpg")
+	tags, err := exiftool.ReadTags(sample)
+	if err != nil {
+		t.Fatalf("failed to read tags: %v", err)
+	}
+
+	policy := Policy{Home: &Location{Lat: 60.17, Lon: 24.94}, HomeRadiusKm: 1,
+		StripPersonal: true}
+	r := policy.Redaction(tags)
+	if leaks := r.Leaks(tags); len(leaks) == 0 {
+		t.Errorf("expected the sample to leak")
+	}
+
+	out := filepath.Join(t.TempDir(), "redacted.jpg")
+	date := time.Date(2019, 6, 14, 10, 11, 12, 0, time.Local)
+	if err := exiftool.WriteTags(sample, out, date, r.Delete); err != nil {
+		t.Fatalf("failed to write tags: %v", err)
+	}
+
+	redacted, err := exiftool.ReadTags(out)
+	if err != nil {
+		t.Fatalf("failed to read tags: %v", err)
+	}
+	if leaks := r.Leaks(redacted); len(leaks) > 0 {
+		t.Errorf("expected no leaks, got %v", leaks)
+	}
+	if d := redacted["ExifIFD:DateTimeOriginal"]; d != "2019:06:14 10:11:12" {
+		t.Errorf("expected the date to be kept, got %v", d)
+	}
+	if m := redacted["IFD0:Model"]; m != "Canon EOS 5D" {
+		t.Errorf("expected the model to be kept, got %v", m)
+	}
+}
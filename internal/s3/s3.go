@@ -0,0 +1,619 @@
+// Package s3 implements an upload destination on an S3-compatible object
+// store (eg. AWS S3 or MinIO). Uploaded files are stored as content-addressed
+// blobs which are copied into an album "folder" (key prefix) when added to
+// an album; the album metadata is kept in a JSON object in the folder.
+package s3
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	photos "github.com/matti777/google-photos-uploader/internal/googlephotos"
+	"github.com/matti777/google-photos-uploader/internal/logging"
+)
+
+const (
+	// Key prefixes of the blobs, the albums and the library items
+	blobsPrefix   = "blobs/"
+	albumsPrefix  = "albums/"
+	libraryPrefix = "library/"
+
+	// Name of the album metadata object in the album folder
+	albumObjectName = ".album.json"
+
+	// Maximum number of keys per list call
+	listPageSize = 1000
+)
+
+// Config holds the connection parameters of the object store
+type Config struct {
+	// Endpoint URL, eg. https://s3.eu-north-1.amazonaws.com or http://localhost:9000
+	Endpoint string
+
+	Region string
+	Bucket string
+
+	// Key prefix of all the objects; may be empty
+	Prefix string
+
+	AccessKey string
+	SecretKey string
+}
+
+// Client is the object store client; implements photos.Client.
+type Client struct {
+	config     Config
+	endpoint   *url.URL
+	httpClient *http.Client
+}
+
+var _ photos.Client = (*Client)(nil)
+
+var log = logging.MustGetLogger()
+
+// albumObject is the album metadata stored in the album folder
+type albumObject struct {
+	Title        string               `json:"title"`
+	Description  string               `json:"description,omitempty"`
+	CoverKey     string               `json:"coverKey,omitempty"`
+	Enrichments  []*photos.Enrichment `json:"enrichments,omitempty"`
+	CreationTime time.Time            `json:"creationTime"`
+}
+
+// object is an object in a list result
+type object struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	Size         int64     `xml:"Size"`
+}
+
+// listResult is the result of ListObjectsV2
+type listResult struct {
+	Contents       []*object `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// New creates an object store client
+func New(config Config) (*Client, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint '%v'", config.Endpoint)
+	}
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("S3 bucket must be defined")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Prefix != "" && !strings.HasSuffix(config.Prefix, "/") {
+		config.Prefix += "/"
+	}
+
+	return &Client{config: config, endpoint: endpoint, httpClient: http.DefaultClient}, nil
+}
+
+// objectURL returns the (path-style) URL of an object key
+func (c *Client) objectURL(key string, query url.Values) *url.URL {
+	u := *c.endpoint
+	u.Path = "/" + c.config.Bucket + "/" + key
+	u.RawQuery = query.Encode()
+
+	return &u
+}
+
+// do signs and sends a request; returns an error for a non-2xx status
+func (c *Client) do(method, key string, query url.Values, headers map[string]string,
+	body io.Reader, contentLength int64) (*http.Response, error) {
+
+	req, err := http.NewRequest(method, c.objectURL(key, query).String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if body != nil {
+		req.ContentLength = contentLength
+	}
+
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		payloadHash = unsignedPayload
+	}
+	signRequest(req, payloadHash, c.config.AccessKey, c.config.SecretKey,
+		c.config.Region, time.Now())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call %v %v: %w", method, key, err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		contents, _ := io.ReadAll(resp.Body)
+		return resp, &statusError{status: resp.StatusCode,
+			message: fmt.Sprintf("%v %v failed: %v: %v", method, key, resp.Status,
+				strings.TrimSpace(string(contents)))}
+	}
+
+	return resp, nil
+}
+
+// statusError is an error response of the object store
+type statusError struct {
+	status  int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// StatusCode returns the HTTP status code of the response
+func (e *statusError) StatusCode() int {
+	return e.status
+}
+
+// isNotFound tells whether the error is a 404 response
+func isNotFound(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && se.status == http.StatusNotFound
+}
+
+// exists tells whether an object exists
+func (c *Client) exists(key string) (bool, error) {
+	resp, err := c.do("HEAD", key, nil, nil, nil, 0)
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	return true, nil
+}
+
+// list lists the objects and the common prefixes under the prefix, going
+// through all the result pages.
+func (c *Client) list(prefix, delimiter string) ([]*object, []string, error) {
+	objects := []*object{}
+	prefixes := []string{}
+	token := ""
+
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix},
+			"max-keys": {fmt.Sprint(listPageSize)}}
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := c.do("GET", "", query, nil, nil, 0)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+
+		var res listResult
+		err = xml.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode object list: %w", err)
+		}
+
+		objects = append(objects, res.Contents...)
+		for _, p := range res.CommonPrefixes {
+			prefixes = append(prefixes, p.Prefix)
+		}
+
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			break
+		}
+		token = res.NextContinuationToken
+	}
+
+	return objects, prefixes, nil
+}
+
+// putJSON writes a JSON object
+func (c *Client) putJSON(key string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal %v: %w", key, err)
+	}
+
+	resp, err := c.do("PUT", key, nil, map[string]string{"Content-Type": "application/json"},
+		strings.NewReader(string(data)), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// albumPrefix returns the key prefix of an album folder
+func (c *Client) albumPrefix(albumID string) string {
+	return c.config.Prefix + albumsPrefix + albumID + "/"
+}
+
+// readAlbum reads the metadata object of the album
+func (c *Client) readAlbum(albumID string) (*albumObject, error) {
+	resp, err := c.do("GET", c.albumPrefix(albumID)+albumObjectName, nil, nil, nil, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read album %v: %w", albumID, err)
+	}
+	defer resp.Body.Close()
+
+	a := &albumObject{}
+	if err := json.NewDecoder(resp.Body).Decode(a); err != nil {
+		return nil, fmt.Errorf("invalid album object %v: %w", albumID, err)
+	}
+
+	return a, nil
+}
+
+// toAlbum converts an album object into an Album
+func (c *Client) toAlbum(albumID string, a *albumObject, items int) *photos.Album {
+	return &photos.Album{ID: albumID, Title: a.Title,
+		ProductURL:      c.objectURL(c.albumPrefix(albumID), nil).String(),
+		IsWriteable:     true,
+		MediaItemsCount: int64(items)}
+}
+
+// toMediaItem converts a listed object into a MediaItem
+func (c *Client) toMediaItem(o *object) *photos.MediaItem {
+	mimeType := mime.TypeByExtension(strings.ToLower(path.Ext(o.Key)))
+	u := c.objectURL(o.Key, nil).String()
+
+	return &photos.MediaItem{ID: o.Key, FileName: path.Base(o.Key), MimeType: mimeType,
+		IsVideo: strings.HasPrefix(mimeType, "video/"), ProductURL: u, BaseURL: u,
+		CreationTime: o.LastModified}
+}
+
+// albumItems returns the media item objects of an album folder
+func albumItems(objects []*object) []*object {
+	res := []*object{}
+	for _, o := range objects {
+		if path.Base(o.Key) != albumObjectName {
+			res = append(res, o)
+		}
+	}
+
+	return res
+}
+
+// ListAlbums lists the album folders
+func (c *Client) ListAlbums() ([]*photos.Album, error) {
+	_, prefixes, err := c.list(c.config.Prefix+albumsPrefix, "/")
+	if err != nil {
+		return nil, err
+	}
+
+	albums := []*photos.Album{}
+	for _, p := range prefixes {
+		albumID := path.Base(strings.TrimSuffix(p, "/"))
+
+		a, err := c.readAlbum(albumID)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		objects, _, err := c.list(p, "")
+		if err != nil {
+			return nil, err
+		}
+
+		albums = append(albums, c.toAlbum(albumID, a, len(albumItems(objects))))
+	}
+
+	return albums, nil
+}
+
+// ListSharedAlbums returns no albums since sharing is not supported
+func (c *Client) ListSharedAlbums() ([]*photos.Album, error) {
+	return []*photos.Album{}, nil
+}
+
+// folderName forms a key-safe folder name out of an album title
+func folderName(title string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r < ' ' {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(title))
+
+	if name == "" || name == "." || name == ".." {
+		return "Untitled"
+	}
+
+	return name
+}
+
+// CreateAlbum creates an album folder with its metadata object
+func (c *Client) CreateAlbum(name string) (*photos.Album, error) {
+	base := folderName(name)
+	albumID := base
+
+	for i := 2; ; i++ {
+		exists, err := c.exists(c.albumPrefix(albumID) + albumObjectName)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			break
+		}
+		albumID = fmt.Sprintf("%v (%v)", base, i)
+	}
+
+	a := &albumObject{Title: name, CreationTime: time.Now()}
+	if err := c.putJSON(c.albumPrefix(albumID)+albumObjectName, a); err != nil {
+		return nil, fmt.Errorf("failed to create album: %w", err)
+	}
+
+	return c.toAlbum(albumID, a, 0), nil
+}
+
+// ShareAlbum is not supported
+func (c *Client) ShareAlbum(album *photos.Album, collaborative,
+	commentable bool) (*photos.ShareInfo, error) {
+
+	return nil, photos.ErrNotSupported
+}
+
+// progressReader reports the number of bytes read to a callback
+type progressReader struct {
+	r        io.Reader
+	count    int64
+	callback func(int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.count += int64(n)
+	if r.callback != nil {
+		r.callback(r.count)
+	}
+
+	return n, err
+}
+
+// UploadPhoto stores the file as a blob named by the SHA-256 of its contents
+// (unless already stored); the hash is used as the upload token.
+func (c *Client) UploadPhoto(filePath string, callback func(int64)) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	key := c.config.Prefix + blobsPrefix + hash
+
+	exists, err := c.exists(key)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		if callback != nil {
+			callback(size)
+		}
+		return hash, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+
+	resp, err := c.do("PUT", key, nil, nil,
+		&progressReader{r: f, callback: callback}, size)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload file: %w", err)
+	}
+	resp.Body.Close()
+
+	return hash, nil
+}
+
+// uniqueKey returns a key not yet in the folder for the file name, adding a
+// numeric suffix (eg. 'IMG_1 (2).jpg') if needed.
+func (c *Client) uniqueKey(folder, name string, used map[string]bool) (string, error) {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	key := folder + name
+	for i := 2; ; i++ {
+		if !used[key] {
+			exists, err := c.exists(key)
+			if err != nil {
+				return "", err
+			}
+			if !exists {
+				used[key] = true
+				return key, nil
+			}
+		}
+		key = fmt.Sprintf("%v%v (%v)%v", folder, base, i, ext)
+	}
+}
+
+// AddToAlbum copies the uploaded blobs into the album folder (or the library
+// folder if album is nil). The descriptions are stored as object metadata.
+func (c *Client) AddToAlbum(album *photos.Album,
+	items []*photos.NewMediaItem) ([]*photos.MediaItem, error) {
+
+	folder := c.config.Prefix + libraryPrefix
+	if album != nil {
+		folder = c.albumPrefix(album.ID)
+	}
+
+	created := make([]*photos.MediaItem, 0, len(items))
+	used := map[string]bool{}
+
+	for _, item := range items {
+		name := folderName(item.FileName)
+		key, err := c.uniqueKey(folder, name, used)
+		if err != nil {
+			return nil, err
+		}
+
+		headers := map[string]string{
+			"x-amz-copy-source": "/" + c.config.Bucket + "/" +
+				c.config.Prefix + blobsPrefix + item.UploadToken,
+			"x-amz-metadata-directive": "REPLACE",
+			"Content-Type":             mime.TypeByExtension(strings.ToLower(path.Ext(name))),
+		}
+		if item.Description != "" {
+			headers["x-amz-meta-description"] = url.QueryEscape(item.Description)
+		}
+
+		resp, err := c.do("PUT", key, nil, headers, nil, 0)
+		if err != nil {
+			log.WithFields(logrus.Fields{logging.FieldFile: item.FileName,
+				logging.FieldErrorClass: photos.ErrorClass(err)}).WithError(err).
+				Warn("Failed to add a photo to the album")
+			continue
+		}
+		resp.Body.Close()
+
+		m := c.toMediaItem(&object{Key: key, LastModified: time.Now()})
+		m.Description = item.Description
+		m.UploadToken = item.UploadToken
+		created = append(created, m)
+	}
+
+	if len(created) == 0 {
+		return nil, fmt.Errorf("Failed to add all of the photos to album")
+	}
+
+	return created, nil
+}
+
+// updateAlbum reads, modifies and writes the album metadata object
+func (c *Client) updateAlbum(albumID string, update func(a *albumObject) error) error {
+	a, err := c.readAlbum(albumID)
+	if err != nil {
+		return err
+	}
+	if err := update(a); err != nil {
+		return err
+	}
+
+	return c.putJSON(c.albumPrefix(albumID)+albumObjectName, a)
+}
+
+// AddEnrichment stores the enrichment in the album metadata; a text enrichment
+// at the beginning of the album becomes the album description.
+func (c *Client) AddEnrichment(album *photos.Album, e *photos.Enrichment, first bool) error {
+	return c.updateAlbum(album.ID, func(a *albumObject) error {
+		if first && e.Text != "" && a.Description == "" {
+			a.Description = e.Text
+			return nil
+		}
+		if first {
+			a.Enrichments = append([]*photos.Enrichment{e}, a.Enrichments...)
+		} else {
+			a.Enrichments = append(a.Enrichments, e)
+		}
+		return nil
+	})
+}
+
+// SetAlbumCover stores the cover item in the album metadata
+func (c *Client) SetAlbumCover(album *photos.Album, mediaItemID string) error {
+	return c.updateAlbum(album.ID, func(a *albumObject) error {
+		if !strings.HasPrefix(mediaItemID, c.albumPrefix(album.ID)) {
+			return fmt.Errorf("media item %v is not in album %v", mediaItemID, album.Title)
+		}
+		a.CoverKey = mediaItemID
+		return nil
+	})
+}
+
+// SearchMediaItems lists the items of an album, or the items of all the
+// albums and the library by their modification date and media type.
+// Content categories are not supported.
+func (c *Client) SearchMediaItems(f *photos.SearchFilter) ([]*photos.MediaItem, error) {
+	if len(f.ContentCategories) > 0 {
+		return nil, fmt.Errorf("content categories are %w", photos.ErrNotSupported)
+	}
+
+	prefixes := []string{c.config.Prefix + albumsPrefix, c.config.Prefix + libraryPrefix}
+	if f.AlbumID != "" {
+		prefixes = []string{c.albumPrefix(f.AlbumID)}
+	}
+
+	day := func(t time.Time) string { return t.Format("2006-01-02") }
+	items := []*photos.MediaItem{}
+
+	for _, p := range prefixes {
+		objects, _, err := c.list(p, "")
+		if err != nil {
+			return nil, err
+		}
+
+		for _, o := range albumItems(objects) {
+			m := c.toMediaItem(o)
+			if !f.StartDate.IsZero() && day(m.CreationTime) < day(f.StartDate) {
+				continue
+			}
+			if !f.EndDate.IsZero() && day(m.CreationTime) > day(f.EndDate) {
+				continue
+			}
+			if (f.MediaType == "PHOTO" && m.IsVideo) || (f.MediaType == "VIDEO" && !m.IsVideo) {
+				continue
+			}
+			items = append(items, m)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+
+	return items, nil
+}
+
+// ListAlbumItems lists the items of the album folder
+func (c *Client) ListAlbumItems(album *photos.Album) ([]*photos.MediaItem, error) {
+	return c.SearchMediaItems(&photos.SearchFilter{AlbumID: album.ID})
+}
+
+// DownloadMediaItem writes the object of the media item into w
+func (c *Client) DownloadMediaItem(item *photos.MediaItem, w io.Writer) (int64, error) {
+	resp, err := c.do("GET", item.ID, nil, nil, nil, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	return io.Copy(w, resp.Body)
+}
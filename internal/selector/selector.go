@@ -0,0 +1,559 @@
+// Package selector lets the user choose the albums to upload after scanning:
+// a keyboard driven list in the terminal where the albums are toggled and
+// renamed before starting the uploads. When stdin or stdout is not a terminal
+// the selection is made with line based commands instead.
+package selector
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattn/go-isatty"
+
+	"github.com/matti777/google-photos-uploader/internal/util"
+)
+
+// Item is an album in the selector
+type Item struct {
+	// Album title; edited by the user
+	Title string
+
+	// Album date (YYYY, YYYY-MM or YYYY-MM-DD); empty if not known
+	Date string
+
+	// Number and total size of the files to upload
+	Files int
+	Bytes int64
+
+	// Whether the album is uploaded
+	Selected bool
+
+	// Whether the title can be edited; false for the library
+	Renamable bool
+
+	// Reason the album cannot be selected; empty if it can
+	Disabled string
+}
+
+// Number of rows on the screen besides the albums
+const chromeRows = 5
+
+// Maximum width of the titles in the list
+const maxTitleWidth = 40
+
+// Default height of the terminal if it cannot be read
+const defaultHeight = 24
+
+// Help line of the list
+const listHelp = "↑/↓ move  space toggle  a all  e rename  enter start  q quit"
+
+// Help line when editing a title
+const editHelp = "enter save  esc cancel"
+
+// Keys of the keyboard
+type key int
+
+const (
+	keyRune key = iota
+	keyUp
+	keyDown
+	keyPageUp
+	keyPageDown
+	keyHome
+	keyEnd
+	keyEnter
+	keyEscape
+	keyBackspace
+	keyInterrupt
+)
+
+// event is a key press; r is the character of a keyRune
+type event struct {
+	key key
+	r   rune
+}
+
+// Run shows the albums for selecting and renaming; interactively when stdin
+// and stdout are a terminal. The items are modified in place. Returns false if
+// the user cancelled.
+func Run(items []*Item) (bool, error) {
+	in, out := os.Stdin, os.Stdout
+
+	if isTerminal(in) && isTerminal(out) {
+		restore, err := makeRaw(int(in.Fd()))
+		if err == nil {
+			defer restore()
+			return runInteractive(in, out, items, terminalHeight(int(out.Fd())))
+		}
+	}
+
+	return runLines(in, out, items)
+}
+
+func isTerminal(f *os.File) bool {
+	return isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
+}
+
+// model is the state of the interactive selector
+type model struct {
+	items []*Item
+
+	// Album under the cursor and the first one on the screen
+	cursor, top int
+
+	// Number of albums that fit on the screen
+	rows int
+
+	// Title being edited, if editing
+	editing bool
+	edit    []rune
+
+	// Message shown under the list, eg. why an album cannot be selected
+	message string
+
+	// Whether the user started the uploads or quit
+	done, started bool
+}
+
+func newModel(items []*Item, height int) *model {
+	rows := height - chromeRows
+	if rows < 1 {
+		rows = 1
+	}
+
+	return &model{items: items, rows: rows}
+}
+
+// handle updates the state with a key press
+func (m *model) handle(e event) {
+	m.message = ""
+
+	if m.editing {
+		m.handleEdit(e)
+		return
+	}
+
+	switch {
+	case e.key == keyUp || e.key == keyRune && e.r == 'k':
+		m.move(-1)
+	case e.key == keyDown || e.key == keyRune && e.r == 'j':
+		m.move(1)
+	case e.key == keyPageUp:
+		m.move(-m.rows)
+	case e.key == keyPageDown:
+		m.move(m.rows)
+	case e.key == keyHome || e.key == keyRune && e.r == 'g':
+		m.move(-len(m.items))
+	case e.key == keyEnd || e.key == keyRune && e.r == 'G':
+		m.move(len(m.items))
+	case e.key == keyRune && (e.r == ' ' || e.r == 'x'):
+		m.toggle()
+	case e.key == keyRune && e.r == 'a':
+		m.toggleAll()
+	case e.key == keyRune && (e.r == 'e' || e.r == 'r'):
+		m.startEdit()
+	case e.key == keyEnter || e.key == keyRune && e.r == 's':
+		if _, files, _ := totals(m.items); files == 0 {
+			m.message = "No files selected"
+			return
+		}
+		m.done, m.started = true, true
+	case e.key == keyEscape || e.key == keyInterrupt || e.key == keyRune && e.r == 'q':
+		m.done = true
+	}
+}
+
+// handleEdit updates the title being edited with a key press
+func (m *model) handleEdit(e event) {
+	switch e.key {
+	case keyRune:
+		m.edit = append(m.edit, e.r)
+	case keyBackspace:
+		if len(m.edit) > 0 {
+			m.edit = m.edit[:len(m.edit)-1]
+		}
+	case keyEnter:
+		title := strings.TrimSpace(string(m.edit))
+		if title == "" {
+			m.message = "The title cannot be empty"
+			return
+		}
+		m.items[m.cursor].Title = title
+		m.editing = false
+	case keyEscape:
+		m.editing = false
+	case keyInterrupt:
+		m.editing = false
+		m.done = true
+	}
+}
+
+// move moves the cursor, scrolling the list to keep it on the screen
+func (m *model) move(n int) {
+	m.cursor += n
+	if m.cursor >= len(m.items) {
+		m.cursor = len(m.items) - 1
+	}
+	if m.cursor < 0 {
+		m.cursor = 0
+	}
+
+	if m.cursor < m.top {
+		m.top = m.cursor
+	}
+	if m.cursor >= m.top+m.rows {
+		m.top = m.cursor - m.rows + 1
+	}
+}
+
+func (m *model) toggle() {
+	item := m.items[m.cursor]
+	if item.Disabled != "" {
+		m.message = "Cannot select: " + item.Disabled
+		return
+	}
+
+	item.Selected = !item.Selected
+}
+
+// toggleAll selects all the albums, or deselects them if all are selected
+func (m *model) toggleAll() {
+	all := true
+	for _, item := range m.items {
+		if item.Disabled == "" && !item.Selected {
+			all = false
+		}
+	}
+
+	for _, item := range m.items {
+		if item.Disabled == "" {
+			item.Selected = !all
+		}
+	}
+}
+
+func (m *model) startEdit() {
+	item := m.items[m.cursor]
+	if !item.Renamable {
+		m.message = "Cannot rename the library"
+		return
+	}
+
+	m.editing = true
+	m.edit = []rune(item.Title)
+}
+
+// totals returns the number of the selected albums, and their files and bytes
+func totals(items []*Item) (albums, files int, bytes int64) {
+	for _, item := range items {
+		if item.Selected {
+			albums++
+			files += item.Files
+			bytes += item.Bytes
+		}
+	}
+
+	return albums, files, bytes
+}
+
+// titleWidth returns the width of the title column
+func titleWidth(items []*Item) int {
+	width := len("Album")
+	for _, item := range items {
+		if n := len([]rune(item.Title)); n > width {
+			width = n
+		}
+	}
+	if width > maxTitleWidth {
+		width = maxTitleWidth
+	}
+
+	return width
+}
+
+// formatItem formats an album as a line of the list
+func formatItem(item *Item, title string, width int) string {
+	check := "[ ]"
+	if item.Selected {
+		check = "[x]"
+	}
+
+	if r := []rune(title); len(r) > width {
+		title = string(r[:width-1]) + "…"
+	}
+
+	date := item.Date
+	if date == "" {
+		date = "-"
+	}
+
+	if item.Disabled != "" {
+		return fmt.Sprintf("%v %-*v  %-10v  %v", check, width, title, date, item.Disabled)
+	}
+
+	return fmt.Sprintf("%v %-*v  %-10v  %5v file(s)  %9v", check, width, title, date,
+		item.Files, util.FormatBytes(item.Bytes))
+}
+
+// render draws the screen; lines end with CRLF as the terminal is in raw mode
+func (m *model) render(w io.Writer) {
+	var b strings.Builder
+
+	// Clear the screen and move the cursor to the top left corner
+	b.WriteString("\x1b[H\x1b[2J")
+
+	albums, files, bytes := totals(m.items)
+	fmt.Fprintf(&b, "Select the albums to upload: %v album(s), %v file(s), %v\r\n\r\n",
+		albums, files, util.FormatBytes(bytes))
+
+	width := titleWidth(m.items)
+	if m.editing && len(m.edit) >= width && width < maxTitleWidth {
+		width = len(m.edit) + 1
+		if width > maxTitleWidth {
+			width = maxTitleWidth
+		}
+	}
+	for i := m.top; i < len(m.items) && i < m.top+m.rows; i++ {
+		item := m.items[i]
+
+		title := item.Title
+		if m.editing && i == m.cursor {
+			// Keep the end of a long title visible while typing
+			edit := append(m.edit, '_')
+			if len(edit) > width {
+				edit = edit[len(edit)-width:]
+			}
+			title = string(edit)
+		}
+
+		marker := "  "
+		if i == m.cursor {
+			marker = "> "
+		}
+		b.WriteString(marker + formatItem(item, title, width) + "\r\n")
+	}
+
+	fmt.Fprintf(&b, "\r\n%v\r\n", m.message)
+	if m.editing {
+		b.WriteString(editHelp)
+	} else {
+		b.WriteString(listHelp)
+	}
+
+	io.WriteString(w, b.String())
+}
+
+// parseKeys parses the key presses of the bytes read from a terminal in raw
+// mode; the escape sequences of a key are read at once.
+func parseKeys(data []byte) []event {
+	events := []event{}
+
+	for s := string(data); s != ""; {
+		switch {
+		case strings.HasPrefix(s, "\x1b[") || strings.HasPrefix(s, "\x1bO"):
+			// CSI or SS3 sequence ending in a letter or '~'
+			end := 2
+			for end < len(s) && (s[end] < 'A' || s[end] > 'Z') && s[end] != '~' {
+				end++
+			}
+			if end == len(s) {
+				return events
+			}
+
+			switch s[2 : end+1] {
+			case "A":
+				events = append(events, event{key: keyUp})
+			case "B":
+				events = append(events, event{key: keyDown})
+			case "H", "1~":
+				events = append(events, event{key: keyHome})
+			case "F", "4~":
+				events = append(events, event{key: keyEnd})
+			case "5~":
+				events = append(events, event{key: keyPageUp})
+			case "6~":
+				events = append(events, event{key: keyPageDown})
+			}
+			s = s[end+1:]
+			continue
+		case s[0] == 0x1b:
+			events = append(events, event{key: keyEscape})
+		case s[0] == '\r' || s[0] == '\n':
+			events = append(events, event{key: keyEnter})
+		case s[0] == 0x7f || s[0] == '\b':
+			events = append(events, event{key: keyBackspace})
+		case s[0] == 0x03 || s[0] == 0x04:
+			events = append(events, event{key: keyInterrupt})
+		case s[0] < 0x20:
+			// Other control characters are ignored
+		default:
+			r, size := utf8.DecodeRuneInString(s)
+			events = append(events, event{key: keyRune, r: r})
+			s = s[size:]
+			continue
+		}
+		s = s[1:]
+	}
+
+	return events
+}
+
+// runInteractive runs the selector on a terminal in raw mode
+func runInteractive(in io.Reader, out io.Writer, items []*Item, height int) (bool, error) {
+	m := newModel(items, height)
+	buf := make([]byte, 64)
+
+	// Hide the cursor while selecting and clear the screen when done
+	io.WriteString(out, "\x1b[?25l")
+	defer io.WriteString(out, "\x1b[H\x1b[2J\x1b[?25h")
+
+	for !m.done {
+		m.render(out)
+
+		n, err := in.Read(buf)
+		if err != nil {
+			if err == io.EOF {
+				return false, nil
+			}
+			return false, fmt.Errorf("failed to read the keyboard: %w", err)
+		}
+
+		for _, e := range parseKeys(buf[:n]) {
+			m.handle(e)
+			if m.done {
+				break
+			}
+		}
+	}
+
+	return m.started, nil
+}
+
+// printItems prints the numbered list of the albums
+func printItems(w io.Writer, items []*Item) {
+	width := titleWidth(items)
+	for i, item := range items {
+		fmt.Fprintf(w, "%3d %v\n", i+1, formatItem(item, item.Title, width))
+	}
+
+	albums, files, bytes := totals(items)
+	fmt.Fprintf(w, "%v album(s) with %v file(s), %v selected.\n", albums, files,
+		util.FormatBytes(bytes))
+}
+
+// Help of the line based selector
+const linesHelp = `Toggle albums by their numbers (eg. '1 3-5'), 'all' or 'none'; rename an
+album with 'r NUMBER TITLE'. Press enter to start or 'q' to quit.
+`
+
+// runLines runs the selector with line based commands, for when there is no
+// terminal; the end of the input cancels.
+func runLines(in io.Reader, out io.Writer, items []*Item) (bool, error) {
+	reader := bufio.NewReader(in)
+
+	printItems(out, items)
+	io.WriteString(out, linesHelp)
+
+	for {
+		io.WriteString(out, "> ")
+		line, err := reader.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			if err == io.EOF {
+				io.WriteString(out, "\n")
+				return false, nil
+			}
+			return false, fmt.Errorf("failed to read the selection: %w", err)
+		}
+
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			if _, files, _ := totals(items); files == 0 {
+				io.WriteString(out, "No files selected\n")
+				continue
+			}
+			return true, nil
+		case line == "q" || line == "quit":
+			return false, nil
+		default:
+			if err := runCommand(items, line); err != nil {
+				fmt.Fprintf(out, "%v\n", err)
+				continue
+			}
+			printItems(out, items)
+		}
+	}
+}
+
+// runCommand runs a command of the line based selector
+func runCommand(items []*Item, line string) error {
+	fields := strings.Fields(line)
+
+	switch fields[0] {
+	case "all", "none":
+		for _, item := range items {
+			if item.Disabled == "" {
+				item.Selected = fields[0] == "all"
+			}
+		}
+		return nil
+	case "r", "rename":
+		if len(fields) < 3 {
+			return fmt.Errorf("usage: r NUMBER TITLE")
+		}
+		i, err := parseNumber(fields[1], len(items))
+		if err != nil {
+			return err
+		}
+		if !items[i].Renamable {
+			return fmt.Errorf("cannot rename the library")
+		}
+		// The title is the rest of the line, keeping its spacing
+		rest := strings.TrimSpace(line[len(fields[0]):])
+		items[i].Title = strings.TrimSpace(rest[len(fields[1]):])
+		return nil
+	}
+
+	// Validate all the numbers before toggling any
+	toggle := []int{}
+	for _, f := range fields {
+		first, last, found := strings.Cut(f, "-")
+		i, err := parseNumber(first, len(items))
+		if err != nil {
+			return err
+		}
+		j := i
+		if found {
+			if j, err = parseNumber(last, len(items)); err != nil {
+				return err
+			}
+		}
+		for ; i <= j; i++ {
+			if items[i].Disabled != "" {
+				return fmt.Errorf("cannot select album %v: %v", i+1, items[i].Disabled)
+			}
+			toggle = append(toggle, i)
+		}
+	}
+
+	for _, i := range toggle {
+		items[i].Selected = !items[i].Selected
+	}
+
+	return nil
+}
+
+// parseNumber parses an album number; returns the index of the album
+func parseNumber(s string, count int) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > count {
+		return 0, fmt.Errorf("invalid album number '%v'", s)
+	}
+
+	return n - 1, nil
+}
@@ -0,0 +1,201 @@
+package selector
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Returns the items of the tests: two albums, a skipped one and the library
+func testItems() []*Item {
+	return []*Item{
+		{Title: "Trip 2019", Date: "2019", Files: 2, Bytes: 3000, Selected: true, Renamable: true},
+		{Title: "Summer", Date: "2020-07", Files: 1, Bytes: 1500000, Selected: true,
+			Renamable: true},
+		{Title: "Undated", Disabled: "skipped: failed to parse the album date", Renamable: true},
+		{Title: "(library)", Files: 3, Bytes: 10, Selected: true},
+	}
+}
+
+// Returns the titles of the selected items
+func selected(items []*Item) []string {
+	titles := []string{}
+	for _, item := range items {
+		if item.Selected {
+			titles = append(titles, item.Title)
+		}
+	}
+
+	return titles
+}
+
+func TestParseKeys(t *testing.T) {
+	tests := map[string][]event{
+		"\x1b[A\x1b[B":  {{key: keyUp}, {key: keyDown}},
+		"\x1bOA":        {{key: keyUp}},
+		"\x1b[5~\x1b[F": {{key: keyPageUp}, {key: keyEnd}},
+		"\x1b":          {{key: keyEscape}},
+		"\r\x7f\x03":    {{key: keyEnter}, {key: keyBackspace}, {key: keyInterrupt}},
+		"aä":            {{key: keyRune, r: 'a'}, {key: keyRune, r: 'ä'}},
+		"\x1b[Z":        {},
+		"\x01":          {},
+	}
+
+	for input, expected := range tests {
+		if events := parseKeys([]byte(input)); !reflect.DeepEqual(events, expected) {
+			t.Errorf("%q: expected %v, got %v", input, expected, events)
+		}
+	}
+}
+
+func TestInteractive(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     string
+		started  bool
+		selected []string
+	}{
+		{name: "start", keys: "\r", started: true,
+			selected: []string{"Trip 2019", "Summer", "(library)"}},
+		{name: "toggle", keys: "j \x1b[B\x1b[B \x1b[Ax\r", started: true,
+			selected: []string{"Trip 2019"}},
+		{name: "toggle disabled", keys: "jj s", started: true,
+			selected: []string{"Trip 2019", "Summer", "(library)"}},
+		{name: "rename", keys: "e\x7f\x7f\x7f\x7fJapan\r\r", started: true,
+			selected: []string{"Trip Japan", "Summer", "(library)"}},
+		{name: "cancel rename", keys: "eXYZ\x1bs", started: true,
+			selected: []string{"Trip 2019", "Summer", "(library)"}},
+		{name: "empty title", keys: "e\x7f\x7f\x7f\x7f\x7f\x7f\x7f\x7f\x7f\r\x1bs", started: true,
+			selected: []string{"Trip 2019", "Summer", "(library)"}},
+		{name: "rename library", keys: "Ger\r", started: true,
+			selected: []string{"Trip 2019", "Summer", "(library)"}},
+		{name: "none selected", keys: "a\raj \r", started: true,
+			selected: []string{"Trip 2019", "(library)"}},
+		{name: "quit", keys: "q", selected: []string{"Trip 2019", "Summer", "(library)"}},
+		{name: "interrupt", keys: "\x03"},
+		{name: "end of input", keys: " "},
+	}
+
+	for _, test := range tests {
+		items := testItems()
+		var out bytes.Buffer
+
+		started, err := runInteractive(strings.NewReader(test.keys), &out, items, 24)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		if started != test.started {
+			t.Errorf("%v: expected started %v, got %v", test.name, test.started, started)
+		}
+		if test.selected != nil && !reflect.DeepEqual(selected(items), test.selected) {
+			t.Errorf("%v: expected %v selected, got %v", test.name, test.selected,
+				selected(items))
+		}
+	}
+}
+
+func TestScroll(t *testing.T) {
+	items := []*Item{}
+	for i := 0; i < 20; i++ {
+		items = append(items, &Item{Title: string(rune('a' + i)), Files: 1, Selected: true})
+	}
+
+	m := newModel(items, 10)
+	for _, test := range []struct {
+		e           event
+		cursor, top int
+	}{
+		{event{key: keyDown}, 1, 0},
+		{event{key: keyPageDown}, 6, 2},
+		{event{key: keyEnd}, 19, 15},
+		{event{key: keyPageUp}, 14, 14},
+		{event{key: keyHome}, 0, 0},
+		{event{key: keyUp}, 0, 0},
+	} {
+		m.handle(test.e)
+		if m.cursor != test.cursor || m.top != test.top {
+			t.Errorf("%v: expected cursor %v and top %v, got %v and %v", test.e,
+				test.cursor, test.top, m.cursor, m.top)
+		}
+	}
+
+	var out bytes.Buffer
+	m.render(&out)
+	if n := strings.Count(out.String(), "[x]"); n != 5 {
+		t.Errorf("expected 5 albums on the screen, got %v:\n%v", n, out.String())
+	}
+}
+
+func TestRender(t *testing.T) {
+	m := newModel(testItems(), 24)
+	m.handle(event{key: keyRune, r: 'j'})
+	m.handle(event{key: keyRune, r: ' '})
+
+	var out bytes.Buffer
+	m.render(&out)
+
+	expected := "\x1b[H\x1b[2J" +
+		"Select the albums to upload: 2 album(s), 5 file(s), 3.0 kB\r\n\r\n" +
+		"  [x] Trip 2019  2019            2 file(s)     3.0 kB\r\n" +
+		"> [ ] Summer     2020-07         1 file(s)     1.5 MB\r\n" +
+		"  [ ] Undated    -           skipped: failed to parse the album date\r\n" +
+		"  [x] (library)  -               3 file(s)       10 B\r\n" +
+		"\r\n\r\n" + listHelp
+	if s := out.String(); s != expected {
+		t.Errorf("expected:\n%q\ngot:\n%q", expected, s)
+	}
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		started  bool
+		selected []string
+		titles   []string
+		output   string
+	}{
+		{name: "start", input: "\n", started: true,
+			selected: []string{"Trip 2019", "Summer", "(library)"}},
+		{name: "toggle", input: "1-2\n4\n1\n\n", started: true, selected: []string{"Trip 2019"}},
+		{name: "none and all", input: "none\n\nall\n\n", started: true,
+			selected: []string{"Trip 2019", "Summer", "(library)"}, output: "No files selected"},
+		{name: "rename", input: "r 2  Summer  in Italy \n\n", started: true,
+			titles: []string{"Trip 2019", "Summer  in Italy", "Undated", "(library)"}},
+		{name: "invalid", input: "5\n1-3\nr 4 Photos\nr 1\nfoo\nq\n",
+			selected: []string{"Trip 2019", "Summer", "(library)"},
+			output:   "cannot select album 3"},
+		{name: "end of input", input: "1\n"},
+	}
+
+	for _, test := range tests {
+		items := testItems()
+		var out bytes.Buffer
+
+		started, err := runLines(strings.NewReader(test.input), &out, items)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		if started != test.started {
+			t.Errorf("%v: expected started %v, got %v", test.name, test.started, started)
+		}
+		if test.selected != nil && !reflect.DeepEqual(selected(items), test.selected) {
+			t.Errorf("%v: expected %v selected, got %v", test.name, test.selected,
+				selected(items))
+		}
+		if test.titles != nil {
+			titles := []string{}
+			for _, item := range items {
+				titles = append(titles, item.Title)
+			}
+			if !reflect.DeepEqual(titles, test.titles) {
+				t.Errorf("%v: expected titles %v, got %v", test.name, test.titles, titles)
+			}
+		}
+		if !strings.Contains(out.String(), test.output) {
+			t.Errorf("%v: expected output to contain '%v', got:\n%v", test.name,
+				test.output, out.String())
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package selector
+
+import "errors"
+
+// makeRaw is not supported on this platform; the line based selector is used
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("raw terminal mode not supported")
+}
+
+// terminalHeight returns the default number of rows
+func terminalHeight(fd int) int {
+	return defaultHeight
+}
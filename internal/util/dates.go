@@ -0,0 +1,335 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DatePrecision tells how precisely an album date is known.
+type DatePrecision int
+
+const (
+	PrecisionYear DatePrecision = iota
+	PrecisionMonth
+	PrecisionDay
+)
+
+func (p DatePrecision) String() string {
+	switch p {
+	case PrecisionMonth:
+		return "month"
+	case PrecisionDay:
+		return "day"
+	}
+
+	return "year"
+}
+
+// AlbumDate is a date parsed from a directory name, along with its precision.
+// Until is the last day of a date range of several days, eg. an event.
+type AlbumDate struct {
+	Time      time.Time
+	Precision DatePrecision
+	Until     time.Time
+}
+
+var (
+	ErrCouldNotParseDate = errors.Errorf("failed to parse the album date")
+)
+
+// datePattern is a regex with named groups 'year' and optionally 'month'
+// (numeric or a month name), 'day' and 'endyear'.
+type datePattern struct {
+	re *regexp.Regexp
+}
+
+// Delimiters around a date; any character that is not a letter or a digit
+const (
+	dateStart = `(?:^|[^\p{L}\d])`
+	dateEnd   = `(?:$|[^\p{L}\d])`
+)
+
+var (
+	// Date patterns added by the user; these are tried first
+	userDatePatterns []*datePattern
+
+	// Built-in date patterns, from the most precise to the least precise
+	builtinDatePatterns []*datePattern
+
+	// Month names (lower case) to month numbers
+	monthNames = map[string]time.Month{}
+)
+
+// Month names in the supported languages, starting from January
+var localizedMonthNames = [][]string{
+	// English
+	{"january", "february", "march", "april", "may", "june", "july",
+		"august", "september", "october", "november", "december"},
+	{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"},
+	// Finnish
+	{"tammikuu", "helmikuu", "maaliskuu", "huhtikuu", "toukokuu", "kesäkuu",
+		"heinäkuu", "elokuu", "syyskuu", "lokakuu", "marraskuu", "joulukuu"},
+	// Swedish
+	{"januari", "februari", "mars", "april", "maj", "juni", "juli",
+		"augusti", "september", "oktober", "november", "december"},
+	// German
+	{"januar", "februar", "märz", "april", "mai", "juni", "juli",
+		"august", "september", "oktober", "november", "dezember"},
+}
+
+// newDatePattern compiles a date pattern; the pattern must contain
+// at least a named group 'year'.
+func newDatePattern(expr string) (*datePattern, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid date pattern '%v'", expr)
+	}
+
+	if re.SubexpIndex("year") < 0 {
+		return nil, errors.Errorf("date pattern '%v' lacks a named group 'year'", expr)
+	}
+
+	return &datePattern{re: re}, nil
+}
+
+// AddDatePatterns adds user supplied regular expressions for parsing album
+// dates. The expressions must contain a named group 'year' and may contain
+// named groups 'month' (number or name) and 'day'. The user supplied patterns
+// take precedence over the built-in ones.
+func AddDatePatterns(exprs []string) error {
+	for _, expr := range exprs {
+		p, err := newDatePattern(expr)
+		if err != nil {
+			return err
+		}
+		userDatePatterns = append(userDatePatterns, p)
+	}
+
+	return nil
+}
+
+// parseMonth parses a month number or name.
+func parseMonth(s string) (time.Month, bool) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 1 || n > 12 {
+			return 0, false
+		}
+		return time.Month(n), true
+	}
+
+	m, ok := monthNames[strings.ToLower(s)]
+
+	return m, ok
+}
+
+// Earliest album year; the year of the first photograph
+const minAlbumYear = 1826
+
+// isAlbumYear tells whether a year is a plausible album year, ie. not a room
+// or an image number
+func isAlbumYear(year int) bool {
+	return year >= minAlbumYear && year <= time.Now().Year()+1
+}
+
+// match tries to match the pattern against a string. If the first match has
+// an invalid year, the later ones are tried, eg. 'Room 1234 - 2019'.
+func (p *datePattern) match(s string) (AlbumDate, bool) {
+	yearIndex := p.re.SubexpIndex("year")
+
+	for _, m := range p.re.FindAllStringSubmatchIndex(s, -1) {
+		start, end := m[2*yearIndex], m[2*yearIndex+1]
+		if start < 0 {
+			continue
+		}
+
+		year, err := strconv.Atoi(s[start:end])
+		if err == nil && isAlbumYear(year) {
+			return p.date(s, m)
+		}
+	}
+
+	return AlbumDate{}, false
+}
+
+// date returns the date of a match of the pattern with a valid year.
+func (p *datePattern) date(s string, m []int) (AlbumDate, bool) {
+	group := func(name string) string {
+		if i := p.re.SubexpIndex(name); i >= 0 && m[2*i] >= 0 {
+			return s[m[2*i]:m[2*i+1]]
+		}
+		return ""
+	}
+
+	year, _ := strconv.Atoi(group("year"))
+
+	if endYear := group("endyear"); endYear != "" {
+		if e, err := strconv.Atoi(endYear); err != nil || e < year {
+			return AlbumDate{}, false
+		}
+	}
+
+	precision := PrecisionYear
+	month := time.January
+	day := 1
+
+	if s := group("month"); s != "" {
+		var ok bool
+		if month, ok = parseMonth(s); !ok {
+			return AlbumDate{}, false
+		}
+		precision = PrecisionMonth
+	}
+
+	if s := group("day"); s != "" {
+		d, err := strconv.Atoi(s)
+		if err != nil || d < 1 || d > 31 {
+			return AlbumDate{}, false
+		}
+		day = d
+		precision = PrecisionDay
+	}
+
+	t := time.Date(year, month, day, 10, 10, 10, 0, time.UTC)
+	if t.Day() != day {
+		// Invalid day of month, eg. February 30
+		return AlbumDate{}, false
+	}
+	if precision == PrecisionYear {
+		// Keep the arbitrary date historically used for year-only albums
+		t = time.Date(year, time.January, 10, 10, 10, 10, 0, time.UTC)
+	}
+
+	return AlbumDate{Time: t, Precision: precision}, true
+}
+
+// ParseAlbumDate parses the album date from a string (directory name). It
+// recognizes full dates ('2019-06-14', '20190614', '14.6.2019'), year and
+// month ('2019-06', '06/2019', 'June 2019', 'kesäkuu 2019'), year ranges
+// ('2018-2019', using the first year) and plain years ('Trip to X - 2009'),
+// anywhere in the string. Years before 1826 or after the next year are not album
+// years ('Room 1234'). Returns ErrCouldNotParseDate if not found.
+func ParseAlbumDate(s string) (AlbumDate, error) {
+	for _, patterns := range [][]*datePattern{userDatePatterns, builtinDatePatterns} {
+		for _, p := range patterns {
+			if d, ok := p.match(s); ok {
+				return d, nil
+			}
+		}
+	}
+
+	return AlbumDate{}, ErrCouldNotParseDate
+}
+
+// IsZero tells whether the date is unset.
+func (d AlbumDate) IsZero() bool {
+	return d.Time.IsZero()
+}
+
+// Contains tells whether the time t falls within the date at its precision,
+// ie. within the same year, month or day, or within the days of a range.
+func (d AlbumDate) Contains(t time.Time) bool {
+	if !d.Until.IsZero() {
+		day := calendarDay(t)
+		return !day.Before(calendarDay(d.Time)) && !day.After(calendarDay(d.Until))
+	}
+
+	switch d.Precision {
+	case PrecisionDay:
+		return t.Year() == d.Time.Year() && t.YearDay() == d.Time.YearDay()
+	case PrecisionMonth:
+		return t.Year() == d.Time.Year() && t.Month() == d.Time.Month()
+	}
+
+	return t.Year() == d.Time.Year()
+}
+
+// End returns the last day within the date at its precision, eg. 2019-12-31
+// for the year 2019, or the last day of a range.
+func (d AlbumDate) End() time.Time {
+	if !d.Until.IsZero() {
+		return d.Until
+	}
+
+	switch d.Precision {
+	case PrecisionDay:
+		return d.Time
+	case PrecisionMonth:
+		return time.Date(d.Time.Year(), d.Time.Month()+1, 0, 0, 0, 0, 0, d.Time.Location())
+	}
+
+	return time.Date(d.Time.Year(), time.December, 31, 0, 0, 0, 0, d.Time.Location())
+}
+
+// calendarDay returns the calendar day of a time in its own timezone.
+func calendarDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func (d AlbumDate) String() string {
+	switch d.Precision {
+	case PrecisionDay:
+		return d.Time.Format("2006-01-02")
+	case PrecisionMonth:
+		return d.Time.Format("2006-01")
+	}
+
+	return d.Time.Format("2006")
+}
+
+func init() {
+	names := []string{}
+	for _, lang := range localizedMonthNames {
+		for i, n := range lang {
+			monthNames[n] = time.Month(i + 1)
+			names = append(names, regexp.QuoteMeta(n))
+		}
+	}
+	// Match the longest month names first
+	sort.SliceStable(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	monthName := fmt.Sprintf(`(?i:%v)`, strings.Join(names, "|"))
+
+	const (
+		year  = `(?P<year>[12]\d{3})`
+		month = `(?P<month>0?[1-9]|1[0-2])`
+		day   = `(?P<day>0?[1-9]|[12]\d|3[01])`
+		sep   = `[-_. ]`
+	)
+
+	exprs := []string{
+		// 2019-06-14, 2019_06_14, 2019.06.14
+		dateStart + year + sep + month + sep + day + dateEnd,
+		// 20190614
+		dateStart + `(?P<year>[12]\d{3})(?P<month>0[1-9]|1[0-2])(?P<day>0[1-9]|[12]\d|3[01])` +
+			`(?:$|[^\d])`,
+		// 14.6.2019, 14-06-2019
+		dateStart + day + `[-.]` + month + `[-.]` + year + dateEnd,
+		// 14 June 2019, 14. kesäkuuta 2019
+		dateStart + day + `\.? ?(?P<month>` + monthName + `)(?:ta)? ` + year + dateEnd,
+		// 2018-2019
+		dateStart + year + `-(?P<endyear>[12]\d{3})` + dateEnd,
+		// 2019-06, 2019_06
+		dateStart + year + `[-_.]` + month + dateEnd,
+		// 06/2019, 06-2019
+		dateStart + month + `[-/.]` + year + dateEnd,
+		// June 2019, kesäkuu 2019
+		dateStart + `(?P<month>` + monthName + `)(?:ta)?,? ` + year + dateEnd,
+		// 2019 June
+		dateStart + year + ` (?P<month>` + monthName + `)` + dateEnd,
+		// 2019
+		dateStart + year + dateEnd,
+	}
+
+	for _, expr := range exprs {
+		p, err := newDatePattern(expr)
+		if err != nil {
+			panic(err)
+		}
+		builtinDatePatterns = append(builtinDatePatterns, p)
+	}
+}
@@ -0,0 +1,207 @@
+package util
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// filenamePattern is a regex for the time in a file name, with named groups
+// 'year', 'month' and 'day' and optionally 'hour', 'minute', 'second' and
+// 'ampm' (AM / PM), or a group 'unix' for a Unix timestamp in seconds.
+type filenamePattern struct {
+	re *regexp.Regexp
+
+	// Whether the time is in UTC instead of the local timezone
+	utc bool
+}
+
+var (
+	// File name patterns added by the user; these are tried first
+	userFilenamePatterns []*filenamePattern
+
+	// Built-in file name patterns
+	builtinFilenamePatterns []*filenamePattern
+)
+
+// newFilenamePattern compiles a file name pattern; the pattern must contain
+// the named groups 'year', 'month' and 'day', or 'unix'.
+func newFilenamePattern(expr string, utc bool) (*filenamePattern, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid file name pattern '%v'", expr)
+	}
+
+	if re.SubexpIndex("unix") < 0 {
+		for _, group := range []string{"year", "month", "day"} {
+			if re.SubexpIndex(group) < 0 {
+				return nil, errors.Errorf("file name pattern '%v' lacks a named group '%v'",
+					expr, group)
+			}
+		}
+	}
+
+	return &filenamePattern{re: re, utc: utc}, nil
+}
+
+// AddFilenamePatterns adds user supplied regular expressions for parsing the
+// time a photo was taken from its file name. The expressions must contain
+// the named groups 'year', 'month' and 'day' and may contain 'hour',
+// 'minute', 'second' and 'ampm'; or a group 'unix' for a Unix timestamp. The
+// user supplied patterns take precedence over the built-in ones.
+func AddFilenamePatterns(exprs []string) error {
+	for _, expr := range exprs {
+		p, err := newFilenamePattern(expr, false)
+		if err != nil {
+			return err
+		}
+		userFilenamePatterns = append(userFilenamePatterns, p)
+	}
+
+	return nil
+}
+
+// match tries to match the pattern against a file name; the time is returned
+// in the given location.
+func (p *filenamePattern) match(name string, loc *time.Location) (time.Time, bool) {
+	m := p.re.FindStringSubmatch(name)
+	if m == nil {
+		return time.Time{}, false
+	}
+
+	group := func(name string) string {
+		if i := p.re.SubexpIndex(name); i >= 0 {
+			return m[i]
+		}
+		return ""
+	}
+
+	if s := group("unix"); s != "" {
+		secs, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(secs, 0).In(loc), true
+	}
+
+	// Numeric groups; -1 if the group is missing and def is -1
+	number := func(name string, def, min, max int) (int, bool) {
+		s := group(name)
+		if s == "" {
+			return def, def >= 0
+		}
+		n, err := strconv.Atoi(s)
+
+		return n, err == nil && n >= min && n <= max
+	}
+
+	year, ok1 := number("year", -1, 1900, 2999)
+	month, ok2 := number("month", -1, 1, 12)
+	day, ok3 := number("day", -1, 1, 31)
+	// Dates without a time are set to midday so that they stay on the same
+	// day in nearby timezones
+	hour, ok4 := number("hour", 12, 0, 23)
+	minute, ok5 := number("minute", 0, 0, 59)
+	second, ok6 := number("second", 0, 0, 59)
+	if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 || !ok6 {
+		return time.Time{}, false
+	}
+
+	switch strings.ToUpper(group("ampm")) {
+	case "AM":
+		if hour == 12 {
+			hour = 0
+		}
+	case "PM":
+		if hour < 12 {
+			hour += 12
+		}
+	}
+
+	in := loc
+	if p.utc {
+		in = time.UTC
+	}
+
+	t := time.Date(year, time.Month(month), day, hour, minute, second, 0, in)
+	if t.Day() != day {
+		// Invalid day of month, eg. February 30
+		return time.Time{}, false
+	}
+
+	return t.In(loc), true
+}
+
+// ParseFilenameTime parses the time a photo was taken from its file name, eg.
+// 'IMG-20190614-WA0003.jpg' (WhatsApp), 'Screenshot_20200101-101010.png',
+// 'PXL_20210305_101112345.jpg' (Pixel phones, in UTC) or
+// 'IMG_20190614_101112.jpg'. Dates without a time are set to midday. The
+// times in the file names are taken to be in the given location, except for
+// the UTC and Unix times which are converted into it. Returns false if not
+// found.
+func ParseFilenameTime(name string, loc *time.Location) (time.Time, bool) {
+	for _, patterns := range [][]*filenamePattern{userFilenamePatterns,
+		builtinFilenamePatterns} {
+
+		for _, p := range patterns {
+			if t, ok := p.match(name, loc); ok {
+				return t, true
+			}
+		}
+	}
+
+	return time.Time{}, false
+}
+
+func init() {
+	const (
+		year     = `(?P<year>(?:19|20)\d{2})`
+		month    = `(?P<month>0[1-9]|1[0-2])`
+		day      = `(?P<day>0[1-9]|[12]\d|3[01])`
+		hour     = `(?P<hour>[01]\d|2[0-3])`
+		hour12   = `(?P<hour>0?[1-9]|1[0-2])`
+		minute   = `(?P<minute>[0-5]\d)`
+		second   = `(?P<second>[0-5]\d)`
+		start    = `(?:^|[^\d])`
+		end      = `(?:$|[^\d])`
+		date     = year + month + day
+		dashDate = year + `-` + month + `-` + day
+	)
+
+	patterns := []struct {
+		expr string
+		utc  bool
+	}{
+		// PXL_20210305_101112345.jpg; Pixel phones name the files in UTC
+		{expr: `^PXL_` + date + `_` + hour + minute + second, utc: true},
+		// IMG_20190614_101112.jpg, Screenshot_20200101-101010.png,
+		// 20190614_101112.jpg, VID_20190614_101112_HDR.mp4
+		{expr: start + date + `[_-]` + hour + minute + second + end},
+		// Screenshot 2020-01-01 at 10.10.10.png, Screen Shot 2020-01-01 at 1.10.10 PM.png
+		// (macOS)
+		{expr: dashDate + ` at ` + hour12 + `\.` + minute + `\.` + second +
+			`(?:\s?(?P<ampm>[AaPp][Mm]))?`},
+		// 2019-06-14 10.11.12.jpg (Dropbox), Screenshot_2020-01-01-10-10-10.png,
+		// signal-2020-01-01-101010.jpg, photo_2020-01-01_10-10-10.jpg (Telegram)
+		{expr: start + dashDate + `[ _-]` + hour + `[.-]?` + minute + `[.-]?` + second + end},
+		// IMG-20190614-WA0003.jpg (WhatsApp)
+		{expr: `^(?:IMG|VID|AUD|PTT|STK)-` + date + `-WA\d+`},
+		// 1560507072.jpg, 1560507072123.jpg; Unix timestamps in seconds or
+		// milliseconds
+		{expr: `^(?P<unix>1\d{9})(?:\d{3})?(?:$|[^\d])`},
+		// 20190614.jpg, DSC_2019-06-14.jpg
+		{expr: start + date + end},
+		{expr: start + dashDate + end},
+	}
+
+	for _, p := range patterns {
+		fp, err := newFilenamePattern(p.expr, p.utc)
+		if err != nil {
+			panic(err)
+		}
+		builtinFilenamePatterns = append(builtinFilenamePatterns, fp)
+	}
+}
@@ -148,3 +148,11 @@ func (q *OperationQueue) Add(op func()) error {
 
 	return nil
 }
+
+// Len returns the number of operations queued or in progress
+func (q *OperationQueue) Len() int {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+
+	return int(q.itemsLeft)
+}
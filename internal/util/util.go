@@ -5,8 +5,6 @@ import (
 	"encoding/csv"
 	"fmt"
 	"os"
-	"regexp"
-	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -18,8 +16,6 @@ import (
 var (
 	log      = logging.MustGetLogger()
 	settings = config.MustGetSettings()
-
-	albumYearRegex *regexp.Regexp
 )
 
 var (
@@ -84,14 +80,14 @@ func ReplaceInString(s, tokens string) (string, error) {
 
 // Chunked returns an array of arrays so that the original array is divided
 // into chunks of equal size (except for the remainder chunk).
-func Chunked(arr []string, chunkSize int) [][]string {
+func Chunked[T any](arr []T, chunkSize int) [][]T {
 	arrayLen := len(arr)
 	numChunks := arrayLen / chunkSize
 	if arrayLen%chunkSize > 0 {
 		numChunks++
 	}
 
-	chunks := make([][]string, 0, numChunks)
+	chunks := make([][]T, 0, numChunks)
 
 	for i := 0; i < arrayLen; i += chunkSize {
 		chunkEnd := i + chunkSize
@@ -105,28 +101,86 @@ func Chunked(arr []string, chunkSize int) [][]string {
 }
 
 // ParseAlbumYear tries to parse the year of the album from a string
-// (directory name) using a certain regex pattern (eg. 'Trip to X - 2009')
+// (directory name) (eg. 'Trip to X - 2009'); see ParseAlbumDate.
 // Returns ErrCouldNotParseYear if not found.
 func ParseAlbumYear(dirName string) (int, error) {
-	res := albumYearRegex.FindStringSubmatch(dirName)
-
-	if len(res) != 2 {
+	d, err := ParseAlbumDate(dirName)
+	if err != nil {
 		return 0, ErrCouldNotParseYear
 	}
 
-	if res[1] == "" {
-		return 0, ErrCouldNotParseYear
+	return d.Time.Year(), nil
+}
+
+// NaturalLess compares two strings in natural order: runs of digits are
+// compared by their numeric value and the rest case insensitively, so that
+// 'IMG_2.jpg' sorts before 'IMG_10.jpg'.
+func NaturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		ca, cb := a[0], b[0]
+		if isDigit(ca) && isDigit(cb) {
+			na, nb := digitPrefix(a), digitPrefix(b)
+
+			// Compare the numbers without their leading zeros by length first
+			ta, tb := strings.TrimLeft(na, "0"), strings.TrimLeft(nb, "0")
+			if len(ta) != len(tb) {
+				return len(ta) < len(tb)
+			}
+			if ta != tb {
+				return ta < tb
+			}
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+
+			a, b = a[len(na):], b[len(nb):]
+			continue
+		}
+
+		la, lb := lower(ca), lower(cb)
+		if la != lb {
+			return la < lb
+		}
+		a, b = a[1:], b[1:]
 	}
 
-	year, err := strconv.Atoi(res[1])
-	if err != nil {
-		log.Fatalf("failed to parse year string %v to int: %v", res[1], err)
-		return 0, ErrCouldNotParseYear
+	return len(a) < len(b)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func lower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
 	}
 
-	return year, nil
+	return c
 }
 
-func init() {
-	albumYearRegex = regexp.MustCompile(`^.+[-_ ]([12]\d{3})$`)
+// digitPrefix returns the leading digits of the string
+func digitPrefix(s string) string {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+
+	return s[:i]
+}
+
+// FormatBytes formats a byte count, eg. 1.5 MB
+func FormatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%v B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
 }
@@ -3,6 +3,7 @@ package util
 import (
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestReplaceInString(t *testing.T) {
@@ -78,6 +79,41 @@ func TestChunked(t *testing.T) {
 	}
 }
 
+func TestFormatBytes(t *testing.T) {
+	tests := map[int64]string{0: "0 B", 999: "999 B", 1000: "1.0 kB", 1500000: "1.5 MB",
+		2500000000: "2.5 GB"}
+
+	for n, expected := range tests {
+		if s := FormatBytes(n); s != expected {
+			t.Errorf("%v: expected %v, got %v", n, expected, s)
+		}
+	}
+}
+
+func TestNaturalLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		less bool
+	}{
+		{"IMG_2.jpg", "IMG_10.jpg", true},
+		{"IMG_10.jpg", "IMG_2.jpg", false},
+		{"img_1.jpg", "IMG_2.jpg", true},
+		{"IMG_002.jpg", "IMG_3.jpg", true},
+		{"IMG_02.jpg", "IMG_002.jpg", true},
+		{"IMG_1.jpg", "IMG_1.jpg", false},
+		{"IMG_1", "IMG_1a", true},
+		{"a10b2", "a10b10", true},
+		{"DSC_9999.jpg", "IMG_0001.jpg", true},
+		{"12345678901234567890.jpg", "12345678901234567891.jpg", true},
+	}
+
+	for _, tt := range tests {
+		if less := NaturalLess(tt.a, tt.b); less != tt.less {
+			t.Errorf("%v < %v: expected %v, got %v", tt.a, tt.b, tt.less, less)
+		}
+	}
+}
+
 func TestParseAlbumYear(t *testing.T) {
 	var year int
 	var err error
@@ -117,3 +153,231 @@ func TestParseAlbumYear(t *testing.T) {
 		t.Errorf("failed to parse album year")
 	}
 }
+
+func TestParseAlbumDate(t *testing.T) {
+	tests := []struct {
+		name      string
+		expected  string
+		precision DatePrecision
+	}{
+		{"Foo bar - 2008", "2008-01-10", PrecisionYear},
+		{"2019-06 Lapland", "2019-06-01", PrecisionMonth},
+		{"Kesä 2018 (Hanko)", "2018-01-10", PrecisionYear},
+		{"20190614_wedding", "2019-06-14", PrecisionDay},
+		{"2019-06-14 Midsummer", "2019-06-14", PrecisionDay},
+		{"Juhannus 21.6.2019", "2019-06-21", PrecisionDay},
+		{"Skiing 2018-2019", "2018-01-10", PrecisionYear},
+		{"June 2019 - Paris", "2019-06-01", PrecisionMonth},
+		{"Kesäkuu 2019", "2019-06-01", PrecisionMonth},
+		{"Mökki 14. heinäkuuta 2020", "2020-07-14", PrecisionDay},
+		{"Trip 06/2017", "2017-06-01", PrecisionMonth},
+		{"2021 March roadtrip", "2021-03-01", PrecisionMonth},
+		{"Mayor visit 2019", "2019-01-10", PrecisionYear},
+		{"Birthday 2019-02-30", "2019-02-01", PrecisionMonth},
+		{"Room 1234 - 2019", "2019-01-10", PrecisionYear},
+	}
+
+	for _, tt := range tests {
+		d, err := ParseAlbumDate(tt.name)
+		if err != nil {
+			t.Errorf("%v: failed to parse album date: %v", tt.name, err)
+			continue
+		}
+
+		if d.Time.Format("2006-01-02") != tt.expected || d.Precision != tt.precision {
+			t.Errorf("%v: expected %v (%v), got %v (%v)", tt.name, tt.expected,
+				tt.precision, d.Time.Format("2006-01-02"), d.Precision)
+		}
+	}
+
+	for _, name := range []string{"Does Not Match2011", "Summer", "Trip - 0211",
+		"IMG12345", "Room 1234", "IMG 2048", "Battle of 1700"} {
+		if _, err := ParseAlbumDate(name); err == nil {
+			t.Errorf("%v: should have failed to parse album date", name)
+		}
+	}
+}
+
+func TestAddDatePatterns(t *testing.T) {
+	defer func() { userDatePatterns = nil }()
+
+	if err := AddDatePatterns([]string{`no year`}); err == nil {
+		t.Errorf("should have failed to add pattern without year group")
+	}
+
+	if err := AddDatePatterns([]string{`^(?P<day>\d\d)(?P<month>\d\d)(?P<year>\d{4})$`}); err != nil {
+		t.Fatalf("failed to add date pattern: %v", err)
+	}
+
+	d, err := ParseAlbumDate("14062019")
+	if err != nil || d.Time.Format("2006-01-02") != "2019-06-14" || d.Precision != PrecisionDay {
+		t.Errorf("failed to parse album date with user pattern: %v, %v", d, err)
+	}
+
+	// The matches with an invalid year are skipped
+	userDatePatterns = nil
+	if err := AddDatePatterns([]string{`(?P<year>\w{4})`}); err != nil {
+		t.Fatalf("failed to add date pattern: %v", err)
+	}
+	d, err = ParseAlbumDate("Room 1234 2019")
+	if err != nil || d.Time.Year() != 2019 || d.Precision != PrecisionYear {
+		t.Errorf("failed to parse album date with user pattern: %v, %v", d, err)
+	}
+	if _, err := ParseAlbumDate("Room 1234"); err == nil {
+		t.Errorf("should have failed to parse album date without a valid year")
+	}
+}
+
+func TestAlbumDateEnd(t *testing.T) {
+	tests := map[string]string{
+		"2019-06-14": "2019-06-14",
+		"2020-02":    "2020-02-29",
+		"2019":       "2019-12-31",
+	}
+
+	for s, expected := range tests {
+		d, err := ParseAlbumDate(s)
+		if err != nil {
+			t.Fatalf("failed to parse %v: %v", s, err)
+		}
+		if end := d.End().Format("2006-01-02"); end != expected {
+			t.Errorf("end of %v: expected %v, got %v", s, expected, end)
+		}
+	}
+
+	r := AlbumDate{Time: time.Date(2019, 6, 14, 20, 0, 0, 0, time.UTC),
+		Precision: PrecisionDay, Until: time.Date(2019, 6, 15, 12, 0, 0, 0, time.UTC)}
+	if end := r.End().Format("2006-01-02"); end != "2019-06-15" {
+		t.Errorf("end of %v: expected 2019-06-15, got %v", r, end)
+	}
+	for _, tt := range []struct {
+		t        time.Time
+		expected bool
+	}{
+		{time.Date(2019, 6, 14, 0, 0, 0, 0, time.UTC), true},
+		{time.Date(2019, 6, 15, 23, 0, 0, 0, time.UTC), true},
+		{time.Date(2019, 6, 16, 4, 0, 0, 0, time.UTC), false},
+		{time.Date(2019, 6, 13, 23, 0, 0, 0, time.UTC), false},
+	} {
+		if r.Contains(tt.t) != tt.expected {
+			t.Errorf("%v contains %v: expected %v", r, tt.t, tt.expected)
+		}
+	}
+}
+
+func TestParseFilenameTime(t *testing.T) {
+	local := func(s string) string {
+		d, err := time.ParseInLocation("2006-01-02 15:04:05", s, time.Local)
+		if err != nil {
+			t.Fatalf("invalid time %v: %v", s, err)
+		}
+		return d.Format(time.RFC3339)
+	}
+	utc := func(s string) string {
+		d, err := time.Parse("2006-01-02 15:04:05", s)
+		if err != nil {
+			t.Fatalf("invalid time %v: %v", s, err)
+		}
+		return d.In(time.Local).Format(time.RFC3339)
+	}
+
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"IMG-20190614-WA0003.jpg", local("2019-06-14 12:00:00")},
+		{"VID-20181231-WA0012.mp4", local("2018-12-31 12:00:00")},
+		{"Screenshot_20200101-101010.png", local("2020-01-01 10:10:10")},
+		{"Screenshot_20200101_101010_com.whatsapp.jpg", local("2020-01-01 10:10:10")},
+		{"Screenshot_2020-01-01-10-10-10.png", local("2020-01-01 10:10:10")},
+		{"Screenshot 2020-01-01 at 10.10.10.png", local("2020-01-01 10:10:10")},
+		{"Screen Shot 2020-01-01 at 1.10.10 PM.png", local("2020-01-01 13:10:10")},
+		{"Screen Shot 2020-01-01 at 12.10.10 AM.png", local("2020-01-01 00:10:10")},
+		{"PXL_20210305_101112345.jpg", utc("2021-03-05 10:11:12")},
+		{"PXL_20210305_235959123.NIGHT.jpg", utc("2021-03-05 23:59:59")},
+		{"IMG_20190614_101112.jpg", local("2019-06-14 10:11:12")},
+		{"IMG_20190614_101112_HDR.jpg", local("2019-06-14 10:11:12")},
+		{"MVIMG_20190614_101112.jpg", local("2019-06-14 10:11:12")},
+		{"20190614_101112.jpg", local("2019-06-14 10:11:12")},
+		{"2019-06-14 10.11.12.jpg", local("2019-06-14 10:11:12")},
+		{"signal-2020-01-01-101010.jpg", local("2020-01-01 10:10:10")},
+		{"signal-2020-01-01-10-10-10-123.jpg", local("2020-01-01 10:10:10")},
+		{"photo_2020-01-01_10-10-10.jpg", local("2020-01-01 10:10:10")},
+		{"1560507072.jpg", time.Unix(1560507072, 0).Format(time.RFC3339)},
+		{"1560507072123.jpg", time.Unix(1560507072, 0).Format(time.RFC3339)},
+		{"DSC_20190614.jpg", local("2019-06-14 12:00:00")},
+		{"scan 2019-06-14.jpg", local("2019-06-14 12:00:00")},
+		{"IMG_20190614_251112.jpg", local("2019-06-14 12:00:00")},
+	}
+
+	for _, tt := range tests {
+		d, ok := ParseFilenameTime(tt.name, time.Local)
+		if !ok {
+			t.Errorf("%v: failed to parse time", tt.name)
+			continue
+		}
+
+		if d.Format(time.RFC3339) != tt.expected {
+			t.Errorf("%v: expected %v, got %v", tt.name, tt.expected, d.Format(time.RFC3339))
+		}
+	}
+
+	for _, name := range []string{"IMG_1234.jpg", "DSC01234.JPG", "IMG-20190231-WA0001.jpg",
+		"P1010001.JPG", "12345678.jpg", "IMG_20191314_101112.jpg", "2x2.jpg",
+		"Screenshot_2020-13-01-10-10-10.png"} {
+
+		if d, ok := ParseFilenameTime(name, time.Local); ok {
+			t.Errorf("%v: should have failed to parse time, got %v", name, d)
+		}
+	}
+}
+
+func TestParseFilenameTimeLocation(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+
+	tests := map[string]time.Time{
+		"IMG_20190614_101112.jpg":    time.Date(2019, 6, 14, 10, 11, 12, 0, tokyo),
+		"PXL_20210305_101112345.jpg": time.Date(2021, 3, 5, 19, 11, 12, 0, tokyo),
+		"1560507072.jpg":             time.Unix(1560507072, 0),
+	}
+
+	for name, expected := range tests {
+		d, ok := ParseFilenameTime(name, tokyo)
+		if !ok || !d.Equal(expected) || d.Location() != tokyo {
+			t.Errorf("%v: expected %v, got %v (%v)", name, expected, d, ok)
+		}
+	}
+}
+
+func TestAddFilenamePatterns(t *testing.T) {
+	defer func() { userFilenamePatterns = nil }()
+
+	if err := AddFilenamePatterns([]string{`^(?P<year>\d{4})(?P<month>\d\d)`}); err == nil {
+		t.Errorf("should have failed to add pattern without day group")
+	}
+	if err := AddFilenamePatterns([]string{`(?P<unix>[`}); err == nil {
+		t.Errorf("should have failed to add invalid pattern")
+	}
+
+	if err := AddFilenamePatterns([]string{
+		`^scan-(?P<day>\d\d)(?P<month>\d\d)(?P<year>\d{4})-(?P<hour>\d\d)(?P<minute>\d\d)`,
+		`^fb_(?P<unix>\d+)_`,
+	}); err != nil {
+		t.Fatalf("failed to add file name patterns: %v", err)
+	}
+
+	tests := map[string]time.Time{
+		"scan-14062019-1011.jpg": time.Date(2019, 6, 14, 10, 11, 0, 0, time.Local),
+		"fb_1560507072_n.jpg":    time.Unix(1560507072, 0),
+		// The user patterns take precedence
+		"scan-14062019-1011_20200101_101010.jpg": time.Date(2019, 6, 14, 10, 11, 0, 0,
+			time.Local),
+	}
+
+	for name, expected := range tests {
+		d, ok := ParseFilenameTime(name, time.Local)
+		if !ok || !d.Equal(expected) {
+			t.Errorf("%v: expected %v, got %v (%v)", name, expected, d, ok)
+		}
+	}
+}
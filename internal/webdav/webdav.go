@@ -0,0 +1,493 @@
+// Package webdav implements an upload destination on a WebDAV server (eg.
+// Nextcloud). Albums are collections (directories) under the base URL; files
+// are uploaded into a staging collection and moved into the album collection
+// when added to an album.
+package webdav
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+
+	photos "github.com/matti777/google-photos-uploader/internal/googlephotos"
+	"github.com/matti777/google-photos-uploader/internal/logging"
+)
+
+// Name of the staging collection for the uploaded files
+const uploadsCollection = ".uploads"
+
+// Config holds the connection parameters of the WebDAV server
+type Config struct {
+	// URL of the base collection, eg.
+	// https://cloud.example.com/remote.php/dav/files/matti/Photos
+	URL string
+
+	Username string
+	Password string
+}
+
+// Client is the WebDAV client; implements photos.Client.
+type Client struct {
+	config     Config
+	base       *url.URL
+	httpClient *http.Client
+}
+
+var _ photos.Client = (*Client)(nil)
+
+var log = logging.MustGetLogger()
+
+// resource is a resource in a PROPFIND response
+type resource struct {
+	Href     string `xml:"href"`
+	Propstat []struct {
+		Prop struct {
+			ResourceType struct {
+				Collection *struct{} `xml:"collection"`
+			} `xml:"resourcetype"`
+			ContentLength int64  `xml:"getcontentlength"`
+			ContentType   string `xml:"getcontenttype"`
+			LastModified  string `xml:"getlastmodified"`
+		} `xml:"prop"`
+		Status string `xml:"status"`
+	} `xml:"propstat"`
+}
+
+// multistatus is a PROPFIND response
+type multistatus struct {
+	Responses []*resource `xml:"response"`
+}
+
+// Properties requested with PROPFIND
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop>
+<d:resourcetype/><d:getcontentlength/><d:getcontenttype/><d:getlastmodified/>
+</d:prop></d:propfind>`
+
+// New creates a WebDAV client
+func New(config Config) (*Client, error) {
+	base, err := url.Parse(config.URL)
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("invalid WebDAV URL '%v'", config.URL)
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+
+	return &Client{config: config, base: base, httpClient: http.DefaultClient}, nil
+}
+
+// resourceURL returns the URL of a path relative to the base collection
+func (c *Client) resourceURL(relPath string) string {
+	u := *c.base
+	u.Path = c.base.Path + relPath
+	u.RawPath = ""
+
+	return u.String()
+}
+
+// statusError is an error response of the server
+type statusError struct {
+	status  int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// StatusCode returns the HTTP status code of the response
+func (e *statusError) StatusCode() int {
+	return e.status
+}
+
+// hasStatus tells whether the error is a response with the status
+func hasStatus(err error, status int) bool {
+	var se *statusError
+	return errors.As(err, &se) && se.status == status
+}
+
+// do sends an authenticated request; returns an error for a non-2xx status
+func (c *Client) do(method, relPath string, headers map[string]string,
+	body io.Reader, contentLength int64) (*http.Response, error) {
+
+	req, err := http.NewRequest(method, c.resourceURL(relPath), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if body != nil && contentLength >= 0 {
+		req.ContentLength = contentLength
+	}
+	if c.config.Username != "" {
+		req.SetBasicAuth(c.config.Username, c.config.Password)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call %v %v: %w", method, relPath, err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return resp, &statusError{status: resp.StatusCode,
+			message: fmt.Sprintf("%v %v failed: %v", method, relPath, resp.Status)}
+	}
+
+	return resp, nil
+}
+
+// relPath returns the path of a PROPFIND href relative to the base collection
+func (c *Client) relPath(href string) string {
+	u, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+
+	return strings.Trim(strings.TrimPrefix(u.Path, c.base.Path), "/")
+}
+
+// propfind lists the members of a collection (excluding itself)
+func (c *Client) propfind(relPath string) ([]*resource, error) {
+	resp, err := c.do("PROPFIND", relPath, map[string]string{"Depth": "1",
+		"Content-Type": "application/xml"}, strings.NewReader(propfindBody),
+		int64(len(propfindBody)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("failed to decode PROPFIND response: %w", err)
+	}
+
+	self := strings.Trim(relPath, "/")
+	res := []*resource{}
+	for _, r := range ms.Responses {
+		if c.relPath(r.Href) != self && len(r.Propstat) > 0 {
+			res = append(res, r)
+		}
+	}
+
+	return res, nil
+}
+
+// isCollection tells whether the resource is a collection
+func (r *resource) isCollection() bool {
+	return r.Propstat[0].Prop.ResourceType.Collection != nil
+}
+
+// toMediaItem converts a file resource into a MediaItem
+func (c *Client) toMediaItem(r *resource) *photos.MediaItem {
+	relPath := c.relPath(r.Href)
+	prop := r.Propstat[0].Prop
+
+	mimeType := prop.ContentType
+	if mimeType == "" {
+		mimeType = mime.TypeByExtension(strings.ToLower(path.Ext(relPath)))
+	}
+	modified, _ := http.ParseTime(prop.LastModified)
+	u := c.resourceURL(relPath)
+
+	return &photos.MediaItem{ID: relPath, FileName: path.Base(relPath), MimeType: mimeType,
+		IsVideo: strings.HasPrefix(mimeType, "video/"), ProductURL: u, BaseURL: u,
+		CreationTime: modified}
+}
+
+// files returns the file resources of a collection
+func (c *Client) files(relPath string) ([]*photos.MediaItem, error) {
+	resources, err := c.propfind(relPath)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []*photos.MediaItem{}
+	for _, r := range resources {
+		if !r.isCollection() {
+			items = append(items, c.toMediaItem(r))
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+
+	return items, nil
+}
+
+// toAlbum forms an Album out of an album collection
+func (c *Client) toAlbum(name string, items int) *photos.Album {
+	return &photos.Album{ID: name, Title: name, ProductURL: c.resourceURL(name + "/"),
+		IsWriteable: true, MediaItemsCount: int64(items)}
+}
+
+// ListAlbums lists the collections of the base collection
+func (c *Client) ListAlbums() ([]*photos.Album, error) {
+	resources, err := c.propfind("")
+	if err != nil {
+		return nil, err
+	}
+
+	albums := []*photos.Album{}
+	for _, r := range resources {
+		name := c.relPath(r.Href)
+		if !r.isCollection() || name == uploadsCollection {
+			continue
+		}
+
+		items, err := c.files(name + "/")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list album %v: %w", name, err)
+		}
+		albums = append(albums, c.toAlbum(name, len(items)))
+	}
+
+	return albums, nil
+}
+
+// ListSharedAlbums returns no albums since sharing is not supported
+func (c *Client) ListSharedAlbums() ([]*photos.Album, error) {
+	return []*photos.Album{}, nil
+}
+
+// sanitizeName replaces the characters not allowed in resource names
+func sanitizeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		if r < ' ' {
+			return -1
+		}
+		return r
+	}, name)
+
+	name = strings.Trim(strings.TrimSpace(name), ".")
+	if name == "" {
+		return "Untitled"
+	}
+
+	return name
+}
+
+// CreateAlbum creates a collection for the album; adds a numeric suffix to
+// the name if a collection with the name already exists.
+func (c *Client) CreateAlbum(name string) (*photos.Album, error) {
+	base := sanitizeName(name)
+	dir := base
+
+	for i := 2; ; i++ {
+		resp, err := c.do("MKCOL", dir+"/", nil, nil, 0)
+		if hasStatus(err, http.StatusMethodNotAllowed) {
+			dir = fmt.Sprintf("%v (%v)", base, i)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create album: %w", err)
+		}
+		resp.Body.Close()
+
+		return c.toAlbum(dir, 0), nil
+	}
+}
+
+// ShareAlbum is not supported
+func (c *Client) ShareAlbum(album *photos.Album, collaborative,
+	commentable bool) (*photos.ShareInfo, error) {
+
+	return nil, photos.ErrNotSupported
+}
+
+// progressReader reports the number of bytes read to a callback
+type progressReader struct {
+	r        io.Reader
+	count    int64
+	callback func(int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.count += int64(n)
+	if r.callback != nil {
+		r.callback(r.count)
+	}
+
+	return n, err
+}
+
+// UploadPhoto uploads the file into the staging collection; the name of the
+// staged file is used as the upload token.
+func (c *Client) UploadPhoto(filePath string, callback func(int64)) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	resp, err := c.do("MKCOL", uploadsCollection+"/", nil, nil, 0)
+	if err != nil && !hasStatus(err, http.StatusMethodNotAllowed) {
+		return "", fmt.Errorf("failed to create upload collection: %w", err)
+	}
+	if err == nil {
+		resp.Body.Close()
+	}
+
+	token := uuid.New().String() + strings.ToLower(path.Ext(filePath))
+
+	resp, err = c.do("PUT", uploadsCollection+"/"+token, nil,
+		&progressReader{r: f, callback: callback}, info.Size())
+	if err != nil {
+		return "", fmt.Errorf("failed to upload file: %w", err)
+	}
+	resp.Body.Close()
+
+	return token, nil
+}
+
+// AddToAlbum moves the staged files into the album collection (or the base
+// collection if album is nil), named by their file names. Descriptions are
+// not supported.
+func (c *Client) AddToAlbum(album *photos.Album,
+	items []*photos.NewMediaItem) ([]*photos.MediaItem, error) {
+
+	dir := ""
+	if album != nil {
+		dir = album.ID + "/"
+	}
+
+	created := make([]*photos.MediaItem, 0, len(items))
+
+	for _, item := range items {
+		name := sanitizeName(item.FileName)
+		ext := path.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+
+		relPath := dir + name
+		var err error
+		for i := 2; ; i++ {
+			var resp *http.Response
+			resp, err = c.do("MOVE", uploadsCollection+"/"+item.UploadToken,
+				map[string]string{"Destination": c.resourceURL(relPath), "Overwrite": "F"},
+				nil, 0)
+			if hasStatus(err, http.StatusPreconditionFailed) {
+				relPath = fmt.Sprintf("%v%v (%v)%v", dir, base, i, ext)
+				continue
+			}
+			if err == nil {
+				resp.Body.Close()
+			}
+			break
+		}
+
+		if err != nil {
+			log.WithFields(logrus.Fields{logging.FieldFile: item.FileName,
+				logging.FieldErrorClass: photos.ErrorClass(err)}).WithError(err).
+				Warn("Failed to add a photo to the album")
+			continue
+		}
+
+		mimeType := mime.TypeByExtension(strings.ToLower(ext))
+		u := c.resourceURL(relPath)
+		created = append(created, &photos.MediaItem{ID: relPath, FileName: path.Base(relPath),
+			Description: item.Description, MimeType: mimeType,
+			IsVideo: strings.HasPrefix(mimeType, "video/"), ProductURL: u, BaseURL: u,
+			CreationTime: time.Now(), UploadToken: item.UploadToken})
+	}
+
+	if len(created) == 0 {
+		return nil, fmt.Errorf("Failed to add all of the photos to album")
+	}
+
+	return created, nil
+}
+
+// AddEnrichment is not supported
+func (c *Client) AddEnrichment(album *photos.Album, e *photos.Enrichment, first bool) error {
+	return photos.ErrNotSupported
+}
+
+// SetAlbumCover is not supported
+func (c *Client) SetAlbumCover(album *photos.Album, mediaItemID string) error {
+	return photos.ErrNotSupported
+}
+
+// SearchMediaItems lists the files of an album, or the files of all the
+// albums and the base collection by their modification date and media type.
+// Content categories are not supported.
+func (c *Client) SearchMediaItems(f *photos.SearchFilter) ([]*photos.MediaItem, error) {
+	if len(f.ContentCategories) > 0 {
+		return nil, fmt.Errorf("content categories are %w", photos.ErrNotSupported)
+	}
+
+	if f.AlbumID != "" {
+		return c.files(f.AlbumID + "/")
+	}
+
+	dirs := []string{""}
+	albums, err := c.ListAlbums()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range albums {
+		dirs = append(dirs, a.ID+"/")
+	}
+
+	day := func(t time.Time) string { return t.Format("2006-01-02") }
+	res := []*photos.MediaItem{}
+
+	for _, dir := range dirs {
+		items, err := c.files(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range items {
+			if !f.StartDate.IsZero() && day(m.CreationTime) < day(f.StartDate) {
+				continue
+			}
+			if !f.EndDate.IsZero() && day(m.CreationTime) > day(f.EndDate) {
+				continue
+			}
+			if (f.MediaType == "PHOTO" && m.IsVideo) || (f.MediaType == "VIDEO" && !m.IsVideo) {
+				continue
+			}
+			res = append(res, m)
+		}
+	}
+
+	return res, nil
+}
+
+// ListAlbumItems lists the files of the album collection
+func (c *Client) ListAlbumItems(album *photos.Album) ([]*photos.MediaItem, error) {
+	return c.SearchMediaItems(&photos.SearchFilter{AlbumID: album.ID})
+}
+
+// DownloadMediaItem writes the file of the media item into w
+func (c *Client) DownloadMediaItem(item *photos.MediaItem, w io.Writer) (int64, error) {
+	resp, err := c.do("GET", item.ID, nil, nil, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	return io.Copy(w, resp.Body)
+}
@@ -0,0 +1,116 @@
+package webdav
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/webdav"
+
+	photos "github.com/matti777/google-photos-uploader/internal/googlephotos"
+)
+
+func TestClient(t *testing.T) {
+	dav := &webdav.Handler{Prefix: "/dav/files/matti", FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS()}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "matti" || pass != "secret" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		dav.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	c, err := New(Config{URL: server.URL + "/dav/files/matti", Username: "matti",
+		Password: "secret"})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	album, err := c.CreateAlbum("Trip: Japan")
+	if err != nil {
+		t.Fatalf("failed to create album: %v", err)
+	}
+	if album.ID != "Trip_ Japan" {
+		t.Errorf("unexpected album: %+v", album)
+	}
+	if again, err := c.CreateAlbum("Trip: Japan"); err != nil || again.ID != "Trip_ Japan (2)" {
+		t.Errorf("expected a suffixed album, got %+v: %v", again, err)
+	}
+
+	srcDir := t.TempDir()
+	tokens := []string{}
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		p := filepath.Join(srcDir, name)
+		if err := os.WriteFile(p, []byte("contents of "+name), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+
+		sent := int64(0)
+		token, err := c.UploadPhoto(p, func(n int64) { sent = n })
+		if err != nil {
+			t.Fatalf("failed to upload: %v", err)
+		}
+		if sent != int64(len("contents of "+name)) {
+			t.Errorf("unexpected progress %v", sent)
+		}
+		tokens = append(tokens, token)
+	}
+
+	items, err := c.AddToAlbum(album, []*photos.NewMediaItem{
+		{UploadToken: tokens[0], FileName: "a.jpg"},
+		{UploadToken: tokens[1], FileName: "a.jpg"},
+		{UploadToken: "unknown", FileName: "x.jpg"},
+	})
+	if err != nil || len(items) != 2 {
+		t.Fatalf("expected 2 items to be added, got %v: %v", len(items), err)
+	}
+	if items[0].ID != "Trip_ Japan/a.jpg" || items[1].ID != "Trip_ Japan/a (2).jpg" ||
+		items[1].UploadToken != tokens[1] {
+
+		t.Errorf("unexpected media items: %+v, %+v", items[0], items[1])
+	}
+
+	if _, err := c.AddToAlbum(nil, []*photos.NewMediaItem{{UploadToken: tokens[2],
+		FileName: "c.jpg"}}); err != nil {
+		t.Errorf("failed to add to library: %v", err)
+	}
+
+	if err := c.SetAlbumCover(album, items[0].ID); err != photos.ErrNotSupported {
+		t.Errorf("expected cover not to be supported, got %v", err)
+	}
+
+	albums, err := c.ListAlbums()
+	if err != nil || len(albums) != 2 {
+		t.Fatalf("expected 2 albums, got %v: %v", len(albums), err)
+	}
+	for _, a := range albums {
+		if a.ID == album.ID && a.MediaItemsCount != 2 {
+			t.Errorf("unexpected album: %+v", a)
+		}
+	}
+
+	albumItems, err := c.ListAlbumItems(album)
+	if err != nil || len(albumItems) != 2 {
+		t.Fatalf("expected 2 album items, got %v: %v", len(albumItems), err)
+	}
+	if albumItems[1].FileName != "a.jpg" || albumItems[1].MimeType != "image/jpeg" {
+		t.Errorf("unexpected album item: %+v", albumItems[1])
+	}
+
+	var buf bytes.Buffer
+	if _, err := c.DownloadMediaItem(albumItems[1], &buf); err != nil ||
+		buf.String() != "contents of a.jpg" {
+
+		t.Errorf("unexpected download %q: %v", buf.String(), err)
+	}
+
+	all, err := c.SearchMediaItems(&photos.SearchFilter{})
+	if err != nil || len(all) != 3 {
+		t.Errorf("expected 3 items in total, got %v: %v", len(all), err)
+	}
+}